@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// on Ctrl-C, an interactive run asks what to do instead of tearing everything down.
+type abortAction int
+
+const (
+	abortFinish    abortAction = iota + 1 // finish the current benchmark, then stop
+	abortStop                             // stop the benchmarks now, keep the partial results
+	abortDetach                           // exit, keeping the instances running
+	abortTerminate                        // terminate the instances immediately
+)
+
+// abortTimeout is the time to answer the menu before abortStop is chosen.
+const abortTimeout = 10 * time.Second
+
+// abortMenu asks what to do after an interrupt. A second interrupt terminates immediately.
+func abortMenu(sigChan <-chan os.Signal) abortAction {
+	stderrTerminal.clearStatus()
+	fmt.Fprintf(os.Stderr, "\ninterrupted, what now?\n"+
+		"  1) finish the current benchmark, then stop\n"+
+		"  2) stop now and keep the partial results (default in %s)\n"+
+		"  3) detach, keeping the instances running (rbench fetch, rbench kill)\n"+
+		"  4) terminate the instances immediately (Ctrl-C again)\n"+
+		"> ", abortTimeout)
+	select {
+	case a := <-menuInput():
+		return parseAbortAnswer(a)
+	case <-sigChan:
+		return abortTerminate
+	case <-time.After(abortTimeout):
+		fmt.Fprintln(os.Stderr)
+		return abortStop
+	}
+}
+
+// menuInput returns the lines typed on stdin; a single reader serves the successive menus.
+var menuInput = sync.OnceValue(func() <-chan string {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- strings.TrimSpace(scanner.Text())
+		}
+		close(lines)
+	}()
+	return lines
+})
+
+// parseAbortAnswer returns the action of a menu answer; anything else is the safe default.
+func parseAbortAnswer(answer string) abortAction {
+	switch answer {
+	case "1":
+		return abortFinish
+	case "3":
+		return abortDetach
+	case "4":
+		return abortTerminate
+	}
+	return abortStop
+}
+
+// interrupted is set once the benchmarks are being stopped (abortFinish or abortStop): their
+// failures are expected.
+var interrupted atomic.Bool
+
+// finishing is set by abortFinish: the benchmarks stop once their current benchmark has run
+// -count times, see stopIfFinished.
+var finishing atomic.Bool
+
+// activeRemotes are the remotes running a benchmark, to stop them on interrupt.
+var activeRemotes sync.Map // host -> remote
+
+// stopBenchmark kills the benchmark running on r, by the pid it recorded (see benchCommand); its
+// output so far is kept.
+func stopBenchmark(r remote) {
+	if _, err := sshRunOnce(r, "kill $(cat "+remoteBenchPID()+") 2>/dev/null; true"); err != nil {
+		slog.Warn(fmt.Sprintf("unable to stop the benchmark on %s, %v", r.host, err))
+	}
+}
+
+// stopAllBenchmarks implements abortStop.
+func stopAllBenchmarks() {
+	interrupted.Store(true)
+	activeRemotes.Range(func(_, v any) bool {
+		go stopBenchmark(v.(remote))
+		return true
+	})
+}
+
+// stopIfFinished is called with each result of r: with abortFinish, the benchmark is stopped
+// once name has run -count times, before the next one goes far.
+func stopIfFinished(r remote, results *benchResults, name string) {
+	if !finishing.Load() || len(results.values(name, "ns/op")) < *countFlag {
+		return
+	}
+	if _, loaded := activeRemotes.LoadAndDelete(r.host); loaded {
+		interrupted.Store(true)
+		slog.Info(fmt.Sprintf("%s done, stopping the benchmark on %s", name, r.host))
+		go stopBenchmark(r)
+	}
+}
+
+// detachInstances implements abortDetach: the benchmarks keep running, their output is kept on
+// the instances.
+func detachInstances() {
+	liveInstances.Range(func(key, _ any) bool {
+		id := key.(string)
+		if *providerFlag == "gcp" {
+			slog.Info(fmt.Sprintf("instance %s kept running: gcloud compute instances delete %s --zone %s to delete it", id, id, *gcpZone))
+			return true
+		}
+		slog.Info(fmt.Sprintf("instance %s kept running: rbench fetch -terminate %s to retrieve the results, rbench kill %s to terminate it", id, id, id))
+		return true
+	})
+}
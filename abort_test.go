@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestParseAbortAnswer(t *testing.T) {
+	for answer, want := range map[string]abortAction{
+		"1": abortFinish,
+		"2": abortStop,
+		"3": abortDetach,
+		"4": abortTerminate,
+		"":  abortStop,
+		"x": abortStop,
+	} {
+		if got := parseAbortAnswer(answer); got != want {
+			t.Errorf("parseAbortAnswer(%q) = %d, want %d", answer, got, want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// with -agent, the benchmarks run through rbench agent, a gRPC server started on the instance
+// and reached through an ssh tunnel: the benchmark runs detached from the connection, which
+// reconnects to its output where it left off. The messages are JSON (agentCodec), so that the
+// service needs no generated code.
+
+// agentChunkSize is the size of the chunks of output and files streamed by the agent.
+const agentChunkSize = 256 << 10
+
+// agentPoll is the interval the agent checks the output of a benchmark for new data.
+const agentPoll = 100 * time.Millisecond
+
+type agentStartRequest struct {
+	Command string // run with sh -c, from the home directory
+}
+
+type agentStartReply struct {
+	ID int
+}
+
+type agentOutputRequest struct {
+	ID     int
+	Offset int64 // in the output of the command, to resume after a reconnection
+}
+
+// agentChunk is a piece of output or file; the last chunk of an output carries the exit code.
+type agentChunk struct {
+	Data   []byte `json:",omitempty"`
+	Exited bool   `json:",omitempty"`
+	Code   int    `json:",omitempty"`
+}
+
+type agentFetchRequest struct {
+	Path   string
+	Offset int64
+}
+
+type agentMetricsRequest struct{}
+
+// agentMetrics are the system metrics of the instance, from /proc.
+type agentMetrics struct {
+	Load1, Load5, Load15 float64
+	MemTotal, MemAvail   int64 // bytes
+	Uptime               time.Duration
+}
+
+func (m agentMetrics) String() string {
+	return fmt.Sprintf("load %.2f %.2f %.2f, memory %.1f/%.1f GiB available, up %s",
+		m.Load1, m.Load5, m.Load15, float64(m.MemAvail)/(1<<30), float64(m.MemTotal)/(1<<30), m.Uptime.Round(time.Second))
+}
+
+type agentTerminateRequest struct {
+	Idle time.Duration // without a call nor a running benchmark, before powering off; 0 disarms
+}
+
+type agentEmpty struct{}
+
+// agentCodec encodes the messages of the agent service in JSON.
+type agentCodec struct{}
+
+func (agentCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (agentCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (agentCodec) Name() string                       { return "json" }
+
+// agentService is the gRPC service of rbench agent.
+type agentService interface {
+	start(context.Context, *agentStartRequest) (*agentStartReply, error)
+	output(*agentOutputRequest, grpc.ServerStream) error
+	fetch(*agentFetchRequest, grpc.ServerStream) error
+	metrics(context.Context, *agentMetricsRequest) (*agentMetrics, error)
+	terminate(context.Context, *agentTerminateRequest) (*agentEmpty, error)
+}
+
+// agentUnary returns the handler of a unary method of agentService.
+func agentUnary[Req, Reply any](call func(agentService, context.Context, *Req) (*Reply, error)) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := new(Req)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(agentService), ctx, req.(*Req))
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv}, handler)
+	}
+}
+
+// agentStream returns the handler of a server streaming method of agentService.
+func agentStream[Req any](call func(agentService, *Req, grpc.ServerStream) error) grpc.StreamHandler {
+	return func(srv any, stream grpc.ServerStream) error {
+		req := new(Req)
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+		return call(srv.(agentService), req, stream)
+	}
+}
+
+var agentServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rbench.Agent",
+	HandlerType: (*agentService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Start", Handler: agentUnary(agentService.start)},
+		{MethodName: "Metrics", Handler: agentUnary(agentService.metrics)},
+		{MethodName: "Terminate", Handler: agentUnary(agentService.terminate)},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Output", Handler: agentStream(agentService.output), ServerStreams: true},
+		{StreamName: "Fetch", Handler: agentStream(agentService.fetch), ServerStreams: true},
+	},
+}
+
+// agentProcess is a command started by the agent; its output is kept in a file.
+type agentProcess struct {
+	output string
+	done   chan struct{}
+	code   int // once done is closed
+}
+
+// agentServer implements agentService.
+type agentServer struct {
+	dir string // of the outputs
+
+	mu    sync.Mutex
+	procs []*agentProcess
+
+	running  atomic.Int32 // processes and streams in progress
+	lastCall atomic.Int64 // unix nanoseconds
+	idle     atomic.Int64 // see agentTerminateRequest
+	poweroff func()
+}
+
+func newAgentServer(dir string) *agentServer {
+	s := &agentServer{dir: dir, poweroff: powerOff}
+	s.lastCall.Store(time.Now().UnixNano())
+	return s
+}
+
+func (s *agentServer) start(_ context.Context, req *agentStartRequest) (*agentStartReply, error) {
+	s.mu.Lock()
+	id := len(s.procs)
+	p := &agentProcess{output: filepath.Join(s.dir, fmt.Sprintf("output-%d", id)), done: make(chan struct{})}
+	s.procs = append(s.procs, p)
+	s.mu.Unlock()
+
+	f, err := os.Create(p.output)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to create the output file, %v", err)
+	}
+	cmd := exec.Command("sh", "-c", req.Command)
+	cmd.Dir, _ = os.UserHomeDir()
+	cmd.Stdout, cmd.Stderr = f, f
+	if err := cmd.Start(); err != nil {
+		f.Close()
+		return nil, status.Errorf(codes.InvalidArgument, "unable to start the command, %v", err)
+	}
+	s.running.Add(1)
+	go func() {
+		defer s.running.Add(-1)
+		err := cmd.Wait()
+		f.Close()
+		p.code = cmd.ProcessState.ExitCode()
+		if err != nil && p.code == 0 {
+			p.code = -1
+		}
+		close(p.done)
+	}()
+	return &agentStartReply{ID: id}, nil
+}
+
+func (s *agentServer) process(id int) (*agentProcess, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 0 || id >= len(s.procs) {
+		return nil, status.Errorf(codes.NotFound, "no command %d", id)
+	}
+	return s.procs[id], nil
+}
+
+func (s *agentServer) output(req *agentOutputRequest, stream grpc.ServerStream) error {
+	p, err := s.process(req.ID)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(p.output)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to open the output, %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(req.Offset, io.SeekStart); err != nil {
+		return status.Errorf(codes.InvalidArgument, "unable to seek to %d, %v", req.Offset, err)
+	}
+	buf := make([]byte, agentChunkSize)
+	for {
+		// the exit status is only sent once the output is drained
+		exited := false
+		select {
+		case <-p.done:
+			exited = true
+		default:
+		}
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&agentChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return status.Errorf(codes.Internal, "unable to read the output, %v", err)
+		}
+		if exited {
+			return stream.SendMsg(&agentChunk{Exited: true, Code: p.code})
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-p.done:
+		case <-time.After(agentPoll):
+		}
+	}
+}
+
+func (s *agentServer) fetch(req *agentFetchRequest, stream grpc.ServerStream) error {
+	f, err := os.Open(req.Path)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "%v", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(req.Offset, io.SeekStart); err != nil {
+		return status.Errorf(codes.InvalidArgument, "unable to seek to %d, %v", req.Offset, err)
+	}
+	buf := make([]byte, agentChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&agentChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "unable to read %s, %v", req.Path, err)
+		}
+	}
+}
+
+func (s *agentServer) metrics(context.Context, *agentMetricsRequest) (*agentMetrics, error) {
+	m, err := readSystemMetrics("/proc")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return m, nil
+}
+
+// readSystemMetrics reads the load, the memory and the uptime of the system from proc.
+func readSystemMetrics(proc string) (*agentMetrics, error) {
+	m := &agentMetrics{}
+	loadavg, err := os.ReadFile(filepath.Join(proc, "loadavg"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the load average, %v", err)
+	}
+	if _, err := fmt.Sscan(string(loadavg), &m.Load1, &m.Load5, &m.Load15); err != nil {
+		return nil, fmt.Errorf("unable to parse the load average %q, %v", loadavg, err)
+	}
+	meminfo, err := os.ReadFile(filepath.Join(proc, "meminfo"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the memory information, %v", err)
+	}
+	for _, line := range strings.Split(string(meminfo), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		kb, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			m.MemTotal = kb << 10
+		case "MemAvailable:":
+			m.MemAvail = kb << 10
+		}
+	}
+	uptime, err := os.ReadFile(filepath.Join(proc, "uptime"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the uptime, %v", err)
+	}
+	var seconds float64
+	if _, err := fmt.Sscan(string(uptime), &seconds); err != nil {
+		return nil, fmt.Errorf("unable to parse the uptime %q, %v", uptime, err)
+	}
+	m.Uptime = time.Duration(seconds * float64(time.Second))
+	return m, nil
+}
+
+func (s *agentServer) terminate(_ context.Context, req *agentTerminateRequest) (*agentEmpty, error) {
+	s.idle.Store(int64(req.Idle))
+	return &agentEmpty{}, nil
+}
+
+// checkIdle powers the machine off if the self-termination is armed and neither a call nor a
+// benchmark happened for its idle time.
+func (s *agentServer) checkIdle(now time.Time) bool {
+	idle := time.Duration(s.idle.Load())
+	if idle <= 0 || s.running.Load() > 0 || now.Sub(time.Unix(0, s.lastCall.Load())) < idle {
+		return false
+	}
+	slog.Warn(fmt.Sprintf("no rbench connection nor benchmark for %s, powering off", idle))
+	s.poweroff()
+	return true
+}
+
+// unaryInterceptor records the calls, for the self-termination.
+func (s *agentServer) unaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	s.lastCall.Store(time.Now().UnixNano())
+	return handler(ctx, req)
+}
+
+// streamInterceptor counts the streams as running until they end.
+func (s *agentServer) streamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	s.running.Add(1)
+	defer func() {
+		s.lastCall.Store(time.Now().UnixNano())
+		s.running.Add(-1)
+	}()
+	return handler(srv, ss)
+}
+
+// powerOff shuts the machine down; instances started with -agent are terminated on shutdown.
+func powerOff() {
+	if out, err := exec.Command("sudo", "-n", "poweroff").CombinedOutput(); err != nil {
+		slog.Error(fmt.Sprintf("unable to power off: %s, %v", strings.TrimSpace(string(out)), err))
+	}
+}
+
+// agentCmd runs the agent on the instance, serving on a unix socket reached through ssh.
+func agentCmd(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	socket := fs.String("socket", "", "unix socket to listen on")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench agent -socket path\n\nstarted on the instances by rbench -agent.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *socket == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("expected -socket")
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(*socket), "rbench-agent-")
+	if err != nil {
+		return err
+	}
+	os.Remove(*socket)
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		return err
+	}
+	// the agent outlives the ssh session that started it
+	signal.Ignore(syscall.SIGHUP)
+
+	s := newAgentServer(dir)
+	srv := grpc.NewServer(grpc.ForceServerCodec(agentCodec{}),
+		grpc.UnaryInterceptor(s.unaryInterceptor), grpc.StreamInterceptor(s.streamInterceptor))
+	srv.RegisterService(&agentServiceDesc, s)
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			if s.checkIdle(now) {
+				return
+			}
+		}
+	}()
+	return srv.Serve(l)
+}
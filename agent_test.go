@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestAgent(t *testing.T) {
+	dir := t.TempDir()
+	l, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newAgentServer(dir)
+	srv := grpc.NewServer(grpc.ForceServerCodec(agentCodec{}),
+		grpc.UnaryInterceptor(s.unaryInterceptor), grpc.StreamInterceptor(s.streamInterceptor))
+	srv.RegisterService(&agentServiceDesc, s)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("unix://"+l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(agentCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	a := &agentClient{conn: conn}
+
+	var out bytes.Buffer
+	err = a.run("echo hello; sleep 0.3; echo world >&2; exit 3", &out)
+	if err == nil || err.Error() != "exit status 3" {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+	if out.String() != "hello\nworld\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	// a reconnection resumes the output at its offset
+	out.Reset()
+	last, err := a.stream("Output", &agentOutputRequest{ID: 0, Offset: 6}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "world\n" || !last.Exited || last.Code != 3 {
+		t.Errorf("unexpected resumed output %q, exit %v %d", out.String(), last.Exited, last.Code)
+	}
+
+	file := filepath.Join(dir, "dump")
+	if err := os.WriteFile(file, []byte("stderr\ngoroutine 1 [select]:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := a.fetch(file, 7); err != nil || string(b) != "goroutine 1 [select]:\n" {
+		t.Errorf("unexpected fetch %q, %v", b, err)
+	}
+	if _, err := a.fetch(filepath.Join(dir, "missing"), 0); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+
+	// self-termination
+	powered := false
+	s.poweroff = func() { powered = true }
+	if err := a.invoke("Terminate", &agentTerminateRequest{Idle: time.Minute}, &agentEmpty{}); err != nil {
+		t.Fatal(err)
+	}
+	if s.checkIdle(time.Now()) || powered {
+		t.Error("powered off right after a call")
+	}
+	if !s.checkIdle(time.Now().Add(2*time.Minute)) || !powered {
+		t.Error("expected to power off once idle")
+	}
+}
+
+func TestReadSystemMetrics(t *testing.T) {
+	proc := t.TempDir()
+	for name, content := range map[string]string{
+		"loadavg": "0.52 0.58 0.59 1/467 12345\n",
+		"meminfo": "MemTotal:       16318508 kB\nMemFree:         1000000 kB\nMemAvailable:    8159254 kB\n",
+		"uptime":  "3600.50 7000.00\n",
+	} {
+		if err := os.WriteFile(filepath.Join(proc, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := readSystemMetrics(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.String(), "load 0.52 0.58 0.59, memory 7.8/15.6 GiB available, up 1h0m1s"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"runtime/debug"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// agentConnectTimeout is the time for the tunnel and the agent to accept calls.
+const agentConnectTimeout = 30 * time.Second
+
+// rbenchPackage is cross-compiled locally and uploaded to the instance for -agent, at the
+// version of the running rbench (latest for a development build).
+func rbenchPackage() string {
+	version := "latest"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	return "github.com/gbotrel/rbench@" + version
+}
+
+// remoteAgent is the path of rbench on the instance, uploaded with the benchmark binary.
+func remoteAgent() string {
+	return remoteTmp() + "/rbench-agent"
+}
+
+// remoteAgentSocket is the unix socket the agent listens on, forwarded by the ssh tunnel.
+func remoteAgentSocket() string {
+	return remoteTmp() + "/rbench-agent.sock"
+}
+
+// agentClient is the connection to the agent of an instance, through an ssh tunnel; both are
+// reopened after a network failure.
+type agentClient struct {
+	r      remote
+	tunnel *exec.Cmd
+	conn   *grpc.ClientConn
+}
+
+// startAgent starts the agent uploaded to r and connects to it. On cloud instances, the agent
+// powers the instance off after -agent-idle without rbench, e.g. if this process dies.
+func startAgent(t target, r remote) (*agentClient, error) {
+	command := fmt.Sprintf("[ -S %[1]s ] || { nohup %[2]s agent -socket %[1]s > %[2]s.log 2>&1 < /dev/null & }",
+		remoteAgentSocket(), remoteAgent())
+	if _, err := sshRun(r, command); err != nil {
+		return nil, fmt.Errorf("unable to start the agent, %v", err)
+	}
+	a := &agentClient{r: r}
+	if err := withRetry("connect to the agent", a.connect); err != nil {
+		return nil, fmt.Errorf("unable to connect to the agent, %v", err)
+	}
+	if t.host == "" && *agentIdle > 0 {
+		if err := a.invoke("Terminate", &agentTerminateRequest{Idle: *agentIdle}, &agentEmpty{}); err != nil {
+			a.close()
+			return nil, fmt.Errorf("unable to arm the self-termination of the agent, %v", err)
+		}
+	}
+	return a, nil
+}
+
+// connect opens the tunnel to the agent socket and waits for the agent to answer.
+func (a *agentClient) connect() error {
+	a.disconnect()
+	addr, err := freeAddr()
+	if err != nil {
+		return err
+	}
+	args := append(sshOptions("-p"), "-N", "-o", "ExitOnForwardFailure=yes", "-L", addr+":"+remoteAgentSocket(), a.r.String())
+	a.tunnel = exec.Command("ssh", args...)
+	if err := a.tunnel.Start(); err != nil {
+		return fmt.Errorf("unable to start the ssh tunnel, %v", err)
+	}
+	if a.conn, err = grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(agentCodec{}))); err != nil {
+		a.disconnect()
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), agentConnectTimeout)
+	defer cancel()
+	for {
+		// the tunnel and the agent may still be starting
+		if _, err = a.metrics(); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			a.disconnect()
+			return agentError(err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// disconnect closes the connection and the tunnel, if any.
+func (a *agentClient) disconnect() {
+	if a.conn != nil {
+		a.conn.Close()
+		a.conn = nil
+	}
+	if a.tunnel != nil {
+		a.tunnel.Process.Kill()
+		a.tunnel.Wait()
+		a.tunnel = nil
+	}
+}
+
+// close stops the agent: an instance kept with -keep must not power itself off.
+func (a *agentClient) close() {
+	a.disconnect()
+	if _, err := sshRunOnce(a.r, "pkill -f '^"+remoteAgent()+" agent'; rm -f "+remoteAgentSocket()); err != nil {
+		slog.Warn(fmt.Sprintf("unable to stop the agent on %s, %v", a.r.host, err))
+	}
+}
+
+// agentError marks the errors of a lost connection to the agent as network errors.
+func agentError(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Canceled, codes.DeadlineExceeded:
+		return fmt.Errorf("%w: %v", errSSHNetwork, err)
+	}
+	return err
+}
+
+// invoke calls a unary method, reconnecting first if the connection was lost.
+func (a *agentClient) invoke(method string, req, reply any) error {
+	if a.conn == nil {
+		if err := a.connect(); err != nil {
+			return err
+		}
+	}
+	return a.conn.Invoke(context.Background(), "/rbench.Agent/"+method, req, reply)
+}
+
+// stream calls a server streaming method and writes the data of its chunks to w; it returns
+// the last chunk.
+func (a *agentClient) stream(method string, req any, w io.Writer) (*agentChunk, error) {
+	if a.conn == nil {
+		if err := a.connect(); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := a.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/rbench.Agent/"+method)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := s.CloseSend(); err != nil {
+		return nil, err
+	}
+	for {
+		chunk := &agentChunk{}
+		if err := s.RecvMsg(chunk); err != nil {
+			if errors.Is(err, io.EOF) {
+				return chunk, nil
+			}
+			return nil, err
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return nil, err
+		}
+		if chunk.Exited {
+			return chunk, nil
+		}
+	}
+}
+
+// offsetWriter counts the bytes written to w, to resume a stream.
+type offsetWriter struct {
+	w      io.Writer
+	offset *int64
+}
+
+func (o offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	*o.offset += int64(n)
+	return n, err
+}
+
+// run runs command on the instance, streaming its output to out. The command keeps running
+// if the connection is lost; its output then resumes where it left off.
+func (a *agentClient) run(command string, out io.Writer) error {
+	var started agentStartReply
+	if err := a.invoke("Start", &agentStartRequest{Command: command}, &started); err != nil {
+		// not retried: the command may have started
+		return fmt.Errorf("unable to start the command with the agent, %v", err)
+	}
+	var offset int64
+	var last *agentChunk
+	err := withRetry("stream the output of the agent", func() error {
+		var err error
+		last, err = a.stream("Output", &agentOutputRequest{ID: started.ID, Offset: offset}, offsetWriter{out, &offset})
+		if err = agentError(err); errors.Is(err, errSSHNetwork) {
+			a.disconnect()
+			slog.Warn(fmt.Sprintf("connection to the agent on %s lost after %d bytes of output, reconnecting: %v", a.r.host, offset, err))
+		}
+		return err
+	})
+	if err != nil {
+		// the command may still be running: not retried either
+		return fmt.Errorf("connection to the agent lost, %v", err)
+	}
+	if !last.Exited {
+		return fmt.Errorf("the agent ended the output without an exit status")
+	}
+	if last.Code != 0 {
+		return fmt.Errorf("exit status %d", last.Code)
+	}
+	return nil
+}
+
+// fetch returns the content of path on the instance, from offset.
+func (a *agentClient) fetch(path string, offset int64) ([]byte, error) {
+	var buf bytes.Buffer
+	err := withRetry("fetch "+path+" from the agent", func() error {
+		buf.Reset()
+		if _, err := a.stream("Fetch", &agentFetchRequest{Path: path, Offset: offset}, &buf); err != nil {
+			if err = agentError(err); errors.Is(err, errSSHNetwork) {
+				a.disconnect()
+			}
+			return err
+		}
+		return nil
+	})
+	return buf.Bytes(), err
+}
+
+// metrics returns the system metrics of the instance.
+func (a *agentClient) metrics() (*agentMetrics, error) {
+	m := &agentMetrics{}
+	if err := a.invoke("Metrics", &agentMetricsRequest{}, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the assets of the package are its testdata directory and the -upload files and directories:
+// they are shipped in a tar archive next to the binary and extracted in a tree mirroring the
+// module, and the benchmark runs from the directory of the package in it, like with go test, so
+// that the relative paths the tests open resolve as they do locally.
+
+// remoteAssets is the archive of the assets on the instance.
+func remoteAssets() string {
+	return remoteTmp() + "/rbench-assets.tar"
+}
+
+// remoteAssetsRoot is the root of the mirrored module, in the working directory.
+const remoteAssetsRoot = "rbench-src"
+
+// shipAssets reports whether the run ships the assets: the -slices, -wasm, -debug and enclave
+// runs have their own working directory.
+func shipAssets() bool {
+	return *slicesFlag == "" && *wasmFlag == "" && *debugFlag == "" && *confidential != "enclave"
+}
+
+// packAssets writes the archive of the assets of pkg, built from dir, with the paths relative to
+// the root of its module; it returns the archive ("" if there is nothing to ship) and the
+// directory of the package relative to the root.
+func packAssets(dir, pkg string, uploads []string) (archive, pkgDir string, err error) {
+	cmd := exec.Command("go", "list", "-f", "{{.Dir}}\n{{with .Module}}{{.Dir}}{{end}}", pkg)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to list package %s, %v", pkg, err)
+	}
+	dirs := strings.Split(strings.TrimSpace(string(out))+"\n", "\n")
+	pkgPath, root := dirs[0], dirs[1]
+	if root == "" {
+		// not in a module
+		root = pkgPath
+	}
+	if pkgDir, err = filepath.Rel(root, pkgPath); err != nil {
+		return "", "", err
+	}
+
+	var paths []string
+	if _, err := os.Stat(filepath.Join(pkgPath, "testdata")); err == nil {
+		paths = append(paths, filepath.Join(pkgPath, "testdata"))
+	}
+	for _, u := range uploads {
+		p := filepath.Join(pkgPath, u)
+		if filepath.IsAbs(u) {
+			p = filepath.Clean(u)
+		}
+		if rel, err := filepath.Rel(root, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", "", fmt.Errorf("-upload: %s is outside of the module %s", u, root)
+		}
+		if _, err := os.Stat(p); err != nil {
+			return "", "", fmt.Errorf("-upload: %v", err)
+		}
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		return "", filepath.ToSlash(pkgDir), nil
+	}
+
+	archive = "/tmp/rbench-assets-" + randString(7) + ".tar"
+	f, err := os.Create(archive)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(archive)
+			archive = ""
+		}
+	}()
+	tw := tar.NewWriter(f)
+	for _, p := range paths {
+		if err := addAssets(tw, root, p); err != nil {
+			return "", "", fmt.Errorf("unable to archive %s, %v", p, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", "", err
+	}
+	return archive, filepath.ToSlash(pkgDir), nil
+}
+
+// addAssets adds the file or the directory tree at p to tw, named relative to root.
+func addAssets(tw *tar.Writer, root, p string) error {
+	return filepath.WalkDir(p, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+}
+
+// setupAssets extracts the uploaded assets in the working directory of r, and returns the
+// directory of the package in the mirrored tree, which the benchmark runs from.
+func setupAssets(r remote, pkgDir string) (string, error) {
+	root := remoteWorkDir() + "/" + remoteAssetsRoot
+	dir := path.Join(root, pkgDir)
+	if _, err := sshRun(r, fmt.Sprintf("mkdir -p %s && tar -xf %s -C %s", shellQuote(dir), remoteAssets(), shellQuote(root))); err != nil {
+		return "", fmt.Errorf("unable to extract the assets, %v", err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPackAssets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module m\n")
+	write("sub/p_test.go", "package p\n")
+	write("sub/testdata/input.txt", "input")
+	write("shared/fixture.json", "{}")
+	write("other/o_test.go", "package o\n")
+
+	archive, pkgDir, err := packAssets(dir, "./sub", []string{"../shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(archive)
+	if pkgDir != "sub" {
+		t.Errorf("package directory %q, expected sub", pkgDir)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	want := []string{"sub/testdata/", "sub/testdata/input.txt", "shared/", "shared/fixture.json"}
+	if !slices.Equal(names, want) {
+		t.Errorf("archive of %v, expected %v", names, want)
+	}
+
+	if _, _, err := packAssets(dir, "./sub", []string{"../../etc"}); err == nil {
+		t.Error("expected an error for an -upload outside of the module")
+	}
+	if _, _, err := packAssets(dir, "./sub", []string{"missing"}); err == nil {
+		t.Error("expected an error for a missing -upload")
+	}
+	archive, _, err = packAssets(dir, "./other", nil)
+	if err != nil || archive != "" {
+		t.Errorf("got %q, %v, expected no archive without testdata", archive, err)
+	}
+}
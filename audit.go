@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwltypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// auditEntry is a command executed on an instance, in the audit log of the run.
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Host     string    `json:"host"`
+	Command  string    `json:"command"`
+	ExitCode int       `json:"exitCode"` // 255: ssh failure, -1: not started
+	Duration float64   `json:"durationSeconds"`
+}
+
+// auditLog records every command executed on the instances (setup, wrappers, benchmark runs,
+// uploads), including the failed attempts.
+var auditLog struct {
+	sync.Mutex
+	entries []auditEntry
+}
+
+// auditCommand records command, executed on r from start; err is the error of the ssh command.
+func auditCommand(r remote, command string, start time.Time, err error) {
+	code := 0
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		code = -1
+	}
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	auditLog.entries = append(auditLog.entries, auditEntry{
+		Time:     start.UTC(),
+		User:     awsUserName,
+		Host:     r.host,
+		Command:  command,
+		ExitCode: code,
+		Duration: time.Since(start).Seconds(),
+	})
+}
+
+// auditJSONL returns the audit log, one JSON entry per line.
+func auditJSONL() []byte {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	var b strings.Builder
+	for _, e := range auditLog.entries {
+		data, _ := json.Marshal(e)
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// maxLogEvents is the maximum number of events of a PutLogEvents request.
+const maxLogEvents = 10000
+
+// publishAuditLog sends the audit log to a log stream rbench/<user>/<runstamp> of the
+// CloudWatch Logs group (-audit-log-group), created by the account admins.
+func publishAuditLog(group string, info runInfo) error {
+	auditLog.Lock()
+	entries := append([]auditEntry(nil), auditLog.entries...)
+	auditLog.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	client := cloudwatchlogs.NewFromConfig(awsConfig)
+	// log stream names can't contain ':'
+	stream := "rbench/" + awsUserName + "/" + strings.ReplaceAll(info.runStamp, ":", "-")
+	_, err := client.CreateLogStream(context.TODO(), &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(group),
+		LogStreamName: aws.String(stream),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the audit log stream, %v", err)
+	}
+	// the events of a request must be in chronological order; entries are recorded when the
+	// commands end, with their start time.
+	events := make([]cwltypes.InputLogEvent, 0, len(entries))
+	for _, e := range entries {
+		data, _ := json.Marshal(e)
+		events = append(events, cwltypes.InputLogEvent{Message: aws.String(string(data)), Timestamp: aws.Int64(e.Time.UnixMilli())})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return aws.ToInt64(events[i].Timestamp) < aws.ToInt64(events[j].Timestamp)
+	})
+	for i := 0; i < len(events); i += maxLogEvents {
+		_, err := client.PutLogEvents(context.TODO(), &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(group),
+			LogStreamName: aws.String(stream),
+			LogEvents:     events[i:min(i+maxLogEvents, len(events))],
+		})
+		if err != nil {
+			return fmt.Errorf("unable to send the audit log, %v", err)
+		}
+	}
+	slog.Info(fmt.Sprintf("audit log of %d commands sent to %s:%s", len(events), group, stream))
+	return nil
+}
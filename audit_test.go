@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	defer func(entries []auditEntry) { auditLog.entries = entries }(auditLog.entries)
+	auditLog.entries = nil
+
+	r := remote{user: "ubuntu", host: "10.0.0.1"}
+	auditCommand(r, "uname -a", time.Now(), nil)
+	auditCommand(r, "exit 3", time.Now(), exec.Command("sh", "-c", "exit 3").Run())
+	auditCommand(r, "true", time.Now(), errors.New("exec: ssh: not found"))
+
+	lines := strings.Split(strings.TrimSpace(string(auditJSONL())), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(lines))
+	}
+	for i, want := range []int{0, 3, -1} {
+		var e auditEntry
+		if err := json.Unmarshal([]byte(lines[i]), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ExitCode != want || e.Host != "10.0.0.1" {
+			t.Errorf("entry %d: unexpected %+v", i, e)
+		}
+	}
+}
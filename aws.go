@@ -2,65 +2,324 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
-	"net"
+	"log/slog"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
-	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
 )
 
 var (
-	awsConfig   aws.Config
-	ec2Client   *ec2.Client
-	awsUserName string
-	awsKeyName  string
+	awsConfig    aws.Config
+	ec2Client    *ec2.Client
+	awsUserName  string
+	awsAccountID string
+	awsCallerARN string // of GetCallerIdentity
+	sshKeyName   string // of the local ssh key, and of the EC2 key pair
+
+	// securityGroupID is the security group of the instances: the security-group key of the
+	// configuration, or the one of this machine, see ensureSecurityGroup.
+	securityGroupID string
 )
 
-func initAWS() error {
-	var err error
-	awsConfig, err = config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-2"))
+// defaultRegion is the region without -region nor a region in the configuration file.
+const defaultRegion = "us-east-2"
+
+// loadAWSConfig loads the SDK configuration (credentials, region), from the -profile
+// shared config profile and assuming -role-arn if set. The region (unless -region is set),
+// security group and profile default to the ones of the configuration file (see rbench init).
+func loadAWSConfig() error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	region := firstNonEmpty(*regionFlag, c[""]["region"], defaultRegion)
+	if otherRegion(c) {
+		slog.Info(fmt.Sprintf("-region=%s: the security group, subnet and AMIs of %s, set up in %s, are ignored",
+			region, configPath(), firstNonEmpty(c[""]["region"], defaultRegion)))
+	} else if sg := c[""]["security-group"]; sg != "" {
+		securityGroupID = sg
+	}
+	if *awsProfile == "" {
+		*awsProfile = c[""]["profile"]
+	}
+
+	opts := []func(*config.LoadOptions) error{config.WithRegion(region), config.WithRetryer(newAWSRetryer)}
+	if *awsProfile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(*awsProfile))
+	}
+	awsConfig, err = config.LoadDefaultConfig(context.TODO(), opts...)
 	if err != nil {
 		return fmt.Errorf("unable to load SDK config, %v", err)
 	}
+	if *debugAWS {
+		awsConfig.APIOptions = append(awsConfig.APIOptions, logAPICalls)
+	}
+	if *roleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsConfig), *roleARN, func(o *stscreds.AssumeRoleOptions) {
+			// the session name identifies the user in the target account
+			o.RoleSessionName = "rbench-" + localUserName()
+		})
+		awsConfig.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return nil
+}
+
+// newAWSRetryer retries throttled and failed (5xx) requests with jittered backoff, up to -aws-retries
+// attempts. The client-side retry quota is disabled: with many instances in flight, throttling
+// errors exhaust it and the next failure aborts the run.
+func newAWSRetryer() aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = *awsRetries
+		o.MaxBackoff = 20 * time.Second
+		o.RateLimiter = ratelimit.None
+	})
+}
+
+// eventuallyConsistent also retries requests on an instance that was just launched and may not be
+// visible to the API yet.
+func eventuallyConsistent(o *ec2.Options) {
+	o.Retryer = retry.AddWithErrorCodes(o.Retryer, "InvalidInstanceID.NotFound")
+}
+
+// localUserName returns the name of the local user.
+func localUserName() string {
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	return "unknown"
+}
+
+// resolveIdentity sets the account and user name the resources are created for. For assumed
+// roles (-role-arn, SSO), the user name is the role session name.
+func resolveIdentity() error {
+	out, err := sts.NewFromConfig(awsConfig).GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return fmt.Errorf("unable to get caller identity, %v", err)
+	}
+	awsAccountID = aws.ToString(out.Account)
+	awsCallerARN = aws.ToString(out.Arn)
+	awsUserName = userNameFromARN(aws.ToString(out.Arn))
+	return nil
+}
+
+// userNameFromARN returns the user name of an IAM user ARN or the session name of an assumed role ARN:
+//
+//	arn:aws:iam::123456789012:user/path/alice -> alice
+//	arn:aws:sts::123456789012:assumed-role/perf-lab/alice -> alice
+func userNameFromARN(arn string) string {
+	parts := strings.Split(arn, "/")
+	return parts[len(parts)-1]
+}
+
+func initAWS() error {
+	err := loadAWSConfig()
+	if err != nil {
+		return err
+	}
 
 	ec2Client = ec2.NewFromConfig(awsConfig)
 
-	// Create an IAM service client
-	svc := iam.NewFromConfig(awsConfig)
+	if err := resolveIdentity(); err != nil {
+		return err
+	}
+
+	sshKeyName = keyPairName()
+	if *eiceFlag {
+		// ephemeral keys, see setupEICE
+		return nil
+	}
+	return withSetupLock(ensureKeyPair)
+}
 
-	// Get the IAM user information
-	iamResult, err := svc.GetUser(context.TODO(), &iam.GetUserInput{})
+// withSetupLock runs f, which creates the ssh key, key pair or security group of this machine,
+// holding a lock shared by the rbench processes of the machine: the children of -type with
+// several types, rbench shop and rbench fanout set them up concurrently otherwise, and would
+// each create them.
+func withSetupLock(f func() error) error {
+	path := filepath.Join(filepath.Dir(configPath()), "setup.lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
-		return fmt.Errorf("unable to get user, %v", err)
+		return fmt.Errorf("unable to open %s, %v", path, err)
+	}
+	// closing the file releases the lock
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("unable to lock %s, %v", path, err)
 	}
-	awsUserName = *iamResult.User.UserName
+	return f()
+}
 
-	// create key pair
-	awsKeyName = "rbench-" + awsUserName
+// keyPairName returns the name of the EC2 key pair of the user on this machine. The key pair is
+// per machine: the same user can run rbench from several workstations concurrently without
+// sharing a PEM file. It is reused by the runs of the machine, and not deleted at teardown since
+// concurrent runs share it.
+func keyPairName() string {
+	return "rbench-" + awsUserName + "-" + hostName()
+}
 
-	// Create the key pair
-	result, err := ec2Client.CreateKeyPair(context.TODO(), &ec2.CreateKeyPairInput{
-		KeyName: aws.String(awsKeyName),
+// awsFlags registers the aws account flags on a subcommand flag set.
+func awsFlags(fs *flag.FlagSet) {
+	fs.StringVar(awsProfile, "profile", "", "AWS shared config profile to use")
+	fs.StringVar(regionFlag, "region", "", "AWS region (default: the region of the config file, or us-east-2)")
+	fs.StringVar(roleARN, "role-arn", "", "IAM role to assume, e.g. to run in another account")
+	fs.IntVar(awsRetries, "aws-retries", *awsRetries, "maximum number of attempts of throttled or failed AWS API requests")
+	fs.BoolVar(debugAWS, "debug-aws", false, "log every AWS API call to the debug log (-v, -log-file), identical calls once a minute")
+}
+
+// describeInstance returns the description of an instance.
+func describeInstance(instanceID string) (types.Instance, error) {
+	out, err := ec2Client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
 	})
+	if err != nil {
+		return types.Instance{}, fmt.Errorf("unable to describe instance, %v", err)
+	}
+	if len(out.Reservations) == 0 || len(out.Reservations[0].Instances) == 0 {
+		return types.Instance{}, fmt.Errorf("instance %s not found", instanceID)
+	}
+	return out.Reservations[0].Instances[0], nil
+}
 
-	if err == nil {
-		// Save the private key material to a file
-		// privateKeyPath is home directory + .ssh
-		err = os.WriteFile(privateKeyPath(), []byte(*result.KeyMaterial), 0600)
-		if err != nil {
-			return fmt.Errorf("unable to write private key to file, %v", err)
+// instanceRemote returns the ssh destination of a running instance; the user is derived
+// from the AMI if empty.
+func instanceRemote(instanceID, user string) (remote, error) {
+	instance, err := describeInstance(instanceID)
+	if err != nil {
+		return remote{}, err
+	}
+	host, err := instanceAddress(instance)
+	if err != nil {
+		return remote{}, err
+	}
+	if user == "" {
+		user = defaultSSHUser(*instance.ImageId)
+	}
+	return remote{user: user, host: host}, nil
+}
+
+// instanceAddress returns the address to reach the instance at: its public IPv4 address,
+// or its IPv6 address on IPv6-only subnets.
+func instanceAddress(instance types.Instance) (string, error) {
+	if instance.PublicIpAddress != nil {
+		return *instance.PublicIpAddress, nil
+	}
+	if instance.Ipv6Address != nil {
+		return *instance.Ipv6Address, nil
+	}
+	for _, ni := range instance.NetworkInterfaces {
+		for _, addr := range ni.Ipv6Addresses {
+			if addr.Ipv6Address != nil {
+				return *addr.Ipv6Address, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("instance %s has neither a public IPv4 nor an IPv6 address (state: %s)",
+		aws.ToString(instance.InstanceId), instance.State.Name)
+}
+
+// hostName returns the local host name, restricted to characters valid in a key pair name.
+func hostName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	host, _, _ = strings.Cut(host, ".")
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
 		}
+		return '-'
+	}, host)
+}
+
+// ensureSSHKey generates the local ssh key if needed, and reports whether it did.
+func ensureSSHKey() (generated bool, err error) {
+	keyPath := privateKeyPath()
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return false, err
+	}
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", sshKeyName, "-f", keyPath).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("unable to generate ssh key: %s, %v", strings.TrimSpace(string(out)), err)
 	}
-	if err != nil && !strings.Contains(err.Error(), "InvalidKeyPair.Duplicate") {
+	return true, nil
+}
+
+// ensureKeyPair generates the local ssh key if needed and imports its public key in EC2.
+func ensureKeyPair() error {
+	generated, err := ensureSSHKey()
+	if err != nil {
 		return err
 	}
 
+	if !generated {
+		_, err := ec2Client.DescribeKeyPairs(context.TODO(), &ec2.DescribeKeyPairsInput{
+			KeyNames: []string{sshKeyName},
+		})
+		if err == nil {
+			// already imported
+			return nil
+		}
+		if !strings.Contains(err.Error(), "InvalidKeyPair.NotFound") {
+			return fmt.Errorf("unable to describe key pair, %v", err)
+		}
+	} else {
+		// a key pair with the same name may have been imported from a previous key; replace it.
+		_, err := ec2Client.DeleteKeyPair(context.TODO(), &ec2.DeleteKeyPairInput{
+			KeyName: aws.String(sshKeyName),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to delete key pair, %v", err)
+		}
+	}
+
+	publicKey, err := os.ReadFile(privateKeyPath() + ".pub")
+	if err != nil {
+		return fmt.Errorf("unable to read public key, %v", err)
+	}
+	_, err = ec2Client.ImportKeyPair(context.TODO(), &ec2.ImportKeyPairInput{
+		KeyName:           aws.String(sshKeyName),
+		PublicKeyMaterial: publicKey,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeKeyPair,
+				Tags: []types.Tag{
+					{
+						Key:   aws.String("rbench"),
+						Value: aws.String(awsUserName),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to import key pair, %v", err)
+	}
 	return nil
 }
 
@@ -83,6 +342,9 @@ func (a instanceArch) GoString() string {
 	}
 }
 
+// instanceTypeInfo describes -type; set by getInstanceArch.
+var instanceTypeInfo types.InstanceTypeInfo
+
 func getInstanceArch() (arch instanceArch, err error) {
 	// Call DescribeInstanceTypes API
 	describeInstanceTypesInput := &ec2.DescribeInstanceTypesInput{
@@ -96,8 +358,10 @@ func getInstanceArch() (arch instanceArch, err error) {
 		return archUnknown, fmt.Errorf("unable to describe instance types, %v", err)
 	}
 
+	instanceTypeInfo = describeInstanceTypesOutput.InstanceTypes[0]
+
 	// we want to know if it's arm or x86
-	architecture := describeInstanceTypesOutput.InstanceTypes[0].ProcessorInfo.SupportedArchitectures[0]
+	architecture := instanceTypeInfo.ProcessorInfo.SupportedArchitectures[0]
 
 	if architecture == types.ArchitectureTypeArm64 {
 		return archArm, nil
@@ -106,35 +370,163 @@ func getInstanceArch() (arch instanceArch, err error) {
 	return archX86, nil
 }
 
-func startInstance(arch instanceArch) (publicIP, instanceID string, err error) {
+// imageForArch returns the AMI used for the given architecture: the one of the amis section
+// of the configuration (unless -region selects another region), or the current Ubuntu image of
+// the region.
+func imageForArch(arch instanceArch) (string, error) {
+	if c, err := loadConfig(); err == nil && !otherRegion(c) && c["amis"][arch.GoString()] != "" {
+		return c["amis"][arch.GoString()], nil
+	}
+	name := ubuntuAMIParameter(arch)
+	out, err := ssm.NewFromConfig(awsConfig).GetParameter(context.TODO(), &ssm.GetParameterInput{
+		Name: aws.String(name),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to look up the Ubuntu AMI in %s (%s), %v", awsConfig.Region, name, err)
+	}
+	return aws.ToString(out.Parameter.Value), nil
+}
 
-	// 	Ubuntu Server 24.04 LTS (HVM), SSD Volume Type
-	// ami-0ea3c35c5c3284d82 (64-bit (x86)) / ami-01ebf7c0e446f85f9 (64-bit (Arm))
+// ubuntuAMIParameter returns the SSM public parameter of the current Ubuntu Server 24.04 LTS AMI
+// for the architecture, published by Canonical in every region.
+func ubuntuAMIParameter(arch instanceArch) string {
+	return "/aws/service/canonical/ubuntu/server/24.04/stable/current/" + arch.GoString() + "/hvm/ebs-gp3/ami-id"
+}
 
-	const (
-		x86AMI = "ami-0ea3c35c5c3284d82"
-		armAMI = "ami-01ebf7c0e446f85f9"
-	)
+// osImage describes how to find the latest image of an OS.
+type osImage struct {
+	owner   string
+	pattern string // name pattern, with a %s for the architecture
+	x86     string // architecture name in image names
+	arm     string
+	user    string // ssh user
+	static  bool   // musl based, needs a static binary
+}
+
+// osImages are the images selectable with -os.
+var osImages = map[string]osImage{
+	"ubuntu":      {"099720109477", "ubuntu/images/hvm-ssd-gp3/ubuntu-noble-24.04-%s-server-*", "amd64", "arm64", "ubuntu", false},
+	"amazonlinux": {"amazon", "al2023-ami-2023.*-kernel-*-%s", "x86_64", "arm64", "ec2-user", false},
+	"debian":      {"136693071363", "debian-12-%s-*", "amd64", "arm64", "admin", false},
+	"alpine":      {"538276064493", "alpine-3.*-%s-*-cloudinit-*", "x86_64", "aarch64", "alpine", true},
+}
 
-	var ami string
+// latestImage returns the most recent AMI of the OS for the architecture.
+func latestImage(img osImage, arch instanceArch) (string, error) {
+	archName, archType := img.x86, types.ArchitectureValuesX8664
 	if arch == archArm {
-		ami = armAMI
-	} else {
-		ami = x86AMI
+		archName, archType = img.arm, types.ArchitectureValuesArm64
+	}
+	out, err := ec2Client.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+		Owners: []string{img.owner},
+		Filters: []types.Filter{
+			{Name: aws.String("name"), Values: []string{fmt.Sprintf(img.pattern, archName)}},
+			{Name: aws.String("architecture"), Values: []string{string(archType)}},
+			{Name: aws.String("state"), Values: []string{"available"}},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to describe images, %v", err)
+	}
+	var latest types.Image
+	for _, image := range out.Images {
+		if aws.ToString(image.CreationDate) > aws.ToString(latest.CreationDate) {
+			latest = image
+		}
+	}
+	if latest.ImageId == nil {
+		return "", fmt.Errorf("no image found for %s", fmt.Sprintf(img.pattern, archName))
+	}
+	return *latest.ImageId, nil
+}
+
+// resolveTargets returns the instances to run the benchmark on: one per -os image, or a single
+// default Ubuntu instance.
+func resolveTargets(arch instanceArch) ([]target, error) {
+	if *osFlag == "" {
+		ami, err := imageForArch(arch)
+		if err != nil {
+			return nil, err
+		}
+		user := *sshUserFlag
+		if user == "" {
+			user = defaultSSHUser(ami)
+		}
+		return []target{{ami: ami, user: user}}, nil
+	}
+
+	var targets []target
+	for _, name := range strings.Split(*osFlag, ",") {
+		name = strings.TrimSpace(name)
+		img, ok := osImages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown os %q (available: %s)", name, strings.Join(sortedKeys(osImages), ", "))
+		}
+		ami, err := latestImage(img, arch)
+		if err != nil {
+			return nil, err
+		}
+		user := img.user
+		if *sshUserFlag != "" {
+			user = *sshUserFlag
+		}
+		targets = append(targets, target{label: name, ami: ami, user: user, static: img.static})
+	}
+	return targets, nil
+}
+
+// defaultSSHUser returns the login user of the given AMI, derived from its name.
+// it falls back to "ubuntu" if the image can't be described.
+func defaultSSHUser(ami string) string {
+	out, err := ec2Client.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+		ImageIds: []string{ami},
+	})
+	if err != nil || len(out.Images) == 0 || out.Images[0].Name == nil {
+		return "ubuntu"
+	}
+	return sshUserForImageName(*out.Images[0].Name)
+}
+
+func sshUserForImageName(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "ubuntu"):
+		return "ubuntu"
+	case strings.Contains(name, "debian"):
+		return "admin"
+	case strings.Contains(name, "alpine"):
+		return "alpine"
+	case strings.Contains(name, "centos"):
+		return "centos"
+	case strings.Contains(name, "fedora"):
+		return "fedora"
+	case strings.Contains(name, "amzn"), strings.Contains(name, "al2023"),
+		strings.Contains(name, "rhel"), strings.Contains(name, "suse"):
+		return "ec2-user"
+	default:
+		return "ubuntu"
+	}
+}
+
+// startInstance launches an instance and waits until its ssh port is reachable.
+// if ctx is cancelled while waiting, the instance is terminated.
+func startInstance(ctx context.Context, ami string) (publicIP, instanceID string, err error) {
+	var slot instanceSlot
+	if *maxInstances > 0 {
+		if slot, err = acquireInstanceSlot(ctx, *maxInstances); err != nil {
+			return "", "", err
+		}
 	}
 
 	// Define the parameters for the EC2 instance
 	instanceName := fmt.Sprintf("rbench/%s/%s", awsUserName, randString(7))
 
-	runResult, err := ec2Client.RunInstances(context.TODO(), &ec2.RunInstancesInput{
-		ImageId:      aws.String(ami), // Ubuntu Server 24.04 LTS
+	securityGroups := []string{securityGroupID}
+	input := &ec2.RunInstancesInput{
+		ImageId:      aws.String(ami),
 		InstanceType: types.InstanceType(*instanceType),
 		MinCount:     aws.Int32(1),
 		MaxCount:     aws.Int32(1),
-		KeyName:      aws.String(awsKeyName),
-		SecurityGroupIds: []string{
-			"sg-02718b1d52ed88934", // default security group
-		},
 
 		TagSpecifications: []types.TagSpecification{
 			{
@@ -151,18 +543,67 @@ func startInstance(arch instanceArch) (publicIP, instanceID string, err error) {
 				},
 			},
 		},
-	})
+	}
+	if !*eiceFlag {
+		input.KeyName = aws.String(sshKeyName)
+	}
+	switch {
+	case *ipv6Only || *efaFlag || *enaExpress || *eiceFlag:
+		// the interface is specified, with the security group set on it.
+		ni := types.InstanceNetworkInterfaceSpecification{
+			DeviceIndex:              aws.Int32(0),
+			Groups:                   securityGroups,
+			AssociatePublicIpAddress: aws.Bool(!*eiceFlag),
+		}
+		if *subnetFlag != "" {
+			// the default subnet otherwise, with -eice
+			ni.SubnetId = aws.String(*subnetFlag)
+		}
+		if *ipv6Only {
+			// no public IPv4 address
+			ni.Ipv6AddressCount = aws.Int32(1)
+			ni.AssociatePublicIpAddress = aws.Bool(false)
+		}
+		if *efaFlag {
+			ni.InterfaceType = aws.String("efa")
+		}
+		if *enaExpress {
+			ni.EnaSrdSpecification = &types.EnaSrdSpecificationRequest{EnaSrdEnabled: aws.Bool(true)}
+		}
+		input.NetworkInterfaces = []types.InstanceNetworkInterfaceSpecification{ni}
+	case *subnetFlag != "":
+		input.SubnetId = aws.String(*subnetFlag)
+		input.SecurityGroupIds = securityGroups
+	default:
+		input.SecurityGroupIds = securityGroups
+	}
+	if placementGroup != "" {
+		input.Placement = &types.Placement{GroupName: aws.String(placementGroup)}
+	}
+	if *agentFlag {
+		// the agent powers the instance off after -agent-idle
+		input.InstanceInitiatedShutdownBehavior = types.ShutdownBehaviorTerminate
+	}
+	switch *confidential {
+	case "sev-snp":
+		input.CpuOptions = &types.CpuOptionsRequest{AmdSevSnp: types.AmdSevSnpSpecificationEnabled}
+	case "enclave":
+		input.EnclaveOptions = &types.EnclaveOptionsRequest{Enabled: aws.Bool(true)}
+	}
+	instanceID, err = runInstance(ctx, input)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to run instance, %v", err)
+		if slot.key != "" {
+			releaseInstanceSlot(slot)
+		}
+		return "", "", err
 	}
-	if len(runResult.Instances) != 1 {
-		return "", "", fmt.Errorf("expected 1 instance, got %d", len(runResult.Instances))
+	if slot.key != "" {
+		recordSlotInstance(slot, instanceID)
 	}
-	instanceID = *runResult.Instances[0].InstanceId
 
 	// wait for the instance to be running
 	waiter := ec2.NewInstanceRunningWaiter(ec2Client)
-	describeResult, err := waiter.WaitForOutput(context.TODO(), &ec2.DescribeInstancesInput{
+	describeResult, err := waiter.WaitForOutput(ctx, &ec2.DescribeInstancesInput{
 		InstanceIds: []string{instanceID},
 	}, 2*time.Minute)
 
@@ -171,37 +612,99 @@ func startInstance(arch instanceArch) (publicIP, instanceID string, err error) {
 		return "", "", fmt.Errorf("error waiting for instance to be running, %v", err)
 	}
 
-	publicIP = *describeResult.Reservations[0].Instances[0].PublicIpAddress
+	if *eiceFlag {
+		// reached by id, through the endpoint
+		if err := waitForEICEPort(ctx, instanceID); err != nil {
+			terminateInstance(instanceID)
+			return "", "", err
+		}
+		return instanceID, instanceID, nil
+	}
+	publicIP, err = instanceAddress(describeResult.Reservations[0].Instances[0])
+	if err != nil {
+		terminateInstance(instanceID)
+		return "", "", err
+	}
+
+	if err := waitForSSHPort(ctx, publicIP); err != nil {
+		terminateInstance(instanceID)
+		return "", "", err
+	}
+	return publicIP, instanceID, nil
+}
+
+// runInstance launches the instance of input and returns its id. The launch is idempotent: the
+// request has a client token, is retried with the same token, and an instance created by an
+// attempt whose response was lost is adopted (or terminated if ctx was cancelled) instead of leaked.
+func runInstance(ctx context.Context, input *ec2.RunInstancesInput) (string, error) {
+	token := "rbench-" + randomToken()
+	input.ClientToken = aws.String(token)
 
-	// Check if SSH port is accessible
-	timeout := 30 * time.Second
-	for i := 0; i < 5; i++ {
-		conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:22", publicIP), timeout)
+	// on top of the retries of the SDK, which reuse the token too
+	const attempts = 3
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		var out *ec2.RunInstancesOutput
+		out, err = ec2Client.RunInstances(ctx, input)
 		if err == nil {
-			conn.Close()
-			time.Sleep(5 * time.Second)
-			return publicIP, instanceID, nil
+			if len(out.Instances) != 1 {
+				return "", fmt.Errorf("expected 1 instance, got %d", len(out.Instances))
+			}
+			instanceID := *out.Instances[0].InstanceId
+			liveInstances.Store(instanceID, true)
+			runCost.start(instanceID, time.Now())
+			return instanceID, nil
+		}
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			// the request was answered: no instance was created
+			return "", fmt.Errorf("unable to run instance, %v", err)
+		}
+
+		// the request may have succeeded without us getting the response
+		instanceID, lookupErr := instanceByClientToken(token)
+		if lookupErr == nil && instanceID != "" {
+			liveInstances.Store(instanceID, true)
+			runCost.start(instanceID, time.Now())
+			if ctx.Err() != nil {
+				terminateInstance(instanceID)
+				return "", ctx.Err()
+			}
+			slog.Info(fmt.Sprintf("adopting instance %s, launched by a failed attempt", instanceID))
+			return instanceID, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
 		}
-		time.Sleep(5 * time.Second)
+		slog.Debug(fmt.Sprintf("launch attempt %d failed, retrying with the same client token: %v", attempt+1, err))
 	}
+	return "", fmt.Errorf("unable to run instance, %v", err)
+}
 
-	terminateInstance(instanceID)
-	return "", "", fmt.Errorf("unable to connect to instance")
+// randomToken returns a random hex string, unique across processes: randString reseeds with the
+// time, which concurrent launches may share.
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
-func terminateInstance(instanceID string) error {
-	fmt.Printf("terminating instance %s\n", instanceID)
-	_, err := ec2Client.TerminateInstances(context.TODO(), &ec2.TerminateInstancesInput{
-		InstanceIds: []string{instanceID},
+// instanceByClientToken returns the id of the instance launched with the client token, if any.
+func instanceByClientToken(token string) (string, error) {
+	out, err := ec2Client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{{Name: aws.String("client-token"), Values: []string{token}}},
 	})
 	if err != nil {
-		err = fmt.Errorf("unable to terminate instance, %v", err)
-		fmt.Println("error: ", err)
-		return err
+		return "", err
 	}
-	return nil
+	for _, r := range out.Reservations {
+		for _, instance := range r.Instances {
+			return aws.ToString(instance.InstanceId), nil
+		}
+	}
+	return "", nil
 }
 
 func privateKeyPath() string {
-	return os.Getenv("HOME") + "/.ssh/" + awsKeyName + ".pem"
+	return os.Getenv("HOME") + "/.ssh/" + sshKeyName + ".pem"
 }
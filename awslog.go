@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// logAPICalls adds a middleware logging every AWS API call at the debug level (-debug-aws):
+// operation, parameters (secrets redacted), status code, request id, attempts and duration.
+// Identical calls (same operation, parameters, status and error), such as the polls of an
+// instance state, are throttled: one is logged per apiLogWindow, with the number of identical
+// calls since the previous one.
+func logAPICalls(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("rbenchLogAPICalls", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		start := time.Now()
+		out, metadata, err := next.HandleInitialize(ctx, in)
+
+		params := redactParams(in.Parameters)
+		attrs := []any{
+			slog.String("params", params),
+			slog.Duration("duration", time.Since(start).Round(time.Millisecond)),
+		}
+		if results, ok := retry.GetAttemptResults(metadata); ok && len(results.Results) > 1 {
+			attrs = append(attrs, slog.Int("attempts", len(results.Results)))
+		}
+		if id, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+			attrs = append(attrs, slog.String("request-id", id))
+		}
+		status := 0
+		if resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok {
+			status = resp.StatusCode
+		}
+		var respErr *smithyhttp.ResponseError
+		if errors.As(err, &respErr) {
+			status = respErr.HTTPStatusCode()
+		}
+		if status != 0 {
+			attrs = append(attrs, slog.Int("status", status))
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		op := fmt.Sprintf("aws %s.%s", awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx))
+		key := fmt.Sprintf("%s %s %d %v", op, params, status, err)
+		if repeated, ok := apiCalls.log(key, op, time.Now()); ok {
+			if repeated > 0 {
+				attrs = append(attrs, slog.Int("repeated", repeated))
+			}
+			slog.Debug(op, attrs...)
+		}
+		return out, metadata, err
+	}), middleware.Before)
+}
+
+// apiLogWindow is the interval at which identical API calls are logged.
+const apiLogWindow = time.Minute
+
+// apiCallLog throttles the trace of identical API calls.
+type apiCallLog struct {
+	mu    sync.Mutex
+	calls map[string]*apiCall
+}
+
+// apiCall is the trace of the calls with the same key.
+type apiCall struct {
+	op         string    // "aws <service>.<operation>"
+	logged     time.Time // the last time a call was logged
+	suppressed int       // calls not logged since
+}
+
+var apiCalls = &apiCallLog{calls: make(map[string]*apiCall)}
+
+// log reports whether the call of op with key, at now, must be logged, with the number of identical
+// calls that weren't since the previous one.
+func (l *apiCallLog) log(key, op string, now time.Time) (repeated int, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.calls[key]
+	if c == nil {
+		c = &apiCall{op: op}
+		l.calls[key] = c
+	} else if now.Sub(c.logged) < apiLogWindow {
+		c.suppressed++
+		return 0, false
+	}
+	repeated, c.suppressed, c.logged = c.suppressed, 0, now
+	return repeated, true
+}
+
+// flush logs the number of identical calls not logged since their last trace, at the end of the
+// run.
+func (l *apiCallLog) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range sortedKeys(l.calls) {
+		if c := l.calls[key]; c.suppressed > 0 {
+			slog.Debug(c.op, slog.Int("repeated", c.suppressed))
+			c.suppressed = 0
+		}
+	}
+}
+
+// redactedFields are the parameters never logged, matched case insensitively on the field name.
+var redactedFields = []string{"secret", "token", "password", "userdata", "credentials", "privatekey"}
+
+// redactParams returns the JSON encoding of the parameters of an API call, with secret fields redacted.
+func redactParams(params any) string {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Sprintf("%T", params)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return string(data)
+	}
+	data, _ = json.Marshal(redact(v))
+	return string(data)
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, field := range v {
+			lower := strings.ToLower(k)
+			secret := false
+			for _, r := range redactedFields {
+				if strings.Contains(lower, r) {
+					secret = true
+					break
+				}
+			}
+			if secret && field != nil {
+				v[k] = "REDACTED"
+			} else {
+				v[k] = redact(field)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+func TestRedactParams(t *testing.T) {
+	out := redactParams(&ec2.RunInstancesInput{
+		ImageId:  aws.String("ami-123"),
+		UserData: aws.String("#!/bin/sh\nexport TOKEN=x"),
+	})
+	if !strings.Contains(out, `"ImageId":"ami-123"`) || strings.Contains(out, "TOKEN") {
+		t.Errorf("unexpected params %s", out)
+	}
+	out = redactParams(&sts.AssumeRoleInput{RoleArn: aws.String("arn"), TokenCode: aws.String("123456")})
+	if strings.Contains(out, "123456") {
+		t.Errorf("token not redacted: %s", out)
+	}
+}
+
+func TestAPICallLog(t *testing.T) {
+	l := &apiCallLog{calls: make(map[string]*apiCall)}
+	start := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	const poll = "aws EC2.DescribeInstances {} 200 <nil>"
+	if _, ok := l.log(poll, "aws EC2.DescribeInstances", start); !ok {
+		t.Fatal("the first call isn't logged")
+	}
+	for i := 1; i <= 5; i++ {
+		if _, ok := l.log(poll, "aws EC2.DescribeInstances", start.Add(time.Duration(i)*5*time.Second)); ok {
+			t.Fatalf("identical call %d logged", i)
+		}
+	}
+	// another call isn't throttled by the polls
+	if _, ok := l.log("aws EC2.TerminateInstances {} 200 <nil>", "aws EC2.TerminateInstances", start.Add(30*time.Second)); !ok {
+		t.Error("a different call isn't logged")
+	}
+	if repeated, ok := l.log(poll, "aws EC2.DescribeInstances", start.Add(apiLogWindow)); !ok || repeated != 5 {
+		t.Errorf("log after the window = %d, %t, expected 5 repeated calls", repeated, ok)
+	}
+}
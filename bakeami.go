@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// bakeAMICmd implements "rbench bake-ami": an instance of the image of the runs is set up with a
+// script (tools, packages, kernel settings), then imaged. The AMI goes in the amis section of the
+// configuration, typically the team one, so that the runs skip the setup.
+func bakeAMICmd(args []string) error {
+	fs := flag.NewFlagSet("bake-ami", flag.ExitOnError)
+	fs.StringVar(instanceType, "type", *instanceType, "instance type of the setup; the AMI is for its architecture")
+	setupFile := fs.String("setup", "", "shell script run as root on the instance before imaging it (required)")
+	name := fs.String("name", "", "name of the AMI (default: rbench/<user>/<architecture>/<date>)")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench bake-ami -setup <script> [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *setupFile == "" {
+		fs.Usage()
+		return fmt.Errorf("expected a -setup script")
+	}
+	script, err := os.ReadFile(*setupFile)
+	if err != nil {
+		return err
+	}
+
+	if err := initAWS(); err != nil {
+		return err
+	}
+	arch, err := getInstanceArch()
+	if err != nil {
+		return err
+	}
+	// on top of the image of the amis section, if any
+	base, err := imageForArch(arch)
+	if err != nil {
+		return err
+	}
+	if err := withSetupLock(ensureSecurityGroup); err != nil {
+		return err
+	}
+	if *name == "" {
+		*name = fmt.Sprintf("rbench/%s/%s/%s", awsUserName, arch.GoString(), time.Now().UTC().Format("20060102-150405"))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	defer terminateAllInstances()
+	statusf("launching a %s instance of %s...", *instanceType, base)
+	host, id, err := startInstance(ctx, base)
+	if err != nil {
+		return err
+	}
+	r := remote{user: defaultSSHUser(base), host: host}
+	statusf("running %s on %s...", *setupFile, id)
+	out, err := sshRun(r, "sudo sh -c "+shellQuote(string(script)))
+	stderrTerminal.clearStatus()
+	fmt.Print(out)
+	if err != nil {
+		return fmt.Errorf("%s failed, %v", *setupFile, err)
+	}
+
+	// the instance is rebooted for a consistent file system image
+	statusf("imaging %s...", id)
+	image, err := ec2Client.CreateImage(ctx, &ec2.CreateImageInput{
+		InstanceId:  aws.String(id),
+		Name:        aws.String(*name),
+		Description: aws.String("rbench image of " + base + ", set up with " + *setupFile),
+		TagSpecifications: []types.TagSpecification{
+			{ResourceType: types.ResourceTypeImage, Tags: []types.Tag{{Key: aws.String("rbench"), Value: aws.String(awsUserName)}}},
+			{ResourceType: types.ResourceTypeSnapshot, Tags: []types.Tag{{Key: aws.String("rbench"), Value: aws.String(awsUserName)}}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the image, %v", err)
+	}
+	ami := aws.ToString(image.ImageId)
+	statusf("waiting for %s to be available...", ami)
+	waiter := ec2.NewImageAvailableWaiter(ec2Client)
+	if err := waiter.Wait(ctx, &ec2.DescribeImagesInput{ImageIds: []string{ami}}, 45*time.Minute); err != nil {
+		return fmt.Errorf("error waiting for image %s, %v", ami, err)
+	}
+	stderrTerminal.clearStatus()
+	fmt.Printf("%s (%s) is available; to use it, add to %s (or the team configuration):\n\namis:\n  %s: %s\n",
+		ami, *name, configPath(), arch.GoString(), ami)
+	return nil
+}
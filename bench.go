@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchResult is a parsed benchmark result line, e.g.
+//
+//	BenchmarkFoo-8   	 1000000	      1234 ns/op	  16 B/op	   1 allocs/op
+type benchResult struct {
+	name       string
+	iterations int64
+	values     []benchValue
+	received   time.Time // when the line was received locally
+}
+
+type benchValue struct {
+	value float64
+	unit  string
+}
+
+// parseBenchLine parses a benchmark result line; it returns false if line isn't one.
+func parseBenchLine(line string) (benchResult, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return benchResult{}, false
+	}
+	iterations, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return benchResult{}, false
+	}
+	r := benchResult{name: fields[0], iterations: iterations}
+	for i := 2; i < len(fields); i += 2 {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return benchResult{}, false
+		}
+		r.values = append(r.values, benchValue{value: v, unit: fields[i+1]})
+	}
+	return r, true
+}
+
+// value returns the value of the given unit, if reported.
+func (r benchResult) value(unit string) (float64, bool) {
+	for _, v := range r.values {
+		if v.unit == unit {
+			return v.value, true
+		}
+	}
+	return 0, false
+}
+
+// benchResults collects the results of the streamed benchmark output.
+// it implements io.Writer so it can be plugged next to os.Stdout.
+type benchResults struct {
+	mu      sync.Mutex
+	partial []byte
+	names   []string // in order of appearance
+	samples map[string][]benchResult
+	gogc    string // of the last gogc configuration line (-gogc), naming the next results
+
+	// onResult, if set, is called with the name of each new result.
+	onResult func(name string)
+}
+
+func newBenchResults() *benchResults {
+	return &benchResults{samples: make(map[string][]benchResult)}
+}
+
+func (r *benchResults) Write(p []byte) (int, error) {
+	var parsed []string
+	defer func() {
+		if r.onResult != nil {
+			for _, name := range parsed {
+				r.onResult(name)
+			}
+		}
+	}()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.partial = append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(r.partial[:i])
+		r.partial = r.partial[i+1:]
+		if m := configLineRegexp.FindStringSubmatch(line); m != nil && m[1] == "gogc" {
+			r.gogc = strings.TrimSpace(m[2])
+		}
+		if res, ok := parseBenchLine(line); ok {
+			if r.gogc != "" {
+				res.name = variantName(res.name, "gogc", r.gogc)
+			}
+			res.received = time.Now()
+			if _, seen := r.samples[res.name]; !seen {
+				r.names = append(r.names, res.name)
+			}
+			r.samples[res.name] = append(r.samples[res.name], res)
+			parsed = append(parsed, res.name)
+		}
+	}
+	return len(p), nil
+}
+
+// add appends samples of a benchmark.
+func (r *benchResults) add(name string, samples []benchResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, seen := r.samples[name]; !seen {
+		r.names = append(r.names, name)
+	}
+	r.samples[name] = append(r.samples[name], samples...)
+}
+
+// variantName names the results of a benchmark in a variant of the run, like benchstat names
+// the sub-benchmarks: BenchmarkSign-2 with gogc=off is BenchmarkSign/gogc=off-2.
+func variantName(name, key, value string) string {
+	base := trimProcs(name)
+	return base + "/" + key + "=" + value + name[len(base):]
+}
+
+// values returns the reported values of a benchmark for the given unit.
+func (r *benchResults) values(name, unit string) []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var values []float64
+	for _, res := range r.samples[name] {
+		if v, ok := res.value(unit); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// units returns the units reported by the benchmarks, in order of appearance: ns/op first,
+// then the B/op, allocs/op and b.ReportMetric ones.
+func (r *benchResults) units() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var units []string
+	seen := make(map[string]bool)
+	for _, name := range r.names {
+		for _, res := range r.samples[name] {
+			for _, v := range res.values {
+				if !seen[v.unit] {
+					seen[v.unit] = true
+					units = append(units, v.unit)
+				}
+			}
+		}
+	}
+	return units
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), values...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+// geomean returns the geometric mean of positive values; benchstat summarizes tables the same way.
+func geomean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		if v <= 0 {
+			return 0
+		}
+		sum += math.Log(v)
+	}
+	return math.Exp(sum / float64(len(values)))
+}
+
+// spread returns the largest deviation from the median, in percent of the median.
+func spread(values []float64) float64 {
+	m := median(values)
+	if m == 0 {
+		return 0
+	}
+	var d float64
+	for _, v := range values {
+		d = math.Max(d, math.Abs(v-m))
+	}
+	return 100 * d / m
+}
+
+// printNoiseReport flags benchmarks whose run-to-run spread (ns/op) exceeds threshold percent,
+// and the ones whose noise exceeds their typical change between commits in the history (noise, by
+// benchmark, may be nil); deltas of such benchmarks between two runs are unreliable.
+func printNoiseReport(w io.Writer, results *benchResults, threshold float64, noise map[string]benchNoise) {
+	for _, name := range results.names {
+		values := results.values(name, "ns/op")
+		if n := noise[name]; n.noisy() {
+			fmt.Fprintln(w, noiseHint(name, n))
+			continue
+		}
+		if len(values) < 2 {
+			continue
+		}
+		if s := spread(values); s > threshold {
+			fmt.Fprintf(w, "noisy — deltas unreliable: %s ±%.1f%% over %d runs; consider a higher -count\n", name, s, len(values))
+		}
+	}
+}
+
+// noiseHint explains why the history flags a benchmark as noisy, with the -count that would make
+// its deltas meaningful.
+func noiseHint(name string, n benchNoise) string {
+	return fmt.Sprintf("noisy — deltas unreliable: %s ±%.1f%% run to run, over its typical change of %.1f%% between commits in the history; consider -count=%d",
+		name, n.run, n.commit, n.count(*countFlag))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseBenchLine(t *testing.T) {
+	r, ok := parseBenchLine("BenchmarkFoo-8   	 1000000	      1234 ns/op	  16 B/op	   1 allocs/op")
+	if !ok {
+		t.Fatal("expected a benchmark line")
+	}
+	if r.name != "BenchmarkFoo-8" || r.iterations != 1000000 || len(r.values) != 3 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	if v, _ := r.value("B/op"); v != 16 {
+		t.Fatalf("expected 16 B/op, got %v", v)
+	}
+
+	for _, l := range []string{
+		"goos: linux",
+		"BenchmarkFoo-8",
+		"BenchmarkFoo-8   	 --- FAIL",
+		"PASS",
+	} {
+		if _, ok := parseBenchLine(l); ok {
+			t.Errorf("%q parsed as a benchmark line", l)
+		}
+	}
+}
+
+func TestBenchResultsWrite(t *testing.T) {
+	results := newBenchResults()
+	for i := 0; i < 3; i++ {
+		// split writes mid-line, as the ssh stream does.
+		fmt.Fprintf(results, "BenchmarkA-2 100 %d ns/", 10+i)
+		fmt.Fprintf(results, "op\nBenchmarkB-2 100 5 ns/op\n")
+	}
+	if len(results.names) != 2 || results.names[0] != "BenchmarkA-2" {
+		t.Fatalf("unexpected names %v", results.names)
+	}
+	values := results.values("BenchmarkA-2", "ns/op")
+	if median(values) != 11 {
+		t.Fatalf("expected median 11, got %v", median(values))
+	}
+	if s := spread(values); s < 9 || s > 9.1 {
+		t.Fatalf("expected spread ~9.09%%, got %v", s)
+	}
+}
+
+func TestBenchResultsUnits(t *testing.T) {
+	results := newBenchResults()
+	fmt.Fprintf(results, "BenchmarkA-2 1000 10 ns/op 120.5 MB/s\n")
+	fmt.Fprintf(results, "BenchmarkB-2 1000 5 ns/op 3 allocs/op 2e+06 constraints/s\n")
+	units := results.units()
+	if strings.Join(units, " ") != "ns/op MB/s allocs/op constraints/s" {
+		t.Fatalf("unexpected units %v", units)
+	}
+	if v := results.values("BenchmarkB-2", "constraints/s"); len(v) != 1 || v[0] != 2e6 {
+		t.Fatalf("unexpected custom metric values %v", v)
+	}
+}
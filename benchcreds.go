@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// -bench-policy gives the benchmark AWS credentials limited to the resources it declares, instead
+// of an instance profile: before the benchmark, rbench assumes -bench-role with the policy as
+// session policy (the credentials get the intersection of the role and the policy) for
+// -bench-creds-ttl, and writes them to remoteBenchCreds on the instance, sourced by the commands
+// running the test binary (warmup, benchmark, timed tests). The file is removed at the end of the
+// run. STS credentials can't be revoked one by one: they stay valid until they expire, which is
+// why their lifetime is short. The session name is the instance id (the run id on a persistent
+// host), to trace their use in CloudTrail.
+
+// remoteBenchCreds is the environment file of the credentials on the instance, in the directory
+// of the run on a shared host.
+func remoteBenchCreds() string {
+	return remoteTmp() + "/rbench-aws-env"
+}
+
+// maxSessionPolicy is the maximum size of a session policy, without whitespace.
+const maxSessionPolicy = 2048
+
+// benchPolicyDoc is the session policy of -bench-policy, compacted.
+var benchPolicyDoc string
+
+// loadBenchPolicy reads the policy of -bench-policy.
+func loadBenchPolicy(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read -bench-policy, %v", err)
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, data); err != nil {
+		return "", fmt.Errorf("-bench-policy %s: invalid JSON, %v", path, err)
+	}
+	if b.Len() > maxSessionPolicy {
+		return "", fmt.Errorf("-bench-policy %s: %d characters, the limit of session policies is %d", path, b.Len(), maxSessionPolicy)
+	}
+	return b.String(), nil
+}
+
+// invalidSessionChars are the characters not allowed in a role session name.
+var invalidSessionChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// benchSessionName returns the role session name of the credentials of a run on id, the run id
+// without an instance.
+func benchSessionName(id string) string {
+	if id == "" {
+		id = "run-" + remoteRunID
+	}
+	name := "rbench-" + invalidSessionChars.ReplaceAllString(id, "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// mintBenchCredentials assumes -bench-role, restricted to the policy of -bench-policy, for the
+// run on id.
+func mintBenchCredentials(id string, ttl time.Duration) (types.Credentials, error) {
+	out, err := sts.NewFromConfig(awsConfig).AssumeRole(context.TODO(), &sts.AssumeRoleInput{
+		RoleArn:         aws.String(*benchRole),
+		RoleSessionName: aws.String(benchSessionName(id)),
+		Policy:          aws.String(benchPolicyDoc),
+		DurationSeconds: aws.Int32(int32(ttl.Seconds())),
+	})
+	if err != nil {
+		return types.Credentials{}, fmt.Errorf("unable to assume %s for the benchmark, %v", *benchRole, err)
+	}
+	return *out.Credentials, nil
+}
+
+// benchCredsEnv returns the environment file of the credentials.
+func benchCredsEnv(creds types.Credentials, region string) string {
+	var b strings.Builder
+	for _, kv := range [][2]string{
+		{"AWS_ACCESS_KEY_ID", aws.ToString(creds.AccessKeyId)},
+		{"AWS_SECRET_ACCESS_KEY", aws.ToString(creds.SecretAccessKey)},
+		{"AWS_SESSION_TOKEN", aws.ToString(creds.SessionToken)},
+		{"AWS_REGION", region},
+		{"AWS_DEFAULT_REGION", region},
+	} {
+		fmt.Fprintf(&b, "export %s=%s\n", kv[0], shellQuote(kv[1]))
+	}
+	return b.String()
+}
+
+// setupBenchCredentials mints the credentials of the run on id and copies them to r. The file is
+// only readable by the user of the benchmark; the credentials never appear in a command line,
+// the audit log included.
+func setupBenchCredentials(t target, r remote, id string) error {
+	t.status("minting the credentials of the benchmark...")
+	creds, err := mintBenchCredentials(id, *benchCredsTTL)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", "rbench-aws-env-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(benchCredsEnv(creds, awsConfig.Region)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// scp keeps the 0600 mode of the temporary file
+	if err := scpCopy("upload credentials", f.Name(), r.String()+":"+remoteBenchCreds()); err != nil {
+		return fmt.Errorf("unable to copy the credentials of the benchmark, %v", err)
+	}
+	slog.Debug(fmt.Sprintf("%sbenchmark credentials of %s expire at %s", t.prefix(), *benchRole, aws.ToTime(creds.Expiration).Local().Format(time.TimeOnly)))
+	return nil
+}
+
+// removeBenchCredentials removes the credentials from r at the end of the run: a kept instance
+// doesn't keep them.
+func removeBenchCredentials(t target, r remote) {
+	if _, err := sshRunOnce(r, "rm -f "+remoteBenchCreds()); err != nil {
+		slog.Warn(fmt.Sprintf("%sunable to remove the credentials of the benchmark, they expire in at most %s: %v", t.prefix(), *benchCredsTTL, err))
+	}
+}
+
+// benchCredsPrefix returns the shell command prefix loading the credentials of the benchmark,
+// with -bench-policy.
+func benchCredsPrefix() string {
+	if *benchPolicy == "" {
+		return ""
+	}
+	return ". " + remoteBenchCreds() + " && "
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+func TestLoadBenchPolicy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	doc, err := loadBenchPolicy(write("policy.json", `{
+  "Version": "2012-10-17",
+  "Statement": [{"Effect": "Allow", "Action": ["s3:GetObject"], "Resource": ["arn:aws:s3:::bench-data/*"]}]
+}`))
+	if err != nil || doc != `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bench-data/*"]}]}` {
+		t.Errorf("loadBenchPolicy = %q, %v", doc, err)
+	}
+	if _, err := loadBenchPolicy(write("invalid.json", `{"Version":`)); err == nil {
+		t.Error("expected an error on invalid JSON")
+	}
+	if _, err := loadBenchPolicy(write("large.json", `{"Sid":"`+strings.Repeat("x", maxSessionPolicy)+`"}`)); err == nil {
+		t.Error("expected an error on a policy above the limit")
+	}
+}
+
+func TestBenchCredsEnv(t *testing.T) {
+	creds := types.Credentials{
+		AccessKeyId:     aws.String("ASIAEXAMPLE"),
+		SecretAccessKey: aws.String("se'cr/et+"),
+		SessionToken:    aws.String("token=="),
+	}
+	path := filepath.Join(t.TempDir(), "env")
+	if err := os.WriteFile(path, []byte(benchCredsEnv(creds, "us-east-2")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("sh", "-c", ". "+path+` && sh -c 'echo "$AWS_ACCESS_KEY_ID $AWS_SECRET_ACCESS_KEY $AWS_SESSION_TOKEN $AWS_REGION"'`).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "ASIAEXAMPLE se'cr/et+ token== us-east-2" {
+		t.Errorf("the benchmark sees %q", got)
+	}
+}
+
+func TestBenchSessionName(t *testing.T) {
+	if n := benchSessionName("i-0123456789abcdef0"); n != "rbench-i-0123456789abcdef0" {
+		t.Errorf("benchSessionName = %q", n)
+	}
+	if n := benchSessionName("projects/x/zones/y:" + strings.Repeat("z", 80)); len(n) != 64 || strings.ContainsAny(n, "/:") {
+		t.Errorf("benchSessionName = %q, expected 64 valid characters", n)
+	}
+	defer func(id string) { remoteRunID = id }(remoteRunID)
+	remoteRunID = "abcdefg"
+	if n := benchSessionName(""); n != "rbench-run-abcdefg" {
+		t.Errorf("benchSessionName = %q, expected the run id", n)
+	}
+}
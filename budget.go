@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// prioritize orders benchmarks by the position of the first -bench alternative
+// ("A|B|C") they match; ties keep the source order.
+func prioritize(benchmarks []string, bench string) []string {
+	topLevel, _, _ := strings.Cut(bench, "/")
+	var alternatives []*regexp.Regexp
+	for _, alt := range strings.Split(topLevel, "|") {
+		if re, err := regexp.Compile(alt); err == nil {
+			alternatives = append(alternatives, re)
+		}
+	}
+	rank := func(name string) int {
+		for i, re := range alternatives {
+			if re.MatchString(name) {
+				return i
+			}
+		}
+		return len(alternatives)
+	}
+	sorted := append([]string(nil), benchmarks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return rank(sorted[i]) < rank(sorted[j]) })
+	return sorted
+}
+
+// runWithBudget runs the benchmarks one at a time, -count rounds over all of them in priority
+// order, as long as they fit in -budget-time (estimated from their previous run, see
+// estimateDuration). it reports the runs that were skipped.
+func runWithBudget(r remote, out io.Writer, results *benchResults, benchmarks []string) error {
+	deadline := time.Now().Add(*budgetTime)
+	benchmarks = prioritize(benchmarks, *benchFlag)
+
+	// keep the sub-benchmark part of the pattern, if any
+	_, sub, hasSub := strings.Cut(*benchFlag, "/")
+
+	lastDuration := make(map[string]time.Duration)
+	skipped := make(map[string]int)
+	rounds := 0
+	for round := 0; round < *countFlag; round++ {
+		complete := true
+		for _, name := range benchmarks {
+			if finishing.Load() || interrupted.Load() {
+				// stopped on interrupt, after the current benchmark
+				return nil
+			}
+			if time.Now().Add(estimateDuration(name, lastDuration)).After(deadline) {
+				skipped[name]++
+				complete = false
+				continue
+			}
+			pattern := "^" + name + "$"
+			if hasSub {
+				pattern += "/" + sub
+			}
+			start := time.Now()
+			if err := sshExec(r, out, results, pattern, 1); err != nil {
+				if continueAfterHang(err) {
+					continue
+				}
+				return err
+			}
+			lastDuration[name] = time.Since(start)
+		}
+		if complete {
+			rounds++
+		}
+	}
+
+	if len(skipped) == 0 {
+		slog.Info(fmt.Sprintf("budget: all %d repetitions completed", *countFlag))
+		return nil
+	}
+	var s []string
+	for _, name := range benchmarks {
+		if n := skipped[name]; n > 0 {
+			s = append(s, fmt.Sprintf("%s (%d/%d)", name, n, *countFlag))
+		}
+	}
+	slog.Warn(fmt.Sprintf("budget: %d/%d complete repetitions; skipped runs: %s", rounds, *countFlag, strings.Join(s, ", ")))
+	return nil
+}
+
+// estimateDuration returns the expected duration of a run of the benchmark: its previous run,
+// or before its first one the longest run so far, or -benchtime before any run (each benchmark
+// takes at least -benchtime, unless it's a number of iterations).
+func estimateDuration(name string, lastDuration map[string]time.Duration) time.Duration {
+	if d, ok := lastDuration[name]; ok {
+		return d
+	}
+	var longest time.Duration
+	for _, d := range lastDuration {
+		longest = max(longest, d)
+	}
+	if longest > 0 {
+		return longest
+	}
+	if *benchTime == "" {
+		// the default of go test
+		return time.Second
+	}
+	d, _ := time.ParseDuration(*benchTime)
+	return d
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPrioritize(t *testing.T) {
+	benchmarks := []string{"BenchmarkA", "BenchmarkB1", "BenchmarkC", "BenchmarkB2"}
+	got := prioritize(benchmarks, "C$|B[12]|.")
+	want := []string{"BenchmarkC", "BenchmarkB1", "BenchmarkB2", "BenchmarkA"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestEstimateDuration(t *testing.T) {
+	defer func(v string) { *benchTime = v }(*benchTime)
+	*benchTime = "3s"
+	last := map[string]time.Duration{}
+	if d := estimateDuration("BenchmarkA", last); d != 3*time.Second {
+		t.Errorf("before any run: got %s, want the -benchtime", d)
+	}
+	*benchTime = "100x"
+	if d := estimateDuration("BenchmarkA", last); d != 0 {
+		t.Errorf("before any run with a number of iterations: got %s, want 0", d)
+	}
+	last["BenchmarkA"] = 5 * time.Second
+	last["BenchmarkB"] = 8 * time.Second
+	if d := estimateDuration("BenchmarkA", last); d != 5*time.Second {
+		t.Errorf("got %s, want the previous run", d)
+	}
+	if d := estimateDuration("BenchmarkC", last); d != 8*time.Second {
+		t.Errorf("before its first run: got %s, want the longest run", d)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// a bundle (-bundle) is a zstd-compressed tar of the artifacts of a run, starting with a
+// manifest.json index.
+const (
+	bundleExt      = ".rbench"
+	bundleManifest = "manifest.json"
+)
+
+// manifest is the index of a bundle.
+type manifest struct {
+	Commit   string          `json:"commit"`
+	RunStamp string          `json:"runstamp"`
+	Args     []string        `json:"args"`
+	Cost     float64         `json:"cost,omitempty"` // of the instances, in USD (on-demand price times uptime)
+	Quality  []targetQuality `json:"quality,omitempty"`
+	Files    []bundleEntry   `json:"files"`
+}
+
+type bundleEntry struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"` // output, json, coverage, cpuprofile, provenance or log
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// artifact is a file of a bundle.
+type artifact struct {
+	name, kind string
+	data       []byte
+}
+
+// lockedBuilder is a strings.Builder safe for concurrent use.
+type lockedBuilder struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (l *lockedBuilder) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *lockedBuilder) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.String()
+}
+
+// writeBundle bundles the artifacts of the run into path.
+func writeBundle(path string, info runInfo) error {
+	m, files := bundleArtifacts(info)
+	if err := createBundle(path, m, files); err != nil {
+		return err
+	}
+	slog.Info("bundle written to " + path)
+	return nil
+}
+
+// bundleArtifacts returns the manifest and the artifacts of the run.
+func bundleArtifacts(info runInfo) (manifest, []artifact) {
+	output := runOutput.String()
+	files := []artifact{{"output.txt", "output", []byte(output)}}
+	if *jsonFlag {
+		files[0] = artifact{"output.json", "json", []byte(output)}
+	}
+	for _, a := range []struct{ path, kind string }{
+		{*coverProfile, "coverage"},
+		{*cpuProfile, "cpuprofile"},
+		{*provenanceFile, "provenance"},
+		{*logFile, "log"},
+	} {
+		if a.path == "" {
+			continue
+		}
+		data, err := os.ReadFile(a.path)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("%s not bundled, %v", a.path, err))
+			continue
+		}
+		files = append(files, artifact{a.kind + "/" + filepath.Base(a.path), a.kind, data})
+	}
+	if audit := auditJSONL(); len(audit) > 0 {
+		files = append(files, artifact{"audit/audit.jsonl", "audit", audit})
+	}
+
+	m := manifest{Commit: info.commitID, RunStamp: info.runStamp, Args: os.Args[1:]}
+	m.Cost, _ = runCost.cost(time.Now())
+	runQuality.Lock()
+	m.Quality = append([]targetQuality(nil), runQuality.targets...)
+	runQuality.Unlock()
+	return m, files
+}
+
+// createBundle writes the manifest, completed with the index of files, and the files to path.
+func createBundle(path string, m manifest, files []artifact) error {
+	for _, f := range files {
+		sum := sha256.Sum256(f.data)
+		m.Files = append(m.Files, bundleEntry{Name: f.name, Kind: f.kind, Size: len(f.data), SHA256: hex.EncodeToString(sum[:])})
+	}
+	index, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create bundle, %v", err)
+	}
+	defer out.Close()
+	zw, err := zstd.NewWriter(out)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+	for _, f := range append([]artifact{{name: bundleManifest, data: index}}, files...) {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data))}); err != nil {
+			return fmt.Errorf("unable to write bundle, %v", err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return fmt.Errorf("unable to write bundle, %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("unable to write bundle, %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("unable to write bundle, %v", err)
+	}
+	return out.Close()
+}
+
+// readBundle returns the manifest and the files of a bundle, by name.
+func readBundle(path string) (manifest, map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifest{}, nil, err
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return manifest{}, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	defer zr.Close()
+
+	var m manifest
+	files := make(map[string][]byte)
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest{}, nil, fmt.Errorf("%s: invalid bundle, %v", path, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return manifest{}, nil, fmt.Errorf("%s: invalid bundle, %v", path, err)
+		}
+		files[h.Name] = data
+	}
+	if err := json.Unmarshal(files[bundleManifest], &m); err != nil {
+		return manifest{}, nil, fmt.Errorf("%s: invalid bundle manifest, %v", path, err)
+	}
+	delete(files, bundleManifest)
+	return m, files, nil
+}
+
+// openResults opens saved results: a text output, or the output of a bundle.
+func openResults(path string) (io.ReadCloser, error) {
+	if !strings.HasSuffix(path, bundleExt) {
+		return os.Open(path)
+	}
+	m, files, err := readBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range m.Files {
+		if e.Kind == "output" || e.Kind == "json" {
+			return io.NopCloser(strings.NewReader(string(files[e.Name]))), nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no output in bundle", path)
+}
+
+// bundleCmd implements "rbench bundle <bundle> [file]": lists the content of a bundle, or
+// prints one of its files (e.g. rbench bundle run.rbench output.txt | benchstat /dev/stdin).
+func bundleCmd(args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench bundle <bundle> [file]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return fmt.Errorf("expected a bundle")
+	}
+	m, files, err := readBundle(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 2 {
+		data, ok := files[fs.Arg(1)]
+		if !ok {
+			return fmt.Errorf("no file %s in %s", fs.Arg(1), fs.Arg(0))
+		}
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	fmt.Printf("commit: %s\nrunstamp: %s\nargs: %s\n", m.Commit, m.RunStamp, strings.Join(m.Args, " "))
+	if m.Cost > 0 {
+		fmt.Printf("cost: ~%s\n", formatCost(m.Cost))
+	}
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "FILE\tKIND\tSIZE\tSHA256\n")
+	for _, e := range m.Files {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", e.Name, e.Kind, e.Size, e.SHA256[:12])
+	}
+	return tw.Flush()
+}
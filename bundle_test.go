@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.rbench")
+	const output = "commit: abc\nBenchmarkA-2 100 5 ns/op\n"
+	files := []artifact{
+		{"output.txt", "output", []byte(output)},
+		{"coverage/cover.out", "coverage", []byte("mode: set\n")},
+	}
+	if err := createBundle(path, manifest{Commit: "abc", Args: []string{"-bench=."}}, files); err != nil {
+		t.Fatal(err)
+	}
+
+	m, got, err := readBundle(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Commit != "abc" || len(m.Files) != 2 || m.Files[1].Kind != "coverage" || m.Files[1].Size != 10 {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	if string(got["coverage/cover.out"]) != "mode: set\n" {
+		t.Errorf("unexpected coverage %q", got["coverage/cover.out"])
+	}
+
+	r, err := openResults(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	if string(data) != output {
+		t.Errorf("unexpected output %q", data)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// the calibration suite qualifies a machine with a few microbenchmarks of its memory bandwidth,
+// single core integer throughput and syscall latency. rbench calibrate stores its results per
+// instance type (or host) as the reference of the type; -calibrate runs it on the instance before
+// the benchmarks, next to their results, and flags the hosts slower than the reference.
+
+// calibrationSuite is the test file of the suite, in a module of its own.
+const calibrationSuite = `package calibrate
+
+import (
+	"syscall"
+	"testing"
+)
+
+var sink uint64
+
+// BenchmarkCalibrateMemory copies a buffer larger than the caches.
+func BenchmarkCalibrateMemory(b *testing.B) {
+	src := make([]byte, 64<<20)
+	dst := make([]byte, len(src))
+	for i := range src {
+		src[i] = byte(i)
+	}
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(dst, src)
+	}
+}
+
+// BenchmarkCalibrateALU runs a dependent chain of shifts, xors and multiplications.
+func BenchmarkCalibrateALU(b *testing.B) {
+	x := uint64(88172645463325252)
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			x ^= x << 13
+			x ^= x >> 7
+			x ^= x << 17
+			x *= 0x9e3779b97f4a7c15
+		}
+	}
+	sink = x
+}
+
+// BenchmarkCalibrateSyscall measures a round trip to the kernel.
+func BenchmarkCalibrateSyscall(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		syscall.Getppid()
+	}
+}
+`
+
+// calibrationTolerance is the slowdown from the reference, in percent, above which a host is
+// flagged as abnormal.
+const calibrationTolerance = 10
+
+// remoteCalibration is the test binary of the suite on the instance.
+func remoteCalibration() string {
+	return remoteTmp() + "/rbench-calibrate"
+}
+
+// writeCalibrationSuite writes the module of the suite in a temporary directory.
+func writeCalibrationSuite() (string, error) {
+	dir, err := os.MkdirTemp("", "rbench-calibrate-")
+	if err != nil {
+		return "", err
+	}
+	for name, content := range map[string]string{
+		"go.mod":            "module rbench/calibrate\n\ngo 1.21\n",
+		"calibrate_test.go": calibrationSuite,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// buildCalibration cross compiles the test binary of the suite; it is static, whatever the target.
+func buildCalibration(arch instanceArch) (string, error) {
+	dir, err := writeCalibrationSuite()
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	fileName := "/tmp/rbench-calibrate-" + randString(7)
+	cmd := exec.Command("go", "test", "-c", "-o", fileName, ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+arch.GoString(), "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("unable to build the calibration suite: %s, %v", strings.TrimSpace(string(out)), err)
+	}
+	return fileName, nil
+}
+
+// runCalibration runs the suite on r and returns its result lines.
+func runCalibration(r remote) (string, error) {
+	out, err := sshRun(r, "cd "+remoteTmp()+" && "+remoteCalibration()+" -test.run=NONE -test.bench=. -test.count=3 -test.benchtime=200ms")
+	if err != nil {
+		return "", fmt.Errorf("calibration failed, %v", err)
+	}
+	var lines strings.Builder
+	for _, l := range strings.Split(out, "\n") {
+		if strings.HasPrefix(l, "BenchmarkCalibrate") {
+			lines.WriteString(l + "\n")
+		}
+	}
+	return lines.String(), nil
+}
+
+// calibrationKey names the machine of a run from its configuration lines: the instance type, or
+// the registered host.
+func calibrationKey(config map[string]string) string {
+	if config["host"] != "" {
+		return "host-" + config["host"]
+	}
+	return config["instance-type"]
+}
+
+// calibrationFile is the reference of a machine, next to the configuration file.
+func calibrationFile(key string) string {
+	return filepath.Join(filepath.Dir(configPath()), "calibration", strings.ReplaceAll(key, "/", "_")+".txt")
+}
+
+// loadCalibration returns the reference results of a machine, nil if there is none.
+func loadCalibration(key string) (*benchResults, error) {
+	data, err := os.ReadFile(calibrationFile(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the calibration of %s, %v", key, err)
+	}
+	results := newBenchResults()
+	results.Write(append(data, '\n'))
+	return results, nil
+}
+
+// checkCalibration returns the benchmarks of the suite slower than the reference by more than
+// calibrationTolerance, with their slowdown.
+func checkCalibration(ref, cur *benchResults) []string {
+	var slow []string
+	for _, name := range cur.names {
+		r, c := median(ref.values(name, "ns/op")), median(cur.values(name, "ns/op"))
+		if r > 0 && c > r*(1+calibrationTolerance/100.) {
+			slow = append(slow, fmt.Sprintf("%s +%.0f%%", trimProcs(name), 100*(c-r)/r))
+		}
+	}
+	return slow
+}
+
+// printCalibration prints the medians of the suite and their ratio to the reference.
+func printCalibration(w io.Writer, key string, ref, cur *benchResults) {
+	fmt.Fprintf(w, "\ncalibration of %s (median ns/op):\n", key)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "\treference\tnow\tnow/reference\n")
+	for _, name := range cur.names {
+		c := median(cur.values(name, "ns/op"))
+		if rv := ref.values(name, "ns/op"); len(rv) > 0 {
+			r := median(rv)
+			fmt.Fprintf(tw, "%s\t%.4g\t%.4g\t%s\n", name, r, c, ratio(r, c))
+		} else {
+			fmt.Fprintf(tw, "%s\t-\t%.4g\t-\n", name, c)
+		}
+	}
+	tw.Flush()
+}
+
+// calibrateCmd implements "rbench calibrate": the suite run as the benchmark of a regular run
+// (the run flags select the machine), compared to the reference of the machine. The first
+// calibration of a machine becomes its reference.
+func calibrateCmd(args []string) error {
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	count := fs.Int("count", 5, "number of runs of the suite")
+	update := fs.Bool("update", false, "replace the reference of the machine with this calibration")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench calibrate [flags] [-- run flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	dir, err := writeCalibrationSuite()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	statusf("calibrating...")
+	// the suite is the benchmarked package
+	out, err := runChildIn(dir, append([]string{"-bench=.", fmt.Sprintf("-count=%d", *count), "-ci=off"}, fs.Args()...))
+	stderrTerminal.clearStatus()
+	fmt.Print(out)
+	if err != nil {
+		return err
+	}
+	config, _ := readConfigLines(strings.NewReader(out))
+	key := calibrationKey(config)
+	if key == "" {
+		return fmt.Errorf("no instance type nor host in the output of the calibration")
+	}
+	cur := newBenchResults()
+	cur.Write([]byte(out + "\n"))
+	ref, err := loadCalibration(key)
+	if err != nil {
+		return err
+	}
+	if ref != nil {
+		printCalibration(os.Stdout, key, ref, cur)
+		if slow := checkCalibration(ref, cur); len(slow) > 0 {
+			fmt.Printf("abnormal host, slower than the reference: %s\n", strings.Join(slow, ", "))
+		}
+		if !*update {
+			return nil
+		}
+	}
+	file := calibrationFile(key)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, []byte(out), 0600); err != nil {
+		return err
+	}
+	fmt.Printf("reference of %s saved to %s\n", key, file)
+	return nil
+}
+
+// checkTargetCalibration compares the calibration of t to the reference of its machine, warns if
+// the host is abnormal, and returns the state recorded in the calibration config line: ok,
+// abnormal or no-reference.
+func checkTargetCalibration(t target, calibration string) string {
+	config := map[string]string{"instance-type": *instanceType}
+	if t.host != "" {
+		config = map[string]string{"host": *targetFlag}
+	}
+	ref, err := loadCalibration(calibrationKey(config))
+	if err != nil {
+		slog.Warn(t.prefix() + err.Error())
+	}
+	if ref == nil {
+		return "no-reference"
+	}
+	cur := newBenchResults()
+	cur.Write([]byte(calibration))
+	if slow := checkCalibration(ref, cur); len(slow) > 0 {
+		slog.Warn(fmt.Sprintf("%sabnormal host, slower than the reference of %s: %s; the results may not be representative",
+			t.prefix(), calibrationKey(config), strings.Join(slow, ", ")))
+		return "abnormal"
+	}
+	return "ok"
+}
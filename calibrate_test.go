@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCalibrationSuite(t *testing.T) {
+	dir, err := writeCalibrationSuite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cmd := exec.Command("go", "test", "-run=NONE", "-bench=.", "-benchtime=1x", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	results := newBenchResults()
+	results.Write(out)
+	for _, name := range []string{"BenchmarkCalibrateMemory", "BenchmarkCalibrateALU", "BenchmarkCalibrateSyscall"} {
+		found := false
+		for _, n := range results.names {
+			found = found || trimProcs(n) == name
+		}
+		if !found {
+			t.Errorf("no result for %s:\n%s", name, out)
+		}
+	}
+}
+
+func TestCheckCalibration(t *testing.T) {
+	ref, cur := newBenchResults(), newBenchResults()
+	ref.Write([]byte("BenchmarkCalibrateALU-2 100 1000 ns/op\nBenchmarkCalibrateSyscall-2 100 100 ns/op\n"))
+	cur.Write([]byte("BenchmarkCalibrateALU-2 100 1050 ns/op\nBenchmarkCalibrateSyscall-2 100 150 ns/op\n"))
+	slow := checkCalibration(ref, cur)
+	if len(slow) != 1 || slow[0] != "BenchmarkCalibrateSyscall +50%" {
+		t.Errorf("checkCalibration = %q, expected the syscall benchmark only", slow)
+	}
+
+	var b strings.Builder
+	printCalibration(&b, "c7g.large", ref, cur)
+	if !strings.Contains(b.String(), "x1.50") {
+		t.Errorf("calibration doesn't show the ratio:\n%s", b.String())
+	}
+}
+
+func TestCalibrationKey(t *testing.T) {
+	if k := calibrationKey(map[string]string{"instance-type": "c7g.large"}); k != "c7g.large" {
+		t.Errorf("calibrationKey = %q, expected c7g.large", k)
+	}
+	if k := calibrationKey(map[string]string{"host": "lab1", "instance-ip": "10.0.0.1"}); k != "host-lab1" {
+		t.Errorf("calibrationKey = %q, expected host-lab1", k)
+	}
+}
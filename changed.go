@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// packageChanged reports whether pkg is affected by the changes since ref (-changed): its files,
+// the files of its dependencies or the module files changed.
+func packageChanged(pkg, ref string) (bool, error) {
+	changed, err := changedSince(ref)
+	if err != nil {
+		return false, err
+	}
+	deps, err := packageDeps(pkg)
+	if err != nil {
+		return false, err
+	}
+	affecting := affectingChanges(deps, changed)
+	for _, file := range affecting {
+		slog.Debug("affected by " + file)
+	}
+	return len(affecting) > 0, nil
+}
+
+// changedSince returns the files changed since the merge base of ref and HEAD, including
+// uncommitted changes and untracked files (not ignored), as absolute paths.
+func changedSince(ref string) ([]string, error) {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, fmt.Errorf("-changed requires a git repository, %v", err)
+	}
+	top := strings.TrimSpace(string(out))
+	base, err := exec.Command("git", "merge-base", ref, "HEAD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the merge base of %s and HEAD, %v", ref, err)
+	}
+	// relative to the top-level directory, NUL-terminated: names may have spaces
+	diff, err := exec.Command("git", "-C", top, "diff", "--name-only", "-z", strings.TrimSpace(string(base))).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to diff against %s, %v", ref, err)
+	}
+	untracked, err := exec.Command("git", "-C", top, "ls-files", "--others", "--exclude-standard", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the untracked files, %v", err)
+	}
+	var files []string
+	for _, name := range strings.Split(string(diff)+string(untracked), "\x00") {
+		if name != "" {
+			files = append(files, filepath.Join(top, name))
+		}
+	}
+	return files, nil
+}
+
+// packageDeps returns the directories of the non standard packages pkg and its tests depend on,
+// including pkg itself.
+func packageDeps(pkg string) ([]string, error) {
+	out, err := exec.Command("go", "list", "-deps", "-test", "-f", "{{if not .Standard}}{{.Dir}}{{end}}", pkg).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the dependencies of %s, %v", pkg, err)
+	}
+	return strings.FieldsFunc(string(out), func(r rune) bool { return r == '\n' }), nil
+}
+
+// affectingChanges returns the changed files that may affect a package whose dependencies
+// are in depDirs: files of the dependencies (and their testdata) and module files.
+func affectingChanges(depDirs, changed []string) []string {
+	deps := make(map[string]bool, len(depDirs))
+	for _, d := range depDirs {
+		deps[d] = true
+	}
+	var affecting []string
+	for _, file := range changed {
+		dir := filepath.Dir(file)
+		if before, _, ok := strings.Cut(file, string(filepath.Separator)+"testdata"+string(filepath.Separator)); ok {
+			dir = before
+		}
+		switch base := filepath.Base(file); {
+		case base == "go.mod", base == "go.sum", deps[dir]:
+			affecting = append(affecting, file)
+		}
+	}
+	return affecting
+}
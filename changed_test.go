@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestAffectingChanges(t *testing.T) {
+	deps := []string{"/repo/fft", "/repo/internal/bits"}
+	changed := []string{
+		"/repo/fft/fft.go",
+		"/repo/fft/testdata/vectors.json",
+		"/repo/internal/bits/bits_test.go",
+		"/repo/internal/bits/sub/sub.go",
+		"/repo/docs/README.md",
+		"/repo/go.sum",
+	}
+	expected := []string{
+		"/repo/fft/fft.go",
+		"/repo/fft/testdata/vectors.json",
+		"/repo/internal/bits/bits_test.go",
+		"/repo/go.sum",
+	}
+	if got := affectingChanges(deps, changed); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write("fft.go", "package fft\n")
+	write(".gitignore", "*.out\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	write("fft.go", "package fft // changed\n")
+	write("new file.go", "package fft\n")
+	write("cpu.out", "ignored\n")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	files, err := changedSince("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	slices.Sort(files)
+	if want := []string{"fft.go", "new file.go"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got %q, want %q", files, want)
+	}
+}
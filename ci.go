@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ciProvider publishes the results of a run in a CI system.
+type ciProvider interface {
+	// publish posts the markdown summary of the results and uploads the artifacts.
+	publish(summary string, artifacts []string) error
+}
+
+// ciAnnotator is implemented by the CI systems attaching annotations to source lines.
+type ciAnnotator interface {
+	annotate(summary string, annotations []annotation) error
+}
+
+// annotation is a finding about a benchmark, attached to its declaration.
+type annotation struct {
+	sourceLine
+	title, message string
+}
+
+// detectCI returns the CI system selected by -ci, detected from the environment with -ci=auto;
+// nil if none.
+func detectCI() ciProvider {
+	name := *ciFlag
+	if name == "auto" {
+		switch {
+		case os.Getenv("GITLAB_CI") == "true":
+			name = "gitlab"
+		case os.Getenv("BUILDKITE") == "true":
+			name = "buildkite"
+		case os.Getenv("GITHUB_ACTIONS") == "true":
+			name = "github"
+		}
+	}
+	switch name {
+	case "github":
+		return githubCI{}
+	case "gitlab":
+		return gitlabCI{}
+	case "buildkite":
+		return buildkiteCI{}
+	}
+	return nil
+}
+
+// publishCI publishes the summary and the artifacts of the run to the CI system.
+func publishCI(ci ciProvider, info runInfo) error {
+	output := runOutput.String()
+	dir, err := os.MkdirTemp("", "rbench-ci-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	outputFile := filepath.Join(dir, "rbench-output.txt")
+	if err := os.WriteFile(outputFile, []byte(output), 0644); err != nil {
+		return err
+	}
+	artifacts := []string{outputFile}
+	if audit := auditJSONL(); len(audit) > 0 {
+		auditFile := filepath.Join(dir, "rbench-audit.jsonl")
+		if err := os.WriteFile(auditFile, audit, 0644); err != nil {
+			return err
+		}
+		artifacts = append(artifacts, auditFile)
+	}
+	for _, f := range []string{*bundleFile, *coverProfile, *cpuProfile, *provenanceFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err == nil {
+			artifacts = append(artifacts, f)
+		}
+	}
+	summary := markdownSummary(output, info.commitID)
+	if len(info.budgetViolations) > 0 {
+		summary += "\n**performance budget exceeded:**\n\n"
+		for _, v := range info.budgetViolations {
+			summary += "- " + v + "\n"
+		}
+	}
+	if a, ok := ci.(ciAnnotator); ok {
+		lines, err := benchmarkLines(benchPackage)
+		if err != nil {
+			return err
+		}
+		if err := a.annotate(summary, benchAnnotations(output, lines)); err != nil {
+			return err
+		}
+	}
+	return ci.publish(summary, artifacts)
+}
+
+// benchAnnotations returns the annotations of the output of a -compare run: the significant
+// ns/op regressions of the head (p < 0.05, like the compare summary), on the declaration of their
+// top-level benchmark function. Without a base to compare with, there are none.
+func benchAnnotations(output string, lines map[string]sourceLine) []annotation {
+	results, bins, ok := compareOutput(output)
+	if !ok {
+		return nil
+	}
+	var annotations []annotation
+	for _, name := range results[1].names {
+		base, head := results[0].values(name, "ns/op"), results[1].values(name, "ns/op")
+		if len(base) == 0 || len(head) == 0 || median(base) == 0 {
+			continue
+		}
+		delta, p := 100*(median(head)-median(base))/median(base), mannWhitneyP(base, head)
+		if p >= 0.05 || delta <= 0 {
+			continue
+		}
+		// BenchmarkX/sub-8 is declared by BenchmarkX
+		fn, _, _ := strings.Cut(name, "/")
+		if i := strings.LastIndexByte(fn, '-'); i > 0 {
+			fn = fn[:i]
+		}
+		line, ok := lines[fn]
+		if !ok {
+			continue
+		}
+		message := fmt.Sprintf("%s: %.4g -> %.4g ns/op from %s to %s (%+.2f%%, p=%.3f, %d and %d runs)",
+			name, median(base), median(head), compareRef(bins[0]), compareRef(bins[1]), delta, p, len(base), len(head))
+		if s := max(spread(base), spread(head)); s > *noiseThreshold {
+			message += fmt.Sprintf("; noisy (±%.1f%%), consider a higher -count", s)
+		}
+		annotations = append(annotations, annotation{
+			sourceLine: line,
+			title:      fmt.Sprintf("%s regressed %+.2f%%", name, delta),
+			message:    message,
+		})
+	}
+	return annotations
+}
+
+// mergeRequestCompare returns the -compare range of a merge request pipeline, from its base to
+// its head: on GitLab, the merge base of the merge request; on GitHub, the base branch of the
+// pull request, whose checkout is the merge of the head into it. The base is fetched if the clone
+// doesn't have it. "" outside of a merge request pipeline.
+func mergeRequestCompare() string {
+	var base string
+	var fetch []string
+	switch detectCI().(type) {
+	case gitlabCI:
+		base = os.Getenv("CI_MERGE_REQUEST_DIFF_BASE_SHA")
+		fetch = []string{base}
+	case githubCI:
+		if branch := os.Getenv("GITHUB_BASE_REF"); branch != "" {
+			base = "origin/" + branch
+			fetch = []string{"+refs/heads/" + branch + ":refs/remotes/origin/" + branch}
+		}
+	}
+	if base == "" {
+		return ""
+	}
+	if exec.Command("git", "rev-parse", "-q", "--verify", base+"^{commit}").Run() != nil {
+		// shallow clones (GIT_DEPTH, actions/checkout) may not reach it
+		args := append([]string{"fetch", "-q", "--depth=1", "origin"}, fetch...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			slog.Warn(fmt.Sprintf("unable to fetch the base %s of the merge request, not comparing with it: %s", base, strings.TrimSpace(string(out))))
+			return ""
+		}
+	}
+	return base + "..HEAD"
+}
+
+// compareOutput returns the results of the base and the head in the output of a -compare run,
+// split by the configuration lines of each round (see compareRound), and their refs; ok is false
+// if output isn't one of a -compare run.
+func compareOutput(output string) (results [2]*benchResults, bins []sliceBinary, ok bool) {
+	results = [2]*benchResults{newBenchResults(), newBenchResults()}
+	bins = make([]sliceBinary, len(compareToolchains))
+	var ref string
+	current := -1
+	for _, line := range strings.Split(output, "\n") {
+		if m := configLineRegexp.FindStringSubmatch(line); m != nil {
+			switch value := strings.TrimSpace(m[2]); m[1] {
+			case "ref":
+				ref = value
+			case "toolchain":
+				current = slices.Index(compareToolchains[:], value)
+				if current >= 0 {
+					bins[current].job.ref, ok = ref, true
+				}
+			}
+			continue
+		}
+		if current >= 0 {
+			results[current].Write([]byte(line + "\n"))
+		}
+	}
+	return results, bins, ok
+}
+
+// markdownSummary returns a markdown table of the medians of the results in output; for a
+// -compare run (a merge request pipeline), the comparison of the head with the base.
+func markdownSummary(output, commit string) string {
+	results := newBenchResults()
+	results.Write([]byte(output + "\n"))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "### rbench results\n\ncommit `%s`", commit)
+	if *targetFlag != "" {
+		fmt.Fprintf(&b, " on `%s`", *targetFlag)
+	} else {
+		fmt.Fprintf(&b, " on `%s`", *instanceType)
+	}
+	fmt.Fprintf(&b, ", %d runs each", *countFlag)
+	if c, ok := runCost.cost(time.Now()); ok {
+		fmt.Fprintf(&b, ", ~%s of instance time", formatCost(c))
+	}
+	b.WriteString("\n\n")
+	if len(results.names) == 0 {
+		b.WriteString("no benchmark results\n")
+		return b.String()
+	}
+	if compared, bins, ok := compareOutput(output); ok {
+		// the summary of the run, like benchstat
+		b.WriteString("```")
+		printCompareSummary(&b, bins, compared, nil)
+		b.WriteString("```\n")
+		return b.String()
+	}
+
+	units := results.units()
+	fmt.Fprintf(&b, "| benchmark | %s |\n|---|%s\n", strings.Join(units, " | "), strings.Repeat("---|", len(units)))
+	for _, name := range results.names {
+		fmt.Fprintf(&b, "| %s |", name)
+		for _, unit := range units {
+			values := results.values(name, unit)
+			if len(values) == 0 {
+				b.WriteString(" - |")
+				continue
+			}
+			fmt.Fprintf(&b, " %.4g ±%.1f%% |", median(values), spread(values))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// buildkiteCI annotates the build and uploads the artifacts with buildkite-agent.
+type buildkiteCI struct{}
+
+func (buildkiteCI) publish(summary string, artifacts []string) error {
+	cmd := exec.Command("buildkite-agent", "annotate", "--style", "info", "--context", "rbench")
+	cmd.Stdin = strings.NewReader(summary)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to annotate the build: %s, %v", strings.TrimSpace(string(out)), err)
+	}
+	for _, f := range artifacts {
+		// artifacts are uploaded relative to their directory
+		cmd := exec.Command("buildkite-agent", "artifact", "upload", filepath.Base(f))
+		cmd.Dir = filepath.Dir(f)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("unable to upload %s: %s, %v", f, strings.TrimSpace(string(out)), err)
+		}
+	}
+	return nil
+}
+
+// gitlabCI copies the artifacts to rbench-artifacts/ in the project directory (to be listed in
+// the artifacts:paths of the job) and posts the summary on the merge request, if any, with the
+// api token $RBENCH_GITLAB_TOKEN.
+type gitlabCI struct{}
+
+func (gitlabCI) publish(summary string, artifacts []string) error {
+	dir := filepath.Join(os.Getenv("CI_PROJECT_DIR"), "rbench-artifacts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "summary.md"), []byte(summary), 0644); err != nil {
+		return err
+	}
+	for _, f := range artifacts {
+		if err := copyFile(f, filepath.Join(dir, filepath.Base(f))); err != nil {
+			return fmt.Errorf("unable to copy %s to the artifacts, %v", f, err)
+		}
+	}
+
+	mr := os.Getenv("CI_MERGE_REQUEST_IID")
+	if mr == "" {
+		return nil
+	}
+	token := os.Getenv("RBENCH_GITLAB_TOKEN")
+	if token == "" {
+		slog.Warn("set RBENCH_GITLAB_TOKEN (a token with the api scope) to post the results on the merge request")
+		return nil
+	}
+	body, err := json.Marshal(map[string]string{"body": summary})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/projects/%s/merge_requests/%s/notes", os.Getenv("CI_API_V4_URL"), os.Getenv("CI_PROJECT_ID"), mr)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("PRIVATE-TOKEN", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to post the merge request note, %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unable to post the merge request note: %s", resp.Status)
+	}
+	return nil
+}
+
+// githubCI appends the summary to the job summary, copies the artifacts to rbench-artifacts/ in
+// the workspace (for actions/upload-artifact), and creates an "rbench" check run annotating
+// the benchmark sources, with $GITHUB_TOKEN (checks: write permission).
+type githubCI struct{}
+
+func (githubCI) publish(summary string, artifacts []string) error {
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("unable to write the job summary, %v", err)
+		}
+		_, err = f.WriteString(summary)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("unable to write the job summary, %v", err)
+		}
+	}
+	dir := filepath.Join(os.Getenv("GITHUB_WORKSPACE"), "rbench-artifacts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range artifacts {
+		if err := copyFile(f, filepath.Join(dir, filepath.Base(f))); err != nil {
+			return fmt.Errorf("unable to copy %s to the artifacts, %v", f, err)
+		}
+	}
+	return nil
+}
+
+// maxCheckAnnotations is the maximum number of annotations per check run request.
+const maxCheckAnnotations = 50
+
+func (githubCI) annotate(summary string, annotations []annotation) error {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		slog.Warn("set GITHUB_TOKEN (with the checks: write permission) to annotate the benchmarks in the pull request")
+		return nil
+	}
+	sha := os.Getenv("GITHUB_SHA")
+	if head, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+		sha = strings.TrimSpace(string(head))
+	}
+	conclusion := "success"
+	if len(annotations) > 0 {
+		conclusion = "neutral"
+	}
+	url := fmt.Sprintf("%s/repos/%s/check-runs", os.Getenv("GITHUB_API_URL"), os.Getenv("GITHUB_REPOSITORY"))
+
+	// the annotations are sent in batches: with the creation of the check run, then updates.
+	method := http.MethodPost
+	for i := 0; i == 0 || i < len(annotations); i += maxCheckAnnotations {
+		batch := annotations[i:min(i+maxCheckAnnotations, len(annotations))]
+		var checkAnnotations []map[string]any
+		for _, a := range batch {
+			checkAnnotations = append(checkAnnotations, map[string]any{
+				"path":             a.path,
+				"start_line":       a.line,
+				"end_line":         a.line,
+				"annotation_level": "warning",
+				"title":            a.title,
+				"message":          a.message,
+			})
+		}
+		body := map[string]any{
+			"name":       "rbench",
+			"head_sha":   sha,
+			"status":     "completed",
+			"conclusion": conclusion,
+			"output": map[string]any{
+				"title":       fmt.Sprintf("%d benchmark annotations", len(annotations)),
+				"summary":     summary,
+				"annotations": checkAnnotations,
+			},
+		}
+		var created struct {
+			ID  int64  `json:"id"`
+			URL string `json:"url"`
+		}
+		if err := githubRequest(method, url, token, body, &created); err != nil {
+			return fmt.Errorf("unable to create the check run, %v", err)
+		}
+		if method == http.MethodPost {
+			method, url = http.MethodPatch, created.URL
+		}
+	}
+	return nil
+}
+
+// githubRequest sends a GitHub API request with a JSON body and decodes the response into v.
+func githubRequest(method, url, token string, body, v any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
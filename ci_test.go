@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownSummary(t *testing.T) {
+	const output = "commit: abc\nBenchmarkA-2 100 10 ns/op 16 B/op\nBenchmarkA-2 100 12 ns/op 16 B/op\nPASS"
+	got := markdownSummary(output, "abc")
+	want := "### rbench results\n\ncommit `abc` on `t2.micro`, 5 runs each\n\n" +
+		"| benchmark | ns/op | B/op |\n|---|---|---|\n" +
+		"| BenchmarkA-2 | 11 ±9.1% | 16 ±0.0% |\n"
+	if got != want {
+		t.Errorf("unexpected summary:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMarkdownSummaryCompare(t *testing.T) {
+	var output strings.Builder
+	output.WriteString("commit: abc\nbaseline-commit: 111\nexperiment-commit: 222\n")
+	for round, values := range [][2]string{{"10", "12"}, {"10.1", "12.1"}, {"9.9", "11.9"}, {"10", "12"}, {"10.2", "12.2"}} {
+		for i := range compareToolchains {
+			j := (round + i) % 2
+			fmt.Fprintf(&output, "ref: %s\ncommit: %s\ntoolchain: %s\nBenchmarkA-2 100 %s ns/op\nPASS\n",
+				[]string{"main", "HEAD"}[j], []string{"111", "222"}[j], compareToolchains[j], values[j])
+		}
+	}
+	got := markdownSummary(output.String(), "abc")
+	for _, want := range []string{"HEAD vs main (median ns/op", "BenchmarkA-2  10 ±2.0%  12 ±1.7%  +20.00% (p=0.012)"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary doesn't contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestBenchAnnotations(t *testing.T) {
+	var output strings.Builder
+	for round := range 5 {
+		for i := range compareToolchains {
+			j := (round + i) % 2
+			// A/small regresses by 20%, B doesn't change, C improves
+			fmt.Fprintf(&output, "ref: %s\ntoolchain: %s\nBenchmarkA/small-2 100 %g ns/op\nBenchmarkB-2 100 %g ns/op\nBenchmarkC-2 100 %g ns/op\n",
+				[]string{"main", "HEAD"}[j], compareToolchains[j], []float64{10, 12}[j]+0.1*float64(round), 10+0.1*float64(round), []float64{10, 8}[j]+0.1*float64(round))
+		}
+	}
+	lines := map[string]sourceLine{
+		"BenchmarkA": {path: "fft/fft_test.go", line: 12},
+		"BenchmarkB": {path: "fft/fft_test.go", line: 30},
+		"BenchmarkC": {path: "fft/fft_test.go", line: 40},
+	}
+	if got := benchAnnotations("BenchmarkA/small-2 100 10 ns/op\nBenchmarkA/small-2 100 20 ns/op\n", lines); len(got) != 0 {
+		t.Errorf("expected no annotation without a base, got %v", got)
+	}
+	got := benchAnnotations(output.String(), lines)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 annotation, got %v", got)
+	}
+	if got[0].sourceLine != lines["BenchmarkA"] || got[0].title != "BenchmarkA/small-2 regressed +19.61%" ||
+		!strings.Contains(got[0].message, "from main to HEAD") {
+		t.Errorf("unexpected annotation %+v", got[0])
+	}
+}
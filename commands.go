@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// subcommand is a rbench command other than the benchmark run; each one parses its own flags.
+type subcommand struct {
+	summary string
+	run     func(args []string) error
+}
+
+// subcommands are dispatched on the first argument; without one (or with "run"), rbench runs the benchmark.
+var subcommands map[string]subcommand
+
+func init() {
+	subcommands = map[string]subcommand{
+		"list":        {"list the benchmarks of a package matching -bench", listCmd},
+		"ps":          {"list the running rbench instances of the account", psCmd},
+		"kill":        {"terminate rbench instances", killCmd},
+		"gc":          {"terminate my instances leaked by runs that died, by age", gcCmd},
+		"ssh":         {"open a shell (or run a command) on a running instance", sshCmd},
+		"shop":        {"run the benchmark on several instance types within a dollar budget, ranked by performance per dollar", shopCmd},
+		"calibrate":   {"run the machine calibration suite and compare it to the reference of the instance type", calibrateCmd},
+		"noise-study": {"run the calibration suite at intervals in every availability zone and report the variance per zone and hour", noiseStudyCmd},
+		"fanout":      {"run a suite concurrently on every registered host and cloud profile, with a hardware coverage matrix", fanoutCmd},
+		"fetch":       {"retrieve the results of a running instance", fetchCmd},
+		"import":      {"add the results of benchfmt files produced elsewhere (CI, laptops) to the results history", importCmd},
+		"history":     {"show the trend of a benchmark over the last commits, from the results history", historyCmd},
+		"series":      {"compare each commit with the previous one over the results history, as benchseries comparison series", seriesCmd},
+		"web":         {"browse the results history and the bundles of a directory in a local web interface", webCmd},
+		"bundle":      {"list the content of a .rbench bundle, or print one of its files", bundleCmd},
+		"runs":        {"list the runs published to S3 with -s3 for a repository and branch", runsCmd},
+		"cost":        {"report the spend of rbench instances", costCmd},
+		"inventory":   {"list the rbench resources of the account in all regions, with their cost", inventoryCmd},
+		"iam-policy":  {"print the least-privilege IAM policy of the rbench features", iamPolicyCmd},
+		"verify":      {"verify a signed provenance artifact", verifyCmd},
+		"report":      {"render saved results, optionally compared to a baseline, through a Go template", reportCmd},
+		"envdiff":     {"diff the environments recorded in two saved outputs", envdiffCmd},
+		"pprof-diff":  {"compare the CPU profiles of two runs with go tool pprof", pprofDiffCmd},
+		"config":      {"publish (push) or sync (pull) the team configuration", configCmd},
+		"bake-ami":    {"set up an instance with a script and image it, for the amis section of the configuration", bakeAMICmd},
+		"doctor":      {"check the local tools and the AWS setup", doctorCmd},
+		"init":        {"set up the AWS account and write the configuration file", initCmd},
+		"completion":  {"print a bash completion script", completionCmd},
+		"agent":       {"serve the gRPC agent of -agent on the instance (started by rbench)", agentCmd},
+		"help":        {"list the commands", helpCmd},
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: rbench [run] [flags] [package]\n       rbench <command> [flags]\n\n")
+		printCommands(flag.CommandLine.Output())
+		fmt.Fprintf(flag.CommandLine.Output(), "\nrun flags:\n")
+		flag.PrintDefaults()
+	}
+}
+
+func printCommands(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "  run\trun the benchmark (default)\n")
+	for _, name := range sortedKeys(subcommands) {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, subcommands[name].summary)
+	}
+	tw.Flush()
+}
+
+func helpCmd(args []string) error {
+	if len(args) == 1 {
+		if args[0] == "run" {
+			flag.Usage()
+			return nil
+		}
+		if _, ok := subcommands[args[0]]; ok {
+			return subcommands[args[0]].run([]string{"-h"})
+		}
+	}
+	flag.Usage()
+	return nil
+}
+
+// listCmd implements "rbench list [-bench=regexp] [package]".
+func listCmd(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	bench := fs.String("bench", ".", "list only the benchmarks matching a regular expression")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench list [flags] [package]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	pkg := "."
+	if fs.NArg() > 0 {
+		pkg = fs.Arg(0)
+	}
+	benchmarks, err := listBenchmarks(pkg, *bench)
+	if err != nil {
+		return err
+	}
+	for _, b := range benchmarks {
+		fmt.Println(b)
+	}
+	return nil
+}
+
+// rbenchInstances returns the pending and running instances tagged by rbench, of owner if set.
+func rbenchInstances(owner string) ([]types.Instance, error) {
+	filter := types.Filter{Name: aws.String("tag-key"), Values: []string{"rbench"}}
+	if owner != "" {
+		filter = types.Filter{Name: aws.String("tag:rbench"), Values: []string{owner}}
+	}
+	paginator := ec2.NewDescribeInstancesPaginator(ec2Client, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			filter,
+			{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}},
+		},
+	})
+	var instances []types.Instance
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe instances, %v", err)
+		}
+		for _, r := range page.Reservations {
+			instances = append(instances, r.Instances...)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return aws.ToTime(instances[i].LaunchTime).Before(aws.ToTime(instances[j].LaunchTime))
+	})
+	return instances, nil
+}
+
+func instanceTag(instance types.Instance, key string) string {
+	for _, tag := range instance.Tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+	return ""
+}
+
+// psCmd implements "rbench ps".
+func psCmd(args []string) error {
+	fs := flag.NewFlagSet("ps", flag.ExitOnError)
+	mine := fs.Bool("mine", false, "only list my instances")
+	awsFlags(fs)
+	fs.Parse(args)
+
+	if err := initAWS(); err != nil {
+		return err
+	}
+	owner := ""
+	if *mine {
+		owner = awsUserName
+	}
+	instances, err := rbenchInstances(owner)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "INSTANCE\tTYPE\tSTATE\tUSER\tUPTIME\tIP\n")
+	for _, instance := range instances {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			aws.ToString(instance.InstanceId),
+			instance.InstanceType,
+			instance.State.Name,
+			instanceTag(instance, "rbench"),
+			time.Since(aws.ToTime(instance.LaunchTime)).Round(time.Second),
+			aws.ToString(instance.PublicIpAddress))
+	}
+	return tw.Flush()
+}
+
+// killCmd implements "rbench kill [-all] [instance-id...]"; only instances tagged by rbench are terminated.
+func killCmd(args []string) error {
+	fs := flag.NewFlagSet("kill", flag.ExitOnError)
+	all := fs.Bool("all", false, "terminate all my instances")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench kill [flags] [instance-id...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if !*all && fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("expected instance ids or -all")
+	}
+
+	if err := initAWS(); err != nil {
+		return err
+	}
+	ids := fs.Args()
+	if *all {
+		instances, err := rbenchInstances(awsUserName)
+		if err != nil {
+			return err
+		}
+		for _, instance := range instances {
+			ids = append(ids, aws.ToString(instance.InstanceId))
+		}
+	}
+	for _, id := range ids {
+		instance, err := describeInstance(id)
+		if err != nil {
+			return err
+		}
+		if instanceTag(instance, "rbench") == "" {
+			return fmt.Errorf("instance %s was not launched by rbench", id)
+		}
+		liveInstances.Store(id, true)
+	}
+	terminateAllInstances()
+	return nil
+}
+
+// sshCmd implements "rbench ssh <instance-id> [command...]".
+func sshCmd(args []string) error {
+	fs := flag.NewFlagSet("ssh", flag.ExitOnError)
+	user := fs.String("ssh-user", "", "ssh user on the instance (default: derived from the AMI)")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench ssh [flags] <instance-id> [command...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("expected an instance id")
+	}
+
+	if err := initAWS(); err != nil {
+		return err
+	}
+	r, err := instanceRemote(fs.Arg(0), *user)
+	if err != nil {
+		return err
+	}
+	sshArgs := append(sshOptions("-p"), r.String())
+	sshArgs = append(sshArgs, fs.Args()[1:]...)
+	cmd := exec.Command("ssh", sshArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// doctorCmd implements "rbench doctor": it checks what a run needs, without launching anything.
+func doctorCmd(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	awsFlags(fs)
+	fs.Parse(args)
+
+	failed := false
+	check := func(name string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok    %s\n", name)
+	}
+
+	for _, tool := range []string{"go", "ssh", "scp", "ssh-keygen"} {
+		_, err := exec.LookPath(tool)
+		check(tool, err)
+	}
+	err := initAWS()
+	check("aws credentials and key pair", err)
+	if err == nil {
+		_, err = rbenchInstances("")
+		check("ec2 access", err)
+	}
+	if failed {
+		return fmt.Errorf("some checks failed")
+	}
+	return nil
+}
+
+// completionCmd implements "rbench completion": a bash completion script for the commands and the run flags.
+//
+//	source <(rbench completion)
+func completionCmd(args []string) error {
+	var flags []string
+	flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "-"+f.Name)
+	})
+	commands := append([]string{"run"}, sortedKeys(subcommands)...)
+	fmt.Printf(`_rbench() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	elif [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -d -- "$cur"))
+	fi
+}
+complete -F _rbench rbench
+`, strings.Join(commands, " "), strings.Join(flags, " "))
+	return nil
+}
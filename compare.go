@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// -compare A..B runs the test binaries of two git refs on the same instance, in time slices like
+// -gogc: every round runs one repetition of each, in alternating order, so that the drifts of
+// the instance affect both equally. The outputs are tagged with ref and commit config lines
+// (benchstat -col ref), and followed by a benchstat-style delta table. They are also tagged for
+// benchseries: toolchain: baseline or experiment, with the commits compared and the time of the
+// head commit, which orders the comparisons of a series.
+
+// compareFiles are the remote binaries of the base (A) and the head (B), in the working directory.
+var compareFiles = [2]string{"bench-base", "bench-head"}
+
+// compareToolchains are the benchseries roles of the base and the head.
+var compareToolchains = [2]string{"baseline", "experiment"}
+
+// parseCompare parses the -compare refs: A..B, or A.. to compare A with the working tree.
+func parseCompare(s string) ([]sliceJob, error) {
+	base, head, ok := strings.Cut(s, "..")
+	if !ok || base == "" || strings.HasPrefix(head, ".") {
+		return nil, fmt.Errorf("-compare: expected A..B git refs (or A.. for the working tree), got %q", s)
+	}
+	for _, ref := range []string{base, head} {
+		if ref == "" {
+			continue
+		}
+		if err := exec.Command("git", "rev-parse", "--verify", "-q", ref+"^{commit}").Run(); err != nil {
+			return nil, fmt.Errorf("-compare: unknown ref %q", ref)
+		}
+	}
+	return []sliceJob{{pkg: benchPackage, ref: base}, {pkg: benchPackage, ref: head}}, nil
+}
+
+// compareRef names the ref of a -compare binary in the output.
+func compareRef(b sliceBinary) string {
+	if b.job.ref == "" {
+		return "worktree"
+	}
+	return b.job.ref
+}
+
+// runCompare runs the benchmarks with the base and the head binaries, -count rounds; results are
+// collected per binary.
+func runCompare(r remote, out io.Writer, bins []sliceBinary, results [2]*benchResults) error {
+	fmt.Fprintf(out, "baseline-commit: %s\nexperiment-commit: %s\n", bins[0].commit, bins[1].commit)
+	if t := gitCommitTimeOf(strings.TrimSuffix(bins[1].commit, "-dirty")); t != "" {
+		fmt.Fprintf(out, "experiment-commit-time: %s\n", t)
+	}
+	for round := 0; round < *countFlag; round++ {
+		if finishing.Load() || interrupted.Load() {
+			// stopped on interrupt, after the current round
+			return nil
+		}
+		if err := compareRound(r, out, bins, results, round, benchPattern(r)); err != nil {
+			return err
+		}
+	}
+	return extendCompare(r, out, bins, results)
+}
+
+// compareRound runs the benchmarks matching pattern once with each binary, the first one
+// alternating with round.
+func compareRound(r remote, out io.Writer, bins []sliceBinary, results [2]*benchResults, round int, pattern string) error {
+	for i := range bins {
+		j := (round + i) % len(bins)
+		fmt.Fprintf(out, "ref: %s\ncommit: %s\ntoolchain: %s\n", compareRef(bins[j]), bins[j].commit, compareToolchains[j])
+		br := r
+		br.bin = "./" + compareFiles[j]
+		if err := sshExec(br, out, results[j], pattern, 1); err != nil {
+			if continueAfterHang(err) {
+				continue
+			}
+			return fmt.Errorf("%s: %w", compareRef(bins[j]), err)
+		}
+	}
+	return nil
+}
+
+// inconclusive returns the benchmarks whose ns/op delta isn't significant (p ≥ 0.05) although
+// the change of the medians exceeds -compare-threshold: more rounds may tell a regression from
+// noise.
+func inconclusive(results [2]*benchResults) []string {
+	var names []string
+	for _, name := range results[1].names {
+		base, head := results[0].values(name, "ns/op"), results[1].values(name, "ns/op")
+		if len(base) == 0 || len(head) == 0 || median(base) == 0 {
+			continue
+		}
+		delta := 100 * math.Abs(median(head)-median(base)) / median(base)
+		if delta >= *compareThreshold && mannWhitneyP(base, head) >= 0.05 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// exactPattern returns the -test.bench pattern matching only the benchmark of a result name:
+// each level of the name anchored, without the GOMAXPROCS suffix.
+func exactPattern(name string) string {
+	levels := strings.Split(trimProcs(name), "/")
+	for i, l := range levels {
+		levels[i] = "^" + regexp.QuoteMeta(l) + "$"
+	}
+	return strings.Join(levels, "/")
+}
+
+// extendCompare runs more rounds of the inconclusive benchmarks (see inconclusive), -count at a
+// time, until their deltas are significant or they reach -compare-max-count rounds.
+func extendCompare(r remote, out io.Writer, bins []sliceBinary, results [2]*benchResults) error {
+	maxCount := *compareMaxCount
+	if maxCount == 0 {
+		maxCount = 3 * *countFlag
+	}
+	for rounds := *countFlag; rounds < maxCount; {
+		names := inconclusive(results)
+		if len(names) == 0 {
+			return nil
+		}
+		batch := min(*countFlag, maxCount-rounds)
+		slog.Info(fmt.Sprintf("%d inconclusive deltas above %g%%, running %d more rounds of %s", len(names), *compareThreshold, batch, strings.Join(names, ", ")))
+		for round := rounds; round < rounds+batch; round++ {
+			for _, name := range names {
+				if finishing.Load() || interrupted.Load() {
+					return nil
+				}
+				if err := compareRound(r, out, bins, results, round, exactPattern(name)); err != nil {
+					return err
+				}
+			}
+		}
+		rounds += batch
+	}
+	if names := inconclusive(results); len(names) > 0 {
+		slog.Warn(fmt.Sprintf("still inconclusive after %d rounds (-compare-max-count): %s", maxCount, strings.Join(names, ", ")))
+	}
+	return nil
+}
+
+// printCompareSummary prints, like benchstat, the median ±spread of each benchmark with the base
+// and the head, and the change when it is significant (Mann-Whitney U test, p < 0.05), "~"
+// otherwise; one table per unit. The benchmarks whose noise exceeds their typical change between
+// commits in the history (noise, by benchmark) are marked, their deltas are unreliable.
+func printCompareSummary(w io.Writer, bins []sliceBinary, results [2]*benchResults, noise map[string]benchNoise) {
+	d := newReportData(nil, results[1], nil, results[0])
+	var noisy []string
+	for _, unit := range d.Units {
+		fmt.Fprintf(w, "\n%s vs %s (median %s ±spread, %d time-sliced rounds):\n", compareRef(bins[1]), compareRef(bins[0]), unit, *countFlag)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "\t%s\t%s\tdelta\n", compareRef(bins[0]), compareRef(bins[1]))
+		for _, b := range d.Benchmarks {
+			m, ok := b.Metrics[unit]
+			if !ok || !m.HasBase {
+				continue
+			}
+			base := results[0].values(b.Name, unit)
+			var delta string
+			p := mannWhitneyP(base, results[1].values(b.Name, unit))
+			if p < 0.05 && m.Base != m.Median {
+				delta = fmt.Sprintf("%+.2f%% (p=%.3f)", m.Delta, p)
+			} else {
+				delta = fmt.Sprintf("~ (p=%.3f)", p)
+			}
+			if unit == "ns/op" && noise[b.Name].noisy() {
+				delta += "  noisy — deltas unreliable"
+				noisy = append(noisy, b.Name)
+			}
+			fmt.Fprintf(tw, "%s\t%.4g ±%.1f%%\t%.4g ±%.1f%%\t%s\n", b.Name, m.Base, spread(base), m.Median, m.Spread, delta)
+		}
+		if g, ok := d.Geomean[unit]; ok && g.Runs > 1 {
+			fmt.Fprintf(tw, "geomean\t%.4g\t%.4g\t%+.2f%%\n", g.Base, g.Median, g.Delta)
+		}
+		tw.Flush()
+	}
+	for _, name := range noisy {
+		fmt.Fprintln(w, noiseHint(name, noise[name]))
+	}
+}
+
+// mannWhitneyP returns the two-sided p-value of the Mann-Whitney U test of x and y, from the
+// normal approximation with tie correction; 1 if either sample has less than 2 values.
+func mannWhitneyP(x, y []float64) float64 {
+	n1, n2 := float64(len(x)), float64(len(y))
+	if n1 < 2 || n2 < 2 {
+		return 1
+	}
+	type sample struct {
+		v float64
+		x bool
+	}
+	all := make([]sample, 0, len(x)+len(y))
+	for _, v := range x {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range y {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// rank sum of x, ties get their average rank
+	var rx, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].x {
+				rx += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	u := rx - n1*(n1+1)/2
+	n := n1 + n2
+	sigma := math.Sqrt(n1 * n2 / 12 * (n + 1 - ties/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	// continuity correction
+	z := (math.Abs(u-n1*n2/2) - 0.5) / sigma
+	return math.Min(1, math.Erfc(math.Max(z, 0)/math.Sqrt2))
+}
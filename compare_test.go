@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMannWhitneyP(t *testing.T) {
+	for _, tc := range []struct {
+		x, y     []float64
+		min, max float64
+	}{
+		// disjoint samples of 5: exact p = 0.008
+		{[]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 0.005, 0.015},
+		{[]float64{1, 2, 3, 4, 5}, []float64{1, 2, 3, 4, 5}, 1, 1},
+		{[]float64{1, 3, 5, 7, 9}, []float64{2, 4, 6, 8, 10}, 0.5, 1},
+		// all ties
+		{[]float64{4, 4, 4}, []float64{4, 4, 4}, 1, 1},
+		{[]float64{1}, []float64{2, 3}, 1, 1},
+	} {
+		if p := mannWhitneyP(tc.x, tc.y); p < tc.min || p > tc.max || math.IsNaN(p) {
+			t.Errorf("mannWhitneyP(%v, %v) = %.4f, expected in [%g, %g]", tc.x, tc.y, p, tc.min, tc.max)
+		}
+	}
+}
+
+func TestCompareSummary(t *testing.T) {
+	bins := []sliceBinary{{job: sliceJob{ref: "HEAD~1"}}, {job: sliceJob{}}}
+	results := [2]*benchResults{newBenchResults(), newBenchResults()}
+	for _, v := range []string{"10", "11", "10", "12", "11"} {
+		results[0].Write([]byte("BenchmarkA-2 100 " + v + " ns/op\nBenchmarkB-2 100 5 ns/op\n"))
+	}
+	for _, v := range []string{"8", "8", "7", "8", "9"} {
+		results[1].Write([]byte("BenchmarkA-2 100 " + v + " ns/op\nBenchmarkB-2 100 5 ns/op\n"))
+	}
+
+	var b strings.Builder
+	noise := map[string]benchNoise{"BenchmarkB-2": {run: 8, commit: 2, runs: 5, commits: 4}}
+	printCompareSummary(&b, bins, results, noise)
+	out := b.String()
+	for _, want := range []string{"worktree vs HEAD~1 (median ns/op", "HEAD~1", "-27.27% (p=", "~ (p=1.000)  noisy — deltas unreliable", "geomean", "consider -count="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary doesn't contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "BenchmarkA-2 ±") {
+		t.Errorf("BenchmarkA-2 isn't noisy:\n%s", out)
+	}
+}
+
+func TestParseCompare(t *testing.T) {
+	for _, s := range []string{"HEAD", "..HEAD", "HEAD...HEAD"} {
+		if _, err := parseCompare(s); err == nil {
+			t.Errorf("parseCompare(%q) succeeded, expected an error", s)
+		}
+	}
+}
+
+func TestInconclusive(t *testing.T) {
+	defer func(threshold float64) { *compareThreshold = threshold }(*compareThreshold)
+	*compareThreshold = 2
+
+	results := [2]*benchResults{newBenchResults(), newBenchResults()}
+	// A: +6% but overlapping samples, B: +10% and clear, C: +0.5%
+	for _, v := range [][2]string{{"100", "90"}, {"110", "125"}, {"95", "106"}} {
+		results[0].Write([]byte("BenchmarkA/small-2 100 " + v[0] + " ns/op\nBenchmarkB-2 100 100 ns/op\nBenchmarkC-2 100 100 ns/op\n"))
+		results[1].Write([]byte("BenchmarkA/small-2 100 " + v[1] + " ns/op\nBenchmarkB-2 100 110 ns/op\nBenchmarkC-2 100 100.5 ns/op\n"))
+	}
+	results[0].Write([]byte("BenchmarkB-2 100 101 ns/op\n"))
+	results[1].Write([]byte("BenchmarkB-2 100 111 ns/op\n"))
+	if got := inconclusive(results); !slices.Equal(got, []string{"BenchmarkA/small-2"}) {
+		t.Errorf("inconclusive = %q, expected BenchmarkA/small-2", got)
+	}
+	if got := exactPattern("BenchmarkA/size=1.5-2"); got != `^BenchmarkA$/^size=1\.5$` {
+		t.Errorf("exactPattern = %q", got)
+	}
+}
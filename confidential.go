@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// -confidential runs the benchmark in a confidential computing environment, to measure its
+// overhead: sev-snp launches the instance with AMD SEV-SNP memory encryption (m6a, c6a, r6a
+// types), enclave runs the test binary in a Nitro Enclave of the instance (Amazon Linux, see
+// setupEnclave), its output streamed back over vsock.
+
+// remoteEnclaveDir holds the enclave image and its build files on the instance.
+func remoteEnclaveDir() string {
+	return remoteTmp() + "/rbench-enclave"
+}
+
+// enclaveVsockPort is the vsock port the enclave sends its output to, on the parent instance (CID 3).
+const enclaveVsockPort = 5005
+
+// enclaveCommand runs the enclave and streams its output, ending with the exit code of the
+// benchmark; it replaces ./bench in sshExec.
+func enclaveCommand() string {
+	return "sudo sh " + remoteEnclaveDir() + "/run.sh"
+}
+
+// setupConfidential checks or sets up the -confidential environment on r, and returns it as
+// benchfmt configuration lines; testArgs and env are baked into the enclave image.
+func setupConfidential(r remote, testArgs, env []string) ([]string, error) {
+	switch *confidential {
+	case "sev-snp":
+		out, err := sshRun(r, "sudo dmesg | grep -m1 -o 'SEV-SNP' || true")
+		if err != nil {
+			return nil, fmt.Errorf("unable to check SEV-SNP, %v", err)
+		}
+		if strings.TrimSpace(out) == "" {
+			return nil, fmt.Errorf("SEV-SNP is not active on the instance")
+		}
+		return []string{"confidential: sev-snp"}, nil
+	case "enclave":
+		return setupEnclave(r, testArgs, env)
+	}
+	return nil, nil
+}
+
+// enclaveScript installs the Nitro Enclaves CLI, reserves the cpus and memory of the enclave and
+// builds its image (EIF) from a container with the test binary. The PCR0 measurement of the image
+// is printed last.
+const enclaveScript = `set -e
+sudo dnf install -y -q aws-nitro-enclaves-cli aws-nitro-enclaves-cli-devel docker socat >/dev/null
+sudo sed -i 's/^cpu_count:.*/cpu_count: %[2]d/; s/^memory_mib:.*/memory_mib: %[3]d/' /etc/nitro_enclaves/allocator.yaml
+sudo systemctl enable -q docker
+sudo systemctl start docker
+sudo systemctl restart nitro-enclaves-allocator.service
+cp %[4]s/bench %[1]s/bench
+sudo docker build -q -t rbench-enclave %[1]s >/dev/null
+sudo nitro-cli build-enclave --docker-uri rbench-enclave:latest --output-file %[1]s/bench.eif > %[1]s/build.json
+grep -o '"PCR0": *"[0-9a-f]*"' %[1]s/build.json | grep -o '[0-9a-f]\{96\}'
+`
+
+// enclaveRunScript starts the enclave and prints its output, received over vsock until the
+// enclave closes the connection, and exits with the exit code of the benchmark.
+const enclaveRunScript = `socat -u VSOCK-LISTEN:%[2]d,reuseaddr STDOUT | awk '/^rbench-exit: / {code = $2; next} {print; fflush()} END {exit code == "" ? 1 : code}' &
+listener=$!
+nitro-cli run-enclave --eif-path %[1]s/bench.eif --cpu-count %[3]d --memory %[4]d > %[1]s/enclave.json || { kill $listener; exit 1; }
+wait $listener
+code=$?
+nitro-cli terminate-enclave --all > /dev/null
+exit $code
+`
+
+var pcr0Regexp = regexp.MustCompile(`^[0-9a-f]{96}$`)
+
+// enclaveFiles returns the files of remoteEnclaveDir, by name: the container of the enclave image,
+// which has no arguments nor environment of its own (the test arguments and env are baked in), and
+// the script running it.
+func enclaveFiles(testArgs, env []string) map[string]string {
+	entry := "cd /tmp && { /bench"
+	for _, a := range testArgs {
+		entry += " " + shellQuote(a)
+	}
+	entry += fmt.Sprintf(` 2>&1; echo "rbench-exit: $?"; } | socat -u STDIN VSOCK-CONNECT:3:%d`, enclaveVsockPort)
+
+	dockerfile := "FROM amazonlinux:2023\nRUN dnf install -y -q socat\nCOPY bench entry.sh /\n"
+	for _, e := range env {
+		key, value, _ := strings.Cut(e, "=")
+		dockerfile += fmt.Sprintf("ENV %s=%q\n", key, value)
+	}
+	dockerfile += `CMD ["/bin/sh", "/entry.sh"]` + "\n"
+
+	return map[string]string{
+		"Dockerfile": dockerfile,
+		"entry.sh":   entry + "\n",
+		"run.sh":     fmt.Sprintf(enclaveRunScript, remoteEnclaveDir(), enclaveVsockPort, *enclaveCPUs, *enclaveMemory),
+	}
+}
+
+// setupEnclave builds the enclave image of the benchmark on r and returns its configuration lines.
+func setupEnclave(r remote, testArgs, env []string) ([]string, error) {
+	tmp, err := os.MkdirTemp("", "rbench-enclave-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, filepath.Base(remoteEnclaveDir()))
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return nil, err
+	}
+	for name, content := range enclaveFiles(testArgs, env) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			return nil, err
+		}
+	}
+	if err := scpCopy("upload enclave files", dir, r.path(filepath.Dir(remoteEnclaveDir()))); err != nil {
+		return nil, err
+	}
+
+	out, err := sshRun(r, fmt.Sprintf(enclaveScript, remoteEnclaveDir(), *enclaveCPUs, *enclaveMemory, remoteTmp()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build the enclave image, %v", err)
+	}
+	lines := []string{
+		"confidential: nitro-enclave",
+		fmt.Sprintf("enclave-cpus: %d", *enclaveCPUs),
+		fmt.Sprintf("enclave-memory: %d MiB", *enclaveMemory),
+	}
+	if pcr0 := lastLine(out); pcr0Regexp.MatchString(pcr0) {
+		// the measurement of the image, as attested by the enclave
+		lines = append(lines, "enclave-pcr0: "+pcr0)
+	}
+	return lines, nil
+}
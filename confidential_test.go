@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnclaveFiles(t *testing.T) {
+	files := enclaveFiles([]string{"-test.bench=^BenchmarkX$", "-test.count=5"}, []string{"RBENCH_SEED=42"})
+
+	entry := files["entry.sh"]
+	if !strings.Contains(entry, `/bench '-test.bench=^BenchmarkX$' '-test.count=5' 2>&1`) {
+		t.Errorf("entry.sh doesn't run the quoted test arguments:\n%s", entry)
+	}
+	if !strings.Contains(entry, "VSOCK-CONNECT:3:5005") {
+		t.Errorf("entry.sh doesn't stream to the parent:\n%s", entry)
+	}
+	if !strings.Contains(files["Dockerfile"], "ENV RBENCH_SEED=\"42\"\n") {
+		t.Errorf("Dockerfile doesn't set the environment:\n%s", files["Dockerfile"])
+	}
+	if run := files["run.sh"]; !strings.Contains(run, "VSOCK-LISTEN:5005") || !strings.Contains(run, "--cpu-count 2 --memory 2048") {
+		t.Errorf("run.sh doesn't listen or size the enclave:\n%s", run)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// configFile is the rbench configuration file: "key: value" lines, grouped in sections:
+//
+//	# persistent hosts, for -target
+//	hosts:
+//	  lab-graviton3: ubuntu@10.2.3.4
+//	# baked images, per architecture, instead of the default Ubuntu images
+//	amis:
+//	  arm64: ami-0123456789abcdef0
+//	# run flag defaults per benchmarked package
+//	packages:
+//	  ./fft: count=10, benchtime=2s
+//	  ./pairing/...: count=3
+//	# run flags of the suites and the cloud profiles of rbench fanout
+//	suites:
+//	  nightly: bench=., count=10
+//	profiles:
+//	  axion: provider=gcp, type=c4a-standard-4
+//
+// keys outside of a section belong to the "" section: defaults of the run flags of the same
+// name (e.g. "type: c7g.large") and the configSettings.
+type configFile map[string]map[string]string
+
+// configSettings are the top-level keys that aren't run flags (see rbench init).
+var configSettings = []string{"region", "security-group", "policy-hook"}
+
+// configPath returns the path of the configuration file, $RBENCH_CONFIG or rbench/config
+// in the user configuration directory (e.g. ~/.config/rbench/config).
+func configPath() string {
+	if p := os.Getenv("RBENCH_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "rbench", "config")
+}
+
+// teamConfigPath returns the path of the team configuration pulled by rbench config pull,
+// next to the configuration file.
+func teamConfigPath() string {
+	return filepath.Join(filepath.Dir(configPath()), "team")
+}
+
+// loadConfig reads the configuration file, on top of the team configuration; missing files
+// are empty configurations.
+func loadConfig() (configFile, error) {
+	c, err := readConfigFile(teamConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	local, err := readConfigFile(configPath())
+	if err != nil {
+		return nil, err
+	}
+	for section, values := range local {
+		if c[section] == nil {
+			c[section] = make(map[string]string)
+		}
+		for k, v := range values {
+			c[section][k] = v
+		}
+	}
+	return c, nil
+}
+
+func readConfigFile(path string) (configFile, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return configFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config, %v", err)
+	}
+	defer f.Close()
+	c, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func parseConfig(r io.Reader) (configFile, error) {
+	c := configFile{}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		indented := line[0] == ' ' || line[0] == '\t'
+		switch {
+		case !indented && value == "":
+			section = key
+			continue
+		case !indented:
+			section = ""
+		case section == "":
+			return nil, fmt.Errorf("line %d: indented key outside of a section", n)
+		}
+		if c[section] == nil {
+			c[section] = make(map[string]string)
+		}
+		c[section][key] = value
+	}
+	return c, scanner.Err()
+}
+
+// regionKeys are the top-level keys naming resources of the region of the configuration file,
+// ignored with the amis section when -region selects another region (see otherRegion).
+var regionKeys = []string{"security-group", "subnet"}
+
+// otherRegion reports whether -region selects another region than the one of c.
+func otherRegion(c configFile) bool {
+	return *regionFlag != "" && *regionFlag != firstNonEmpty(c[""]["region"], defaultRegion)
+}
+
+// applyConfigDefaults sets the flags of fs that weren't given on the command line from the
+// packages section entry of pkg, then from the top-level keys of the configuration file.
+func applyConfigDefaults(fs *flag.FlagSet, c configFile, pkg string) error {
+	defaults := make(map[string]string)
+	for _, key := range sortedKeys(c[""]) {
+		if slices.Contains(configSettings, key) {
+			continue
+		}
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("%s: unknown key %q", configPath(), key)
+		}
+		defaults[key] = c[""][key]
+	}
+	if pattern := matchPackage(c["packages"], pkg); pattern != "" {
+		values, err := packageDefaults(fs, c["packages"][pattern])
+		if err != nil {
+			return fmt.Errorf("%s: packages: %s: %v", configPath(), pattern, err)
+		}
+		maps.Copy(defaults, values)
+		slog.Debug(fmt.Sprintf("package defaults for %s: %s", pattern, c["packages"][pattern]))
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, key := range sortedKeys(defaults) {
+		if set[key] || (otherRegion(c) && slices.Contains(regionKeys, key)) {
+			continue
+		}
+		if err := fs.Set(key, defaults[key]); err != nil {
+			return fmt.Errorf("%s: invalid %s: %v", configPath(), key, err)
+		}
+	}
+	return nil
+}
+
+// packageDefaults parses the flag=value list of a packages entry. Values may contain commas
+// (e.g. gogc=off,100): a comma only starts a new entry before the name of a flag of fs.
+func packageDefaults(fs *flag.FlagSet, s string) (map[string]string, error) {
+	values := make(map[string]string)
+	key := ""
+	for _, v := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(v, "=")
+		if name = strings.TrimSpace(name); ok && fs.Lookup(name) != nil {
+			key = name
+			values[key] = strings.TrimSpace(value)
+			continue
+		}
+		if key == "" || (ok && isFlagName(name)) {
+			return nil, fmt.Errorf("expected flag=value, got %q", strings.TrimSpace(v))
+		}
+		values[key] += "," + strings.TrimSpace(v)
+	}
+	return values, nil
+}
+
+// isFlagName reports whether s looks like the name of a flag (e.g. count, budget-time).
+func isFlagName(s string) bool {
+	return s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyz0123456789-") == ""
+}
+
+// matchPackage returns the most specific key of the packages section matching pkg: the same
+// path, or a parent directory for keys ending with /... (e.g. ./pairing/...).
+func matchPackage(packages map[string]string, pkg string) string {
+	pkg = path.Clean(pkg)
+	best, bestScore := "", -1
+	for pattern := range packages {
+		dir, tree := strings.CutSuffix(pattern, "/...")
+		dir = path.Clean(dir)
+		if dir != pkg && !(tree && (dir == "." || strings.HasPrefix(pkg, dir+"/"))) {
+			continue
+		}
+		// deeper directories first, then exact matches
+		score := 2 * len(dir)
+		if !tree {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = pattern, score
+		}
+	}
+	return best
+}
+
+// updateConfig sets top-level keys of the configuration file, keeping the rest of the file
+// (comments, sections) as is.
+func updateConfig(values map[string]string) error {
+	path := configPath()
+	if path == "" {
+		return fmt.Errorf("unable to locate the user configuration directory")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to read config, %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create config directory, %v", err)
+	}
+	if err := os.WriteFile(path, []byte(setConfigKeys(string(data), values)), 0644); err != nil {
+		return fmt.Errorf("unable to write config, %v", err)
+	}
+	return nil
+}
+
+// setConfigKeys returns the configuration with the top-level keys set to values: existing keys
+// are replaced in place, new ones are added after the last top-level key preceding the sections.
+func setConfigKeys(data string, values map[string]string) string {
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	if data == "" {
+		lines = nil
+	}
+	done := make(map[string]bool)
+	insert, inSection := 0, false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if value == "" {
+			inSection = true
+			continue
+		}
+		if v, ok := values[key]; ok {
+			lines[i] = key + ": " + v
+			done[key] = true
+		}
+		if !inSection {
+			insert = i + 1
+		}
+	}
+	var added []string
+	for _, key := range sortedKeys(values) {
+		if !done[key] {
+			added = append(added, key+": "+values[key])
+		}
+	}
+	lines = slices.Insert(lines, insert, added...)
+	return strings.Join(lines, "\n") + "\n"
+}
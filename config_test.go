@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := parseConfig(strings.NewReader(`# comment
+type: c7g.large
+hosts:
+  lab-graviton3: ubuntu@10.2.3.4
+	lab-x86: admin@10.2.3.5
+
+count: 10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c["hosts"]["lab-graviton3"] != "ubuntu@10.2.3.4" || c["hosts"]["lab-x86"] != "admin@10.2.3.5" {
+		t.Errorf("unexpected hosts %v", c["hosts"])
+	}
+	if c[""]["type"] != "c7g.large" || c[""]["count"] != "10" {
+		t.Errorf("unexpected top-level keys %v", c[""])
+	}
+
+	if _, err := parseConfig(strings.NewReader("  orphan: x\n")); err == nil {
+		t.Error("expected an error for an indented key outside of a section")
+	}
+}
+
+func TestSetConfigKeys(t *testing.T) {
+	const data = `# rbench
+type: t2.micro
+
+# persistent hosts, for -target
+hosts:
+  lab: ubuntu@10.2.3.4
+`
+	got := setConfigKeys(data, map[string]string{"type": "c7g.large", "region": "eu-west-1"})
+	want := `# rbench
+type: c7g.large
+region: eu-west-1
+
+# persistent hosts, for -target
+hosts:
+  lab: ubuntu@10.2.3.4
+`
+	if got != want {
+		t.Errorf("unexpected config:\n%s\nwant:\n%s", got, want)
+	}
+	if got := setConfigKeys("", map[string]string{"region": "eu-west-1"}); got != "region: eu-west-1\n" {
+		t.Errorf("unexpected new config %q", got)
+	}
+}
+
+func TestApplyConfigDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	count := fs.Int("count", 1, "")
+	instance := fs.String("type", "t2.micro", "")
+	if err := fs.Parse([]string{"-count=3"}); err != nil {
+		t.Fatal(err)
+	}
+	c := configFile{"": {"count": "10", "type": "c7g.large", "region": "eu-west-1"}}
+	if err := applyConfigDefaults(fs, c, "."); err != nil {
+		t.Fatal(err)
+	}
+	if *count != 3 || *instance != "c7g.large" {
+		t.Errorf("unexpected flags count=%d type=%s", *count, *instance)
+	}
+	if err := applyConfigDefaults(fs, configFile{"": {"typo": "1"}}, "."); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+
+	// the subnet of the configuration is in its region
+	defer func(v string) { *regionFlag = v }(*regionFlag)
+	for _, test := range []struct{ region, want string }{{"", "subnet-1"}, {"eu-west-1", "subnet-1"}, {"us-west-2", ""}} {
+		*regionFlag = test.region
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		subnet := fs.String("subnet", "", "")
+		if err := applyConfigDefaults(fs, configFile{"": {"region": "eu-west-1", "subnet": "subnet-1"}}, "."); err != nil {
+			t.Fatal(err)
+		}
+		if *subnet != test.want {
+			t.Errorf("-region=%s: got subnet %q, want %q", test.region, *subnet, test.want)
+		}
+	}
+}
+
+func TestApplyConfigDefaultsPackages(t *testing.T) {
+	c := configFile{
+		"":         {"count": "5", "benchtime": "1s"},
+		"packages": {"./fft": "count=10, benchtime=2s", "./pairing/...": "count=3", "pairing/bls": "count=4"},
+	}
+	for _, test := range []struct {
+		pkg, args string
+		count     int
+		benchtime string
+	}{
+		{"./fft", "", 10, "2s"},
+		{"fft", "-count=2", 2, "2s"},
+		{"./pairing/bn254", "", 3, "1s"},
+		{"./pairing/bls", "", 4, "1s"},
+		{"./other", "", 5, "1s"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		count := fs.Int("count", 1, "")
+		benchtime := fs.String("benchtime", "", "")
+		if err := fs.Parse(strings.Fields(test.args)); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyConfigDefaults(fs, c, test.pkg); err != nil {
+			t.Fatal(err)
+		}
+		if *count != test.count || *benchtime != test.benchtime {
+			t.Errorf("%s %s: got count=%d benchtime=%s, want count=%d benchtime=%s",
+				test.pkg, test.args, *count, *benchtime, test.count, test.benchtime)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := applyConfigDefaults(fs, configFile{"packages": {"./fft": "typo=1"}}, "./fft"); err == nil {
+		t.Error("expected an error for an unknown flag")
+	}
+
+	// list values keep their commas
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	gogc := fs.String("gogc", "", "")
+	count := fs.Int("count", 1, "")
+	if err := applyConfigDefaults(fs, configFile{"packages": {"./fft": "gogc=off,100, 200, count=2"}}, "./fft"); err != nil {
+		t.Fatal(err)
+	}
+	if *gogc != "off,100,200" || *count != 2 {
+		t.Errorf("got gogc=%s count=%d, want gogc=off,100,200 count=2", *gogc, *count)
+	}
+	if err := applyConfigDefaults(fs, configFile{"packages": {"./fft": "gogc=off, typo=1"}}, "./fft"); err == nil {
+		t.Error("expected an error for an unknown flag after a list value")
+	}
+}
+
+func TestLoadConfigTeam(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("RBENCH_CONFIG", filepath.Join(dir, "config"))
+	if err := os.WriteFile(filepath.Join(dir, "team"), []byte("security-group: sg-team\ntype: c7g.large\namis:\n  arm64: ami-team\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte("type: c7i.large\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c[""]["type"] != "c7i.large" || c[""]["security-group"] != "sg-team" || c["amis"]["arm64"] != "ami-team" {
+		t.Errorf("unexpected merged config %v", c)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// flagConditions are the flags, or flag values, of the flagConflicts table, by the name they
+// are reported with.
+var flagConditions = map[string]func() bool{
+	"-aslr=false":           func() bool { return !*aslr },
+	"-bench-policy":         func() bool { return *benchPolicy != "" },
+	"-bundle":               func() bool { return *bundleFile != "" },
+	"-budget-time":          func() bool { return *budgetTime > 0 },
+	"-compare":              func() bool { return *compareFlag != "" },
+	"-confidential":         func() bool { return *confidential != "" },
+	"-confidential=enclave": func() bool { return *confidential == "enclave" },
+	"-core":                 func() bool { return *coreDumps },
+	"-core-class":           func() bool { return *coreClass != "" },
+	"-coverprofile":         func() bool { return *coverProfile != "" },
+	"-cpuprofile":           func() bool { return *cpuProfile != "" },
+	"-debug":                func() bool { return *debugFlag != "" },
+	"-efa":                  func() bool { return *efaFlag },
+	"-eice":                 func() bool { return *eiceFlag },
+	"-ena-express":          func() bool { return *enaExpress },
+	"-gcflags":              func() bool { return *gcflagsFlag != "" },
+	"-gcstats":              func() bool { return *gcStats },
+	"-gogc":                 func() bool { return *gogcFlag != "" },
+	"-ipv6":                 func() bool { return *ipv6Only },
+	"-json":                 func() bool { return *jsonFlag },
+	"-keep":                 func() bool { return *keepFlag },
+	"-max-instances":        func() bool { return *maxInstances > 0 },
+	"-mitigations-off":      func() bool { return *mitigationsOff },
+	"-nodes":                func() bool { return *nodesFlag > 1 },
+	"-os":                   func() bool { return *osFlag != "" },
+	"-os=alpine":            func() bool { return slices.Contains(splitList(*osFlag), "alpine") },
+	"-os other than ubuntu or amazonlinux": func() bool {
+		return slices.ContainsFunc(splitList(*osFlag), func(name string) bool { return name != "ubuntu" && name != "amazonlinux" })
+	},
+	"several -os":      func() bool { return len(splitList(*osFlag)) > 1 },
+	"several -type":    func() bool { return len(splitList(*instanceType)) > 1 },
+	"-provenance":      func() bool { return *provenanceFile != "" },
+	"-provider=gcp":    func() bool { return *providerFlag == "gcp" },
+	"-require-metal":   func() bool { return *requireMetal },
+	"-runtime-metrics": func() bool { return *runtimeMetrics },
+	"-shards":          func() bool { return *shardsFlag > 1 },
+	"-slices":          func() bool { return *slicesFlag != "" },
+	"-static":          func() bool { return *staticFlag },
+	"-subnet":          func() bool { return *subnetFlag != "" },
+	"-target":          func() bool { return *targetFlag != "" },
+	"-time-tests":      func() bool { return *timeTests },
+	"-tmpfs":           func() bool { return *tmpfsFlag != "" },
+	"-upload":          func() bool { return *uploadFlag != "" },
+	"-warmup":          func() bool { return *warmupPasses > 0 || *warmupCmd != "" },
+	"-wasm":            func() bool { return *wasmFlag != "" },
+	"-watchdog":        func() bool { return *watchdog > 0 },
+	"-with-local":      func() bool { return *withLocal },
+}
+
+// flagConflicts are the modes of a run and the flags they can't be used with, checked by
+// checkFlagConflicts; a pair is listed once, under either flag.
+var flagConflicts = []struct {
+	mode string
+	with []string
+}{
+	// the trace timestamps are relative to the start of each process
+	{"-gcstats", []string{"-budget-time", "-gogc"}},
+	// these rely on a native process
+	{"-wasm", []string{"-debug", "-core", "-with-local", "-static"}},
+	// the harness annotates the plain text output of a native process
+	{"-runtime-metrics", []string{"-json", "-wasm"}},
+	// these rely on the single ./bench process of an instance
+	{"-slices", []string{"several -os", "-debug", "-wasm", "-core", "-with-local", "-gogc", "-budget-time", "-gcstats", "-coverprofile",
+		"-cpuprofile", "-provenance", "-watchdog", "-tmpfs", "-core-class", "-warmup"}},
+	// the assets are shipped next to ./bench, see shipAssets
+	{"-upload", []string{"-slices", "-wasm", "-debug", "-confidential=enclave"}},
+	// these replace the benchmark run
+	{"-time-tests", []string{"-json", "-slices", "-gogc", "-budget-time", "-wasm", "-confidential"}},
+	// these rely on the single ./bench binary of an instance
+	{"-compare", []string{"-slices", "-gogc", "-budget-time", "-debug", "-wasm", "-core", "-with-local", "-gcstats", "-coverprofile",
+		"-cpuprofile", "-provenance", "-tmpfs", "-confidential", "-shards", "-time-tests", "-nodes"}},
+	{"-shards", []string{"-target", "-slices", "-debug", "-with-local"}},
+	// the debugger needs -gcflags=all=-N -l, which would replace them
+	{"-debug", []string{"-gcflags"}},
+	{"-gogc", []string{"-budget-time"}},
+	// these are EC2 features
+	{"-provider=gcp", []string{"-os", "-subnet", "-ipv6", "-efa", "-ena-express", "-require-metal", "-max-instances"}},
+	// the peers run the benchmark as is, along with the first node
+	{"-nodes", []string{"-provider=gcp", "-target", "-keep", "several -os", "-shards", "-gogc", "-slices", "-budget-time", "-time-tests",
+		"-debug", "-confidential", "-bench-policy", "-warmup", "-wasm"}},
+	// the enclave runs the test binary alone, without the instance tooling around ./bench
+	{"-confidential=enclave", []string{"-gogc", "-budget-time", "-slices", "-debug", "-wasm", "-core", "-gcstats", "-coverprofile",
+		"-cpuprofile", "-watchdog", "-tmpfs", "-warmup", "-aslr=false", "-core-class"}},
+	{"-confidential", []string{"-provider=gcp", "-target"}},
+	// only the ubuntu and amazonlinux images have the EC2 Instance Connect agent
+	{"-eice", []string{"-provider=gcp", "-target", "-ipv6", "-os other than ubuntu or amazonlinux"}},
+	// the kernel command line is set with update-grub or grubby, which alpine has neither of
+	{"-mitigations-off", []string{"-os=alpine"}},
+	{"-bench-policy", []string{"-provider=gcp", "-target", "-slices", "-wasm", "-confidential=enclave"}},
+	// the host is not launched by rbench
+	{"-keep", []string{"-target"}},
+	// each type runs in its own rbench, with its own outputs
+	{"several -type", []string{"-target", "-debug", "-with-local", "-bundle", "-provenance"}},
+}
+
+// checkFlagConflicts returns an error for the first mode set along with flags it can't be used
+// with.
+func checkFlagConflicts() error {
+	for _, c := range flagConflicts {
+		if !flagConditions[c.mode]() {
+			continue
+		}
+		if set := conflictsWith(c.mode); len(set) > 0 {
+			return fmt.Errorf("%s can't be used with %s", c.mode, joinOr(set))
+		}
+	}
+	return nil
+}
+
+// conflictsWith returns the flags set that mode can't be used with, whether it's set or not.
+func conflictsWith(mode string) []string {
+	var set []string
+	for _, c := range flagConflicts {
+		switch {
+		case c.mode == mode:
+			for _, name := range c.with {
+				if flagConditions[name]() {
+					set = append(set, name)
+				}
+			}
+		case slices.Contains(c.with, mode) && flagConditions[c.mode]():
+			set = append(set, c.mode)
+		}
+	}
+	return set
+}
+
+// joinOr joins the names as "a, b or c".
+func joinOr(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestFlagConflicts(t *testing.T) {
+	for _, c := range flagConflicts {
+		for _, name := range append([]string{c.mode}, c.with...) {
+			if flagConditions[name] == nil {
+				t.Errorf("%s: unknown flag %q", c.mode, name)
+			}
+		}
+	}
+
+	defer func(slices, osName, debug string) { *slicesFlag, *osFlag, *debugFlag = slices, osName, debug }(*slicesFlag, *osFlag, *debugFlag)
+	*slicesFlag, *osFlag, *debugFlag = "", "ubuntu,alpine", "dlv"
+	if err := checkFlagConflicts(); err != nil {
+		t.Errorf("unexpected conflict: %v", err)
+	}
+	*slicesFlag = "./a:BenchmarkA"
+	if err := checkFlagConflicts(); err == nil || err.Error() != "-slices can't be used with several -os or -debug" {
+		t.Errorf("unexpected error %v", err)
+	}
+	// the pairs are checked both ways
+	if got := conflictsWith("-debug"); len(got) != 1 || got[0] != "-slices" {
+		t.Errorf("conflictsWith(-debug) = %v", got)
+	}
+}
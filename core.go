@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// remoteCorePattern is where the instance kernel writes core dumps (-core); %p is the pid.
+func remoteCorePattern() string {
+	return remoteTmp() + "/rbench-core.%p"
+}
+
+// enableCoreDumps makes the kernel write core dumps next to the benchmark binary. The binary
+// runs with GOTRACEBACK=crash so that Go panics and fatal signals abort with a core. It returns
+// the command restoring the previous core pattern, for restoreHost.
+func enableCoreDumps(r remote) (string, error) {
+	out, err := sshRun(r, "cat /proc/sys/kernel/core_pattern && echo "+remoteCorePattern()+" | sudo tee /proc/sys/kernel/core_pattern >/dev/null")
+	if err != nil {
+		return "", fmt.Errorf("unable to enable core dumps, %v", err)
+	}
+	return "echo " + shellQuote(strings.TrimSpace(out)) + " > /proc/sys/kernel/core_pattern", nil
+}
+
+// collectCoreDumps downloads the core dumps left by a crashed run, with the binary, into
+// rbench-core-<name> and prints how to open them.
+func collectCoreDumps(r remote, binary, name string) error {
+	out, err := sshRun(r, "ls "+remoteTmp()+"/rbench-core.* 2>/dev/null || true")
+	if err != nil {
+		return err
+	}
+	cores := strings.Fields(out)
+	if len(cores) == 0 {
+		return nil
+	}
+
+	dir := "rbench-core-" + name
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("unable to create core dump directory, %v", err)
+	}
+	if err := copyFile(binary, filepath.Join(dir, "bench")); err != nil {
+		return err
+	}
+	if err := scpCopy("download core dumps", r.path(remoteTmp()+"/rbench-core.*"), dir); err != nil {
+		return fmt.Errorf("failed to download the core dumps: %w", err)
+	}
+	for _, core := range cores {
+		slog.Info(fmt.Sprintf("benchmark crashed, core dump downloaded: dlv core %s %s",
+			filepath.Join(dir, "bench"), filepath.Join(dir, filepath.Base(core))))
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// coreClassScript lists the core types of a heterogeneous machine: the performance (cpu_core)
+// and efficiency (cpu_atom) cpus of Intel hybrid processors, or the capacity of each cpu on
+// ARM big.LITTLE systems.
+const coreClassScript = `if [ -r /sys/devices/cpu_core/cpus ] && [ -r /sys/devices/cpu_atom/cpus ]; then ` +
+	`echo "p $(cat /sys/devices/cpu_core/cpus)"; echo "e $(cat /sys/devices/cpu_atom/cpus)"; ` +
+	`else for f in /sys/devices/system/cpu/cpu[0-9]*/cpu_capacity; do ` +
+	`[ -r "$f" ] && c=${f#/sys/devices/system/cpu/cpu} && echo "${c%%/*} $(cat $f)"; done; fi`
+
+// coreClasses are the cpus of each core class of a machine, in taskset list format.
+type coreClasses struct {
+	p, e string
+}
+
+// parseCoreClasses parses the output of coreClassScript; it returns zero classes if the cores
+// are all of the same type.
+func parseCoreClasses(out string) (coreClasses, error) {
+	var c coreClasses
+	capacities := make(map[int][]int)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		k, v, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "p":
+			c.p = v
+		case "e":
+			c.e = v
+		default:
+			cpu, err1 := strconv.Atoi(k)
+			capacity, err2 := strconv.Atoi(v)
+			if err1 != nil || err2 != nil {
+				return coreClasses{}, fmt.Errorf("unexpected cpu capacity %q", line)
+			}
+			capacities[capacity] = append(capacities[capacity], cpu)
+		}
+	}
+	if c.p != "" || len(capacities) < 2 {
+		return c, nil
+	}
+	// the largest capacity is the performance class, all the others efficiency
+	var levels []int
+	for capacity := range capacities {
+		levels = append(levels, capacity)
+	}
+	sort.Ints(levels)
+	var e []int
+	for _, l := range levels[:len(levels)-1] {
+		e = append(e, capacities[l]...)
+	}
+	return coreClasses{p: joinCPUs(capacities[levels[len(levels)-1]]), e: joinCPUs(e)}, nil
+}
+
+func joinCPUs(cpus []int) string {
+	sort.Ints(cpus)
+	s := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		s[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(s, ",")
+}
+
+// countCPUs returns the number of cpus of a taskset list (e.g. 0-3,8).
+func countCPUs(list string) int {
+	n := 0
+	for _, part := range strings.Split(list, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		if !isRange {
+			n++
+			continue
+		}
+		a, _ := strconv.Atoi(lo)
+		b, _ := strconv.Atoi(hi)
+		n += b - a + 1
+	}
+	return n
+}
+
+// setupCoreClasses detects the core classes of the machine and pins the benchmark to the
+// -core-class cpus. It returns the classes and the class used as benchfmt configuration lines;
+// on a heterogeneous machine without -core-class, the runs are reported as mixed.
+func setupCoreClasses(t target, r *remote) ([]string, error) {
+	out, err := sshRun(*r, coreClassScript)
+	if err != nil {
+		return nil, fmt.Errorf("unable to detect the core types, %v", err)
+	}
+	c, err := parseCoreClasses(out)
+	if err != nil {
+		return nil, err
+	}
+	if c.p == "" {
+		if *coreClass != "" {
+			return nil, fmt.Errorf("-core-class=%s: the cores of the machine are all of the same type", *coreClass)
+		}
+		return nil, nil
+	}
+	lines := []string{fmt.Sprintf("core-classes: p=%d e=%d", countCPUs(c.p), countCPUs(c.e))}
+	switch *coreClass {
+	case "p":
+		r.pin = c.p
+	case "e":
+		r.pin = c.e
+	default:
+		slog.Warn(t.prefix() + "the machine has performance and efficiency cores: the scheduler mixes them, use -core-class=p or -core-class=e for stable results")
+		return append(lines, "core-class: mixed"), nil
+	}
+	return append(lines, "core-class: "+*coreClass, "core-class-cpus: "+r.pin), nil
+}
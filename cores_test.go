@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseCoreClasses(t *testing.T) {
+	for _, test := range []struct {
+		out  string
+		want coreClasses
+	}{
+		// Intel hybrid
+		{"p 0-7\ne 8-15\n", coreClasses{p: "0-7", e: "8-15"}},
+		// ARM big.LITTLE
+		{"0 446\n1 446\n2 1024\n3 1024\n4 871\n", coreClasses{p: "2,3", e: "0,1,4"}},
+		// homogeneous
+		{"0 1024\n1 1024\n", coreClasses{}},
+		{"", coreClasses{}},
+	} {
+		got, err := parseCoreClasses(test.out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("parseCoreClasses(%q) = %+v, want %+v", test.out, got, test.want)
+		}
+	}
+}
+
+func TestCountCPUs(t *testing.T) {
+	for list, want := range map[string]int{"0-7": 8, "0,1,4": 3, "0-3,8,10-11": 7} {
+		if got := countCPUs(list); got != want {
+			t.Errorf("countCPUs(%s) = %d, want %d", list, got, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	cetypes "github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+// costCmd implements "rbench cost report": the spend of rbench instances per month,
+// per user (or any other tag) and per instance type, from the Cost Explorer API.
+//
+// the rbench tag must be activated as a cost allocation tag in the billing console.
+func costCmd(args []string) error {
+	fs := flag.NewFlagSet("cost", flag.ExitOnError)
+	months := fs.Int("months", 3, "number of months to report, including the current one")
+	tagKey := fs.String("tag", "rbench", "tag to group costs by (rbench: per user)")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench cost report [flags]\n")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "report" {
+		fs.Usage()
+		return fmt.Errorf("expected \"report\"")
+	}
+	fs.Parse(args[1:])
+
+	if err := loadAWSConfig(); err != nil {
+		return err
+	}
+	// Cost Explorer is only served from us-east-1.
+	ceClient := costexplorer.NewFromConfig(awsConfig, func(o *costexplorer.Options) {
+		o.Region = "us-east-1"
+	})
+
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1-*months, 0)
+	end := now.AddDate(0, 0, 1)
+
+	input := &costexplorer.GetCostAndUsageInput{
+		TimePeriod: &cetypes.DateInterval{
+			Start: aws.String(start.Format(time.DateOnly)),
+			End:   aws.String(end.Format(time.DateOnly)),
+		},
+		Granularity: cetypes.GranularityMonthly,
+		Metrics:     []string{"UnblendedCost"},
+		// only resources tagged by rbench
+		Filter: &cetypes.Expression{
+			Not: &cetypes.Expression{
+				Tags: &cetypes.TagValues{Key: aws.String("rbench"), MatchOptions: []cetypes.MatchOption{cetypes.MatchOptionAbsent}},
+			},
+		},
+		GroupBy: []cetypes.GroupDefinition{
+			{Type: cetypes.GroupDefinitionTypeTag, Key: tagKey},
+			{Type: cetypes.GroupDefinitionTypeDimension, Key: aws.String("INSTANCE_TYPE")},
+		},
+	}
+
+	type costLine struct {
+		month, group, instanceType string
+		amount                     float64
+	}
+	var lines []costLine
+	for {
+		out, err := ceClient.GetCostAndUsage(context.TODO(), input)
+		if err != nil {
+			return fmt.Errorf("unable to get cost and usage, %v", err)
+		}
+		for _, r := range out.ResultsByTime {
+			month := aws.ToString(r.TimePeriod.Start)[:7]
+			for _, g := range r.Groups {
+				amount, _ := strconv.ParseFloat(aws.ToString(g.Metrics["UnblendedCost"].Amount), 64)
+				if len(g.Keys) != 2 || amount == 0 {
+					continue
+				}
+				// tag groups are returned as "key$value"
+				_, group, _ := strings.Cut(g.Keys[0], "$")
+				if group == "" {
+					group = "(untagged)"
+				}
+				lines = append(lines, costLine{month, group, g.Keys[1], amount})
+			}
+		}
+		if out.NextPageToken == nil {
+			break
+		}
+		input.NextPageToken = out.NextPageToken
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].month != lines[j].month {
+			return lines[i].month < lines[j].month
+		}
+		if lines[i].group != lines[j].group {
+			return lines[i].group < lines[j].group
+		}
+		return lines[i].instanceType < lines[j].instanceType
+	})
+
+	perMonth := make(map[string]float64)
+	perGroup := make(map[string]float64)
+	var total float64
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "month\t%s\tinstance type\tcost (USD)\n", *tagKey)
+	for _, l := range lines {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\n", l.month, l.group, l.instanceType, l.amount)
+		perMonth[l.month] += l.amount
+		perGroup[l.group] += l.amount
+		total += l.amount
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, m := range sortedKeys(perMonth) {
+		fmt.Fprintf(w, "month %s\t%.2f\n", m, perMonth[m])
+	}
+	for _, g := range sortedKeys(perGroup) {
+		fmt.Fprintf(w, "%s %s\t%.2f\n", *tagKey, g, perGroup[g])
+	}
+	fmt.Fprintf(w, "total\t%.2f\n", total)
+	return w.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// costMeter accumulates the cost of the instances of the run: their on-demand price times their
+// uptime, from launch to termination.
+type costMeter struct {
+	mu      sync.Mutex
+	price   float64              // USD per instance hour, 0 while unknown
+	running map[string]time.Time // launch time of the live instances
+	done    time.Duration        // uptime of the terminated instances
+}
+
+// runCost is the cost meter of the instances launched by this run.
+var runCost = costMeter{running: make(map[string]time.Time)}
+
+func (m *costMeter) start(instanceID string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running[instanceID] = now
+}
+
+func (m *costMeter) stop(instanceID string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if start, ok := m.running[instanceID]; ok {
+		m.done += now.Sub(start)
+		delete(m.running, instanceID)
+	}
+}
+
+func (m *costMeter) setPrice(price float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.price = price
+}
+
+// cost returns the cost at now, and false while the price is unknown or nothing was launched.
+func (m *costMeter) cost(now time.Time) (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	uptime := m.done
+	for _, start := range m.running {
+		uptime += now.Sub(start)
+	}
+	if m.price == 0 || uptime == 0 {
+		return 0, false
+	}
+	return m.price * uptime.Hours(), true
+}
+
+// suffix is appended to the status lines: the cost so far, if known.
+func (m *costMeter) suffix() string {
+	c, ok := m.cost(time.Now())
+	if !ok {
+		return ""
+	}
+	return " [" + formatCost(c) + "]"
+}
+
+// formatCost formats a cost in USD, with a tenth of a cent under a dollar.
+func formatCost(c float64) string {
+	if c < 1 {
+		return fmt.Sprintf("$%.3f", c)
+	}
+	return fmt.Sprintf("$%.2f", c)
+}
+
+// meterCost prices the instance type for runCost and, on a terminal, refreshes the cost of the
+// status line every second until ctx is done.
+func meterCost(ctx context.Context, instanceType string) {
+	go func() {
+		price, err := onDemandPrice(instanceType)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("no cost meter, %v", err))
+			return
+		}
+		runCost.setPrice(price)
+	}()
+	if !isTerminal(os.Stderr) {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stderrTerminal.refreshStatus()
+			}
+		}
+	}()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCostMeter(t *testing.T) {
+	m := costMeter{running: make(map[string]time.Time)}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.start("i-1", t0)
+	m.start("i-2", t0.Add(30*time.Minute))
+	if _, ok := m.cost(t0.Add(time.Hour)); ok {
+		t.Fatal("no cost expected while the price is unknown")
+	}
+	m.setPrice(2)
+	m.stop("i-1", t0.Add(time.Hour))
+	m.stop("i-1", t0.Add(2*time.Hour)) // terminated twice
+	// 1h + 1h of i-2 at $2/h
+	if c, ok := m.cost(t0.Add(90 * time.Minute)); !ok || math.Abs(c-4) > 1e-9 {
+		t.Errorf("cost = %v, %v; want 4", c, ok)
+	}
+	m.stop("i-2", t0.Add(90*time.Minute))
+	if c, _ := m.cost(t0.Add(10 * time.Hour)); math.Abs(c-4) > 1e-9 {
+		t.Errorf("cost after termination = %v; want 4", c)
+	}
+	if got := formatCost(0.0123); got != "$0.012" {
+		t.Errorf("formatCost(0.0123) = %q", got)
+	}
+	if got := formatCost(12.345); got != "$12.35" {
+		t.Errorf("formatCost(12.345) = %q", got)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// remoteCoverProfile returns a new coverage profile path on the instance; each invocation of the
+// benchmark binary (see -budget-time) writes its own profile.
+func remoteCoverProfile() string {
+	return fmt.Sprintf("%s/rbench-cover-%s.out", remoteTmp(), randString(7))
+}
+
+// coverDirs are the local directories the coverage profiles of the targets are downloaded to.
+var coverDirs struct {
+	sync.Mutex
+	dirs []string
+}
+
+// downloadCoverProfiles retrieves the coverage profiles written on the instance.
+func downloadCoverProfiles(r remote) error {
+	dir, err := os.MkdirTemp("", "rbench-cover-")
+	if err != nil {
+		return fmt.Errorf("unable to create coverage directory, %v", err)
+	}
+	coverDirs.Lock()
+	coverDirs.dirs = append(coverDirs.dirs, dir)
+	coverDirs.Unlock()
+
+	if err := scpCopy("download coverage", r.path(remoteTmp()+"/rbench-cover-*.out"), dir); err != nil {
+		return fmt.Errorf("failed to download the coverage profiles: %w", err)
+	}
+	return nil
+}
+
+// writeCoverProfile merges the downloaded coverage profiles into path.
+func writeCoverProfile(path string) error {
+	coverDirs.Lock()
+	defer coverDirs.Unlock()
+
+	var profiles []io.Reader
+	for _, dir := range coverDirs.dirs {
+		defer os.RemoveAll(dir)
+		files, _ := filepath.Glob(filepath.Join(dir, "*.out"))
+		for _, file := range files {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			profiles = append(profiles, f)
+		}
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("no coverage profile was retrieved")
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create coverage profile, %v", err)
+	}
+	if err := mergeCoverProfiles(out, profiles); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// mergeCoverProfiles merges coverage profiles of the same binary: counts of a block are
+// summed, or or-ed in set mode.
+func mergeCoverProfiles(w io.Writer, profiles []io.Reader) error {
+	var (
+		mode   string
+		blocks []string // in order of first appearance
+		counts = make(map[string]int64)
+	)
+	for _, p := range profiles {
+		scanner := bufio.NewScanner(p)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if m, ok := strings.CutPrefix(line, "mode: "); ok {
+				if mode != "" && m != mode {
+					return fmt.Errorf("cannot merge coverage profiles of modes %s and %s", mode, m)
+				}
+				mode = m
+				continue
+			}
+			// file.go:12.34,56.2 3 1
+			i := strings.LastIndexByte(line, ' ')
+			if i < 0 {
+				continue
+			}
+			block := line[:i]
+			count, err := strconv.ParseInt(line[i+1:], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid coverage profile line %q", line)
+			}
+			prev, seen := counts[block]
+			if !seen {
+				blocks = append(blocks, block)
+			}
+			if mode == "set" {
+				counts[block] = max(prev, min(count, 1))
+			} else {
+				counts[block] = prev + count
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	if mode == "" {
+		return fmt.Errorf("coverage profile without mode line")
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %s\n", mode)
+	for _, block := range blocks {
+		fmt.Fprintf(bw, "%s %d\n", block, counts[block])
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMergeCoverProfiles(t *testing.T) {
+	for _, tt := range []struct {
+		mode     string
+		a, b     string
+		expected string
+	}{
+		{"set", "0", "1", "1"},
+		{"count", "2", "3", "5"},
+	} {
+		p1 := "mode: " + tt.mode + "\nx.go:1.1,2.2 1 " + tt.a + "\nx.go:3.1,4.2 2 0\n"
+		p2 := "mode: " + tt.mode + "\nx.go:1.1,2.2 1 " + tt.b + "\nx.go:3.1,4.2 2 0\n"
+		var out strings.Builder
+		if err := mergeCoverProfiles(&out, []io.Reader{strings.NewReader(p1), strings.NewReader(p2)}); err != nil {
+			t.Fatal(err)
+		}
+		expected := "mode: " + tt.mode + "\nx.go:1.1,2.2 1 " + tt.expected + "\nx.go:3.1,4.2 2 0\n"
+		if out.String() != expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.mode, expected, out.String())
+		}
+	}
+
+	var out strings.Builder
+	err := mergeCoverProfiles(&out, []io.Reader{strings.NewReader("mode: set\n"), strings.NewReader("mode: count\n")})
+	if err == nil {
+		t.Error("expected an error merging profiles of different modes")
+	}
+}
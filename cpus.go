@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// readCPUs returns the number of vCPUs and of physical cores of the machine; cores is 0 if
+// lscpu isn't available.
+func readCPUs(r remote) (vcpus, cores int, err error) {
+	out, err := sshRun(r, "nproc; lscpu -p=Core,Socket 2>/dev/null | grep -v '^#' | sort -u | wc -l")
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to count the CPUs, %v", err)
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unable to count the CPUs: %q", out)
+	}
+	if vcpus, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, fmt.Errorf("unable to count the CPUs: %q", out)
+	}
+	cores, _ = strconv.Atoi(fields[1])
+	return vcpus, cores, nil
+}
+
+// cpuList returns the default -test.cpu list of a machine: 1, the physical cores and all the
+// vCPUs, so that the parallel benchmarks show the scaling and the effect of SMT.
+func cpuList(vcpus, cores int) string {
+	list := []int{1, vcpus}
+	if cores > 0 && cores < vcpus {
+		list = append(list, cores)
+	}
+	slices.Sort(list)
+	list = slices.Compact(list)
+	s := make([]string, len(list))
+	for i, n := range list {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+// setupCPUs sets the -test.cpu list of r from its CPUs, without -cpu, and warns if -cpu exceeds
+// them. It returns the CPU counts as benchfmt configuration lines.
+func setupCPUs(t target, r *remote) ([]string, error) {
+	vcpus, cores, err := readCPUs(*r)
+	if err != nil {
+		return nil, err
+	}
+	if r.pin != "" {
+		// GOMAXPROCS defaults to the cpus of the affinity mask
+		vcpus, cores = countCPUs(r.pin), 0
+	}
+	if *cpuFlag > vcpus {
+		slog.Warn(fmt.Sprintf("%s-cpu=%d exceeds the %d vCPUs of the machine: the goroutines are time-sliced, the results don't measure %d-way parallelism",
+			t.prefix(), *cpuFlag, vcpus, *cpuFlag))
+	}
+	if *cpuFlag == 0 {
+		r.cpus = cpuList(vcpus, cores)
+	}
+	lines := []string{fmt.Sprintf("vcpus: %d", vcpus)}
+	if cores > 0 {
+		lines = append(lines, fmt.Sprintf("cores: %d", cores))
+	}
+	return lines, nil
+}
+
+// cpuArg returns the -test.cpu argument of the benchmark on r, if any.
+func cpuArg(r remote) string {
+	switch {
+	case *cpuFlag > 0:
+		return fmt.Sprintf("-test.cpu=%d", *cpuFlag)
+	case r.cpus != "":
+		return "-test.cpu=" + r.cpus
+	}
+	return ""
+}
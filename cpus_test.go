@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestCPUList(t *testing.T) {
+	for _, test := range []struct {
+		vcpus, cores int
+		want         string
+	}{
+		{2, 1, "1,2"},
+		{8, 4, "1,4,8"},
+		{4, 4, "1,4"},
+		{4, 0, "1,4"},
+		{1, 1, "1"},
+	} {
+		if got := cpuList(test.vcpus, test.cores); got != test.want {
+			t.Errorf("cpuList(%d, %d) = %s, want %s", test.vcpus, test.cores, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// delvePackage is cross-compiled locally and uploaded to the instance for -debug.
+const delvePackage = "github.com/go-delve/delve/cmd/dlv@latest"
+
+// buildDelve cross-compiles dlv for arch and returns the path of the binary.
+func buildDelve(arch instanceArch) (string, error) {
+	return crossInstall(arch, delvePackage, "dlv")
+}
+
+// crossInstall cross-compiles the command pkg (path@version) named name for arch with go install
+// and returns the path of the binary.
+func crossInstall(arch instanceArch, pkg, name string) (string, error) {
+	gopath, err := exec.Command("go", "env", "GOPATH").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to get GOPATH, %v", err)
+	}
+	cmd := exec.Command("go", "install", pkg)
+	// go install refuses to cross compile to GOBIN; binaries land in GOPATH/bin/linux_<arch>.
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+arch.GoString(), "CGO_ENABLED=0", "GOBIN=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("unable to build %s: %s, %v", name, strings.TrimSpace(string(out)), err)
+	}
+	dir := filepath.Join(filepath.SplitList(strings.TrimSpace(string(gopath)))[0], "bin")
+	if runtime.GOOS != "linux" || runtime.GOARCH != arch.GoString() {
+		dir = filepath.Join(dir, "linux_"+arch.GoString())
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// remoteDelve is the path of dlv on the instance, uploaded with the benchmark binary.
+func remoteDelve() string {
+	return remoteTmp() + "/rbench-dlv"
+}
+
+// debugSession runs the -debug benchmark under a headless Delve server on the instance,
+// forwarded to localhost:-debug-port. It returns when the client detaches.
+func debugSession(t target, r remote) error {
+	port := strconv.Itoa(*debugPort)
+	testArgs := []string{
+		"-test.run=NONE",
+		"-test.bench=" + *debugFlag,
+		"-test.count=1",
+		fmt.Sprintf("-test.benchmem=%t", *benchMem),
+	}
+	testArgs = append(testArgs, extraTestArgs()...)
+	command := "cd " + remoteTmp() + " && " + remoteDelve() + " exec --headless --api-version=2 --listen=127.0.0.1:" + port + " ./bench --"
+	for _, a := range testArgs {
+		command += " " + shellQuote(a)
+	}
+	// the binary is built from the local sources: no path substitution is needed to attach.
+	slog.Info(t.prefix() + fmt.Sprintf("delve listening on localhost:%s; attach with dlv connect localhost:%s "+
+		"or a remote attach configuration in your editor. The instance is terminated when the client detaches.", port, port))
+
+	args := append(sshOptions("-p"), "-L", port+":127.0.0.1:"+port, r.String(), command)
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	start := time.Now()
+	err := cmd.Run()
+	auditCommand(r, command, start, err)
+	if err != nil {
+		return fmt.Errorf("debug session failed, %v", err)
+	}
+	return nil
+}
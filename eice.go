@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// -eice connects to the instances through the EC2 Instance Connect Endpoint of their VPC: they
+// have neither a public address nor a key pair. The ssh destination is the instance id; every
+// ssh and scp connection pushes an ephemeral key of the run to the instance (valid 60 seconds)
+// and tunnels through the endpoint, with the AWS CLI as ProxyCommand. The images must run the
+// EC2 Instance Connect agent (ubuntu, amazonlinux).
+
+var (
+	eiceEndpoint types.Ec2InstanceConnectEndpoint // of the VPC of the instances
+	eiceKey      string                           // ephemeral private key of the run
+)
+
+// setupEICE finds the endpoint of the VPC of the instances, generates the ephemeral key and
+// exports the profile (or the credentials of -role-arn) and the region of the run to the AWS CLI
+// of the ProxyCommand. The key is removed by cleanupEICE.
+func setupEICE() error {
+	if _, err := exec.LookPath("aws"); err != nil {
+		return fmt.Errorf("-eice requires the AWS CLI (aws ec2-instance-connect), %v", err)
+	}
+	vpc, err := instancesVPC()
+	if err != nil {
+		return err
+	}
+	out, err := ec2Client.DescribeInstanceConnectEndpoints(context.TODO(), &ec2.DescribeInstanceConnectEndpointsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{vpc}},
+			{Name: aws.String("state"), Values: []string{string(types.Ec2InstanceConnectEndpointStateCreateComplete)}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to describe the instance connect endpoints, %v", err)
+	}
+	if len(out.InstanceConnectEndpoints) == 0 {
+		return fmt.Errorf("-eice: no EC2 Instance Connect Endpoint in %s (%s)", vpc, awsConfig.Region)
+	}
+	eiceEndpoint = out.InstanceConnectEndpoints[0]
+
+	os.Setenv("AWS_REGION", awsConfig.Region)
+	os.Setenv("AWS_DEFAULT_REGION", awsConfig.Region)
+	if *roleARN == "" {
+		// the CLI resolves and refreshes the credentials of the profile (SSO, assumed roles) itself
+		if *awsProfile != "" {
+			os.Setenv("AWS_PROFILE", *awsProfile)
+		}
+	} else if err := exportEICECredentials(); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "rbench-eice-")
+	if err != nil {
+		return err
+	}
+	eiceKey = filepath.Join(dir, "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", sshKeyName+"-eice", "-f", eiceKey).CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("unable to generate the ephemeral ssh key: %s, %v", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// exportEICECredentials exports the credentials of -role-arn, which the CLI doesn't assume
+// itself, to the CLI of the next ProxyCommand. The credentials are temporary: they are exported
+// again before each connection, from the cache of the SDK, which renews them before they expire.
+func exportEICECredentials() error {
+	creds, err := awsConfig.Credentials.Retrieve(context.TODO())
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the AWS credentials, %v", err)
+	}
+	os.Setenv("AWS_ACCESS_KEY_ID", creds.AccessKeyID)
+	os.Setenv("AWS_SECRET_ACCESS_KEY", creds.SecretAccessKey)
+	os.Setenv("AWS_SESSION_TOKEN", creds.SessionToken)
+	os.Unsetenv("AWS_PROFILE")
+	return nil
+}
+
+// cleanupEICE removes the ephemeral key.
+func cleanupEICE() {
+	if eiceKey != "" {
+		os.RemoveAll(filepath.Dir(eiceKey))
+	}
+}
+
+// eiceProxyCommand is the ssh ProxyCommand pushing the ephemeral key to the instance (%h) for
+// the user (%r), and tunneling to its ssh port (%p) through the endpoint.
+func eiceProxyCommand() string {
+	if *roleARN != "" {
+		if err := exportEICECredentials(); err != nil {
+			slog.Warn(err.Error())
+		}
+	}
+	endpoint := aws.ToString(eiceEndpoint.InstanceConnectEndpointId)
+	return fmt.Sprintf("aws ec2-instance-connect send-ssh-public-key --instance-id %%h --instance-os-user %%r --ssh-public-key file://%s.pub >/dev/null && "+
+		"exec aws ec2-instance-connect open-tunnel --instance-id %%h --remote-port %%p --instance-connect-endpoint-id %s", eiceKey, endpoint)
+}
+
+// waitForEICEPort waits until the ssh server of a new instance answers through the endpoint: the
+// tunnel carries its banner.
+func waitForEICEPort(ctx context.Context, instanceID string) error {
+	endpoint := aws.ToString(eiceEndpoint.InstanceConnectEndpointId)
+	for i := 0; i < 10; i++ {
+		if *roleARN != "" {
+			if err := exportEICECredentials(); err != nil {
+				return err
+			}
+		}
+		attempt, cancel := context.WithTimeout(ctx, 30*time.Second)
+		cmd := exec.CommandContext(attempt, "aws", "ec2-instance-connect", "open-tunnel", "--instance-id", instanceID,
+			"--remote-port", fmt.Sprint(*sshPort), "--instance-connect-endpoint-id", endpoint)
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err == nil {
+			banner := make([]byte, 4)
+			_, err = io.ReadFull(stdout, banner)
+			if err == nil && string(banner) != "SSH-" {
+				err = fmt.Errorf("unexpected banner %q", banner)
+			}
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return fmt.Errorf("unable to connect to instance through %s", endpoint)
+}
+
+// ensureEICESecurityGroup sets securityGroupID to the security group of this machine for -eice:
+// ssh is allowed from the security groups of the endpoint only.
+func ensureEICESecurityGroup() error {
+	vpc, err := instancesVPC()
+	if err != nil {
+		return err
+	}
+	name := sshKeyName + "-eice"
+	out, err := ec2Client.DescribeSecurityGroups(context.TODO(), &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("group-name"), Values: []string{name}},
+			{Name: aws.String("vpc-id"), Values: []string{vpc}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to describe security groups, %v", err)
+	}
+	var perms []types.IpPermission
+	if len(out.SecurityGroups) > 0 {
+		securityGroupID = aws.ToString(out.SecurityGroups[0].GroupId)
+		perms = out.SecurityGroups[0].IpPermissions
+	} else {
+		created, err := ec2Client.CreateSecurityGroup(context.TODO(), &ec2.CreateSecurityGroupInput{
+			GroupName:   aws.String(name),
+			Description: aws.String("rbench instances, ssh access through the EC2 Instance Connect Endpoint"),
+			VpcId:       aws.String(vpc),
+			TagSpecifications: []types.TagSpecification{{
+				ResourceType: types.ResourceTypeSecurityGroup,
+				Tags:         []types.Tag{{Key: aws.String("rbench"), Value: aws.String(awsUserName)}},
+			}},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create security group, %v", err)
+		}
+		securityGroupID = aws.ToString(created.GroupId)
+	}
+
+	var missing []types.UserIdGroupPair
+	for _, group := range eiceEndpoint.SecurityGroupIds {
+		if !sshFromGroup(perms, group) {
+			missing = append(missing, types.UserIdGroupPair{GroupId: aws.String(group)})
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	_, err = ec2Client.AuthorizeSecurityGroupIngress(context.TODO(), &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId: aws.String(securityGroupID),
+		IpPermissions: []types.IpPermission{{
+			IpProtocol: aws.String("tcp"), FromPort: aws.Int32(int32(*sshPort)), ToPort: aws.Int32(int32(*sshPort)), UserIdGroupPairs: missing,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to allow ssh from the endpoint in security group %s, %v", securityGroupID, err)
+	}
+	return nil
+}
+
+// sshFromGroup reports whether the ssh rules of a security group allow the security group id.
+func sshFromGroup(perms []types.IpPermission, id string) bool {
+	for _, p := range perms {
+		if aws.ToString(p.IpProtocol) != "tcp" || aws.ToInt32(p.FromPort) != int32(*sshPort) || aws.ToInt32(p.ToPort) != int32(*sshPort) {
+			continue
+		}
+		for _, g := range p.UserIdGroupPairs {
+			if aws.ToString(g.GroupId) == id {
+				return true
+			}
+		}
+	}
+	return false
+}
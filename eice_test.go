@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestEICESSHOptions(t *testing.T) {
+	defer func(key string, endpoint types.Ec2InstanceConnectEndpoint) { eiceKey, eiceEndpoint = key, endpoint }(eiceKey, eiceEndpoint)
+	eiceKey = "/tmp/rbench-eice-1/id_ed25519"
+	eiceEndpoint = types.Ec2InstanceConnectEndpoint{InstanceConnectEndpointId: aws.String("eice-0123")}
+
+	opts := sshOptions("-p")
+	if i := slices.Index(opts, "-i"); i < 0 || opts[i+1] != eiceKey {
+		t.Errorf("ephemeral key not used: %v", opts)
+	}
+	var proxy string
+	for _, o := range opts {
+		if p, ok := strings.CutPrefix(o, "ProxyCommand="); ok {
+			proxy = p
+		}
+	}
+	for _, want := range []string{"send-ssh-public-key --instance-id %h --instance-os-user %r --ssh-public-key file://" + eiceKey + ".pub",
+		"exec aws ec2-instance-connect open-tunnel --instance-id %h --remote-port %p --instance-connect-endpoint-id eice-0123"} {
+		if !strings.Contains(proxy, want) {
+			t.Errorf("ProxyCommand %q, expected %q", proxy, want)
+		}
+	}
+}
+
+func TestSSHFromGroup(t *testing.T) {
+	perms := []types.IpPermission{
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int32(443), ToPort: aws.Int32(443),
+			UserIdGroupPairs: []types.UserIdGroupPair{{GroupId: aws.String("sg-https")}}},
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int32(22), ToPort: aws.Int32(22),
+			UserIdGroupPairs: []types.UserIdGroupPair{{GroupId: aws.String("sg-eice")}}},
+	}
+	if !sshFromGroup(perms, "sg-eice") || sshFromGroup(perms, "sg-https") {
+		t.Error("unexpected ssh rules")
+	}
+}
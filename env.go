@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// readEnvironment returns the kernel, microcode revision and local Go version as benchfmt
+// configuration lines; the benchmark binary itself prints goos, goarch, pkg and cpu.
+func readEnvironment(r remote) ([]string, error) {
+	out, err := sshRun(r, "uname -r; grep -m1 '^microcode' /proc/cpuinfo | cut -d: -f2 || true")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the instance environment, %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	env := []string{"kernel: " + strings.TrimSpace(lines[0])}
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		env = append(env, "microcode: "+strings.TrimSpace(lines[1]))
+	}
+	if v, err := exec.Command("go", "env", "GOVERSION").Output(); err == nil {
+		env = append(env, "go-version: "+strings.TrimSpace(string(v)))
+	}
+	return env, nil
+}
+
+// flagsLine returns the flags set on the command line of fs, as a benchfmt configuration line:
+// envdiff shows the runs differing by their flags (-count, -gogc, -tune, ...), not only by their
+// environment. The values with spaces or quotes are shell-quoted.
+func flagsLine(fs *flag.FlagSet) string {
+	var args []string
+	fs.Visit(func(f *flag.Flag) {
+		v := f.Value.String()
+		if v == "" || strings.ContainsAny(v, " \t'\"\\$") {
+			v = shellQuote(v)
+		}
+		args = append(args, "-"+f.Name+"="+v)
+	})
+	return "flags: " + strings.Join(args, " ")
+}
+
+var configLineRegexp = regexp.MustCompile(`^([a-z][^\s:]*): (.*)$`)
+
+// readConfigLines returns the benchfmt configuration lines of a result file; for keys set several
+// times, the last value wins.
+func readConfigLines(r io.Reader) (map[string]string, error) {
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if m := configLineRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+			config[m[1]] = strings.TrimSpace(m[2])
+		}
+	}
+	return config, scanner.Err()
+}
+
+// volatileKeys change at every run and are not part of the environment.
+var volatileKeys = map[string]bool{"runstamp": true, "instance-ip": true}
+
+// diffConfig writes the keys whose values differ between a and b, in a unified diff style.
+func diffConfig(w io.Writer, a, b map[string]string, all bool) (changed int) {
+	keys := make(map[string]string)
+	for k := range a {
+		keys[k] = ""
+	}
+	for k := range b {
+		keys[k] = ""
+	}
+	for _, k := range sortedKeys(keys) {
+		if volatileKeys[k] && !all {
+			continue
+		}
+		va, inA := a[k]
+		vb, inB := b[k]
+		if inA == inB && va == vb {
+			continue
+		}
+		changed++
+		if inA {
+			fmt.Fprintf(w, "-%s: %s\n", k, va)
+		}
+		if inB {
+			fmt.Fprintf(w, "+%s: %s\n", k, vb)
+		}
+	}
+	return changed
+}
+
+// envdiffCmd implements "rbench envdiff <run-a> <run-b>": the differences between the environments
+// recorded in the headers of two saved rbench outputs.
+func envdiffCmd(args []string) error {
+	fs := flag.NewFlagSet("envdiff", flag.ExitOnError)
+	all := fs.Bool("all", false, "also show the keys that change at every run (runstamp, instance-ip)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench envdiff [flags] <run-a> <run-b>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("expected two result files")
+	}
+
+	var configs [2]map[string]string
+	for i, name := range fs.Args() {
+		f, err := openResults(name)
+		if err != nil {
+			return err
+		}
+		configs[i], err = readConfigLines(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("unable to read %s, %v", name, err)
+		}
+	}
+	fmt.Printf("--- %s\n+++ %s\n", fs.Arg(0), fs.Arg(1))
+	if diffConfig(os.Stdout, configs[0], configs[1], *all) == 0 {
+		fmt.Println("same environment")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestDiffConfig(t *testing.T) {
+	a, err := readConfigLines(strings.NewReader(`instance-type: c7i.large
+kernel: 6.8.0-1012-aws
+runstamp: 2024-09-01T10:00:00Z
+goos: linux
+BenchmarkA-2 100 10 ns/op
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := readConfigLines(strings.NewReader(`instance-type: c7i.large
+kernel: 6.8.0-1015-aws
+runstamp: 2024-09-02T10:00:00Z
+goos: linux
+tune: network
+BenchmarkA-2 100 12 ns/op
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if n := diffConfig(&out, a, b, false); n != 2 {
+		t.Errorf("expected 2 changes, got %d", n)
+	}
+	expected := "-kernel: 6.8.0-1012-aws\n+kernel: 6.8.0-1015-aws\n+tune: network\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestFlagsLine(t *testing.T) {
+	fs := flag.NewFlagSet("rbench", flag.ContinueOnError)
+	fs.Int("count", 10, "")
+	fs.String("gogc", "", "")
+	fs.String("warmup-cmd", "", "")
+	fs.String("type", "t3.micro", "")
+	if err := fs.Parse([]string{"-type=c7g.large", "-gogc=off,100", "-warmup-cmd=curl -s localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	// the flags set, in lexical order, and not the defaults
+	if got, want := flagsLine(fs), "flags: -gogc=off,100 -type=c7g.large -warmup-cmd='curl -s localhost'"; got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// fanoutMachine is a machine of rbench fanout: a registered host, or a cloud profile of the
+// configuration (run flags, e.g. provider=gcp, type=c4a-standard-4).
+type fanoutMachine struct {
+	name string
+	args []string
+}
+
+// flagArgs turns a "key=value, key=value" list of the configuration into run flag arguments.
+func flagArgs(list string) ([]string, error) {
+	var args []string
+	for _, kv := range splitList(list) {
+		key, value, ok := strings.Cut(kv, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || flag.CommandLine.Lookup(key) == nil {
+			return nil, fmt.Errorf("expected flag=value, got %q", kv)
+		}
+		args = append(args, "-"+key+"="+value)
+	}
+	return args, nil
+}
+
+// fanoutMachines returns the registered hosts and the cloud profiles of the configuration,
+// restricted to the only ones if not empty.
+func fanoutMachines(c configFile, only []string) ([]fanoutMachine, error) {
+	var machines []fanoutMachine
+	for _, name := range sortedKeys(c["hosts"]) {
+		machines = append(machines, fanoutMachine{name: name, args: []string{"-target=" + name}})
+	}
+	for _, name := range sortedKeys(c["profiles"]) {
+		if _, ok := c["hosts"][name]; ok {
+			return nil, fmt.Errorf("%s: %s is both a host and a profile", configPath(), name)
+		}
+		args, err := flagArgs(c["profiles"][name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: profiles: %s: %v", configPath(), name, err)
+		}
+		machines = append(machines, fanoutMachine{name: name, args: args})
+	}
+	if len(only) > 0 {
+		for _, name := range only {
+			if !slices.ContainsFunc(machines, func(m fanoutMachine) bool { return m.name == name }) {
+				return nil, fmt.Errorf("unknown machine %q; register it in the hosts or profiles section of %s", name, configPath())
+			}
+		}
+		machines = slices.DeleteFunc(machines, func(m fanoutMachine) bool { return !slices.Contains(only, m.name) })
+	}
+	return machines, nil
+}
+
+// fanoutCmd implements "rbench fanout": the same suite run concurrently on every registered
+// host and cloud profile, summarized as a hardware coverage matrix. The suites are run flags in
+// the suites section of the configuration:
+//
+//	suites:
+//	  nightly: bench=., count=10, benchtime=2s
+//	profiles:
+//	  graviton4: type=c8g.xlarge
+//	  axion: provider=gcp, type=c4a-standard-4
+func fanoutCmd(args []string) error {
+	fs := flag.NewFlagSet("fanout", flag.ExitOnError)
+	suite := fs.String("suite", "", "suite of the suites section of the configuration to run")
+	only := fs.String("machines", "", "comma-separated hosts and profiles to run on (default: all)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench fanout -suite <name> [flags] [-- run flags] [package]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	var runArgs []string
+	if *suite != "" {
+		list, ok := c["suites"][*suite]
+		if !ok {
+			return fmt.Errorf("unknown suite %q; define it in the suites section of %s", *suite, configPath())
+		}
+		if runArgs, err = flagArgs(list); err != nil {
+			return fmt.Errorf("%s: suites: %s: %v", configPath(), *suite, err)
+		}
+	}
+	// the run flags follow the fanout flags (after --)
+	runArgs = append(append(runArgs, "-ci=off"), fs.Args()...)
+	machines, err := fanoutMachines(c, splitList(*only))
+	if err != nil {
+		return err
+	}
+	if len(machines) == 0 {
+		return fmt.Errorf("no machine to run on; register hosts or profiles in %s", configPath())
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		done    int
+		outputs = make([]string, len(machines))
+		errs    = make([]error, len(machines))
+	)
+	statusf("running on %d machines...", len(machines))
+	for i, m := range machines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the machine flags take precedence over the suite
+			outputs[i], errs[i] = runChild(childArgs(runArgs, m.args...))
+			mu.Lock()
+			done++
+			statusf("%d/%d machines done", done, len(machines))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	stderrTerminal.clearStatus()
+
+	configs := make([]map[string]string, len(machines))
+	results := make([]*benchResults, len(machines))
+	for i, m := range machines {
+		// benchstat -col machine
+		fmt.Printf("machine: %s\n%s\n", m.name, outputs[i])
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", m.name, errs[i])
+			continue
+		}
+		configs[i], _ = readConfigLines(strings.NewReader(outputs[i]))
+		results[i] = newBenchResults()
+		results[i].Write([]byte(outputs[i] + "\n"))
+	}
+	printCoverageMatrix(os.Stdout, machines, configs, results)
+	if failed := len(machines) - countNonNil(results); failed > 0 {
+		return fmt.Errorf("%d/%d machines failed", failed, len(machines))
+	}
+	return nil
+}
+
+func countNonNil(results []*benchResults) (n int) {
+	for _, r := range results {
+		if r != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// printCoverageMatrix prints the hardware of each machine, then the median ns/op of each
+// benchmark on each of them; "-" marks the gaps (failed runs, benchmarks skipped on a machine).
+func printCoverageMatrix(w io.Writer, machines []fanoutMachine, configs []map[string]string, results []*benchResults) {
+	fmt.Fprintf(w, "\nhardware:\n")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "machine\tgoarch\tcpu\tinstance\n")
+	for i, m := range machines {
+		if results[i] == nil {
+			fmt.Fprintf(tw, "%s\tfailed\t\t\n", m.name)
+			continue
+		}
+		instance := firstNonEmpty(configs[i]["instance-type"], configs[i]["host"])
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.name, configs[i]["goarch"], configs[i]["cpu"], instance)
+	}
+	tw.Flush()
+
+	var names []string
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		for _, name := range r.names {
+			// the -N GOMAXPROCS suffix differs across machines
+			if base := trimProcs(name); !slices.Contains(names, base) {
+				names = append(names, base)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nns/op:\n")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "benchmark")
+	for _, m := range machines {
+		fmt.Fprint(tw, "\t"+m.name)
+	}
+	fmt.Fprint(tw, "\t\n")
+	for _, name := range names {
+		fmt.Fprint(tw, name)
+		for _, r := range results {
+			cell := "-"
+			if r != nil {
+				for _, n := range r.names {
+					if trimProcs(n) == name {
+						if v := r.values(n, "ns/op"); len(v) > 0 {
+							cell = fmt.Sprintf("%.4g", median(v))
+						}
+					}
+				}
+			}
+			fmt.Fprint(tw, "\t"+cell)
+		}
+		fmt.Fprint(tw, "\t\n")
+	}
+	tw.Flush()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFanoutMachines(t *testing.T) {
+	c := configFile{
+		"hosts":    {"lab-epyc": "ubuntu@10.0.0.1"},
+		"profiles": {"axion": "provider=gcp, type=c4a-standard-4", "graviton4": "type=c8g.xlarge"},
+	}
+	machines, err := fanoutMachines(c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range machines {
+		names = append(names, m.name+" "+strings.Join(m.args, " "))
+	}
+	want := []string{"lab-epyc -target=lab-epyc", "axion -provider=gcp -type=c4a-standard-4", "graviton4 -type=c8g.xlarge"}
+	if !slices.Equal(names, want) {
+		t.Errorf("got %q, expected %q", names, want)
+	}
+
+	if machines, err = fanoutMachines(c, []string{"graviton4"}); err != nil || len(machines) != 1 || machines[0].name != "graviton4" {
+		t.Errorf("-machines=graviton4: got %v, %v", machines, err)
+	}
+	if _, err := fanoutMachines(c, []string{"cray"}); err == nil {
+		t.Error("expected an error for an unknown machine")
+	}
+	c["profiles"]["bad"] = "nosuchflag=1"
+	if _, err := fanoutMachines(c, nil); err == nil {
+		t.Error("expected an error for an unknown flag")
+	}
+}
+
+func TestPrintCoverageMatrix(t *testing.T) {
+	machines := []fanoutMachine{{name: "a"}, {name: "b"}, {name: "c"}}
+	configs := []map[string]string{{"goarch": "arm64", "cpu": "Neoverse-V2", "instance-type": "c8g.xlarge"}, {"goarch": "amd64", "host": "lab-epyc"}, nil}
+	results := []*benchResults{newBenchResults(), newBenchResults(), nil}
+	results[0].Write([]byte("BenchmarkX-4 \t 100\t 10 ns/op\nBenchmarkY-4 \t 100\t 20 ns/op\n"))
+	results[1].Write([]byte("BenchmarkX-16 \t 100\t 30 ns/op\n"))
+
+	var b strings.Builder
+	printCoverageMatrix(&b, machines, configs, results)
+	got := b.String()
+	for _, want := range []string{"Neoverse-V2", "lab-epyc", "BenchmarkX", "BenchmarkY"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%s", want, got)
+		}
+	}
+	if !strings.Contains(got, "\nc        failed") {
+		t.Errorf("the failed machine isn't reported:\n%s", got)
+	}
+	for _, line := range strings.Split(got, "\n") {
+		if f := strings.Fields(line); len(f) == 4 && f[0] == "BenchmarkY" && (f[1] != "20" || f[2] != "-" || f[3] != "-") {
+			t.Errorf("unexpected row %q", line)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// fetchCmd implements "rbench fetch <instance-id>": it retrieves the results and artifacts
+// (/tmp/rbench-*) of an instance that is still running, e.g. after the local rbench died.
+func fetchCmd(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	outDir := fs.String("o", "", "directory to write the files to (default: rbench-<instance-id>)")
+	user := fs.String("ssh-user", "", "ssh user on the instance (default: derived from the AMI)")
+	terminate := fs.Bool("terminate", false, "terminate the instance once the files are retrieved")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench fetch [flags] <instance-id>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected an instance id")
+	}
+	instanceID := fs.Arg(0)
+	if *outDir == "" {
+		*outDir = "rbench-" + instanceID
+	}
+
+	if err := initAWS(); err != nil {
+		return err
+	}
+	r, err := instanceRemote(instanceID, *user)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return fmt.Errorf("unable to create output directory, %v", err)
+	}
+	statusf("fetching results from %s...", r)
+	// the glob is expanded by the remote shell
+	if err := scpCopy("fetch", r.path("/tmp/rbench-*"), *outDir); err != nil {
+		return fmt.Errorf("failed to fetch the results: %w", err)
+	}
+	slog.Info(fmt.Sprintf("results of %s written to %s", instanceID, *outDir))
+
+	if *terminate {
+		liveInstances.Store(instanceID, true)
+		return terminateInstance(instanceID)
+	}
+	return nil
+}
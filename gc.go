@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// gcCandidates returns the instances rbench gc terminates: the ones launched before now-olderThan,
+// except the ones kept with -keep (kept ids) unless withKept is set.
+func gcCandidates(instances []types.Instance, kept map[string]bool, olderThan time.Duration, withKept bool, now time.Time) []types.Instance {
+	var orphans []types.Instance
+	for _, instance := range instances {
+		if now.Sub(aws.ToTime(instance.LaunchTime)) < olderThan {
+			continue
+		}
+		if kept[aws.ToString(instance.InstanceId)] && !withKept {
+			continue
+		}
+		orphans = append(orphans, instance)
+	}
+	return orphans
+}
+
+// gcCmd implements "rbench gc": terminates my instances leaked by runs that died (crash, sleep of
+// the laptop), which keep billing, and deletes my unused key pairs (see gcKeyPairs).
+func gcCmd(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 2*time.Hour, "only terminate the instances launched at least this long ago, to spare the ones of runs in progress (0: all)")
+	dryRun := fs.Bool("dry-run", false, "list the instances that would be terminated, without terminating them")
+	withKept := fs.Bool("kept", false, "also terminate the instances kept for reuse with -keep")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench gc [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := initAWS(); err != nil {
+		return err
+	}
+	instances, err := rbenchInstances(awsUserName)
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]bool)
+	if err := updateKept(func(k []keptInstance) []keptInstance {
+		for _, i := range k {
+			kept[i.ID] = true
+		}
+		return k
+	}); err != nil {
+		return err
+	}
+	now := time.Now()
+	orphans := gcCandidates(instances, kept, *olderThan, *withKept, now)
+	// the key pairs of the instances left running are in use
+	terminating := make(map[string]bool)
+	for _, instance := range orphans {
+		terminating[aws.ToString(instance.InstanceId)] = true
+	}
+	used := make(map[string]bool)
+	for _, instance := range instances {
+		if !terminating[aws.ToString(instance.InstanceId)] {
+			used[aws.ToString(instance.KeyName)] = true
+		}
+	}
+	if err := gcKeyPairs(used, *olderThan, *dryRun, now); err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		fmt.Printf("no instance of %s to terminate (%d running)\n", awsUserName, len(instances))
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "INSTANCE\tTYPE\tSTATE\tAGE\tNAME\n")
+	for _, instance := range orphans {
+		name := instanceTag(instance, "Name")
+		if kept[aws.ToString(instance.InstanceId)] {
+			name += " (kept)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			aws.ToString(instance.InstanceId),
+			instance.InstanceType,
+			instance.State.Name,
+			now.Sub(aws.ToTime(instance.LaunchTime)).Round(time.Minute),
+			name)
+	}
+	tw.Flush()
+	if *dryRun {
+		fmt.Printf("%d instance(s) would be terminated\n", len(orphans))
+		return nil
+	}
+
+	for id := range terminating {
+		liveInstances.Store(id, true)
+	}
+	terminateAllInstances()
+	if len(kept) == 0 {
+		return nil
+	}
+	return updateKept(func(k []keptInstance) []keptInstance {
+		var left []keptInstance
+		for _, i := range k {
+			if !terminating[i.ID] {
+				left = append(left, i)
+			}
+		}
+		return left
+	})
+}
+
+// gcKeyPairs deletes my key pairs that no instance uses, imported at least olderThan ago (a run
+// may be about to launch with a newer one), except the one of this machine. The key pairs of the
+// other machines are imported again by their next run, if any.
+func gcKeyPairs(used map[string]bool, olderThan time.Duration, dryRun bool, now time.Time) error {
+	out, err := ec2Client.DescribeKeyPairs(context.TODO(), &ec2.DescribeKeyPairsInput{
+		Filters: []types.Filter{{Name: aws.String("tag:rbench"), Values: []string{awsUserName}}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to describe key pairs, %v", err)
+	}
+	for _, k := range out.KeyPairs {
+		name := aws.ToString(k.KeyName)
+		if name == sshKeyName || used[name] || now.Sub(aws.ToTime(k.CreateTime)) < olderThan {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("key pair %s would be deleted\n", name)
+			continue
+		}
+		if _, err := ec2Client.DeleteKeyPair(context.TODO(), &ec2.DeleteKeyPairInput{KeyName: k.KeyName}); err != nil {
+			return fmt.Errorf("unable to delete key pair %s, %v", name, err)
+		}
+		fmt.Printf("key pair %s deleted\n", name)
+	}
+	return nil
+}
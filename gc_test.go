@@ -0,0 +1,41 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestGCCandidates(t *testing.T) {
+	now := time.Now()
+	instance := func(id string, age time.Duration) types.Instance {
+		return types.Instance{InstanceId: aws.String(id), LaunchTime: aws.Time(now.Add(-age))}
+	}
+	instances := []types.Instance{instance("i-old", 5*time.Hour), instance("i-kept", 3*time.Hour), instance("i-new", 10*time.Minute)}
+	kept := map[string]bool{"i-kept": true}
+
+	ids := func(instances []types.Instance) []string {
+		var ids []string
+		for _, i := range instances {
+			ids = append(ids, aws.ToString(i.InstanceId))
+		}
+		return ids
+	}
+	for _, c := range []struct {
+		olderThan time.Duration
+		withKept  bool
+		want      []string
+	}{
+		{0, false, []string{"i-old", "i-new"}},
+		{time.Hour, false, []string{"i-old"}},
+		{time.Hour, true, []string{"i-old", "i-kept"}},
+		{6 * time.Hour, true, nil},
+	} {
+		if got := ids(gcCandidates(instances, kept, c.olderThan, c.withKept, now)); !slices.Equal(got, c.want) {
+			t.Errorf("-older-than=%s -kept=%t: got %v, expected %v", c.olderThan, c.withKept, got, c.want)
+		}
+	}
+}
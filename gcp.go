@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// -provider=gcp launches Compute Engine VMs instead of EC2 instances, with the gcloud CLI and
+// its credentials (gcloud auth login). The instances are reached with the local rbench ssh key,
+// passed in their metadata; the default network allows ssh (default-allow-ssh firewall rule).
+
+// gcpSSHUser is the user created on the VMs by the guest agent, from the ssh-keys metadata.
+const gcpSSHUser = "rbench"
+
+// gcpImageProject hosts the Ubuntu images, see gcpImageFamily.
+const gcpImageProject = "ubuntu-os-cloud"
+
+// gcpImageFamily returns the Ubuntu image family of the architecture; its latest image is used.
+func gcpImageFamily(arch instanceArch) string {
+	return "ubuntu-2404-lts-" + arch.GoString()
+}
+
+type gcpProvider struct{}
+
+// initGCP resolves the project, sets up the ssh key and returns the architecture of the -type
+// machine type.
+func initGCP() (instanceArch, error) {
+	if _, err := exec.LookPath("gcloud"); err != nil {
+		return archUnknown, fmt.Errorf("-provider=gcp requires the gcloud CLI, %v", err)
+	}
+	if *gcpProject == "" {
+		out, err := gcloud(context.TODO(), "config", "get-value", "project")
+		if err != nil || strings.TrimSpace(out) == "" {
+			return archUnknown, fmt.Errorf("no -gcp-project, and no default project in the gcloud configuration")
+		}
+		*gcpProject = strings.TrimSpace(out)
+	}
+	sshKeyName = "rbench-" + localUserName() + "-" + hostName()
+	if err := withSetupLock(func() error { _, err := ensureSSHKey(); return err }); err != nil {
+		return archUnknown, err
+	}
+	cloud = gcpProvider{}
+
+	out, err := gcloud(context.TODO(), "compute", "machine-types", "describe", *instanceType,
+		"--project="+*gcpProject, "--zone="+*gcpZone, "--format=value(architecture)")
+	if err != nil {
+		return archUnknown, fmt.Errorf("unable to describe machine type %s in %s, %v", *instanceType, *gcpZone, err)
+	}
+	return gcpArch(strings.TrimSpace(out), *instanceType), nil
+}
+
+// gcpArch returns the architecture of a machine type, from its description or, when the API
+// doesn't report it, from its series.
+func gcpArch(architecture, machineType string) instanceArch {
+	switch architecture {
+	case "ARM64":
+		return archArm
+	case "X86_64":
+		return archX86
+	}
+	series, _, _ := strings.Cut(machineType, "-")
+	switch series {
+	case "t2a", "c4a", "n4a", "a4x":
+		return archArm
+	}
+	return archX86
+}
+
+// gcpTarget returns the Ubuntu target of the architecture.
+func gcpTarget(arch instanceArch) target {
+	user := *sshUserFlag
+	if user == "" {
+		user = gcpSSHUser
+	}
+	return target{ami: gcpImageFamily(arch), user: user}
+}
+
+// gcpLabel turns s into a valid label value: lowercase letters, digits, - and _.
+func gcpLabel(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, s)
+	return s[:min(len(s), 63)]
+}
+
+func (gcpProvider) start(ctx context.Context, family string) (string, string, error) {
+	publicKey, err := os.ReadFile(privateKeyPath() + ".pub")
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read public key, %v", err)
+	}
+	name := "rbench-" + randString(10)
+	// terminated on interrupt even if the creation is still in progress
+	liveInstances.Store(name, true)
+	host, err := gcloud(ctx, "compute", "instances", "create", name,
+		"--project="+*gcpProject, "--zone="+*gcpZone,
+		"--machine-type="+*instanceType,
+		"--image-family="+family, "--image-project="+gcpImageProject,
+		"--labels=rbench="+gcpLabel(localUserName()),
+		"--metadata=ssh-keys="+gcpSSHUser+":"+strings.TrimSpace(string(publicKey)),
+		"--format=value(networkInterfaces[0].accessConfigs[0].natIP)")
+	if err != nil {
+		if ctx.Err() != nil {
+			// the instance may have been created
+			terminateInstance(name)
+			return "", "", ctx.Err()
+		}
+		liveInstances.Delete(name)
+		return "", "", fmt.Errorf("unable to create instance, %v", err)
+	}
+	runCost.start(name, time.Now())
+
+	host = strings.TrimSpace(host)
+	if host == "" {
+		terminateInstance(name)
+		return "", "", fmt.Errorf("instance %s has no external IP address", name)
+	}
+	if err := waitForSSHPort(ctx, host); err != nil {
+		terminateInstance(name)
+		return "", "", err
+	}
+	return host, name, nil
+}
+
+func (gcpProvider) terminate(name string) error {
+	_, err := gcloud(context.TODO(), "compute", "instances", "delete", name,
+		"--project="+*gcpProject, "--zone="+*gcpZone)
+	return err
+}
+
+// gcloud runs a gcloud command without prompts and returns its output.
+func gcloud(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "gcloud", append(args, "--quiet")...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("%s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestGCPArch(t *testing.T) {
+	for _, tc := range []struct {
+		architecture, machineType string
+		want                      instanceArch
+	}{
+		{"ARM64", "c4a-standard-4", archArm},
+		{"X86_64", "c3-standard-4", archX86},
+		// not reported
+		{"", "t2a-standard-1", archArm},
+		{"", "n2-standard-2", archX86},
+		{"", "e2-micro", archX86},
+	} {
+		if got := gcpArch(tc.architecture, tc.machineType); got != tc.want {
+			t.Errorf("gcpArch(%q, %q) = %s, expected %s", tc.architecture, tc.machineType, got.GoString(), tc.want.GoString())
+		}
+	}
+}
+
+func TestGCPLabel(t *testing.T) {
+	for in, want := range map[string]string{
+		"alice":       "alice",
+		"Bob.Smith":   "bob_smith",
+		"ci-runner_2": "ci-runner_2",
+	} {
+		if got := gcpLabel(in); got != want {
+			t.Errorf("gcpLabel(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
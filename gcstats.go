@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// remoteGCTrace receives the stderr of the benchmark with -gcstats: the GODEBUG=gctrace=1 lines
+// would otherwise be interleaved with (and split) the result lines.
+func remoteGCTrace() string {
+	return remoteTmp() + "/rbench-gctrace.txt"
+}
+
+// gcEvent is a garbage collection reported by gctrace.
+type gcEvent struct {
+	at     time.Duration // since the start of the process
+	pause  time.Duration // stop the world phases
+	goalMB float64
+}
+
+// gc 1 @0.012s 2%: 0.018+0.46+0.003 ms clock, 0.14+0.15/0.58/0.19+0.029 ms cpu, 4->4->0 MB, 4 MB goal, ...
+var gcTraceRegexp = regexp.MustCompile(`^gc \d+ @([\d.]+)s \d+%: ([\d.]+)\+[\d.]+\+([\d.]+) ms clock, .* (\d+) MB goal`)
+
+// parseGCTrace returns the gc events of the trace, and its other lines (e.g. a panic).
+func parseGCTrace(trace string) (events []gcEvent, other []string) {
+	for _, line := range strings.Split(trace, "\n") {
+		m := gcTraceRegexp.FindStringSubmatch(line)
+		if m == nil {
+			if strings.TrimSpace(line) != "" {
+				other = append(other, line)
+			}
+			continue
+		}
+		at, _ := strconv.ParseFloat(m[1], 64)
+		sweepTerm, _ := strconv.ParseFloat(m[2], 64)
+		markTerm, _ := strconv.ParseFloat(m[3], 64)
+		goal, _ := strconv.ParseFloat(m[4], 64)
+		events = append(events, gcEvent{
+			at:     time.Duration(at * float64(time.Second)),
+			pause:  time.Duration((sweepTerm + markTerm) * float64(time.Millisecond)),
+			goalMB: goal,
+		})
+	}
+	return events, other
+}
+
+// printGCSummary attributes the gc events to the benchmark whose result was received next,
+// and prints per benchmark gc counts, total pauses and peak heap goals. start is when the
+// benchmark process was started.
+func printGCSummary(w io.Writer, results *benchResults, events []gcEvent, start time.Time) {
+	results.mu.Lock()
+	var all []benchResult
+	for _, name := range results.names {
+		all = append(all, results.samples[name]...)
+	}
+	results.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].received.Before(all[j].received) })
+
+	type summary struct {
+		n      int
+		pause  time.Duration
+		goalMB float64
+	}
+	summaries := make(map[string]*summary)
+	for _, e := range events {
+		t := start.Add(e.at)
+		i := sort.Search(len(all), func(i int) bool { return !all[i].received.Before(t) })
+		if i == len(all) {
+			continue
+		}
+		sum, ok := summaries[all[i].name]
+		if !ok {
+			sum = &summary{}
+			summaries[all[i].name] = sum
+		}
+		sum.n++
+		sum.pause += e.pause
+		sum.goalMB = max(sum.goalMB, e.goalMB)
+	}
+
+	for _, name := range results.names {
+		sum, ok := summaries[name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "gc %s: %d gcs over %d runs, pauses=%s, peak goal=%.0fMB\n",
+			name, sum.n, len(results.samples[name]), sum.pause, sum.goalMB)
+	}
+}
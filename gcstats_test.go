@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGCSummary(t *testing.T) {
+	const trace = `gc 1 @0.500s 2%: 0.010+0.46+0.020 ms clock, 0.14+0.15/0.58/0.19+0.029 ms cpu, 4->4->0 MB, 4 MB goal, 0 MB stacks, 0 MB globals, 8 P
+gc 2 @1.000s 2%: 0.030+0.50+0.040 ms clock, 0.14+0.15/0.58/0.19+0.029 ms cpu, 4->6->1 MB, 8 MB goal, 0 MB stacks, 0 MB globals, 8 P
+gc 3 @3.000s 1%: 0.100+1.00+0.100 ms clock, 0.14+0.15/0.58/0.19+0.029 ms cpu, 12->12->2 MB, 16 MB goal, 0 MB stacks, 0 MB globals, 8 P
+panic: boom
+`
+	events, other := parseGCTrace(trace)
+	if len(events) != 3 || events[1].pause != 70*time.Microsecond || events[2].goalMB != 16 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if len(other) != 1 || other[0] != "panic: boom" {
+		t.Fatalf("unexpected other lines %q", other)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := newBenchResults()
+	results.Write([]byte("BenchmarkA-2 100 5 ns/op\n"))
+	results.Write([]byte("BenchmarkB-2 100 5 ns/op\n"))
+	results.samples["BenchmarkA-2"][0].received = start.Add(2 * time.Second)
+	results.samples["BenchmarkB-2"][0].received = start.Add(4 * time.Second)
+
+	var b strings.Builder
+	printGCSummary(&b, results, events, start)
+	want := "gc BenchmarkA-2: 2 gcs over 1 runs, pauses=100µs, peak goal=8MB\n" +
+		"gc BenchmarkB-2: 1 gcs over 1 runs, pauses=200µs, peak goal=16MB\n"
+	if b.String() != want {
+		t.Fatalf("unexpected summary:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// buildDir is the directory the benchmark binary is built from; the current directory
+// unless -stash-run builds from a clean worktree.
+var buildDir string
+
+func gitCommitID() (string, error) {
+	// Check if the directory is a Git repository
+	cmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
+	_, err := cmd.Output()
+	if err != nil {
+		return "not a git repo", nil
+	}
+
+	// Get the commit ID
+	cmd = exec.Command("git", "rev-parse", "HEAD")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	commitID := strings.TrimSpace(string(out))
+
+	// Check if the working directory is dirty
+	cmd = exec.Command("git", "status", "--porcelain")
+	out, err = cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	if len(out) > 0 {
+		commitID += "-dirty"
+	}
+
+	return commitID, nil
+}
+
+// gitCommitTime returns the committer date of HEAD in RFC3339 format (UTC),
+// as expected by benchseries to order results. It returns "" outside a git repo.
+func gitCommitTime() string {
+	return gitCommitTimeOf("HEAD")
+}
+
+// gitCommitTimeOf returns the committer date of ref like gitCommitTime.
+func gitCommitTimeOf(ref string) string {
+	out, err := exec.Command("git", "log", "-1", "--format=%cI", ref).Output()
+	if err != nil {
+		return ""
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(out)))
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// addCleanWorktree checks out HEAD in a temporary worktree and sets buildDir to the
+// current directory's counterpart in it.
+func addCleanWorktree() (string, error) {
+	dir, sub, err := addWorktree("HEAD")
+	if err != nil {
+		return "", err
+	}
+	buildDir = sub
+	return dir, nil
+}
+
+// addWorktree checks out ref in a temporary worktree. It returns the worktree and the current
+// directory's counterpart in it.
+func addWorktree(ref string) (dir, sub string, err error) {
+	prefix, err := exec.Command("git", "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to locate the git repository, %v", err)
+	}
+	dir, err = os.MkdirTemp("", "rbench-worktree-")
+	if err != nil {
+		return "", "", err
+	}
+	out, err := exec.Command("git", "worktree", "add", "--detach", dir, ref).CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("unable to create worktree: %s, %v", strings.TrimSpace(string(out)), err)
+	}
+	return dir, filepath.Join(dir, strings.TrimSpace(string(prefix))), nil
+}
+
+// removeWorktree removes a worktree created by addWorktree.
+func removeWorktree(dir string) {
+	if out, err := exec.Command("git", "worktree", "remove", "--force", dir).CombinedOutput(); err != nil {
+		slog.Warn(fmt.Sprintf("unable to remove worktree %s: %s", dir, strings.TrimSpace(string(out))))
+	}
+}
+
+// gitRepoName names the repository from its origin remote (e.g. github.com/owner/repo), or from
+// its directory without one. It returns "local" outside a git repo.
+func gitRepoName() string {
+	if out, err := exec.Command("git", "remote", "get-url", "origin").Output(); err == nil {
+		if name := repoNameFromURL(strings.TrimSpace(string(out))); name != "" {
+			return name
+		}
+	}
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "local"
+	}
+	return filepath.Base(strings.TrimSpace(string(out)))
+}
+
+// repoNameFromURL returns host/path of a remote URL, in the https, ssh or scp-like syntax.
+func repoNameFromURL(url string) string {
+	if _, rest, ok := strings.Cut(url, "://"); ok {
+		url = rest
+	} else if host, path, ok := strings.Cut(url, ":"); ok {
+		// git@github.com:owner/repo.git
+		url = host + "/" + path
+	}
+	if _, rest, ok := strings.Cut(url, "@"); ok {
+		url = rest
+	}
+	return strings.Trim(strings.TrimSuffix(url, ".git"), "/")
+}
+
+// gitBranch returns the current branch; on a detached HEAD, the branch of the CI job if any, or
+// "detached".
+func gitBranch() string {
+	out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if branch := strings.TrimSpace(string(out)); err == nil && branch != "HEAD" {
+		return branch
+	}
+	for _, v := range []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "BUILDKITE_BRANCH"} {
+		if branch := os.Getenv(v); branch != "" {
+			return branch
+		}
+	}
+	return "detached"
+}
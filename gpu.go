@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// instanceHasGPU reports whether the instance type has GPUs; their metrics
+// are then sampled with nvidia-smi dmon during the run.
+func instanceHasGPU() bool {
+	return instanceTypeInfo.GpuInfo != nil && len(instanceTypeInfo.GpuInfo.Gpus) > 0
+}
+
+// dmonLog is the output of nvidia-smi dmon during the run.
+func dmonLog() string {
+	return remoteTmp() + "/rbench-dmon.log"
+}
+
+// startGPUMonitor starts nvidia-smi dmon in the background on the instance.
+// it returns false if nvidia-smi is not installed (e.g. no driver in the AMI).
+func startGPUMonitor(r remote) (bool, error) {
+	out, err := sshRun(r, fmt.Sprintf("command -v nvidia-smi >/dev/null || exit 0; "+
+		"nohup nvidia-smi dmon -s pucm -o DT > %s 2>&1 < /dev/null & echo started", dmonLog()))
+	if err != nil {
+		return false, fmt.Errorf("unable to start nvidia-smi dmon, %v", err)
+	}
+	return strings.TrimSpace(out) == "started", nil
+}
+
+// stopGPUMonitor stops nvidia-smi dmon and returns its samples.
+func stopGPUMonitor(r remote) ([]gpuSample, error) {
+	out, err := sshRun(r, "pkill -f 'nvidia-smi dmon'; cat "+dmonLog())
+	if err != nil {
+		return nil, fmt.Errorf("unable to collect nvidia-smi dmon samples, %v", err)
+	}
+	return parseDmon(out), nil
+}
+
+// remoteClockOffset returns the offset of the instance clock relative to the local one.
+func remoteClockOffset(r remote) (time.Duration, error) {
+	start := time.Now()
+	out, err := sshRun(r, "date +%s.%N")
+	if err != nil {
+		return 0, err
+	}
+	end := time.Now()
+	secs, err := strconv.ParseFloat(strings.TrimSpace(out), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected date output %q", out)
+	}
+	remote := time.Unix(0, int64(secs*1e9))
+	local := start.Add(end.Sub(start) / 2)
+	return remote.Sub(local), nil
+}
+
+// gpuSample is a line of nvidia-smi dmon output, for one GPU.
+type gpuSample struct {
+	t      time.Time
+	values map[string]float64 // by column name: sm, mem, pwr, pclk, fb, ...
+}
+
+// parseDmon parses the output of nvidia-smi dmon -o DT; columns are read from the header
+// since they depend on the driver version. Timestamps are assumed UTC.
+func parseDmon(log string) []gpuSample {
+	var columns []string
+	var samples []gpuSample
+	for _, line := range strings.Split(log, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "#Date" {
+			columns = fields
+			continue
+		}
+		if strings.HasPrefix(fields[0], "#") || len(columns) == 0 || len(fields) != len(columns) {
+			continue
+		}
+		t, err := time.Parse("20060102 15:04:05", fields[0]+" "+fields[1])
+		if err != nil {
+			continue
+		}
+		s := gpuSample{t: t, values: make(map[string]float64)}
+		for i := 2; i < len(fields); i++ {
+			if v, err := strconv.ParseFloat(fields[i], 64); err == nil {
+				s.values[columns[i]] = v
+			}
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// printGPUSummary attributes the samples to the benchmark whose result was received next,
+// and prints per benchmark averages (peak for the framebuffer memory).
+func printGPUSummary(w io.Writer, results *benchResults, samples []gpuSample, start time.Time, offset time.Duration) {
+	results.mu.Lock()
+	var all []benchResult
+	for _, name := range results.names {
+		all = append(all, results.samples[name]...)
+	}
+	results.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].received.Before(all[j].received) })
+
+	type summary struct {
+		n    int
+		sums map[string]float64
+		fb   float64
+	}
+	summaries := make(map[string]*summary)
+	for _, s := range samples {
+		t := s.t.Add(-offset)
+		if t.Before(start) {
+			continue
+		}
+		i := sort.Search(len(all), func(i int) bool { return !all[i].received.Before(t) })
+		if i == len(all) {
+			continue
+		}
+		sum, ok := summaries[all[i].name]
+		if !ok {
+			sum = &summary{sums: make(map[string]float64)}
+			summaries[all[i].name] = sum
+		}
+		sum.n++
+		for k, v := range s.values {
+			sum.sums[k] += v
+		}
+		sum.fb = max(sum.fb, s.values["fb"])
+	}
+
+	for _, name := range results.names {
+		sum, ok := summaries[name]
+		if !ok {
+			continue
+		}
+		avg := func(k string) float64 { return sum.sums[k] / float64(sum.n) }
+		fmt.Fprintf(w, "gpu %s: sm=%.1f%% mem=%.1f%% pwr=%.0fW pclk=%.0fMHz mclk=%.0fMHz fb=%.0fMB (%d samples)\n",
+			name, avg("sm"), avg("mem"), avg("pwr"), avg("pclk"), avg("mclk"), sum.fb, sum.n)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGPUSummary(t *testing.T) {
+	const log = `#Date       Time        gpu    pwr  gtemp  mtemp     sm    mem    enc    dec   mclk   pclk     fb   bar1
+#YYYYMMDD   HH:MM:SS    Idx      W      C      C      %      %      %      %    MHz    MHz     MB     MB
+ 20240101   00:00:01      0    100     30      -     50     10      0      0   5000   1000    100      2
+ 20240101   00:00:02      0    200     30      -     70     30      0      0   5000   1200    300      2
+ 20240101   00:00:03      0     50     30      -     10      5      0      0   5000    800     50      2
+`
+	samples := parseDmon(log)
+	if len(samples) != 3 || samples[1].values["sm"] != 70 {
+		t.Fatalf("unexpected samples %+v", samples)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := newBenchResults()
+	results.Write([]byte("BenchmarkA-2 100 5 ns/op\n"))
+	results.Write([]byte("BenchmarkB-2 100 5 ns/op\n"))
+	results.samples["BenchmarkA-2"][0].received = start.Add(2500 * time.Millisecond)
+	results.samples["BenchmarkB-2"][0].received = start.Add(4 * time.Second)
+
+	var b strings.Builder
+	printGPUSummary(&b, results, samples, start, 0)
+	want := "gpu BenchmarkA-2: sm=60.0% mem=20.0% pwr=150W pclk=1100MHz mclk=5000MHz fb=300MB (2 samples)\n" +
+		"gpu BenchmarkB-2: sm=10.0% mem=5.0% pwr=50W pclk=800MHz mclk=5000MHz fb=50MB (1 samples)\n"
+	if b.String() != want {
+		t.Fatalf("unexpected summary:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// the results history is a local store of the results of every run, whatever produced them: one
+// JSON record per benchmark and run, in the bbolt database historyFile. The runs record their
+// results (-history), rbench import adds the ones of benchfmt files (CI, laptops) with their
+// metadata, and rbench history shows the trend of a benchmark over the commits.
+//
+// the records are in the bucket recordBucket by sequence number (the order they were added),
+// indexed by key (keyBucket, to store a record once) and by machine (machineBucket, as most
+// queries are for one machine).
+
+// historyFile is the results history, next to the configuration file.
+func historyFile() string {
+	return filepath.Join(filepath.Dir(configPath()), "history.db")
+}
+
+// the buckets of the history.
+var (
+	recordBucket  = []byte("records")
+	keyBucket     = []byte("keys")
+	machineBucket = []byte("machines")
+)
+
+// historyRecord is the results of a benchmark in a run.
+type historyRecord struct {
+	Time      time.Time            `json:"time"` // of the run (runstamp)
+	Commit    string               `json:"commit"`
+	Machine   string               `json:"machine"` // instance type, or host-<name> (see calibrationKey)
+	Benchmark string               `json:"benchmark"`
+	Values    map[string][]float64 `json:"values"` // by unit
+	Source    string               `json:"source"` // "run", or the imported file
+	Meta      map[string]string    `json:"meta,omitempty"`
+}
+
+// key identifies the results of a benchmark in a run: a record is stored once. The metadata
+// tells apart the targets of a run on the same machine (-os matrix).
+func (h historyRecord) key() string {
+	key := []string{h.Time.UTC().Format(time.RFC3339Nano), h.Commit, h.Machine, h.Benchmark}
+	for _, k := range sortedKeys(h.Meta) {
+		key = append(key, k+"="+h.Meta[k])
+	}
+	return strings.Join(key, " ")
+}
+
+// historyRecords returns the records of the results of a run.
+func historyRecords(results *benchResults, at time.Time, commit, machine, source string, meta map[string]string) []historyRecord {
+	var records []historyRecord
+	for _, name := range results.names {
+		values := make(map[string][]float64)
+		for _, unit := range results.units() {
+			if v := results.values(name, unit); len(v) > 0 {
+				values[unit] = v
+			}
+		}
+		records = append(records, historyRecord{at, commit, machine, name, values, source, meta})
+	}
+	return records
+}
+
+// recordHistory adds the results of the run on t to the history; a failure is only a warning.
+func recordHistory(t target, info runInfo, results *benchResults) {
+	if len(results.names) == 0 {
+		return
+	}
+	at, err := time.Parse(time.RFC3339, info.runStamp)
+	if err != nil {
+		at = time.Now().UTC().Truncate(time.Second)
+	}
+	meta := make(map[string]string)
+	if *labelFlag != "" {
+		meta["label"] = *labelFlag
+	}
+	if t.label != "" {
+		meta["os"] = t.label
+	}
+	if _, err := appendHistory(historyRecords(results, at, info.commitID, historyMachine(t), "run", meta)); err != nil {
+		slog.Warn(t.prefix() + "unable to record the results history: " + err.Error())
+	}
+}
+
+// appendHistory adds the records to the history, except the ones already in it, and returns the
+// number of records added.
+func appendHistory(records []historyRecord) (int, error) {
+	db, err := openHistory(false)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	added := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		added, err = putHistory(tx, records)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to write %s, %v", historyFile(), err)
+	}
+	return added, nil
+}
+
+// putHistory adds the records that aren't in the history yet.
+func putHistory(tx *bolt.Tx, records []historyRecord) (int, error) {
+	buckets := make([]*bolt.Bucket, 3)
+	for i, name := range [][]byte{recordBucket, keyBucket, machineBucket} {
+		b, err := tx.CreateBucketIfNotExists(name)
+		if err != nil {
+			return 0, err
+		}
+		buckets[i] = b
+	}
+	recordsBucket, keys, machines := buckets[0], buckets[1], buckets[2]
+	added := 0
+	for _, h := range records {
+		key := []byte(h.key())
+		if keys.Get(key) != nil {
+			continue
+		}
+		seq, err := recordsBucket.NextSequence()
+		if err != nil {
+			return 0, err
+		}
+		id := binary.BigEndian.AppendUint64(nil, seq)
+		data, err := json.Marshal(h)
+		if err != nil {
+			return 0, err
+		}
+		if err := recordsBucket.Put(id, data); err != nil {
+			return 0, err
+		}
+		if err := keys.Put(key, id); err != nil {
+			return 0, err
+		}
+		if err := machines.Put(machineIndexKey(h.Machine, id), nil); err != nil {
+			return 0, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+// machineIndexKey is the key of a record in the machine index: the records of a machine share
+// its prefix, in the order they were added.
+func machineIndexKey(machine string, id []byte) []byte {
+	return append([]byte(machine+"\x00"), id...)
+}
+
+// readHistory returns the records of the history of machine (of all the machines if empty) for
+// which keep returns true (all of them if keep is nil), in the order they were added.
+func readHistory(machine string, keep func(historyRecord) bool) ([]historyRecord, error) {
+	if _, err := os.Stat(historyFile()); os.IsNotExist(err) && !legacyHistory() {
+		return nil, nil
+	}
+	db, err := openHistory(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var records []historyRecord
+	add := func(data []byte) error {
+		var h historyRecord
+		if err := json.Unmarshal(data, &h); err != nil {
+			return err
+		}
+		if keep == nil || keep(h) {
+			records = append(records, h)
+		}
+		return nil
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		recordsBucket := tx.Bucket(recordBucket)
+		if recordsBucket == nil {
+			return nil
+		}
+		if machine == "" {
+			return recordsBucket.ForEach(func(_, data []byte) error { return add(data) })
+		}
+		prefix := machineIndexKey(machine, nil)
+		c := tx.Bucket(machineBucket).Cursor()
+		for k, _ := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if err := add(recordsBucket.Get(k[len(prefix):])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s, %v", historyFile(), err)
+	}
+	return records, nil
+}
+
+// openHistory opens the history, shared by the concurrent runs: bbolt locks the file, readers
+// share it. A history.jsonl of an older version is migrated on first use.
+func openHistory(readOnly bool) (*bolt.DB, error) {
+	path := historyFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) || legacyHistory() {
+		// created or migrated read-write
+		readOnly = false
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Minute, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s, %v", path, err)
+	}
+	if !readOnly && legacyHistory() {
+		if err := migrateHistory(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// legacyHistoryFile is the history of the older versions, one JSON record per line.
+func legacyHistoryFile() string {
+	return filepath.Join(filepath.Dir(configPath()), "history.jsonl")
+}
+
+func legacyHistory() bool {
+	_, err := os.Stat(legacyHistoryFile())
+	return err == nil
+}
+
+// migrateHistory adds the records of the legacy history to db, and renames it when done.
+func migrateHistory(db *bolt.DB) error {
+	path := legacyHistoryFile()
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var records []historyRecord
+	if err := scanHistory(file, func(h historyRecord) { records = append(records, h) }); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := putHistory(tx, records)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to migrate %s, %v", path, err)
+	}
+	slog.Info(fmt.Sprintf("migrated %d records of %s to %s", len(records), path, historyFile()))
+	return os.Rename(path, path+".migrated")
+}
+
+// scanHistory calls f with each record of r, one JSON record per line.
+func scanHistory(r io.Reader, f func(historyRecord)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var h historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		f(h)
+	}
+	return scanner.Err()
+}
+
+// parseMeta parses comma-separated key=value pairs.
+func parseMeta(s string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, kv := range splitList(s) {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid metadata %q, expected key=value", kv)
+		}
+		meta[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return meta, nil
+}
+
+// importCmd implements "rbench import": adds the results of benchfmt files (or bundles) produced
+// elsewhere to the history. The commit, machine and time of the results are read from their
+// configuration lines (commit:, instance-type: or host:, runstamp:), the flags override them.
+func importCmd(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	commit := fs.String("commit", "", "commit of the results (default: the commit: line of the file)")
+	machine := fs.String("machine", "", "machine of the results, e.g. an instance type (default: the instance-type:, host: or cpu: line of the file)")
+	at := fs.String("time", "", "time of the run, RFC 3339 (default: the runstamp: line of the file, or its modification time)")
+	metaFlag := fs.String("meta", "", "comma-separated key=value metadata of the results, e.g. source=ci,runner=ubuntu-latest")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench import [flags] <file>...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no file to import")
+	}
+	meta, err := parseMeta(*metaFlag)
+	if err != nil {
+		return err
+	}
+	for _, path := range fs.Args() {
+		records, err := importFile(path, *commit, *machine, *at, meta)
+		if err != nil {
+			return err
+		}
+		added, err := appendHistory(records)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d benchmarks imported", path, added)
+		if added < len(records) {
+			fmt.Printf(", %d already in the history", len(records)-added)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// importFile returns the records of the results of a file, with the metadata of rbench import.
+func importFile(path, commit, machine, at string, meta map[string]string) ([]historyRecord, error) {
+	r, err := openResults(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s, %v", path, err)
+	}
+	config, err := readConfigLines(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s, %v", path, err)
+	}
+	results := newBenchResults()
+	results.Write(append(data, '\n'))
+	if len(results.names) == 0 {
+		return nil, fmt.Errorf("%s: no benchmark results", path)
+	}
+
+	if commit == "" {
+		commit = config["commit"]
+	}
+	if commit == "" {
+		return nil, fmt.Errorf("%s: no commit: line, set -commit", path)
+	}
+	if machine == "" {
+		machine = calibrationKey(config)
+	}
+	if machine == "" {
+		machine = config["cpu"]
+	}
+	if machine == "" {
+		return nil, fmt.Errorf("%s: no instance-type:, host: nor cpu: line, set -machine", path)
+	}
+	if at == "" {
+		at = config["runstamp"]
+	}
+	var t time.Time
+	if at != "" {
+		if t, err = time.Parse(time.RFC3339, at); err != nil {
+			return nil, fmt.Errorf("%s: invalid time, %v", path, err)
+		}
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		t = info.ModTime().UTC().Truncate(time.Second)
+	}
+	source, err := filepath.Abs(path)
+	if err != nil {
+		source = path
+	}
+	// the -label of the run, unless set
+	m := map[string]string{}
+	if config["label"] != "" {
+		m["label"] = config["label"]
+	}
+	for k, v := range meta {
+		m[k] = v
+	}
+	return historyRecords(results, t, commit, machine, source, m), nil
+}
+
+// historyPoint is the results of a benchmark on a machine at a commit, across its runs.
+type historyPoint struct {
+	commit string
+	first  time.Time // of the first run
+	runs   int
+	values []float64
+}
+
+// historyTrend returns, for each benchmark and machine of records ("<benchmark> on <machine>"),
+// the values of unit at the last n commits, oldest first; the commits are ordered by their first
+// run.
+func historyTrend(records []historyRecord, unit string, n int) map[string][]historyPoint {
+	trends := make(map[string][]historyPoint)
+	for _, h := range records {
+		values := h.Values[unit]
+		if len(values) == 0 {
+			continue
+		}
+		key := h.Benchmark + " on " + h.Machine
+		points := trends[key]
+		i := slices.IndexFunc(points, func(p historyPoint) bool { return p.commit == h.Commit })
+		if i < 0 {
+			points = append(points, historyPoint{commit: h.Commit, first: h.Time})
+			i = len(points) - 1
+		}
+		p := &points[i]
+		if h.Time.Before(p.first) {
+			p.first = h.Time
+		}
+		p.runs++
+		p.values = append(p.values, values...)
+		trends[key] = points
+	}
+	for key, points := range trends {
+		sort.SliceStable(points, func(i, j int) bool { return points[i].first.Before(points[j].first) })
+		if n > 0 && len(points) > n {
+			points = points[len(points)-n:]
+		}
+		trends[key] = points
+	}
+	return trends
+}
+
+// benchNoise is the noise of a benchmark on a machine over the history: its run-to-run spread
+// against its typical change between consecutive commits, in percent of the median.
+type benchNoise struct {
+	run     float64 // median spread of the commits run at least twice
+	commit  float64 // median absolute change between consecutive commits
+	runs    int     // commits run at least twice
+	commits int     // changes between commits
+}
+
+// noisy reports whether the run-to-run noise of the benchmark exceeds its typical change between
+// commits, with enough history to tell: its deltas are mostly noise.
+func (n benchNoise) noisy() bool {
+	return n.runs >= 3 && n.commits >= 2 && n.run > n.commit
+}
+
+// count returns the -count at which the noise of the median of the benchmark falls under its
+// typical change between commits, from the current count: the noise decreases as the square root
+// of the number of runs.
+func (n benchNoise) count(count int) int {
+	if n.commit == 0 {
+		return count * 4
+	}
+	r := n.run / n.commit
+	return int(math.Ceil(float64(count) * r * r))
+}
+
+// historyNoise returns the noise of unit of each benchmark of records, by "<benchmark> on
+// <machine>" like historyTrend.
+func historyNoise(records []historyRecord, unit string) map[string]benchNoise {
+	noise := make(map[string]benchNoise)
+	for key, points := range historyTrend(records, unit, 0) {
+		var spreads, changes []float64
+		for i, p := range points {
+			if len(p.values) > 1 {
+				spreads = append(spreads, spread(p.values))
+			}
+			if i > 0 {
+				if old := median(points[i-1].values); old != 0 {
+					changes = append(changes, 100*math.Abs(median(p.values)-old)/old)
+				}
+			}
+		}
+		noise[key] = benchNoise{median(spreads), median(changes), len(spreads), len(changes)}
+	}
+	return noise
+}
+
+// machineNoise returns the ns/op noise of the benchmarks of machine over the history, by
+// benchmark; nil if the history can't be read.
+func machineNoise(machine string) map[string]benchNoise {
+	records, err := readHistory(machine, nil)
+	if err != nil {
+		slog.Debug("unable to read the results history: " + err.Error())
+		return nil
+	}
+	noise := make(map[string]benchNoise)
+	for key, n := range historyNoise(records, "ns/op") {
+		noise[strings.TrimSuffix(key, " on "+machine)] = n
+	}
+	return noise
+}
+
+// historyMachine returns the machine of the results of t in the history.
+func historyMachine(t target) string {
+	if t.host != "" {
+		return calibrationKey(map[string]string{"host": *targetFlag})
+	}
+	return calibrationKey(map[string]string{"instance-type": *instanceType})
+}
+
+// printHistory prints the trends of historyTrend: the median and spread of each commit, and its
+// change from the previous one.
+func printHistory(w io.Writer, trends map[string][]historyPoint, unit string) {
+	for i, key := range sortedKeys(trends) {
+		points := trends[key]
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s (median %s):\n", key, unit)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "  COMMIT\tDATE\tRUNS\tMEDIAN\tSPREAD\tDELTA\n")
+		for j, p := range points {
+			delta := "-"
+			if j > 0 {
+				delta = formatDelta(median(points[j-1].values), median(p.values))
+			}
+			commit := p.commit
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%d\t%.4g\t±%.1f%%\t%s\n", commit, p.first.Local().Format("2006-01-02 15:04"),
+				p.runs, median(p.values), spread(p.values), delta)
+		}
+		tw.Flush()
+		if len(points) > 1 {
+			fmt.Fprintf(w, "  over %d commits: %s\n", len(points), formatDelta(median(points[0].values), median(points[len(points)-1].values)))
+		}
+	}
+}
+
+// formatDelta formats the change from old to cur in percent.
+func formatDelta(old, cur float64) string {
+	if old == 0 {
+		return "-"
+	}
+	d := 100 * (cur - old) / old
+	if math.Abs(d) < 0.05 {
+		return "~"
+	}
+	return fmt.Sprintf("%+.1f%%", d)
+}
+
+// historyCmd implements "rbench history": the trend of the benchmarks matching a regular
+// expression (like -bench) over the last commits, per machine.
+func historyCmd(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	machine := fs.String("machine", "", "only the results of this machine (instance type, or host-<name>)")
+	commits := fs.Int("n", 20, "number of commits to show, the most recent ones (0: all)")
+	unit := fs.String("unit", "ns/op", "unit of the values, e.g. B/op or a b.ReportMetric unit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench history [flags] <benchmark regexp>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected a benchmark regular expression")
+	}
+	re, err := regexp.Compile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid benchmark regexp, %v", err)
+	}
+	records, err := readHistory(*machine, func(h historyRecord) bool {
+		return re.MatchString(trimProcs(h.Benchmark))
+	})
+	if err != nil {
+		return err
+	}
+	trends := historyTrend(records, *unit, *commits)
+	if len(trends) == 0 {
+		return fmt.Errorf("no %s result of %s in %s", *unit, fs.Arg(0), historyFile())
+	}
+	printHistory(os.Stdout, trends, *unit)
+	return nil
+}
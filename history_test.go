@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportHistory(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("RBENCH_CONFIG", filepath.Join(dir, "config"))
+	path := filepath.Join(dir, "bench.txt")
+	out := "goos: linux\ncpu: Apple M2\nlabel: ci\nBenchmarkSign-8 100 1000 ns/op 16 B/op\nBenchmarkSign-8 100 1100 ns/op 16 B/op\nBenchmarkVerify-8 10 5000 ns/op\n"
+	if err := os.WriteFile(path, []byte(out), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := importFile(path, "", "", "", nil); err == nil {
+		t.Error("expected an error without commit")
+	}
+	records, err := importFile(path, "abc123", "", "2026-10-15T09:00:00Z", map[string]string{"source": "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, expected 2", len(records))
+	}
+	sign := records[0]
+	if sign.Benchmark != "BenchmarkSign-8" || sign.Machine != "Apple M2" || sign.Commit != "abc123" ||
+		!sign.Time.Equal(time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)) || len(sign.Values["ns/op"]) != 2 || len(sign.Values["B/op"]) != 2 {
+		t.Errorf("unexpected record %+v", sign)
+	}
+	if sign.Meta["source"] != "ci" || sign.Meta["label"] != "ci" {
+		t.Errorf("unexpected metadata %v", sign.Meta)
+	}
+
+	// imports are idempotent
+	for _, want := range []int{2, 0} {
+		if added, err := appendHistory(records); err != nil || added != want {
+			t.Fatalf("appendHistory = %d, %v, expected %d", added, err, want)
+		}
+	}
+	stored, err := readHistory("Apple M2", func(h historyRecord) bool { return h.Benchmark == "BenchmarkVerify-8" })
+	if err != nil || len(stored) != 1 || stored[0].Values["ns/op"][0] != 5000 {
+		t.Errorf("readHistory = %+v, %v", stored, err)
+	}
+
+	// a history of an older version is migrated
+	legacy := `{"time":"2026-10-14T09:00:00Z","commit":"aaa","machine":"c7g.large","benchmark":"BenchmarkSign-2","values":{"ns/op":[100]},"source":"run"}`
+	if err := os.WriteFile(legacyHistoryFile(), []byte(legacy+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := readHistory("", nil); err != nil || len(stored) != 3 || stored[2].Machine != "c7g.large" {
+		t.Errorf("readHistory after migration = %+v, %v", stored, err)
+	}
+	if legacyHistory() {
+		t.Error("the legacy history wasn't renamed")
+	}
+
+	if _, err := parseMeta("source=ci,runner"); err == nil {
+		t.Error("expected an error on metadata without value")
+	}
+}
+
+func TestHistoryTrend(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 9, 0, 0, 0, time.UTC) }
+	records := []historyRecord{
+		{Time: day(12), Commit: "aaa", Machine: "c7g.large", Benchmark: "BenchmarkSign-2", Values: map[string][]float64{"ns/op": {100, 102}}},
+		{Time: day(14), Commit: "ccc", Machine: "c7g.large", Benchmark: "BenchmarkSign-2", Values: map[string][]float64{"ns/op": {110}}},
+		{Time: day(13), Commit: "bbb", Machine: "c7g.large", Benchmark: "BenchmarkSign-2", Values: map[string][]float64{"ns/op": {100}}},
+		{Time: day(15), Commit: "bbb", Machine: "c7g.large", Benchmark: "BenchmarkSign-2", Values: map[string][]float64{"ns/op": {102}}},
+		{Time: day(12), Commit: "aaa", Machine: "m7i.large", Benchmark: "BenchmarkSign-2", Values: map[string][]float64{"B/op": {16}}},
+	}
+	trends := historyTrend(records, "ns/op", 0)
+	points := trends["BenchmarkSign-2 on c7g.large"]
+	if len(trends) != 1 || len(points) != 3 {
+		t.Fatalf("historyTrend = %+v, expected 3 commits on c7g.large", trends)
+	}
+	// ordered by first run, the runs of a commit merged
+	if points[1].commit != "bbb" || points[1].runs != 2 || median(points[1].values) != 101 || points[2].commit != "ccc" {
+		t.Errorf("unexpected points %+v", points)
+	}
+	if last := historyTrend(records, "ns/op", 2)["BenchmarkSign-2 on c7g.large"]; len(last) != 2 || last[0].commit != "bbb" {
+		t.Errorf("last 2 commits: %+v", last)
+	}
+
+	var b strings.Builder
+	printHistory(&b, trends, "ns/op")
+	for _, want := range []string{"BenchmarkSign-2 on c7g.large (median ns/op)", "+8.9%", "over 3 commits: +8.9%"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("history doesn't contain %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestHistoryNoise(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 9, 0, 0, 0, time.UTC) }
+	var records []historyRecord
+	for i, commit := range []string{"aaa", "bbb", "ccc", "ddd"} {
+		records = append(records,
+			// ±10% run to run, 1% between commits: noisy
+			historyRecord{Time: day(10 + i), Commit: commit, Machine: "c7g.large", Benchmark: "BenchmarkNoisy-2", Values: map[string][]float64{"ns/op": {100 + float64(i), 90, 110}}},
+			// ±1% run to run, 10% between commits
+			historyRecord{Time: day(10 + i), Commit: commit, Machine: "c7g.large", Benchmark: "BenchmarkStable-2", Values: map[string][]float64{"ns/op": {100 * (1 + 0.1*float64(i)), 99 * (1 + 0.1*float64(i))}}},
+		)
+	}
+	noise := historyNoise(records, "ns/op")
+	noisy, stable := noise["BenchmarkNoisy-2 on c7g.large"], noise["BenchmarkStable-2 on c7g.large"]
+	if !noisy.noisy() || noisy.runs != 4 || noisy.commits != 3 {
+		t.Errorf("BenchmarkNoisy-2: %+v, expected noisy", noisy)
+	}
+	if stable.noisy() {
+		t.Errorf("BenchmarkStable-2: %+v, expected not noisy", stable)
+	}
+	if c := noisy.count(10); c <= 10 {
+		t.Errorf("suggested count %d, expected more than 10", c)
+	}
+	if (benchNoise{run: 10, commit: 1, runs: 2, commits: 1}).noisy() {
+		t.Error("too little history to flag a benchmark")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// hostTarget returns the target of a persistent host registered in the hosts section of the
+// configuration (-target), and its architecture; no instance is launched for it.
+func hostTarget(name string) (target, instanceArch, error) {
+	c, err := loadConfig()
+	if err != nil {
+		return target{}, archUnknown, err
+	}
+	dest, ok := c["hosts"][name]
+	if !ok {
+		return target{}, archUnknown, fmt.Errorf("unknown target %q; register it in the hosts section of %s", name, configPath())
+	}
+	user, host, ok := strings.Cut(dest, "@")
+	if !ok {
+		return target{}, archUnknown, fmt.Errorf("target %s: expected user@host, got %q", name, dest)
+	}
+	if *sshUserFlag != "" {
+		user = *sshUserFlag
+	}
+	t := target{host: host, user: user}
+
+	out, err := sshRun(remote{user: user, host: host}, "uname -m")
+	if err != nil {
+		return target{}, archUnknown, fmt.Errorf("unable to reach target %s, %v", name, err)
+	}
+	switch arch := strings.TrimSpace(out); arch {
+	case "x86_64":
+		return t, archX86, nil
+	case "aarch64", "arm64":
+		return t, archArm, nil
+	default:
+		return target{}, archUnknown, fmt.Errorf("target %s: unsupported architecture %s", name, arch)
+	}
+}
+
+// setupHostRun creates the directory of the run on a registered host (see remoteRunID): the host
+// is shared with other users and runs, its files must not collide.
+func setupHostRun(r remote) error {
+	if _, err := sshRun(r, "mkdir -m 700 -p "+remoteTmp()); err != nil {
+		return fmt.Errorf("unable to create the run directory on %s, %v", *targetFlag, err)
+	}
+	return nil
+}
+
+// restoreHost undoes the setup of the run on a registered host, which outlives it: restore are
+// the commands restoring the kernel settings changed by the run (-tune, -stable, -thp, -smt-off,
+// -core, -clocksource), run as root in reverse order; the tmpfs is unmounted and the files of the
+// run are removed.
+func restoreHost(t target, r remote, restore []string) {
+	var script []string
+	for _, cmd := range slices.Backward(restore) {
+		script = append(script, cmd+" 2>/dev/null")
+	}
+	if *tmpfsFlag != "" {
+		script = append(script, "umount "+remoteTmpfsDir(), "rmdir "+remoteTmpfsDir())
+	}
+	if len(script) > 0 {
+		t.status("restoring the settings of %s...", *targetFlag)
+		if _, err := sshRunOnce(r, "sudo sh -c "+shellQuote(strings.Join(script, "; "))); err != nil {
+			slog.Warn(fmt.Sprintf("%sunable to restore the settings of %s: %v", t.prefix(), *targetFlag, err))
+		}
+	}
+	if _, err := sshRunOnce(r, "rm -rf "+remoteTmp()); err != nil {
+		slog.Warn(fmt.Sprintf("%sunable to remove %s on %s: %v", t.prefix(), remoteTmp(), *targetFlag, err))
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// iamStatement is a statement of an IAM policy document.
+type iamStatement struct {
+	Sid       string
+	Effect    string
+	Action    []string
+	Resource  []string
+	Condition map[string]map[string]any `json:",omitempty"`
+}
+
+type iamPolicy struct {
+	Version   string
+	Statement []iamStatement
+}
+
+// iamFeatures are the statements needed by each feature of rbench, for rbench iam-policy. The
+// describe calls don't support resource-level permissions; instances, key pairs and security
+// groups are restricted to the ones tagged or named by rbench.
+var iamFeatures = map[string][]iamStatement{
+	// rbench run, ps, kill, ssh, fetch; the account policy and team configuration parameters
+	"run": {
+		{Sid: "Identity", Action: []string{"sts:GetCallerIdentity"}, Resource: []string{"*"}},
+		{Sid: "Describe", Action: []string{
+			"ec2:DescribeImages",
+			"ec2:DescribeInstances",
+			"ec2:DescribeInstanceTypes",
+			"ec2:DescribeKeyPairs",
+			"ec2:DescribeSecurityGroups",
+			"ec2:DescribeSubnets",
+			"ec2:DescribeVpcs",
+		}, Resource: []string{"*"}},
+		{Sid: "KeyPairs", Action: []string{"ec2:ImportKeyPair", "ec2:DeleteKeyPair"}, Resource: []string{"arn:aws:ec2:*:*:key-pair/rbench-*"}},
+		{Sid: "RunTaggedInstances", Action: []string{"ec2:RunInstances"}, Resource: []string{"arn:aws:ec2:*:*:instance/*"},
+			Condition: map[string]map[string]any{"StringLike": {"aws:RequestTag/rbench": "*"}}},
+		{Sid: "RunInstancesResources", Action: []string{"ec2:RunInstances"}, Resource: []string{
+			"arn:aws:ec2:*::image/*",
+			"arn:aws:ec2:*:*:key-pair/rbench-*",
+			"arn:aws:ec2:*:*:network-interface/*",
+			"arn:aws:ec2:*:*:security-group/*",
+			"arn:aws:ec2:*:*:subnet/*",
+			"arn:aws:ec2:*:*:volume/*",
+		}},
+		{Sid: "TagOnCreate", Action: []string{"ec2:CreateTags"}, Resource: []string{"arn:aws:ec2:*:*:*/*"},
+			Condition: map[string]map[string]any{"StringEquals": {"ec2:CreateAction": []string{"RunInstances", "ImportKeyPair", "CreateSecurityGroup"}}}},
+		{Sid: "SecurityGroup", Action: []string{"ec2:CreateSecurityGroup"}, Resource: []string{"arn:aws:ec2:*:*:security-group/*", "arn:aws:ec2:*:*:vpc/*"}},
+		{Sid: "SecurityGroupRules", Action: []string{"ec2:AuthorizeSecurityGroupIngress", "ec2:RevokeSecurityGroupIngress"}, Resource: []string{"arn:aws:ec2:*:*:security-group/*"},
+			Condition: map[string]map[string]any{"StringLike": {"ec2:ResourceTag/rbench": "*"}}},
+		{Sid: "TerminateTaggedInstances", Action: []string{"ec2:TerminateInstances"}, Resource: []string{"arn:aws:ec2:*:*:instance/*"},
+			Condition: map[string]map[string]any{"StringLike": {"ec2:ResourceTag/rbench": "*"}}},
+		{Sid: "Parameters", Action: []string{"ssm:GetParameter"}, Resource: []string{"arn:aws:ssm:*:*:parameter/rbench/*",
+			// the Ubuntu AMIs, see imageForArch
+			"arn:aws:ssm:*::parameter/aws/service/canonical/*"}},
+		{Sid: "Prices", Action: []string{"pricing:GetProducts"}, Resource: []string{"*"}},
+	},
+	// rbench init: security group and instance type setup
+	"init": {
+		{Sid: "Describe", Action: []string{
+			"ec2:DescribeInstanceTypeOfferings",
+			"ec2:DescribeRegions",
+			"ec2:DescribeSecurityGroups",
+			"ec2:DescribeVpcs",
+		}, Resource: []string{"*"}},
+		{Sid: "InitSecurityGroup", Action: []string{"ec2:CreateSecurityGroup", "ec2:AuthorizeSecurityGroupIngress"}, Resource: []string{
+			"arn:aws:ec2:*:*:security-group/*",
+			"arn:aws:ec2:*:*:vpc/*",
+		}},
+	},
+	// rbench inventory
+	"inventory": {
+		{Sid: "Describe", Action: []string{
+			"ec2:DescribeCapacityReservations",
+			"ec2:DescribeImages",
+			"ec2:DescribeInstances",
+			"ec2:DescribeKeyPairs",
+			"ec2:DescribeRegions",
+			"ec2:DescribeSecurityGroups",
+			"ec2:DescribeVolumes",
+		}, Resource: []string{"*"}},
+		{Sid: "Prices", Action: []string{"pricing:GetProducts"}, Resource: []string{"*"}},
+	},
+	// -eice: the key push and the tunnel of the ssh connections
+	"eice": {
+		{Sid: "Describe", Action: []string{"ec2:DescribeInstanceConnectEndpoints"}, Resource: []string{"*"}},
+		{Sid: "InstanceConnectKeys", Action: []string{"ec2-instance-connect:SendSSHPublicKey"}, Resource: []string{"arn:aws:ec2:*:*:instance/*"},
+			Condition: map[string]map[string]any{"StringLike": {"ec2:ResourceTag/rbench": "*"}}},
+		{Sid: "InstanceConnectTunnel", Action: []string{"ec2-instance-connect:OpenTunnel"}, Resource: []string{"arn:aws:ec2:*:*:instance-connect-endpoint/*"}},
+	},
+	// -nodes: the cluster placement group of the instances
+	"nodes": {
+		{Sid: "PlacementGroups", Action: []string{"ec2:CreatePlacementGroup", "ec2:DeletePlacementGroup"}, Resource: []string{"arn:aws:ec2:*:*:placement-group/rbench-*"}},
+		{Sid: "RunInPlacementGroups", Action: []string{"ec2:RunInstances"}, Resource: []string{"arn:aws:ec2:*:*:placement-group/rbench-*"}},
+		{Sid: "TagPlacementGroups", Action: []string{"ec2:CreateTags"}, Resource: []string{"arn:aws:ec2:*:*:placement-group/rbench-*"},
+			Condition: map[string]map[string]any{"StringEquals": {"ec2:CreateAction": "CreatePlacementGroup"}}},
+	},
+	// -max-instances: the slot table, see acquireInstanceSlot
+	"max-instances": {
+		{Sid: "InstanceSlots", Action: []string{
+			"dynamodb:CreateTable",
+			"dynamodb:DeleteItem",
+			"dynamodb:DescribeTable",
+			"dynamodb:PutItem",
+			"dynamodb:Scan",
+			"dynamodb:TagResource",
+			"dynamodb:UpdateItem",
+		}, Resource: []string{"arn:aws:dynamodb:*:*:table/" + instanceSlotTable}},
+	},
+	// rbench bake-ami
+	"bake-ami": {
+		{Sid: "BakeImages", Action: []string{"ec2:CreateImage"}, Resource: []string{"arn:aws:ec2:*:*:instance/*", "arn:aws:ec2:*::image/*", "arn:aws:ec2:*::snapshot/*"}},
+		{Sid: "TagImages", Action: []string{"ec2:CreateTags"}, Resource: []string{"arn:aws:ec2:*::image/*", "arn:aws:ec2:*::snapshot/*"},
+			Condition: map[string]map[string]any{"StringEquals": {"ec2:CreateAction": "CreateImage"}}},
+	},
+	// rbench cost report
+	"cost": {
+		{Sid: "CostExplorer", Action: []string{"ce:GetCostAndUsage"}, Resource: []string{"*"}},
+	},
+	// rbench config push (admins)
+	"team-config": {
+		{Sid: "TeamConfig", Action: []string{"ssm:PutParameter"}, Resource: []string{"arn:aws:ssm:*:*:parameter" + teamConfigParameter}},
+	},
+	// -s3, rbench runs
+	"s3": {
+		{Sid: "Results", Action: []string{"s3:GetObject", "s3:PutObject", "s3:ListBucket", "s3:GetLifecycleConfiguration", "s3:PutLifecycleConfiguration"}, Resource: []string{"arn:aws:s3:::*"}},
+	},
+	// -audit-log-group
+	"audit": {
+		{Sid: "AuditLog", Action: []string{"logs:CreateLogStream", "logs:PutLogEvents"}, Resource: []string{"arn:aws:logs:*:*:log-group:*:log-stream:rbench/*"}},
+	},
+	// -sign-key=kms:..., rbench verify of KMS signatures
+	"kms": {
+		{Sid: "Signing", Action: []string{"kms:GetPublicKey", "kms:Sign", "kms:Verify"}, Resource: []string{"arn:aws:kms:*:*:key/*", "arn:aws:kms:*:*:alias/*"}},
+	},
+}
+
+// iamPolicyCmd implements "rbench iam-policy": the least-privilege IAM policy of the features.
+func iamPolicyCmd(args []string) error {
+	fs := flag.NewFlagSet("iam-policy", flag.ExitOnError)
+	features := fs.String("features", "run", "comma-separated features to grant: "+strings.Join(sortedKeys(iamFeatures), ", ")+" or all")
+	fs.StringVar(roleARN, "role-arn", "", "also allow assuming this role (-role-arn of the runs)")
+	fs.StringVar(benchRole, "bench-role", "", "also allow assuming this role for the credentials of the benchmarks (-bench-role of the runs)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench iam-policy [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	names := splitList(*features)
+	if slices.Contains(names, "all") {
+		names = sortedKeys(iamFeatures)
+	}
+	p, err := iamPolicyDocument(names, *roleARN)
+	if err != nil {
+		return err
+	}
+	if *benchRole != "" {
+		p.Statement = append(p.Statement, iamStatement{Sid: "BenchRole", Effect: "Allow", Action: []string{"sts:AssumeRole"}, Resource: []string{*benchRole}})
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", data)
+	return err
+}
+
+// iamPolicyDocument returns the policy granting the features; the actions of the statements
+// shared by several features (same Sid) are merged.
+func iamPolicyDocument(features []string, role string) (iamPolicy, error) {
+	p := iamPolicy{Version: "2012-10-17"}
+	index := make(map[string]int)
+	add := func(s iamStatement) {
+		s.Effect = "Allow"
+		s.Action = slices.Clone(s.Action)
+		i, ok := index[s.Sid]
+		if !ok {
+			index[s.Sid] = len(p.Statement)
+			p.Statement = append(p.Statement, s)
+			return
+		}
+		merged := &p.Statement[i]
+		for _, a := range s.Action {
+			if !slices.Contains(merged.Action, a) {
+				merged.Action = append(merged.Action, a)
+			}
+		}
+		slices.Sort(merged.Action)
+	}
+	for _, name := range features {
+		statements, ok := iamFeatures[name]
+		if !ok {
+			return iamPolicy{}, fmt.Errorf("unknown feature %q (expected %s)", name, strings.Join(sortedKeys(iamFeatures), ", "))
+		}
+		for _, s := range statements {
+			add(s)
+		}
+	}
+	if role != "" {
+		add(iamStatement{Sid: "AssumeRole", Action: []string{"sts:AssumeRole"}, Resource: []string{role}})
+	}
+	return p, nil
+}
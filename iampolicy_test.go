@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIAMPolicyDocument(t *testing.T) {
+	actions := func(p iamPolicy) []string {
+		var all []string
+		for _, s := range p.Statement {
+			all = append(all, s.Action...)
+		}
+		return all
+	}
+
+	p, err := iamPolicyDocument([]string{"run"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := actions(p); !slices.Contains(a, "ec2:RunInstances") || slices.Contains(a, "ce:GetCostAndUsage") {
+		t.Errorf("unexpected run actions %v", a)
+	}
+
+	p, err = iamPolicyDocument([]string{"run", "inventory"}, "arn:aws:iam::123456789012:role/rbench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sids := make(map[string]int)
+	for _, s := range p.Statement {
+		sids[s.Sid]++
+	}
+	if sids["Describe"] != 1 || sids["Prices"] != 1 || sids["AssumeRole"] != 1 {
+		t.Errorf("statements not merged: %v", sids)
+	}
+	if a := actions(p); !slices.Contains(a, "ec2:DescribeVolumes") {
+		t.Errorf("missing inventory actions %v", a)
+	}
+	// the feature statements are not modified by the merge
+	if slices.Contains(iamFeatures["run"][1].Action, "ec2:DescribeVolumes") {
+		t.Error("feature statements modified")
+	}
+
+	if _, err := iamPolicyDocument([]string{"typo"}, ""); err == nil {
+		t.Error("expected an error for an unknown feature")
+	}
+}
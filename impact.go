@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// reportImpact projects the ns/op changes from a baseline at scale: the CPU time and the cost per
+// day of running each benchmarked operation opsPerDay times on the instance type.
+type reportImpact struct {
+	OpsPerDay    float64
+	InstanceType string
+	Price        float64 // on-demand USD per instance-hour, 0 if unknown
+	VCPUs        int
+	Benchmarks   []benchmarkImpact // with a baseline, in order of appearance
+}
+
+type benchmarkImpact struct {
+	Name     string
+	Delta    float64 // ns/op
+	CPUHours float64 // per day
+	Dollars  float64 // per day, 0 without a price
+}
+
+// newReportImpact computes the impact of the ns/op changes of d. A CPU-hour costs the price of a
+// vCPU of the instance: the benchmarks are assumed to keep one vCPU busy.
+func newReportImpact(d reportData, opsPerDay, price float64) reportImpact {
+	im := reportImpact{OpsPerDay: opsPerDay, InstanceType: d.Config["instance-type"], Price: price}
+	im.VCPUs, _ = strconv.Atoi(d.Config["vcpus"])
+	vcpuPrice := price
+	if im.VCPUs > 0 {
+		vcpuPrice /= float64(im.VCPUs)
+	}
+	for _, b := range d.Benchmarks {
+		m, ok := b.Metrics["ns/op"]
+		if !ok || !m.HasBase {
+			continue
+		}
+		delta := m.Median - m.Base
+		cpuHours := delta * opsPerDay / 3600e9
+		im.Benchmarks = append(im.Benchmarks, benchmarkImpact{Name: b.Name, Delta: delta, CPUHours: cpuHours, Dollars: cpuHours * vcpuPrice})
+	}
+	return im
+}
+
+// printImpact prints the impact as a table, savings negative.
+func printImpact(w io.Writer, im reportImpact) {
+	fmt.Fprintf(w, "\nprojected impact at %.3g ops/day", im.OpsPerDay)
+	if im.InstanceType != "" {
+		fmt.Fprintf(w, " on %s", im.InstanceType)
+	}
+	if im.Price > 0 {
+		fmt.Fprintf(w, " ($%.4f/hour", im.Price)
+		if im.VCPUs > 0 {
+			fmt.Fprintf(w, ", %d vCPUs", im.VCPUs)
+		}
+		fmt.Fprintf(w, ")")
+	}
+	fmt.Fprintln(w, ":")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "benchmark\tΔ ns/op\tCPU-hours/day\t$/day\t$/year\t\n")
+	for _, b := range im.Benchmarks {
+		dollars, yearly := "-", "-"
+		if im.Price > 0 {
+			dollars, yearly = fmt.Sprintf("%+.2f", b.Dollars), fmt.Sprintf("%+.0f", 365*b.Dollars)
+		}
+		fmt.Fprintf(tw, "%s\t%+.4g\t%+.3g\t%s\t%s\t\n", b.Name, b.Delta, b.CPUHours, dollars, yearly)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestReportImpact(t *testing.T) {
+	base, results := newBenchResults(), newBenchResults()
+	base.Write([]byte("BenchmarkSign-2 100 2000 ns/op\nBenchmarkVerify-2 100 500 ns/op\n"))
+	results.Write([]byte("BenchmarkSign-2 100 1500 ns/op\nBenchmarkVerify-2 100 500 ns/op\nBenchmarkNew-2 100 10 ns/op\n"))
+	config := map[string]string{"instance-type": "c7g.large", "vcpus": "2"}
+	d := newReportData(config, results, nil, base)
+
+	im := newReportImpact(d, 1e9, 0.0725)
+	if len(im.Benchmarks) != 2 || im.VCPUs != 2 {
+		t.Fatalf("unexpected impact %+v", im)
+	}
+	// -500ns * 1e9 ops = -500s = -0.1389 CPU-hours, at 0.0725/2 $ per vCPU-hour
+	sign := im.Benchmarks[0]
+	if sign.Name != "BenchmarkSign-2" || math.Abs(sign.CPUHours+500.0/3600) > 1e-9 || math.Abs(sign.Dollars+500.0/3600*0.0725/2) > 1e-9 {
+		t.Errorf("unexpected impact of Sign %+v", sign)
+	}
+
+	var b strings.Builder
+	printImpact(&b, im)
+	out := b.String()
+	for _, want := range []string{"at 1e+09 ops/day on c7g.large ($0.0725/hour, 2 vCPUs)", "-500", "-0.139", "-0.01", "-2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+
+	// without a price
+	b.Reset()
+	printImpact(&b, newReportImpact(d, 1e9, 0))
+	if !strings.Contains(strings.Join(strings.Fields(b.String()), " "), "BenchmarkSign-2 -500 -0.139 - -") {
+		t.Errorf("unexpected impact without price\n%s", b.String())
+	}
+}
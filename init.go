@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// initCmd implements "rbench init": it walks through the AWS setup (credentials, region,
+// security group, key pair, default instance type), validating each answer against AWS, and
+// saves the answers to the configuration file.
+func initCmd(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench init [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	values := make(map[string]string)
+	in := bufio.NewReader(os.Stdin)
+
+	// credentials
+	if *awsProfile, err = prompt(in, "AWS profile (empty for the default credential chain)", firstNonEmpty(*awsProfile, c[""]["profile"]), nil); err != nil {
+		return err
+	}
+	if err := loadAWSConfig(); err != nil {
+		return err
+	}
+	if err := resolveIdentity(); err != nil {
+		return fmt.Errorf("%v; check your credentials (aws configure, aws sso login)", err)
+	}
+	fmt.Printf("ok    credentials of %s in account %s\n", awsUserName, awsAccountID)
+	if *awsProfile != "" {
+		values["profile"] = *awsProfile
+	}
+
+	// region
+	regions, err := enabledRegions()
+	if err != nil {
+		return err
+	}
+	values["region"], err = prompt(in, "region", awsConfig.Region, func(region string) error {
+		if !slices.Contains(regions, region) {
+			return fmt.Errorf("region %s is not enabled in the account (%s)", region, strings.Join(regions, ", "))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	awsConfig.Region = values["region"]
+	ec2Client = ec2.NewFromConfig(awsConfig)
+
+	// security group
+	def := securityGroupID
+	if err := checkSecurityGroup(def); err != nil {
+		def = "new"
+	}
+	values["security-group"], err = prompt(in, `security group of the instances, allowing ssh ("new" to create one)`, def, func(id string) error {
+		if id == "new" {
+			return nil
+		}
+		return checkSecurityGroup(id)
+	})
+	if err != nil {
+		return err
+	}
+	if values["security-group"] == "new" {
+		vpc, err := defaultVPC()
+		if err != nil {
+			return fmt.Errorf("%v; create a security group allowing ssh and enter its id", err)
+		}
+		if values["security-group"], err = createSecurityGroup("rbench-"+awsUserName, vpc); err != nil {
+			return err
+		}
+		fmt.Printf("ok    created security group %s\n", values["security-group"])
+	}
+
+	// key pair
+	sshKeyName = keyPairName()
+	if err := ensureKeyPair(); err != nil {
+		return err
+	}
+	fmt.Printf("ok    key pair %s (%s)\n", sshKeyName, privateKeyPath())
+
+	// default instance type
+	values["type"], err = prompt(in, "default instance type", firstNonEmpty(c[""]["type"], *instanceType), func(t string) error {
+		out, err := ec2Client.DescribeInstanceTypeOfferings(context.TODO(), &ec2.DescribeInstanceTypeOfferingsInput{
+			LocationType: types.LocationTypeRegion,
+			Filters:      []types.Filter{{Name: aws.String("instance-type"), Values: []string{t}}},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to describe instance type offerings, %v", err)
+		}
+		if len(out.InstanceTypeOfferings) == 0 {
+			return fmt.Errorf("instance type %s is not offered in %s", t, awsConfig.Region)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := updateConfig(values); err != nil {
+		return err
+	}
+	fmt.Printf("configuration written to %s; check the setup with rbench doctor\n", configPath())
+	return nil
+}
+
+// prompt asks a question on the terminal and returns the answer, def if empty; invalid answers
+// are asked again.
+func prompt(in *bufio.Reader, question, def string, validate func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Printf("%s [%s]: ", question, def)
+		} else {
+			fmt.Printf("%s: ", question)
+		}
+		answer, err := in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			answer = def
+		}
+		if err != nil {
+			// end of input
+			fmt.Println()
+		}
+		var verr error
+		if validate != nil {
+			verr = validate(answer)
+		}
+		switch {
+		case verr == nil:
+			return answer, nil
+		case err != nil:
+			return "", verr
+		}
+		fmt.Printf("FAIL  %v\n", verr)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// enabledRegions returns the regions enabled in the account.
+func enabledRegions() ([]string, error) {
+	out, err := ec2.NewFromConfig(awsConfig).DescribeRegions(context.TODO(), &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe regions, %v", err)
+	}
+	var regions []string
+	for _, r := range out.Regions {
+		regions = append(regions, aws.ToString(r.RegionName))
+	}
+	slices.Sort(regions)
+	return regions, nil
+}
+
+// checkSecurityGroup returns an error if the security group doesn't exist or doesn't allow ssh.
+func checkSecurityGroup(id string) error {
+	out, err := ec2Client.DescribeSecurityGroups(context.TODO(), &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []string{id},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to describe security group %s, %v", id, err)
+	}
+	if len(out.SecurityGroups) == 0 {
+		return fmt.Errorf("security group %s not found in %s", id, awsConfig.Region)
+	}
+	for _, p := range out.SecurityGroups[0].IpPermissions {
+		all := aws.ToString(p.IpProtocol) == "-1"
+		port := int32(*sshPort)
+		if all || (aws.ToString(p.IpProtocol) == "tcp" && aws.ToInt32(p.FromPort) <= port && port <= aws.ToInt32(p.ToPort)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("security group %s doesn't allow ssh (tcp/%d, -ssh-port)", id, *sshPort)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// storage prices used by the inventory estimates, in USD per GB-month (gp3 volumes, EBS
+// snapshots backing the images, us-east-1); they vary slightly across regions.
+const (
+	volumeGBMonth   = 0.08
+	snapshotGBMonth = 0.05
+)
+
+// resource is an rbench-tagged resource of the inventory.
+type resource struct {
+	region, kind, id, detail, owner string
+	created                         time.Time
+	cost                            float64 // estimated spend since creation, USD; -1 if unknown
+}
+
+// inventoryCmd implements "rbench inventory": every rbench-tagged resource of the account,
+// in all the enabled regions, with its age and an estimate of its cost so far.
+func inventoryCmd(args []string) error {
+	fs := flag.NewFlagSet("inventory", flag.ExitOnError)
+	mine := fs.Bool("mine", false, "only list my resources")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench inventory [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := loadAWSConfig(); err != nil {
+		return err
+	}
+	owner := ""
+	if *mine {
+		if err := resolveIdentity(); err != nil {
+			return err
+		}
+		owner = awsUserName
+	}
+	regions, err := enabledRegions()
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		resources []resource
+		errs      []error
+	)
+	for _, region := range regions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rs, err := regionInventory(region, owner)
+			mu.Lock()
+			defer mu.Unlock()
+			resources = append(resources, rs...)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", region, err))
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		slog.Warn(err.Error())
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		a, b := resources[i], resources[j]
+		if a.region != b.region {
+			return a.region < b.region
+		}
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		return a.created.Before(b.created)
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "REGION\tKIND\tID\tDETAIL\tUSER\tAGE\tCOST\n")
+	var total float64
+	for _, r := range resources {
+		age, cost := "-", "-"
+		if !r.created.IsZero() {
+			age = formatAge(time.Since(r.created))
+		}
+		if r.cost >= 0 {
+			cost = fmt.Sprintf("~$%.2f", r.cost)
+			total += r.cost
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.region, r.kind, r.id, r.detail, r.owner, age, cost)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d resources, ~$%.2f estimated so far (on-demand and list storage prices)\n", len(resources), total)
+	if len(errs) > 0 {
+		return fmt.Errorf("the inventory of %d regions failed", len(errs))
+	}
+	return nil
+}
+
+// formatAge returns a short duration: 45m, 5h, 12d.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// regionInventory returns the rbench-tagged resources of region, of owner if set.
+func regionInventory(region, owner string) ([]resource, error) {
+	ctx := context.TODO()
+	client := ec2.NewFromConfig(awsConfig, func(o *ec2.Options) {
+		o.Region = region
+	})
+	filters := []types.Filter{{Name: aws.String("tag-key"), Values: []string{"rbench"}}}
+	if owner != "" {
+		filters = []types.Filter{{Name: aws.String("tag:rbench"), Values: []string{owner}}}
+	}
+	months := func(since time.Time) float64 {
+		return time.Since(since).Hours() / (24 * 30)
+	}
+	prices := make(map[types.InstanceType]float64)
+	hourlyPrice := func(t types.InstanceType) float64 {
+		p, ok := prices[t]
+		if !ok {
+			var err error
+			if p, err = regionOnDemandPrice(region, string(t)); err != nil {
+				p = -1
+			}
+			prices[t] = p
+		}
+		return p
+	}
+	tag := func(tags []types.Tag) string {
+		return instanceTag(types.Instance{Tags: tags}, "rbench")
+	}
+	var resources []resource
+
+	instances := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{Filters: filters})
+	for instances.HasMorePages() {
+		page, err := instances.NextPage(ctx)
+		if err != nil {
+			return resources, fmt.Errorf("unable to describe instances, %v", err)
+		}
+		for _, rv := range page.Reservations {
+			for _, i := range rv.Instances {
+				if i.State.Name == types.InstanceStateNameTerminated {
+					continue
+				}
+				r := resource{
+					region:  region,
+					kind:    "instance",
+					id:      aws.ToString(i.InstanceId),
+					detail:  fmt.Sprintf("%s %s", i.InstanceType, i.State.Name),
+					owner:   tag(i.Tags),
+					created: aws.ToTime(i.LaunchTime),
+					cost:    -1,
+				}
+				// stopped instances only pay for their volumes, listed separately
+				if i.State.Name == types.InstanceStateNameRunning || i.State.Name == types.InstanceStateNamePending {
+					if p := hourlyPrice(i.InstanceType); p >= 0 {
+						r.cost = p * time.Since(r.created).Hours()
+					}
+				} else {
+					r.cost = 0
+				}
+				resources = append(resources, r)
+			}
+		}
+	}
+
+	volumes := ec2.NewDescribeVolumesPaginator(client, &ec2.DescribeVolumesInput{Filters: filters})
+	for volumes.HasMorePages() {
+		page, err := volumes.NextPage(ctx)
+		if err != nil {
+			return resources, fmt.Errorf("unable to describe volumes, %v", err)
+		}
+		for _, v := range page.Volumes {
+			resources = append(resources, resource{
+				region:  region,
+				kind:    "volume",
+				id:      aws.ToString(v.VolumeId),
+				detail:  fmt.Sprintf("%d GB %s %s", aws.ToInt32(v.Size), v.VolumeType, v.State),
+				owner:   tag(v.Tags),
+				created: aws.ToTime(v.CreateTime),
+				cost:    float64(aws.ToInt32(v.Size)) * volumeGBMonth * months(aws.ToTime(v.CreateTime)),
+			})
+		}
+	}
+
+	images, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{Owners: []string{"self"}, Filters: filters})
+	if err != nil {
+		return resources, fmt.Errorf("unable to describe images, %v", err)
+	}
+	for _, img := range images.Images {
+		created, _ := time.Parse(time.RFC3339, aws.ToString(img.CreationDate))
+		var gb int32
+		for _, m := range img.BlockDeviceMappings {
+			if m.Ebs != nil {
+				gb += aws.ToInt32(m.Ebs.VolumeSize)
+			}
+		}
+		// snapshots are incremental: the size of the volumes is an upper bound
+		resources = append(resources, resource{
+			region:  region,
+			kind:    "image",
+			id:      aws.ToString(img.ImageId),
+			detail:  aws.ToString(img.Name),
+			owner:   tag(img.Tags),
+			created: created,
+			cost:    float64(gb) * snapshotGBMonth * months(created),
+		})
+	}
+
+	reservations := ec2.NewDescribeCapacityReservationsPaginator(client, &ec2.DescribeCapacityReservationsInput{Filters: filters})
+	for reservations.HasMorePages() {
+		page, err := reservations.NextPage(ctx)
+		if err != nil {
+			return resources, fmt.Errorf("unable to describe capacity reservations, %v", err)
+		}
+		for _, c := range page.CapacityReservations {
+			r := resource{
+				region:  region,
+				kind:    "capacity-reservation",
+				id:      aws.ToString(c.CapacityReservationId),
+				detail:  fmt.Sprintf("%d x %s %s", aws.ToInt32(c.TotalInstanceCount), aws.ToString(c.InstanceType), c.State),
+				owner:   tag(c.Tags),
+				created: aws.ToTime(c.CreateDate),
+				cost:    -1,
+			}
+			// reserved capacity is billed at the on-demand price while active, used or not
+			if p := hourlyPrice(types.InstanceType(aws.ToString(c.InstanceType))); p >= 0 {
+				end := time.Now()
+				if c.State != types.CapacityReservationStateActive && c.EndDate != nil {
+					end = aws.ToTime(c.EndDate)
+				}
+				r.cost = p * float64(aws.ToInt32(c.TotalInstanceCount)) * end.Sub(r.created).Hours()
+			}
+			resources = append(resources, r)
+		}
+	}
+
+	groups, err := client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{Filters: filters})
+	if err != nil {
+		return resources, fmt.Errorf("unable to describe security groups, %v", err)
+	}
+	for _, g := range groups.SecurityGroups {
+		resources = append(resources, resource{
+			region: region,
+			kind:   "security-group",
+			id:     aws.ToString(g.GroupId),
+			detail: aws.ToString(g.GroupName),
+			owner:  tag(g.Tags),
+		})
+	}
+
+	keys, err := client.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{Filters: filters})
+	if err != nil {
+		return resources, fmt.Errorf("unable to describe key pairs, %v", err)
+	}
+	for _, k := range keys.KeyPairs {
+		resources = append(resources, resource{
+			region:  region,
+			kind:    "key-pair",
+			id:      aws.ToString(k.KeyPairId),
+			detail:  aws.ToString(k.KeyName),
+			owner:   tag(k.Tags),
+			created: aws.ToTime(k.CreateTime),
+		})
+	}
+	return resources, nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatAge(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		45 * time.Minute:    "45m",
+		5 * time.Hour:       "5h",
+		47 * time.Hour:      "47h",
+		12 * 24 * time.Hour: "12d",
+	} {
+		if got := formatAge(d); got != want {
+			t.Errorf("formatAge(%s) = %s, want %s", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// test2json converts the output of the test binary, run with -test.v=test2json, to
+// go test -json events using the local go tool test2json; the remote host doesn't need a Go toolchain.
+type test2json struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	events *testEvents
+}
+
+// startTest2JSON starts the converter; events are written to out.
+func startTest2JSON(out io.Writer) (*test2json, error) {
+	t := &test2json{
+		cmd:    exec.Command("go", "tool", "test2json", "-t", "-p", benchPackage),
+		events: &testEvents{},
+	}
+	t.cmd.Stdout = io.MultiWriter(out, t.events)
+	var err error
+	if t.stdin, err = t.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err := t.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start go tool test2json, %v", err)
+	}
+	return t, nil
+}
+
+func (t *test2json) Write(p []byte) (int, error) {
+	return t.stdin.Write(p)
+}
+
+// Close flushes the converter and waits for it to exit.
+func (t *test2json) Close() error {
+	t.stdin.Close()
+	if err := t.cmd.Wait(); err != nil {
+		return fmt.Errorf("go tool test2json failed, %v", err)
+	}
+	return nil
+}
+
+// testEvent is a go test -json event (see go doc test2json).
+type testEvent struct {
+	Action string
+	Test   string
+	Output string
+}
+
+// testEvents decodes the events written to it and records the failed tests and benchmarks.
+type testEvents struct {
+	mu      sync.Mutex
+	partial []byte
+	failed  []string
+}
+
+func (e *testEvents) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.partial = append(e.partial, p...)
+	for {
+		i := bytes.IndexByte(e.partial, '\n')
+		if i < 0 {
+			break
+		}
+		var event testEvent
+		if err := json.Unmarshal(e.partial[:i], &event); err == nil && event.Action == "fail" && event.Test != "" {
+			e.failed = append(e.failed, event.Test)
+		}
+		e.partial = e.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// failures returns the names of the failed tests and benchmarks.
+func (e *testEvents) failures() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.failed...)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTestEvents(t *testing.T) {
+	var events testEvents
+	fmt.Fprint(&events, `{"Action":"run","Test":"TestA"}
+{"Action":"fail","Test":"TestA","Elapsed":0}
+{"Action":"output","Output":"commit: x\n"}
+{"Action":"pass","Test":"BenchmarkB"}
+{"Action":"fail","Elap`)
+	fmt.Fprint(&events, `sed":0}
+`)
+	if failed := events.failures(); len(failed) != 1 || failed[0] != "TestA" {
+		t.Fatalf("unexpected failures %v", failed)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// -keep leaves the instances running after the run, recorded in keptFile; the next runs launching
+// the same instance (provider, region, type, image and launch options) reuse them instead, which
+// skips the launch and the ssh wait. A reused instance is kept again with -keep, and terminated
+// otherwise. The kernel settings of the previous runs (-tune, -thp, ...) persist on it.
+
+// keptFile is the state file of the kept instances, next to the configuration file.
+func keptFile() string {
+	return filepath.Join(filepath.Dir(configPath()), "kept.json")
+}
+
+// keptInstance is an instance left running by -keep.
+type keptInstance struct {
+	ID     string    `json:"id"`
+	Host   string    `json:"host"`
+	Launch string    `json:"launch"` // see launchKey
+	Since  time.Time `json:"since"`
+}
+
+// launchKey identifies the instances a target launches: a kept instance is reused by the targets
+// with the same key.
+func launchKey(t target) string {
+	region := *gcpZone
+	if *providerFlag == "aws" {
+		region = awsConfig.Region
+	}
+	// the kernel command line of -mitigations-off is a boot setting too
+	return fmt.Sprintf("%s %s %s %s subnet=%s ipv6=%t efa=%t ena-express=%t confidential=%s mitigations-off=%t eice=%t",
+		*providerFlag, region, *instanceType, t.ami, *subnetFlag, *ipv6Only, *efaFlag, *enaExpress, *confidential, *mitigationsOff, *eiceFlag)
+}
+
+// updateKept applies f to the kept instances, with the state file locked.
+func updateKept(f func([]keptInstance) []keptInstance) error {
+	path := keptFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open %s, %v", path, err)
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("unable to lock %s, %v", path, err)
+	}
+	var kept []keptInstance
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &kept); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if data, err = json.MarshalIndent(f(kept), "", "  "); err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(append(data, '\n'), 0); err != nil {
+		return fmt.Errorf("unable to write %s, %v", path, err)
+	}
+	return nil
+}
+
+// claimKept removes a kept instance of the launch key of t from the state file and returns it;
+// false if there is none.
+func claimKept(t target) (keptInstance, bool, error) {
+	var (
+		claimed keptInstance
+		found   bool
+	)
+	err := updateKept(func(kept []keptInstance) []keptInstance {
+		for i, k := range kept {
+			if k.Launch == launchKey(t) {
+				claimed, found = k, true
+				return append(kept[:i], kept[i+1:]...)
+			}
+		}
+		return kept
+	})
+	return claimed, found, err
+}
+
+// reuseKept returns the address and the id of a kept instance for t, reachable and cleaned of the
+// files of its previous runs; the instances that are gone are dropped. ok is false if there is no
+// instance to reuse.
+func reuseKept(t target) (host, id string, ok bool) {
+	for {
+		k, found, err := claimKept(t)
+		if err != nil {
+			slog.Warn(t.prefix() + err.Error())
+			return "", "", false
+		}
+		if !found {
+			return "", "", false
+		}
+		r := remote{user: t.user, host: k.Host}
+		cleanup := "rm -rf /tmp/rbench-* /tmp/bench /tmp/bench-* " + remoteTmpfsDir() + "/* 2>/dev/null; true"
+		if _, err := sshRunOnce(r, cleanup); err != nil {
+			slog.Warn(fmt.Sprintf("%skept instance %s is unreachable, forgotten: %v", t.prefix(), k.ID, err))
+			continue
+		}
+		slog.Info(fmt.Sprintf("%sreusing instance %s (kept since %s)", t.prefix(), k.ID, k.Since.Local().Format(time.Stamp)))
+		liveInstances.Store(k.ID, true)
+		runCost.start(k.ID, time.Now())
+		return k.Host, k.ID, true
+	}
+}
+
+// releaseInstance terminates the instance of t at the end of its run, or records it in the state
+// file with -keep, unless the run was aborted.
+func releaseInstance(t target, host, id string) {
+	if !*keepFlag || interrupted.Load() {
+		terminateInstance(id)
+		return
+	}
+	if _, ok := liveInstances.LoadAndDelete(id); !ok {
+		// terminated meanwhile
+		return
+	}
+	runCost.stop(id, time.Now())
+	err := updateKept(func(kept []keptInstance) []keptInstance {
+		return append(kept, keptInstance{ID: id, Host: host, Launch: launchKey(t), Since: time.Now()})
+	})
+	if err != nil {
+		slog.Error(fmt.Sprintf("%sunable to record the kept instance, terminating it: %v", t.prefix(), err))
+		liveInstances.Store(id, true)
+		terminateInstance(id)
+		return
+	}
+	if *providerFlag == "gcp" {
+		slog.Info(fmt.Sprintf("%sinstance %s kept running for the next runs; gcloud compute instances delete %s --zone %s to delete it", t.prefix(), id, id, *gcpZone))
+		return
+	}
+	slog.Info(fmt.Sprintf("%sinstance %s kept running for the next runs; rbench kill %s to terminate it", t.prefix(), id, id))
+}
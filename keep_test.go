@@ -0,0 +1,38 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestClaimKept(t *testing.T) {
+	t.Setenv("RBENCH_CONFIG", filepath.Join(t.TempDir(), "config"))
+	ubuntu, debian := target{ami: "ami-ubuntu"}, target{ami: "ami-debian"}
+
+	if _, found, err := claimKept(ubuntu); err != nil || found {
+		t.Fatalf("got %v, %v without state file", found, err)
+	}
+	err := updateKept(func(kept []keptInstance) []keptInstance {
+		return append(kept,
+			keptInstance{ID: "i-1", Host: "10.0.0.1", Launch: launchKey(ubuntu)},
+			keptInstance{ID: "i-2", Host: "10.0.0.2", Launch: launchKey(debian)},
+			keptInstance{ID: "i-3", Host: "10.0.0.3", Launch: launchKey(ubuntu)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each instance is claimed once, in order
+	for _, want := range []string{"i-1", "i-3"} {
+		k, found, err := claimKept(ubuntu)
+		if err != nil || !found || k.ID != want {
+			t.Fatalf("claimed %+v, %v, %v, expected %s", k, found, err, want)
+		}
+	}
+	if _, found, _ := claimKept(ubuntu); found {
+		t.Error("claimed an instance twice")
+	}
+	if k, found, _ := claimKept(debian); !found || k.Host != "10.0.0.2" {
+		t.Errorf("claimed %+v, %v, expected i-2", k, found)
+	}
+}
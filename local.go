@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// localRun is the run of the benchmark on the local machine (-with-local), to compare with the remote results.
+type localRun struct {
+	done    chan struct{}
+	results *benchResults
+	err     error
+}
+
+// startLocalRun runs the benchmark locally with the same flags once the binaries are built,
+// so that the local compilation doesn't compete with the cross compilation.
+func startLocalRun(bins *binaries) *localRun {
+	l := &localRun{done: make(chan struct{}), results: newBenchResults()}
+	go func() {
+		defer close(l.done)
+		<-bins.done
+		if bins.err != nil {
+			// nothing to compare to
+			return
+		}
+
+		args := []string{"test",
+			"-run", *run,
+			"-bench", *benchFlag,
+			fmt.Sprintf("-count=%d", *countFlag),
+			fmt.Sprintf("-benchmem=%t", *benchMem),
+		}
+		if *cpuFlag > 0 {
+			args = append(args, fmt.Sprintf("-cpu=%d", *cpuFlag))
+		}
+		if *benchTime != "" {
+			args = append(args, "-benchtime", *benchTime)
+		}
+		if *tagsFlag != "" {
+			args = append(args, "-tags", *tagsFlag)
+		}
+		if *gcflagsFlag != "" {
+			args = append(args, "-gcflags="+*gcflagsFlag)
+		}
+		if *ldflagsFlag != "" {
+			args = append(args, "-ldflags="+*ldflagsFlag)
+		}
+		if *timeoutFlag > 0 {
+			args = append(args, "-timeout="+timeoutFlag.String())
+		}
+		if *shortFlag {
+			args = append(args, "-short")
+		}
+		args = append(args, benchPackage)
+		if len(testBinaryArgs) > 0 {
+			args = append(append(args, "-args"), testBinaryArgs...)
+		}
+		cmd := exec.Command("go", args...)
+		cmd.Dir = buildDir
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", seedEnv, *seedFlag))
+		var stderr strings.Builder
+		cmd.Stdout = l.results
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			l.err = fmt.Errorf("local benchmark failed: %s, %v", strings.TrimSpace(stderr.String()), err)
+		}
+	}()
+	return l
+}
+
+var gomaxprocsSuffix = regexp.MustCompile(`-\d+$`)
+
+// printLocalComparison prints the medians of the local and remote runs side by side, one table per
+// unit; benchmarks are matched regardless of their GOMAXPROCS suffix, which differs between machines.
+func printLocalComparison(w io.Writer, local, remote *benchResults) {
+	localNames := make(map[string]string)
+	for _, name := range local.names {
+		localNames[gomaxprocsSuffix.ReplaceAllString(name, "")] = name
+	}
+
+	for _, unit := range remote.units() {
+		fmt.Fprintf(w, "\nlocal vs remote (median %s):\n", unit)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "\tlocal\tremote\tremote/local\n")
+		var localMedians, remoteMedians []float64
+		for _, name := range remote.names {
+			values := remote.values(name, unit)
+			if len(values) == 0 {
+				continue
+			}
+			r := median(values)
+			localName, ok := localNames[gomaxprocsSuffix.ReplaceAllString(name, "")]
+			if !ok {
+				fmt.Fprintf(tw, "%s\t-\t%.4g\t-\n", name, r)
+				continue
+			}
+			l := median(local.values(localName, unit))
+			fmt.Fprintf(tw, "%s\t%.4g\t%.4g\t%s\n", name, l, r, ratio(l, r))
+			localMedians = append(localMedians, l)
+			remoteMedians = append(remoteMedians, r)
+		}
+		if len(localMedians) > 1 {
+			l, r := geomean(localMedians), geomean(remoteMedians)
+			fmt.Fprintf(tw, "geomean\t%.4g\t%.4g\t%s\n", l, r, ratio(l, r))
+		}
+		tw.Flush()
+	}
+}
+
+func ratio(before, after float64) string {
+	if before == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("x%.2f", after/before)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLocalComparison(t *testing.T) {
+	local, remote := newBenchResults(), newBenchResults()
+	fmt.Fprintf(local, "BenchmarkA-8 100 10 ns/op\nBenchmarkC-8 100 40 ns/op\n")
+	fmt.Fprintf(remote, "BenchmarkA-2 100 15 ns/op\nBenchmarkB-2 100 5 ns/op\nBenchmarkC-2 100 40 ns/op\n")
+
+	var out strings.Builder
+	printLocalComparison(&out, local, remote)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	if f := strings.Fields(lines[2]); strings.Join(f, " ") != "BenchmarkA-2 10 15 x1.50" {
+		t.Errorf("unexpected line %q", lines[2])
+	}
+	if f := strings.Fields(lines[3]); strings.Join(f, " ") != "BenchmarkB-2 - 5 -" {
+		t.Errorf("unexpected line %q", lines[3])
+	}
+	if f := strings.Fields(lines[5]); strings.Join(f, " ") != "geomean 20 24.49 x1.22" {
+		t.Errorf("unexpected geomean line %q", lines[5])
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rbench logs through log/slog: human readable output on stderr, filtered by -v, and optionally
+// JSON logs of everything in -log-file. stdout is reserved for the benchmark results.
+
+// levelTrace is the level of the most verbose logs (-v=2).
+const levelTrace = slog.LevelDebug - 4
+
+// verbosity implements the -v flag; -v alone means -v=1.
+type verbosity int
+
+var (
+	verbose verbosity
+	logFile = flag.String("log-file", "", "write JSON logs (all levels) to this file")
+)
+
+func init() {
+	flag.Var(&verbose, "v", "verbosity: -v for debug output (retries, transfer rates), -v=2 for traces")
+}
+
+func (v *verbosity) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *verbosity) Set(s string) error {
+	switch s {
+	case "true":
+		*v = 1
+	case "false":
+		*v = 0
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid verbosity %q", s)
+		}
+		*v = verbosity(n)
+	}
+	return nil
+}
+
+func (v *verbosity) IsBoolFlag() bool {
+	return true
+}
+
+func (v verbosity) level() slog.Level {
+	switch {
+	case v >= 2:
+		return levelTrace
+	case v == 1:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// stderrTerminal is the output of the default logger, shared with the results stream
+// to clear status lines.
+var stderrTerminal = &terminal{w: os.Stderr}
+
+// setupTerminalLogging installs the default logger of the subcommands, and of a run until its
+// flags are parsed: to the terminal only.
+func setupTerminalLogging() {
+	slog.SetDefault(slog.New(&humanHandler{out: stderrTerminal, level: verbose.level()}))
+}
+
+// setupLogging installs the default logger according to the flags.
+func setupLogging() error {
+	handlers := multiHandler{&humanHandler{out: stderrTerminal, level: verbose.level()}}
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("unable to open log file, %v", err)
+		}
+		handlers = append(handlers, slog.NewJSONHandler(f, &slog.HandlerOptions{Level: levelTrace}))
+	}
+	slog.SetDefault(slog.New(handlers))
+	return nil
+}
+
+// statusKey marks transient status lines: on the terminal, they are overwritten by the next line.
+const statusKey = "status"
+
+// statusf logs a transient status line.
+func statusf(format string, args ...any) {
+	slog.Info(fmt.Sprintf(format, args...), statusKey, true)
+}
+
+// humanHandler writes "message key=value ..." lines, prefixed by the level for warnings and errors.
+// groups are not supported.
+type humanHandler struct {
+	out   *terminal
+	level slog.Level
+	attrs []slog.Attr
+}
+
+// terminal is the output shared by a humanHandler and its derived handlers.
+type terminal struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending bool   // a status line is displayed
+	status  string // the last status line, without the cost of the run
+}
+
+func (h *humanHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *humanHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	transient := false
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("warning: ")
+	}
+	b.WriteString(r.Message)
+	writeAttr := func(a slog.Attr) bool {
+		if a.Key == statusKey {
+			transient = a.Value.Bool()
+			return true
+		}
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	line := b.String()
+	if transient {
+		h.out.status = line
+		line = "\r" + line + runCost.suffix() + clearStr
+	} else {
+		if h.out.pending {
+			// clear the status line first.
+			line = "\r" + clearStr + "\r" + line
+		}
+		line += "\n"
+	}
+	h.out.pending = transient
+	_, err := io.WriteString(h.out.w, line)
+	return err
+}
+
+func (h *humanHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &h2
+}
+
+func (h *humanHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// clearStatus clears the status line, if any, before other output is written to the terminal.
+func (t *terminal) clearStatus() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending {
+		io.WriteString(t.w, "\r"+clearStr+"\r")
+		t.pending = false
+	}
+}
+
+// refreshStatus redraws the status line, if any, with the current cost of the run.
+func (t *terminal) refreshStatus() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending {
+		io.WriteString(t.w, "\r"+t.status+runCost.suffix()+clearStr)
+	}
+}
+
+// statusClearingWriter clears the status line before writing to w.
+type statusClearingWriter struct {
+	w io.Writer
+}
+
+func (s statusClearingWriter) Write(p []byte) (int, error) {
+	stderrTerminal.clearStatus()
+	return s.w.Write(p)
+}
+
+// multiHandler sends records to all its handlers.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			if e := h.Handle(ctx, r.Clone()); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	m2 := make(multiHandler, len(m))
+	for i, h := range m {
+		m2[i] = h.WithAttrs(attrs)
+	}
+	return m2
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	m2 := make(multiHandler, len(m))
+	for i, h := range m {
+		m2[i] = h.WithGroup(name)
+	}
+	return m2
+}
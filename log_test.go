@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHumanHandler(t *testing.T) {
+	var b strings.Builder
+	logger := slog.New(&humanHandler{out: &terminal{w: &b}, level: slog.LevelInfo})
+
+	logger.Info("plain")
+	logger.Debug("filtered")
+	logger.Info("uploading...", statusKey, true)
+	logger.Error("failed", "attempt", 2)
+
+	want := "plain\n" +
+		"\ruploading..." + clearStr +
+		"\r" + clearStr + "\rerror: failed attempt=2\n"
+	if b.String() != want {
+		t.Fatalf("unexpected output:\n%q\nwant:\n%q", b.String(), want)
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -26,15 +29,135 @@ import (
 // define the flags
 var (
 	// same as go test ...
-	benchFlag = flag.String("bench", ".", "run only those benchmarks matching a regular expression")
-	countFlag = flag.Int("count", 5, "run each benchmark n times")
-	cpuFlag   = flag.Int("cpu", 0, "number of parallel CPUs to use")
-	benchMem  = flag.Bool("benchmem", false, "print memory allocation statistics")
-	run       = flag.String("run", "NONE", "run only those tests and examples matching the regular expression")
-	tagsFlag  = flag.String("tags", "", "a space-separated list of build tags")
+	benchFlag    = flag.String("bench", ".", "run only those benchmarks matching a regular expression")
+	countFlag    = flag.Int("count", 5, "run each benchmark n times")
+	benchTime    = flag.String("benchtime", "", "run enough iterations of each benchmark to take t, or exactly n iterations with Nx (e.g. 1000x)")
+	budgetTime   = flag.Duration("budget-time", 0, "run as many repetitions (up to -count) as fit in this duration, benchmarks by order of the -bench alternatives")
+	cpuFlag      = flag.Int("cpu", 0, "GOMAXPROCS of the benchmark (default: 1, the physical cores and the vCPUs of the machine)")
+	benchMem     = flag.Bool("benchmem", false, "print memory allocation statistics")
+	run          = flag.String("run", "NONE", "run only those tests and examples matching the regular expression")
+	timeTests    = flag.Bool("time-tests", false, "time the tests and examples matching -run, each in its own process, and report their wall-clock durations as BenchmarkRun/<name> results")
+	tagsFlag     = flag.String("tags", "", "a space-separated list of build tags")
+	timeoutFlag  = flag.Duration("timeout", 0, "panic the test binary if it runs longer than this (-test.timeout; 0: no timeout)")
+	shortFlag    = flag.Bool("short", false, "tell long-running tests and benchmarks to shorten their run time (-test.short)")
+	gcflagsFlag  = flag.String("gcflags", "", "arguments to pass on each go tool compile invocation of the build")
+	ldflagsFlag  = flag.String("ldflags", "", "arguments to pass on each go tool link invocation of the build")
+	wasmFlag     = flag.String("wasm", "", "build for wasip1/wasm and run the benchmark under this runtime on the instance: wasmtime or wazero")
+	staticFlag   = flag.Bool("static", false, "build a statically linked binary (CGO_ENABLED=0), checked for dynamic dependencies before the upload")
+	seedFlag     = flag.Int64("seed", 0, "random seed passed to the benchmark in $RBENCH_SEED and recorded in the output (default: a random seed)")
+	gogcFlag     = flag.String("gogc", "", "comma-separated GOGC values (e.g. off,100,400) to sweep on the same instance, in alternating time slices of one repetition")
+	shardsFlag   = flag.Int("shards", 0, "split the benchmarks matching -bench across this many instances, by a stable hash of their names")
+	shardFlag    = flag.Int("shard", 0, "with -shards, only run this shard (1-based), e.g. to re-run it in isolation")
+	shuffleFlag  = flag.String("shuffle", "off", "randomize the order of the tests and benchmarks of each run (-test.shuffle): off, on (random seed, recorded) or a seed")
+	compareFlag  = flag.String("compare", "", "compare two git refs, A..B (A.. for the working tree): both test binaries run on the same instance in alternating rounds, followed by a delta table")
+	slicesFlag   = flag.String("slices", "", "comma-separated jobs, [package][@git ref], run concurrently on one instance, each in a cgroup with an exclusive share of the cores")
+	warmupPasses = flag.Int("warmup", 0, "number of unrecorded passes of the selected benchmarks before the measured runs")
+	warmupCmd    = flag.String("warmup-cmd", "", "shell command to run on the instance (in the working directory of the benchmark) instead of the -warmup passes")
+	coverProfile = flag.String("coverprofile", "", "write a coverage profile of the remote runs to this file, merged across instances")
+	coverMode    = flag.String("covermode", "", "coverage mode: set, count or atomic (default set)")
+	cpuProfile   = flag.String("cpuprofile", "", "write a CPU profile of the remote runs to this file, merged across instances (see rbench pprof-diff)")
+
+	// -compare runs more rounds of the benchmarks with a large delta that isn't significant
+	compareThreshold = flag.Float64("compare-threshold", 2, "with -compare, run more rounds of the benchmarks whose delta exceeds this percentage but isn't significant")
+	compareMaxCount  = flag.Int("compare-max-count", 0, "with -compare, maximum number of rounds of an inconclusive benchmark (0: 3 × -count; -count disables the extra rounds)")
+
+	// pre-flight checks
+	vetFlag        = flag.Bool("vet", false, "run go vet and benchmark checks (b.N use, timed setup) on the package before launching")
+	vetTool        = flag.String("vettool", "", "analysis tool for go vet -vettool (e.g. a staticcheck-like multichecker)")
+	pretestFlag    = flag.Bool("pretest", false, "run the tests of the package (-short, or the -run ones) and each benchmark once locally before launching")
+	pretestTimeout = flag.Duration("pretest-timeout", 2*time.Minute, "timeout of the -pretest run")
+
+	// working tree
+	requireClean = flag.Bool("require-clean", os.Getenv("CI") != "", "abort if the working tree is dirty (default true when $CI is set)")
+	changedFlag  = flag.String("changed", "", "only run if the package or its dependencies changed since this git ref (e.g. origin/main)")
+	stashRun     = flag.Bool("stash-run", false, "if the working tree is dirty, benchmark HEAD from a clean temporary worktree")
+	uploadFlag   = flag.String("upload", "", "comma-separated files and directories, relative to the package, to ship with the binary in addition to its testdata directory")
+
+	// aws account
+	awsProfile    = flag.String("profile", "", "AWS shared config profile to use")
+	regionFlag    = flag.String("region", "", "AWS region (default: the region of the config file, or us-east-2)")
+	roleARN       = flag.String("role-arn", "", "IAM role to assume, e.g. to run in another account")
+	awsRetries    = flag.Int("aws-retries", 10, "maximum number of attempts of throttled or failed AWS API requests")
+	debugAWS      = flag.Bool("debug-aws", false, "log every AWS API call to the debug log (-v, -log-file), identical calls once a minute")
+	benchPolicy   = flag.String("bench-policy", "", "IAM policy (JSON file) of the AWS resources the benchmark uses: it gets short-lived credentials of -bench-role limited to them, as AWS_* environment variables")
+	benchRole     = flag.String("bench-role", "", "IAM role the -bench-policy credentials are derived from")
+	benchCredsTTL = flag.Duration("bench-creds-ttl", time.Hour, "lifetime of the -bench-policy credentials, from 15m to the maximum session duration of -bench-role")
 
 	// instance type
-	instanceType = flag.String("type", "t2.micro", "ec2 instance type")
+	providerFlag  = flag.String("provider", "aws", "cloud the instances are launched in: aws (EC2) or gcp (Compute Engine, with the gcloud CLI)")
+	gcpProject    = flag.String("gcp-project", "", "with -provider=gcp, project to launch the instances in (default: the project of the gcloud configuration)")
+	gcpZone       = flag.String("gcp-zone", "us-central1-a", "with -provider=gcp, zone to launch the instances in")
+	instanceType  = flag.String("type", "t2.micro", "ec2 instance type, or Compute Engine machine type with -provider=gcp (default e2-micro); a comma-separated list runs on each type concurrently and compares them")
+	targetFlag    = flag.String("target", "", "run on a persistent host registered in the hosts section of the config file instead of an ec2 instance")
+	osFlag        = flag.String("os", "", "comma-separated list of OS images to run the benchmark on, one instance each (ubuntu, amazonlinux, debian, alpine)")
+	subnetFlag    = flag.String("subnet", "", "subnet to launch the instances in (default: the default subnet)")
+	ipv6Only      = flag.Bool("ipv6", false, "launch the instances without public IPv4 address and connect over IPv6 (requires an IPv6 -subnet)")
+	efaFlag       = flag.Bool("efa", false, "attach an Elastic Fabric Adapter (requires -subnet); the EFA software is installed if needed and the fabric checked before the run")
+	enaExpress    = flag.Bool("ena-express", false, "enable ENA Express (SRD) on the network interface (requires -subnet)")
+	nodesFlag     = flag.Int("nodes", 1, "number of instances of a multi-node run (requires -subnet): launched in a cluster placement group, the benchmark runs on all of them at once, with the private addresses of the nodes in $RBENCH_NODES and the index of each in $RBENCH_NODE; the first node is recorded")
+	keepFlag      = flag.Bool("keep", false, "leave the instances running after the run, to be reused by the next runs with the same instance type, image and launch options")
+	maxInstances  = flag.Int("max-instances", 0, "maximum number of rbench instances running simultaneously in the account; launches are queued above it (0: unlimited)")
+	confidential  = flag.String("confidential", "", "run in a confidential computing environment: sev-snp (AMD SEV-SNP instance, e.g. m6a) or enclave (Nitro Enclave of the instance, requires -os=amazonlinux)")
+	enclaveCPUs   = flag.Int("enclave-cpus", 2, "with -confidential=enclave, vCPUs of the enclave, taken from the instance")
+	enclaveMemory = flag.Int("enclave-memory", 2048, "with -confidential=enclave, memory of the enclave in MiB, taken from the instance")
+
+	// ssh
+	sshUserFlag = flag.String("ssh-user", "", "ssh user on the instance (default: derived from the AMI; ubuntu, ec2-user, admin, ...)")
+	sshPort     = flag.Int("ssh-port", 22, "ssh port on the instance")
+	bwLimit     = flag.Int("bwlimit", 0, "limit upload bandwidth, in Kbit/s (0: unlimited)")
+	compress    = flag.Bool("compress", false, "enable ssh transport compression")
+	eiceFlag    = flag.Bool("eice", false, "connect through the EC2 Instance Connect Endpoint of the VPC: no public address nor key pair, an ephemeral key is pushed at each connection (requires the AWS CLI)")
+
+	sshRetries        = flag.Int("ssh-retries", 5, "number of attempts of ssh/scp operations failing on network errors")
+	sshBackoff        = flag.Duration("ssh-backoff", time.Second, "initial delay between ssh/scp attempts, doubled (with jitter) at each attempt")
+	sshDeadline       = flag.Duration("ssh-deadline", 3*time.Minute, "overall deadline for retrying an ssh/scp operation")
+	readyFlag         = flag.String("ready", "port", "readiness condition before the upload: port, cloud-init, file:<path> or cmd:<command>")
+	readyTimeout      = flag.Duration("ready-timeout", 10*time.Minute, "maximum wait for the -ready condition")
+	sshConnectTimeout = flag.Duration("ssh-connect-timeout", 15*time.Second, "ssh connection timeout")
+
+	agentFlag = flag.Bool("agent", false, "run the benchmarks through a gRPC agent started on the instance (rbench agent): they keep running when the connection drops, and their output resumes where it left off")
+	agentIdle = flag.Duration("agent-idle", time.Hour, "with -agent, cloud instances power themselves off (terminated on EC2) after this long without rbench connected nor a benchmark running, e.g. if rbench dies (0: never)")
+
+	// instance tuning
+	tuneFlag       = flag.String("tune", "", "comma-separated list of kernel tuning presets applied before the run (network, lowlatency)")
+	requireMetal   = flag.Bool("require-metal", false, "only run on bare metal (.metal) instance types")
+	clockSource    = flag.String("clocksource", "", "kernel clocksource to select before the run (e.g. tsc); fails if unavailable")
+	mitigationsOff = flag.Bool("mitigations-off", false, "reboot the instance with CPU vulnerability mitigations disabled (mitigations=off)")
+	aslr           = flag.Bool("aslr", true, "address space layout randomization of the benchmark process; -aslr=false runs it under setarch -R")
+	thpFlag        = flag.String("thp", "", "transparent huge pages mode to set before the run: never, madvise or always (default: unchanged)")
+	tmpfsFlag      = flag.String("tmpfs", "", "run the benchmark from a tmpfs working directory of this size (e.g. 2g), keeping the files it writes in memory")
+	coreClass      = flag.String("core-class", "", "on machines with performance and efficiency cores, pin the benchmark to one class: p or e")
+	stableFlag     = flag.Bool("stable", false, "low-noise mode: set the performance cpufreq governor and disable turbo boost before the run (the stable -tune preset)")
+	smtOff         = flag.Bool("smt-off", false, "take the SMT siblings offline before the run, one hardware thread per core")
+	numaBalancing  = flag.String("numa-balancing", "", "automatic NUMA balancing to set before the run: on or off (default: unchanged)")
+
+	// debugging
+	debugFlag     = flag.String("debug", "", "run the benchmarks matching a regular expression (e.g. ^BenchmarkX$) under a headless delve on the instance, forwarded to localhost")
+	debugPort     = flag.Int("debug-port", 2345, "local and remote port of the delve server (-debug)")
+	watchdog      = flag.Duration("watchdog", 0, "detect benchmarks producing no output for this long: interactively, ask whether to dump their goroutines (SIGQUIT) to rbench-hang-<host>-<time>.txt and stop them, otherwise warn and keep waiting (0: disabled)")
+	watchdogAbort = flag.Bool("watchdog-abort", false, "dump and stop a -watchdog hang right away, even if the connection is lost, and stop the run of the target instead of continuing with its remaining -gogc slices or -budget-time runs")
+
+	// results
+	jsonFlag       = flag.Bool("json", false, "output go test -json events (converted locally with go tool test2json)")
+	labelFlag      = flag.String("label", "", "experiment label, recorded as a \"label\" config line (e.g. for benchstat -col label)")
+	perfBudgetFlag = flag.String("perf-budget", "", "check the results against this performance budget file and fail the run if exceeded (default in CI: "+perfBudgetFile+" at the root of the repository, if any)")
+	calibrateFlag  = flag.Bool("calibrate", false, "run the calibration suite (see rbench calibrate) before the benchmarks, and flag the instance if slower than the reference of its type")
+	runtimeMetrics = flag.Bool("runtime-metrics", false, "append the gc cycles, the p99 scheduling latency and the mutex wait of each benchmark function (runtime/metrics) to its results")
+	gcStats        = flag.Bool("gcstats", false, "trace the garbage collector (GODEBUG=gctrace=1) and summarize gc counts, pauses and heap goals per benchmark")
+	coreDumps      = flag.Bool("core", false, "enable core dumps (GOTRACEBACK=crash) and download them with the binary if the benchmark crashes")
+	withLocal      = flag.Bool("with-local", false, "also run the benchmark on the local machine and compare the medians")
+	sourceLinks    = flag.String("source-links", "", "turn the file:line references of failures into terminal hyperlinks, from a URL template with {path} and {line} (e.g. vscode://file{path}:{line})")
+	ciFlag         = flag.String("ci", "auto", "publish a summary and the artifacts of the run to the CI system: auto (detected from the environment), github, gitlab, buildkite or off")
+	bundleFile     = flag.String("bundle", "", "also write the artifacts of the run (output, coverage, CPU profile, provenance, logs) to a .rbench bundle")
+	provenanceFile = flag.String("provenance", "", "write a signed record of the run (commit, binary hash, instance identity, outputs) to this file")
+	signKey        = flag.String("sign-key", "", "key signing -provenance: an ed25519 private key file (PKCS #8 PEM) or kms:<key id>")
+	s3URL          = flag.String("s3", "", "also publish the bundle of the run to this S3 location (s3://bucket/prefix), indexed per repository and branch (see rbench runs)")
+	s3Retention    = flag.Int("s3-retention", 90, "expire the runs published with -s3 after this many days (lifecycle rule of the prefix); 0 keeps them")
+	auditLogGroup  = flag.String("audit-log-group", "", "also send the audit log of the commands run on the instances to this CloudWatch Logs group")
+	noiseThreshold = flag.Float64("noise", 5, "flag benchmarks whose run-to-run spread exceeds this percentage")
+	historyFlag    = flag.Bool("history", true, "record the results in the local results history (see rbench history)")
+	maxSteal       = flag.Float64("max-steal", 5, "warn if the hypervisor steals more than this percentage of the CPU time during the run (0: never)")
+	stealRetries   = flag.Int("steal-retries", 0, "rerun on a fresh instance, up to this many times, when the steal time exceeds -max-steal")
 )
 
 const clearStr = "                                                                                                            "
@@ -47,130 +170,530 @@ func main() {
 	// then we stream the output to the local machine
 	// then we terminate the instance
 
-	// parse the flags
-	flag.Parse()
+	setupTerminalLogging()
+	if len(os.Args) > 1 {
+		if os.Args[1] == "run" {
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		} else if cmd, ok := subcommands[os.Args[1]]; ok {
+			err := cmd.run(os.Args[2:])
+			apiCalls.flush()
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
 
-	commitID, err := gitCommitID()
-	if err != nil {
-		fmt.Printf("error: %v\n", err)
+	// parse the flags
+	if err := parseArgs(); err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	if err := setupLogging(); err != nil {
+		slog.Error(err.Error())
 		return
 	}
 
-	// init aws sdk objects
-	err = initAWS()
+	if *changedFlag != "" {
+		changed, err := packageChanged(benchPackage, *changedFlag)
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		if !changed {
+			slog.Info(fmt.Sprintf("no change affecting %s since %s, nothing to benchmark", benchPackage, *changedFlag))
+			return
+		}
+	}
+
+	// check that there is something to run before paying for an instance
+	benchmarks, err := listBenchmarks(benchPackage, *benchFlag)
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
+		slog.Error(err.Error())
+		return
+	}
+	if len(benchmarks) == 0 && *benchFlag != "NONE" {
+		slog.Error(fmt.Sprintf("no benchmark in %s matches -bench=%s", benchPackage, *benchFlag))
 		return
 	}
 
-	// get instance architecture
-	fmt.Printf("\rgetting instance architecture..." + clearStr)
-	arch, err := getInstanceArch()
+	if *vetFlag {
+		statusf("vetting %s...", benchPackage)
+		if err := preflight(benchmarks); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+	}
+	if *pretestFlag {
+		statusf("testing %s locally...", benchPackage)
+		if err := pretest(); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+	}
+
+	commitID, err := gitCommitID()
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
+		slog.Error(err.Error())
 		return
 	}
-
-	fmt.Printf("\rcompiling benchmark binary arch=%s..."+clearStr, arch.GoString())
-	benchFileName, err := compileBenchmarkBinary(arch)
+	var worktree string
+	if strings.HasSuffix(commitID, "-dirty") {
+		switch {
+		case *stashRun:
+			// benchmark the committed state only
+			worktree, err = addCleanWorktree()
+			if err != nil {
+				slog.Error(err.Error())
+				return
+			}
+			defer func() {
+				if worktree != "" {
+					removeWorktree(worktree)
+				}
+			}()
+			commitID = strings.TrimSuffix(commitID, "-dirty")
+		case *requireClean:
+			slog.Error("working tree is dirty; commit your changes or use -stash-run to benchmark HEAD")
+			return
+		default:
+			slog.Warn("working tree is dirty, results won't be reproducible")
+		}
+	}
+	if *wasmFlag != "" {
+		if _, ok := wasmRuntimes[*wasmFlag]; !ok {
+			slog.Error(fmt.Sprintf("-wasm: unknown runtime %q, expected wasmtime or wazero", *wasmFlag))
+			return
+		}
+	}
+	if *coreClass != "" && *coreClass != "p" && *coreClass != "e" {
+		slog.Error(fmt.Sprintf("-core-class: unknown class %q, expected p or e", *coreClass))
+		return
+	}
+	if *tmpfsFlag != "" && !tmpfsSizeRegexp.MatchString(*tmpfsFlag) {
+		slog.Error(fmt.Sprintf("-tmpfs: invalid size %q, expected e.g. 512m or 2g", *tmpfsFlag))
+		return
+	}
+	if *watchdog != 0 && *watchdog < time.Second {
+		slog.Error("-watchdog must be at least 1s")
+		return
+	}
+	if *slicesFlag != "" {
+		if _, err := parseSlices(*slicesFlag); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+	}
+	if *timeTests && (*run == "NONE" || *run == "") {
+		slog.Error("-time-tests requires a -run pattern selecting the tests and examples to time")
+		return
+	}
+	if *compareFlag == "" && len(conflictsWith("-compare")) == 0 {
+		if r := mergeRequestCompare(); r != "" {
+			slog.Info("merge request pipeline, comparing with its base: -compare=" + r)
+			*compareFlag = r
+		}
+	}
+	if *compareFlag != "" {
+		if _, err := parseCompare(*compareFlag); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		if *compareMaxCount < 0 || *compareThreshold < 0 {
+			slog.Error("-compare-max-count and -compare-threshold must be positive")
+			return
+		}
+	}
+	if *shuffleFlag != "off" && *shuffleFlag != "on" {
+		if _, err := strconv.ParseInt(*shuffleFlag, 10, 64); err != nil {
+			slog.Error(fmt.Sprintf("-shuffle: invalid value %q, expected off, on or a seed", *shuffleFlag))
+			return
+		}
+	}
+	if *shardsFlag < 0 || *shardFlag < 0 || *shardFlag > *shardsFlag {
+		slog.Error("-shard must be between 1 and -shards")
+		return
+	}
+	for _, v := range splitList(*gogcFlag) {
+		if _, err := strconv.Atoi(v); err != nil && v != "off" {
+			slog.Error(fmt.Sprintf("-gogc: invalid value %q, expected a percentage or off", v))
+			return
+		}
+	}
+	if _, err := readyScript(); err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	switch *ciFlag {
+	case "auto", "github", "gitlab", "buildkite", "off":
+	default:
+		slog.Error(fmt.Sprintf("-ci: unknown CI system %q", *ciFlag))
+		return
+	}
+	if *bundleFile != "" && !strings.HasSuffix(*bundleFile, bundleExt) {
+		slog.Error("-bundle: the file name must end with " + bundleExt)
+		return
+	}
+	if *s3URL != "" {
+		if _, _, err := parseS3URL(*s3URL); err != nil {
+			slog.Error("-s3: " + err.Error())
+			return
+		}
+	}
+	if *s3Retention < 0 {
+		slog.Error("-s3-retention can't be negative")
+		return
+	}
+	if *provenanceFile != "" && *signKey == "" {
+		slog.Error("-provenance requires a -sign-key")
+		return
+	}
+	switch *providerFlag {
+	case "aws":
+	case "gcp":
+		typeSet := false
+		flag.Visit(func(f *flag.Flag) { typeSet = typeSet || f.Name == "type" })
+		if !typeSet {
+			*instanceType = "e2-micro"
+		}
+	default:
+		slog.Error(fmt.Sprintf("-provider: unknown provider %q, expected aws or gcp", *providerFlag))
+		return
+	}
+	if *ipv6Only && *subnetFlag == "" {
+		slog.Error("-ipv6 requires an IPv6 -subnet")
+		return
+	}
+	if (*efaFlag || *enaExpress) && *subnetFlag == "" {
+		slog.Error("-efa and -ena-express require a -subnet")
+		return
+	}
+	if *nodesFlag < 1 {
+		slog.Error("-nodes must be at least 1")
+		return
+	}
+	if *nodesFlag > 1 && *subnetFlag == "" {
+		// the cluster placement group is in the availability zone of the subnet
+		slog.Error("-nodes requires a -subnet")
+		return
+	}
+	switch *confidential {
+	case "", "sev-snp":
+	case "enclave":
+		if *osFlag != "amazonlinux" {
+			slog.Error("-confidential=enclave requires -os=amazonlinux (Nitro Enclaves CLI)")
+			return
+		}
+	default:
+		slog.Error(fmt.Sprintf("-confidential: unknown environment %q, expected sev-snp or enclave", *confidential))
+		return
+	}
+	if *benchPolicy != "" || *benchRole != "" {
+		if *benchPolicy == "" || *benchRole == "" {
+			slog.Error("-bench-policy and -bench-role go together")
+			return
+		}
+		if *benchCredsTTL < 15*time.Minute {
+			slog.Error("-bench-creds-ttl must be at least 15m")
+			return
+		}
+		if benchPolicyDoc, err = loadBenchPolicy(*benchPolicy); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+	}
+	if err := checkFlagConflicts(); err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	tune, err := parseTunePresets(*tuneFlag)
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
+		slog.Error(err.Error())
 		return
 	}
-
-	// create a new ec2 instance
-	fmt.Printf("\rstarting %s instance..."+clearStr, *instanceType)
-	publicIP, instanceID, err := startInstance(arch)
+	if *stableFlag && !slices.Contains(tune, "stable") {
+		tune = append(tune, "stable")
+	}
+	seedGenerated := *seedFlag == 0
+	if seedGenerated {
+		*seedFlag = rand.Int63n(1<<53) + 1
+	}
+	if *shuffleFlag == "on" {
+		// recorded, so that the order can be reproduced
+		*shuffleFlag = strconv.FormatInt(rand.Int63n(1<<53)+1, 10)
+	}
+	if instanceTypes := splitList(*instanceType); len(instanceTypes) > 1 {
+		// each type runs in its own rbench, with its own outputs
+		if err := runTypeMatrix(instanceTypes); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	toggles, err := parseToggles()
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
+		slog.Error(err.Error())
 		return
 	}
 
-	// Create a channel to listen for incoming signals
-	sigChan := make(chan os.Signal, 1)
-	// Notify the channel for interrupt (Ctrl+C) and termination signals
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
-
-	go func() {
-		// print status
-		fmt.Printf("\rssh ready (%s). uploading benchmark binary..."+clearStr, publicIP)
-
-		// upload the binary
-		err = scp(benchFileName, publicIP)
+	var (
+		arch    instanceArch
+		targets []target
+	)
+	if *targetFlag != "" {
+		// persistent host, nothing to provision
+		if *mitigationsOff {
+			slog.Error("-mitigations-off is not supported on registered hosts")
+			return
+		}
+		statusf("connecting to %s...", *targetFlag)
+		// the host is shared: the remote files of the run are in a directory of their own
+		remoteRunID = randString(7)
+		t, hostArch, err := hostTarget(*targetFlag)
 		if err != nil {
-			fmt.Printf("error: %v\n", err)
-			close(sigChan)
+			slog.Error(err.Error())
+			return
+		}
+		arch, targets = hostArch, []target{t}
+	} else if *providerFlag == "gcp" {
+		statusf("getting machine type architecture...")
+		arch, err = initGCP()
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		targets = []target{gcpTarget(arch)}
+	} else {
+		// init aws sdk objects
+		err = initAWS()
+		if err != nil {
+			slog.Error(err.Error())
 			return
 		}
 
-		fmt.Printf("\rrunning benchmark..." + clearStr + "\n")
-		// write header
-		fmt.Printf("ec2-user: %s\n", awsUserName)
-		fmt.Printf("instance IP: %s\n", publicIP)
-		fmt.Printf("instance type: %s\n", *instanceType)
-		fmt.Printf("commit ID: %s\n", commitID)
+		if err := enforcePolicy(); err != nil {
+			slog.Error(err.Error())
+			return
+		}
 
-		// execute the benchmark
-		err = sshExec(publicIP)
+		// get instance architecture
+		statusf("getting instance architecture...")
+		arch, err = getInstanceArch()
 		if err != nil {
-			fmt.Printf("error: %v\n", err)
+			slog.Error(err.Error())
+			return
 		}
-		close(sigChan)
-	}()
-
-	// Wait for a signal
-	<-sigChan
-	terminateInstance(instanceID)
 
-	// Exit the program gracefully
-	os.Exit(0)
+		if err := checkMetal(); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		if err := checkNetworking(); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		if *eiceFlag {
+			if err := setupEICE(); err != nil {
+				slog.Error(err.Error())
+				return
+			}
+			defer cleanupEICE()
+		}
+		if *benchPolicy != "" {
+			// before paying for an instance
+			statusf("checking -bench-role...")
+			if _, err := mintBenchCredentials("preflight", *benchCredsTTL); err != nil {
+				slog.Error(err.Error())
+				return
+			}
+		}
+		if err := withSetupLock(ensureSecurityGroup); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		if *nodesFlag > 1 {
+			if err := createPlacementGroup(); err != nil {
+				slog.Error(err.Error())
+				return
+			}
+			defer deletePlacementGroup()
+		}
 
-}
+		targets, err = resolveTargets(arch)
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+	}
+	if *shardsFlag > 1 {
+		if targets, err = shardTargets(targets, benchmarks); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+	}
+	if *targetFlag == "" && *providerFlag == "aws" {
+		if err := enforcePolicyHook(len(targets) * *nodesFlag); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+	}
+	if *debugFlag != "" && len(targets) > 1 {
+		slog.Error("-debug requires a single target")
+		return
+	}
+	if *staticFlag {
+		for i := range targets {
+			targets[i].static = true
+		}
+	}
+	info := runInfo{
+		commitID:   commitID,
+		commitTime: gitCommitTime(),
+		runStamp:   time.Now().UTC().Format(time.RFC3339),
+		tune:       tune,
+		toggles:    toggles,
+		benchmarks: benchmarks,
+		worktree:   worktree,
+	}
 
-func sshExec(publicIP string) error {
-	args := []string{"-i", privateKeyPath(),
-		fmt.Sprintf("ubuntu@%s", publicIP),
-		"cd /tmp && ./bench",
-		fmt.Sprintf("-test.bench=%s", *benchFlag),
-		fmt.Sprintf("-test.count=%d", *countFlag),
-		fmt.Sprintf("-test.benchmem=%t", *benchMem),
-		fmt.Sprintf("-test.run=%s", *run),
+	// compile the benchmark binary while the instances boot; both are independent and
+	// the launch (+ ssh polling) dominates the latency.
+	if *targetFlag != "" {
+		statusf("compiling benchmark binary arch=%s...", arch.GoString())
+	} else {
+		statusf("compiling benchmark binary arch=%s and starting %d %s instance(s)...", arch.GoString(), len(targets), *instanceType)
 	}
-	if *cpuFlag > 0 {
-		args = append(args, fmt.Sprintf("-test.cpu=%d", *cpuFlag))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// no need to wait for the instances if the build fails, abort the launches.
+	bins := buildBinaries(arch, targets, cancel)
+	if *targetFlag == "" && *providerFlag == "aws" {
+		// from the EC2 on-demand prices
+		meterCost(ctx, *instanceType)
+	}
+	if *withLocal {
+		info.local = startLocalRun(bins)
 	}
 
-	cmd := exec.Command("ssh", args...)
+	// Create a channel to listen for incoming signals
+	sigChan := make(chan os.Signal, 1)
+	// Notify the channel for interrupt (Ctrl+C) and termination signals
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
 
-	// Stream stdout and stderr
-	stdoutPipe, _ := cmd.StdoutPipe()
-	stderrPipe, _ := cmd.StderrPipe()
+	done := make(chan struct{})
+	go func() {
+		runTargets(ctx, targets, info, bins)
+		close(done)
+	}()
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start the SSH command: %v", err)
+	// Wait for a signal or the end of the run; interactively, Ctrl-C asks what to do, other
+	// signals terminate the instances.
+	interactive := isTerminal(os.Stdin) && isTerminal(os.Stderr)
+	finished := false
+wait:
+	for {
+		var sig os.Signal
+		select {
+		case sig = <-sigChan:
+		case <-done:
+			finished = true
+			break wait
+		}
+		action := abortTerminate
+		if sig == os.Interrupt && interactive {
+			action = abortMenu(sigChan)
+		}
+		switch action {
+		case abortFinish:
+			slog.Info("finishing the current benchmarks; Ctrl-C for the menu")
+			finishing.Store(true)
+			continue
+		case abortStop:
+			slog.Info("stopping the benchmarks")
+			stopAllBenchmarks()
+			continue
+		case abortDetach:
+			detachInstances()
+		default:
+			cancel()
+			terminateAllInstances()
+		}
+		break wait
 	}
-
-	// Goroutines to handle real-time streaming
-	go io.Copy(os.Stdout, stdoutPipe)
-	go io.Copy(os.Stderr, stderrPipe)
-
-	// Wait for the command to complete
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("failed to run the benchmark: %v", err)
+	exitCode := 0
+	if finished {
+		<-bins.done
+		if bins.err != nil {
+			slog.Error(bins.err.Error())
+		}
+		if c, ok := runCost.cost(time.Now()); ok {
+			slog.Info(fmt.Sprintf("instance cost: ~%s (on-demand price of %s times the uptime)", formatCost(c), *instanceType))
+		}
+		if *provenanceFile != "" && bins.err == nil {
+			if err := writeProvenance(*provenanceFile, info); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+		if *coverProfile != "" && bins.err == nil {
+			if err := writeCoverProfile(*coverProfile); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+		if *cpuProfile != "" && bins.err == nil {
+			if err := writeCPUProfile(*cpuProfile); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+		if *bundleFile != "" {
+			if err := writeBundle(*bundleFile, info); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+		if *s3URL != "" {
+			if err := publishS3(*s3URL, info); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+		if seedGenerated {
+			slog.Info(fmt.Sprintf("random inputs seeded with %d; rerun with -seed=%d to reproduce them", *seedFlag, *seedFlag))
+		}
+		if *auditLogGroup != "" {
+			if err := publishAuditLog(*auditLogGroup, info); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+		if path := perfBudgetPath(); path != "" {
+			violations, err := enforcePerfBudget(path, runOutput.String())
+			if err != nil {
+				slog.Error(err.Error())
+				exitCode = 1
+			} else if len(violations) > 0 {
+				fmt.Printf("\nperformance budget exceeded (%s):\n", path)
+				for _, v := range violations {
+					fmt.Printf("  %s\n", v)
+				}
+				info.budgetViolations = violations
+				exitCode = 1
+			}
+		}
+		if ci := detectCI(); ci != nil {
+			if err := publishCI(ci, info); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+	}
+	// os.Exit skips deferred calls
+	if worktree != "" {
+		removeWorktree(worktree)
+		worktree = ""
 	}
+	cleanupEICE()
+	apiCalls.flush()
 
-	return nil
-}
+	// Exit the program gracefully
+	os.Exit(exitCode)
 
-func scp(benchFileName, publicIP string) error {
-	cmd := exec.Command("scp", "-o", "StrictHostKeyChecking=no", "-i", privateKeyPath(), benchFileName, fmt.Sprintf("ubuntu@%s:/tmp/bench", publicIP))
-	var uploadStdout, uploadStderr strings.Builder
-	cmd.Stdout = &uploadStdout
-	cmd.Stderr = &uploadStderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to upload the binary: \nstdout: %s\nstderr: %s, %v", uploadStdout.String(), uploadStderr.String(), err)
-	}
-	return nil
 }
 
 const lockFileName = ".rbench.lock"
@@ -203,7 +726,14 @@ func releaseLock(lockFile *os.File) error {
 	return nil
 }
 
-func compileBenchmarkBinary(arch instanceArch) (fileName string, err error) {
+// compileBenchmarkBinary cross compiles the test binary; static binaries are built without cgo
+// so they run on musl based distributions.
+func compileBenchmarkBinary(arch instanceArch, static bool) (fileName string, err error) {
+	return compileTestBinary(arch, static, buildDir, benchPackage)
+}
+
+// compileTestBinary cross compiles the test binary of pkg, from dir.
+func compileTestBinary(arch instanceArch, static bool, dir, pkg string) (fileName string, err error) {
 	// lock current directory with a .rbench.lock file
 	// Acquire lock
 	lockFile, err := acquireLock()
@@ -220,51 +750,68 @@ func compileBenchmarkBinary(arch instanceArch) (fileName string, err error) {
 	if *tagsFlag != "" {
 		args = append(args, "-tags", *tagsFlag)
 	}
+	if *gcflagsFlag != "" {
+		args = append(args, "-gcflags="+*gcflagsFlag)
+	}
+	if *ldflagsFlag != "" {
+		args = append(args, "-ldflags="+*ldflagsFlag)
+	}
+	if *debugFlag != "" {
+		// no optimizations nor inlining, for the debugger
+		args = append(args, "-gcflags=all=-N -l")
+	}
+	if *coverProfile != "" {
+		args = append(args, "-cover")
+		if *coverMode != "" {
+			args = append(args, "-covermode", *coverMode)
+		}
+	}
+	if *runtimeMetrics {
+		tmp, err := os.MkdirTemp("", "rbench-overlay-")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(tmp)
+		overlay, err := runtimeMetricsOverlay(dir, pkg, tmp)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "-overlay="+overlay)
+	}
+	args = append(args, pkg)
 	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
 	cmd.Env = append(os.Environ(), "GOOS=linux", fmt.Sprintf("GOARCH=%s", arch.GoString()))
+	if *wasmFlag != "" {
+		cmd.Env = append(os.Environ(), wasmEnv()...)
+	}
+	if static {
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=0")
+	}
 	var stdout, stderr strings.Builder
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to cross build the package: \nstdout: %s\nstderr: %s, %v", stdout.String(), stderr.String(), err)
+		report := ""
+		if static {
+			report = cgoReport()
+		}
+		return "", fmt.Errorf("failed to cross build the package: \nstdout: %s\nstderr: %s, %v%s", stdout.String(), stderr.String(), err, report)
 	}
 
 	// check that the binary has been created
 	if _, err := os.Stat(benchFileName); err != nil {
 		return "", fmt.Errorf("binary not found: %v - cmd %s failed", err, cmd.String())
 	}
-
-	return benchFileName, nil
-}
-
-func gitCommitID() (string, error) {
-	// Check if the directory is a Git repository
-	cmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
-	_, err := cmd.Output()
-	if err != nil {
-		return "not a git repo", nil
-	}
-
-	// Get the commit ID
-	cmd = exec.Command("git", "rev-parse", "HEAD")
-	out, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-	commitID := strings.TrimSpace(string(out))
-
-	// Check if the working directory is dirty
-	cmd = exec.Command("git", "status", "--porcelain")
-	out, err = cmd.Output()
-	if err != nil {
-		return "", err
-	}
-	if len(out) > 0 {
-		commitID += "-dirty"
+	if static {
+		if err := checkStatic(benchFileName); err != nil {
+			os.Remove(benchFileName)
+			return "", err
+		}
 	}
 
-	return commitID, nil
+	return benchFileName, nil
 }
 
 func randString(n int) string {
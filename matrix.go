@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// runTypeMatrix implements -type with several instance types: the benchmark is run concurrently
+// on one instance of each type, by a child rbench per type with the same flags and seed, and the
+// results are merged into a table of the median ns/op per type, followed by the performance per
+// dollar of each benchmark (on-demand prices, aws only).
+func runTypeMatrix(instanceTypes []string) error {
+	// the -type and -seed of the children override the ones of the command line; the pre-flight
+	// checks are done.
+	args := childArgs(os.Args[1:], "-seed="+fmt.Sprint(*seedFlag), "-shuffle="+*shuffleFlag, "-ci=off",
+		"-vet=false", "-pretest=false", "-changed=")
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		done    int
+		outputs = make([]string, len(instanceTypes))
+		errs    = make([]error, len(instanceTypes))
+	)
+	statusf("running on %s...", strings.Join(instanceTypes, ", "))
+	for i, name := range instanceTypes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outputs[i], errs[i] = runChild(childArgs(args, "-type="+name))
+			mu.Lock()
+			done++
+			statusf("%d/%d instance types done", done, len(instanceTypes))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	stderrTerminal.clearStatus()
+
+	machines := make([]fanoutMachine, len(instanceTypes))
+	configs := make([]map[string]string, len(instanceTypes))
+	results := make([]*benchResults, len(instanceTypes))
+	byType := make(map[string]*benchResults)
+	for i, name := range instanceTypes {
+		machines[i] = fanoutMachine{name: name}
+		// the outputs carry an instance-type config line, for benchstat -col instance-type
+		fmt.Println(outputs[i])
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, errs[i])
+			continue
+		}
+		configs[i], _ = readConfigLines(strings.NewReader(outputs[i]))
+		results[i] = newBenchResults()
+		results[i].Write([]byte(outputs[i] + "\n"))
+		byType[name] = results[i]
+	}
+	printCoverageMatrix(os.Stdout, machines, configs, results)
+
+	if *providerFlag == "aws" {
+		candidates := make([]shopCandidate, len(instanceTypes))
+		if err := loadAWSConfig(); err != nil {
+			slog.Warn(err.Error())
+		} else {
+			for i, name := range instanceTypes {
+				candidates[i].instanceType = name
+				if candidates[i].price, err = onDemandPrice(name); err != nil {
+					slog.Warn(err.Error())
+				}
+			}
+			printShopSummary(os.Stdout, candidates, byType)
+		}
+	}
+	if failed := len(instanceTypes) - countNonNil(results); failed > 0 {
+		return fmt.Errorf("%d/%d instance types failed", failed, len(instanceTypes))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// checkMetal fails if -require-metal is set and the instance type is virtualized.
+func checkMetal() error {
+	if *requireMetal && !aws.ToBool(instanceTypeInfo.BareMetal) {
+		return fmt.Errorf("instance type %s is not bare metal; use a .metal type (e.g. c7i.metal-24xl)", *instanceType)
+	}
+	return nil
+}
+
+// readVirtualization returns, as benchfmt configuration lines, whether the instance is bare
+// metal, its hypervisor and the virtualization detected on the host. On EC2 instances, the first
+// two are those of the instance type (nitro, xen); elsewhere (-target hosts, GCP), they are
+// derived from the detected virtualization.
+func readVirtualization(t target, r remote) ([]string, error) {
+	out, err := sshRun(r, "systemd-detect-virt || true; cat /sys/devices/virtual/dmi/id/product_name 2>/dev/null || true")
+	if err != nil {
+		return nil, fmt.Errorf("unable to detect virtualization, %v", err)
+	}
+	lines := strings.SplitN(strings.TrimSpace(out), "\n", 2)
+	virt := strings.TrimSpace(lines[0])
+	var metal []string
+	switch {
+	case t.host == "" && *providerFlag == "aws":
+		hypervisor := string(instanceTypeInfo.Hypervisor)
+		if hypervisor == "" {
+			hypervisor = "none"
+		}
+		metal = append(metal, fmt.Sprintf("bare-metal: %t", aws.ToBool(instanceTypeInfo.BareMetal)), "hypervisor: "+hypervisor)
+	case virt != "":
+		// the virtualization technology, e.g. kvm
+		metal = append(metal, fmt.Sprintf("bare-metal: %t", virt == "none"), "hypervisor: "+virt)
+	}
+	metal = append(metal, "virtualization: "+virt)
+	if len(lines) == 2 {
+		metal = append(metal, "product-name: "+strings.TrimSpace(lines[1]))
+	}
+
+	if *requireMetal && virt != "none" {
+		return metal, fmt.Errorf("-require-metal: the host reports virtualization %q", virt)
+	}
+	return metal, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// disableMitigations adds mitigations=off to the kernel command line and reboots the instance:
+// with grubby on Amazon Linux, with a grub.d snippet on Debian and Ubuntu.
+// it must run before the upload: /tmp is cleared on boot.
+func disableMitigations(r remote) error {
+	const script = `if command -v grubby > /dev/null; then grubby --update-kernel=ALL --args=mitigations=off; ` +
+		`elif command -v update-grub > /dev/null; then ` +
+		`echo "GRUB_CMDLINE_LINUX_DEFAULT=\"\$GRUB_CMDLINE_LINUX_DEFAULT mitigations=off\"" > /etc/default/grub.d/99-rbench.cfg && update-grub; ` +
+		`else echo "neither grubby nor update-grub found" >&2; exit 1; fi`
+	if _, err := sshRun(r, "sudo sh -c '"+script+"'"); err != nil {
+		return fmt.Errorf("unable to update kernel command line, %v", err)
+	}
+
+	// the connection is closed by the reboot; ignore the error.
+	sshRunOnce(r, "sudo systemctl reboot")
+	time.Sleep(15 * time.Second)
+
+	if err := waitForSSH(r, 5*time.Minute); err != nil {
+		return fmt.Errorf("instance did not come back after reboot, %v", err)
+	}
+
+	out, err := sshRun(r, "cat /proc/cmdline")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "mitigations=off") {
+		return fmt.Errorf("mitigations=off not in kernel command line: %s", strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// waitForSSH polls the instance until an ssh command succeeds.
+func waitForSSH(r remote, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := sshRunOnce(r, "true")
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// readMitigations returns the state of the CPU vulnerability mitigations reported by the kernel,
+// as benchfmt configuration lines.
+func readMitigations(r remote) ([]string, error) {
+	out, err := sshRun(r, "grep -H . /sys/devices/system/cpu/vulnerabilities/*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mitigations state, %v", err)
+	}
+	state := "default"
+	if *mitigationsOff {
+		state = "off"
+	}
+	lines := []string{"mitigations: " + state}
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		if file, value, ok := strings.Cut(l, ":"); ok {
+			lines = append(lines, fmt.Sprintf("vulnerability-%s: %s", path.Base(file), value))
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Multi-node runs (-nodes) launch the instances of a target in a cluster placement group, on the
+// same network spine, and run the benchmark on all of them at once: collective-communication
+// benchmarks need the real fabric (-efa, -ena-express) between several machines. Each node is
+// told the private addresses of all the nodes and its index in them; the results are those of
+// the first node, the others are peers whose output is only reported on failure.
+
+// Environment of the benchmark on each node of a multi-node run.
+const (
+	nodesEnv = "RBENCH_NODES" // comma-separated private addresses of the nodes, the first is the recorded one
+	nodeEnv  = "RBENCH_NODE"  // index of the node in RBENCH_NODES
+)
+
+// nodeGrace is how long the peers may run after the first node is done.
+const nodeGrace = time.Minute
+
+// placementGroup is the cluster placement group of the -nodes instances, see createPlacementGroup.
+var placementGroup string
+
+// createPlacementGroup creates the cluster placement group the instances of a multi-node run are
+// launched in, and allows the traffic between the instances in the security group (EFA requires
+// all of it). The group is deleted by deletePlacementGroup once the instances are terminated; the
+// security group rule is kept, like the ssh one.
+func createPlacementGroup() error {
+	name := "rbench-" + awsUserName + "-" + randString(7)
+	_, err := ec2Client.CreatePlacementGroup(context.TODO(), &ec2.CreatePlacementGroupInput{
+		GroupName: aws.String(name),
+		Strategy:  types.PlacementStrategyCluster,
+		TagSpecifications: []types.TagSpecification{{
+			ResourceType: types.ResourceTypePlacementGroup,
+			Tags:         []types.Tag{{Key: aws.String("rbench"), Value: aws.String(awsUserName)}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the placement group, %v", err)
+	}
+	placementGroup = name
+	_, err = ec2Client.AuthorizeSecurityGroupIngress(context.TODO(), &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId: aws.String(securityGroupID),
+		IpPermissions: []types.IpPermission{{
+			IpProtocol:       aws.String("-1"),
+			UserIdGroupPairs: []types.UserIdGroupPair{{GroupId: aws.String(securityGroupID)}},
+		}},
+	})
+	if err != nil && !strings.Contains(err.Error(), "InvalidPermission.Duplicate") {
+		return fmt.Errorf("unable to allow the traffic between the nodes in security group %s, %v", securityGroupID, err)
+	}
+	return nil
+}
+
+// deletePlacementGroup deletes the placement group, after waiting for its instances to terminate.
+func deletePlacementGroup() {
+	if placementGroup == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	out, err := ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{{Name: aws.String("placement-group-name"), Values: []string{placementGroup}}},
+	})
+	if err == nil {
+		var ids []string
+		for _, r := range out.Reservations {
+			for _, instance := range r.Instances {
+				ids = append(ids, aws.ToString(instance.InstanceId))
+			}
+		}
+		if len(ids) > 0 {
+			err = ec2.NewInstanceTerminatedWaiter(ec2Client).Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids}, 10*time.Minute)
+		}
+	}
+	if err == nil {
+		_, err = ec2Client.DeletePlacementGroup(ctx, &ec2.DeletePlacementGroupInput{GroupName: aws.String(placementGroup)})
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("unable to delete the placement group %s, %v; aws ec2 delete-placement-group --group-name %s to delete it",
+			placementGroup, err, placementGroup))
+	}
+}
+
+// nodeGroup is the peers of the first node of a multi-node run.
+type nodeGroup struct {
+	launched chan struct{}
+	ids      []string // instances, launched concurrently
+	hosts    []string
+	err      error
+
+	peers []remote       // once set up
+	done  chan error     // of the peer runs
+	wg    sync.WaitGroup // of the peer runs
+}
+
+// startNodes launches the n peers of t in the background.
+func startNodes(ctx context.Context, t target, n int) *nodeGroup {
+	g := &nodeGroup{launched: make(chan struct{}), ids: make([]string, n), hosts: make([]string, n)}
+	go func() {
+		defer close(g.launched)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				host, id, err := cloud.start(ctx, t.ami)
+				mu.Lock()
+				defer mu.Unlock()
+				g.hosts[i], g.ids[i] = host, id
+				if err != nil && g.err == nil {
+					g.err = fmt.Errorf("node %d: %v", i+1, err)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+	return g
+}
+
+// release terminates the peers, once launched.
+func (g *nodeGroup) release() {
+	<-g.launched
+	for _, id := range g.ids {
+		if id != "" {
+			terminateInstance(id)
+		}
+	}
+}
+
+// setup waits for the peers, uploads the files of the first node to them and checks their
+// network; it sets the multi-node environment of r, the first node, launched as instanceID, and
+// returns the nodes as benchfmt configuration lines.
+func (g *nodeGroup) setup(t target, r *remote, instanceID string, uploads []*upload, bins *binaries) ([]string, error) {
+	<-g.launched
+	if g.err != nil {
+		return nil, g.err
+	}
+	addrs, err := privateAddresses(append([]string{instanceID}, g.ids...))
+	if err != nil {
+		return nil, err
+	}
+	nodes := strings.Join(addrs, ",")
+	r.env = []string{nodesEnv + "=" + nodes, nodeEnv + "=0"}
+
+	g.peers = make([]remote, len(g.hosts))
+	errs := make([]error, len(g.hosts))
+	var wg sync.WaitGroup
+	for i, host := range g.hosts {
+		peer := remote{user: t.user, host: host, bin: r.bin, env: []string{nodesEnv + "=" + nodes, nodeEnv + "=" + strconv.Itoa(i+1)}}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the uploads keep their progress
+			var files []*upload
+			for _, u := range uploads {
+				files = append(files, &upload{local: u.local, remote: u.remote})
+			}
+			if err := uploadFiles(t, peer, files); err != nil {
+				errs[i] = err
+				return
+			}
+			if bins.assets != "" {
+				if peer.dir, err = setupAssets(peer, bins.pkgDir); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+			if _, err := setupNetworking(peer); err != nil {
+				errs[i] = err
+				return
+			}
+			g.peers[i] = peer
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("node %d (%s): %v", i+1, g.hosts[i], err)
+		}
+	}
+	return []string{fmt.Sprintf("nodes: %d", len(addrs)), "placement: cluster"}, nil
+}
+
+// run starts the benchmark on the peers, like on the first node (see sshExec), with count
+// repetitions of bench.
+func (g *nodeGroup) run(bench string, count int) {
+	g.done = make(chan error, len(g.peers))
+	for i, peer := range g.peers {
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			// the output is kept on the peer, its end is reported on failure
+			out, err := sshRunOnce(peer, fmt.Sprintf("cd %s && { %s 2>&1; echo $? > %s; } | tee -a %s | tail -n 20; exit $(cat %s)",
+				peer.runDir(), benchCommand(peer, benchTestArgs(peer, bench, count)), remoteExitFile(), remoteResultsFile(), remoteExitFile()))
+			if err != nil {
+				g.done <- fmt.Errorf("node %d (%s): %v\n%s", i+1, peer.host, err, strings.TrimSpace(out))
+			}
+		}()
+	}
+}
+
+// wait waits for the peers, for nodeGrace at most once the first node is done, and returns the
+// first failure.
+func (g *nodeGroup) wait() error {
+	finished := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(nodeGrace):
+		return fmt.Errorf("the peers are still running %s after the first node", nodeGrace)
+	}
+	select {
+	case err := <-g.done:
+		return err
+	default:
+		return nil
+	}
+}
+
+// privateAddresses returns the private IPv4 addresses of instances, in order.
+func privateAddresses(ids []string) ([]string, error) {
+	out, err := ec2Client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{InstanceIds: ids}, eventuallyConsistent)
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe the nodes, %v", err)
+	}
+	byID := make(map[string]string)
+	for _, r := range out.Reservations {
+		for _, instance := range r.Instances {
+			byID[aws.ToString(instance.InstanceId)] = aws.ToString(instance.PrivateIpAddress)
+		}
+	}
+	addrs := make([]string, len(ids))
+	for i, id := range ids {
+		if addrs[i] = byID[id]; addrs[i] == "" {
+			return nil, fmt.Errorf("no private address for node %s", id)
+		}
+	}
+	return addrs, nil
+}
+
+// efaScript installs the EFA software (libfabric and the efa provider) if needed, then checks that
+// the fabric is usable; it prints the libfabric version.
+const efaScript = `set -e
+FI_INFO=/opt/amazon/efa/bin/fi_info
+if [ ! -x $FI_INFO ]; then
+	cd /tmp
+	curl -sSfO https://efa-installer.amazonaws.com/aws-efa-installer-latest.tar.gz
+	tar xzf aws-efa-installer-latest.tar.gz
+	cd aws-efa-installer && sudo ./efa_installer.sh -y >/tmp/rbench-efa-install.log 2>&1
+fi
+$FI_INFO -p efa -t FI_EP_RDM >/dev/null
+$FI_INFO --version | grep libfabric:
+`
+
+// checkNetworking checks that the instance type supports the -efa and -ena-express interfaces.
+func checkNetworking() error {
+	network := instanceTypeInfo.NetworkInfo
+	if network == nil {
+		network = new(types.NetworkInfo)
+	}
+	if *efaFlag && !aws.ToBool(network.EfaSupported) {
+		return fmt.Errorf("instance type %s doesn't support EFA", *instanceType)
+	}
+	if *enaExpress && !aws.ToBool(network.EnaSrdSupported) {
+		return fmt.Errorf("instance type %s doesn't support ENA Express", *instanceType)
+	}
+	return nil
+}
+
+// setupNetworking validates the -efa fabric or the -ena-express interface, and returns the
+// interconnect as benchfmt configuration lines.
+func setupNetworking(r remote) ([]string, error) {
+	switch {
+	case *efaFlag:
+		out, err := sshRun(r, efaScript)
+		if err != nil {
+			return nil, fmt.Errorf("EFA fabric check failed (installer log in /tmp/rbench-efa-install.log), %v", err)
+		}
+		lines := []string{"interconnect: efa"}
+		if _, version, ok := strings.Cut(strings.TrimSpace(out), ":"); ok {
+			lines = append(lines, "libfabric: "+strings.TrimSpace(version))
+		}
+		return lines, nil
+	case *enaExpress:
+		// ena_srd_mode is 1 (TCP) or 3 (TCP and UDP) when ENA Express is enabled
+		out, err := sshRun(r, `ethtool -S $(ip -o route get 1.1.1.1 | awk '{print $5}') | awk '/ena_srd_mode/ {print $2}'`)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the ENA Express mode, %v", err)
+		}
+		if mode := strings.TrimSpace(out); mode == "" || mode == "0" {
+			return nil, fmt.Errorf("ENA Express is not enabled on the instance (ena_srd_mode %q)", mode)
+		}
+		return []string{"interconnect: ena-express"}, nil
+	}
+	return nil, nil
+}
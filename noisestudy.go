@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// rbench noise-study runs the calibration suite at intervals, in every availability zone of the
+// region, for hours or days: each round launches one instance per zone. The median of each
+// calibration benchmark of each run is appended to the samples file as it comes, so that a study
+// can be interrupted, resumed and reported at any time. The report shows the variance observed
+// per zone and per hour of the day, to choose when and where to run the comparisons that matter.
+
+// noiseSample is the median ns/op of a calibration benchmark in a run of a noise study.
+type noiseSample struct {
+	at      time.Time
+	zone    string
+	name    string
+	nsPerOp float64
+}
+
+// writeNoiseSamples appends samples to w, one tab-separated line each: time, zone, benchmark
+// and ns/op.
+func writeNoiseSamples(w io.Writer, samples []noiseSample) error {
+	for _, s := range samples {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%g\n", s.at.UTC().Format(time.RFC3339), s.zone, s.name, s.nsPerOp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readNoiseSamples reads the samples written by writeNoiseSamples.
+func readNoiseSamples(r io.Reader) ([]noiseSample, error) {
+	var samples []noiseSample
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected time, zone, benchmark and ns/op", n)
+		}
+		at, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		v, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		samples = append(samples, noiseSample{at, fields[1], fields[2], v})
+	}
+	return samples, scanner.Err()
+}
+
+// noiseSamples returns the samples of the output of a calibration run in zone.
+func noiseSamples(output, zone string, at time.Time) []noiseSample {
+	results := newBenchResults()
+	results.Write([]byte(output + "\n"))
+	var samples []noiseSample
+	for _, name := range results.names {
+		if values := results.values(name, "ns/op"); len(values) > 0 {
+			samples = append(samples, noiseSample{at, zone, trimProcs(name), median(values)})
+		}
+	}
+	return samples
+}
+
+// printNoiseStudy prints, for each calibration benchmark, the median and the spread of the
+// samples per zone and per hour of the day (UTC), and the quietest of each.
+func printNoiseStudy(w io.Writer, samples []noiseSample) {
+	if len(samples) == 0 {
+		fmt.Fprintln(w, "no sample")
+		return
+	}
+	byName := make(map[string][]noiseSample)
+	for _, s := range samples {
+		byName[s.name] = append(byName[s.name], s)
+	}
+	first, last := samples[0].at, samples[0].at
+	for _, s := range samples {
+		if s.at.Before(first) {
+			first = s.at
+		}
+		if s.at.After(last) {
+			last = s.at
+		}
+	}
+	fmt.Fprintf(w, "%d samples from %s to %s (median ns/op ±spread)\n", len(samples),
+		first.UTC().Format(time.RFC3339), last.UTC().Format(time.RFC3339))
+	for _, name := range sortedKeys(byName) {
+		fmt.Fprintf(w, "\n%s:\n", name)
+		printNoiseGroups(w, "zone", byName[name], func(s noiseSample) string { return s.zone })
+		printNoiseGroups(w, "hour (UTC)", byName[name], func(s noiseSample) string { return fmt.Sprintf("%02d:00", s.at.UTC().Hour()) })
+	}
+}
+
+// printNoiseGroups prints the samples grouped by key, and the group of lowest spread among the
+// ones of several samples.
+func printNoiseGroups(w io.Writer, title string, samples []noiseSample, key func(noiseSample) string) {
+	groups := make(map[string][]float64)
+	for _, s := range samples {
+		groups[key(s)] = append(groups[key(s)], s.nsPerOp)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\tsamples\tmedian\tspread\n", title)
+	quietest, lowest := "", 0.
+	for _, g := range sortedKeys(groups) {
+		values := groups[g]
+		s := spread(values)
+		fmt.Fprintf(tw, "  %s\t%d\t%.4g\t±%.1f%%\n", g, len(values), median(values), s)
+		if len(values) > 1 && (quietest == "" || s < lowest) {
+			quietest, lowest = g, s
+		}
+	}
+	tw.Flush()
+	if quietest != "" && len(groups) > 1 {
+		fmt.Fprintf(w, "  quietest %s: %s (±%.1f%%)\n", title, quietest, lowest)
+	}
+}
+
+// defaultSubnets returns the default subnet of each availability zone of the region, by zone.
+func defaultSubnets() (map[string]string, error) {
+	out, err := ec2Client.DescribeSubnets(context.TODO(), &ec2.DescribeSubnetsInput{
+		Filters: []types.Filter{{Name: aws.String("default-for-az"), Values: []string{"true"}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe subnets, %v", err)
+	}
+	subnets := make(map[string]string)
+	for _, s := range out.Subnets {
+		subnets[aws.ToString(s.AvailabilityZone)] = aws.ToString(s.SubnetId)
+	}
+	if len(subnets) == 0 {
+		return nil, fmt.Errorf("no default subnet in %s", awsConfig.Region)
+	}
+	return subnets, nil
+}
+
+// noiseStudyCmd implements "rbench noise-study". Each run is a child rbench benchmarking the
+// calibration suite, with the -subnet of its zone; the run flags select the instance.
+func noiseStudyCmd(args []string) error {
+	fs := flag.NewFlagSet("noise-study", flag.ExitOnError)
+	zonesFlag := fs.String("zones", "", "comma-separated availability zones (default: every zone with a default subnet)")
+	interval := fs.Duration("interval", time.Hour, "time between the starts of two rounds")
+	rounds := fs.Int("rounds", 24, "number of rounds")
+	count := fs.Int("count", 3, "number of runs of the suite per instance")
+	output := fs.String("o", "noise-study.txt", "file the samples are appended to")
+	reportOnly := fs.Bool("report", false, "print the report of the samples of -o, without running")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench noise-study [flags] [-- run flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if !*reportOnly {
+		if err := runNoiseStudy(*zonesFlag, *interval, *rounds, *count, *output, fs.Args()); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	samples, err := readNoiseSamples(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *output, err)
+	}
+	printNoiseStudy(os.Stdout, samples)
+	return nil
+}
+
+// runNoiseStudy runs the rounds of a noise study, appending the samples to output.
+func runNoiseStudy(zonesFlag string, interval time.Duration, rounds, count int, output string, runFlags []string) error {
+	if err := loadAWSConfig(); err != nil {
+		return err
+	}
+	ec2Client = ec2.NewFromConfig(awsConfig)
+	subnets, err := defaultSubnets()
+	if err != nil {
+		return err
+	}
+	zones := splitList(zonesFlag)
+	if len(zones) == 0 {
+		zones = sortedKeys(subnets)
+	}
+	for _, zone := range zones {
+		if subnets[zone] == "" {
+			return fmt.Errorf("no default subnet in zone %s (%s)", zone, awsConfig.Region)
+		}
+	}
+	dir, err := writeCalibrationSuite()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the run flags follow the study flags (after --); the region is the one of the study
+	runArgs := []string{"-bench=.", fmt.Sprintf("-count=%d", count), "-ci=off", "-region=" + awsConfig.Region}
+	if *awsProfile != "" {
+		runArgs = append(runArgs, "-profile="+*awsProfile)
+	}
+	runArgs = append(runArgs, runFlags...)
+	start := time.Now()
+	for round := 0; round < rounds; round++ {
+		if wait := time.Until(start.Add(time.Duration(round) * interval)); wait > 0 {
+			statusf("round %d/%d at %s...", round+1, rounds, time.Now().Add(wait).Format(time.Kitchen))
+			time.Sleep(wait)
+		}
+		statusf("round %d/%d in %s...", round+1, rounds, strings.Join(zones, ", "))
+		at := time.Now()
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			samples []noiseSample
+		)
+		for _, zone := range zones {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				out, err := runChildIn(dir, childArgs(runArgs, "-subnet="+subnets[zone]))
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					stderrTerminal.clearStatus()
+					fmt.Fprintf(os.Stderr, "round %d, %s: %v\n", round+1, zone, err)
+					return
+				}
+				samples = append(samples, noiseSamples(out, zone, at)...)
+			}()
+		}
+		wg.Wait()
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].zone < samples[j].zone })
+		if err := writeNoiseSamples(f, samples); err != nil {
+			return fmt.Errorf("unable to write %s, %v", output, err)
+		}
+	}
+	stderrTerminal.clearStatus()
+	return nil
+}
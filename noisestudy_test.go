@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNoiseStudy(t *testing.T) {
+	at := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	samples := noiseSamples("goos: linux\nBenchmarkCalibrateALU-2 100 1000 ns/op\nBenchmarkCalibrateALU-2 100 1100 ns/op\nBenchmarkCalibrateALU-2 100 1020 ns/op\n", "us-east-2a", at)
+	if len(samples) != 1 || samples[0].name != "BenchmarkCalibrateALU" || samples[0].nsPerOp != 1020 {
+		t.Fatalf("noiseSamples = %+v, expected the median of BenchmarkCalibrateALU", samples)
+	}
+	samples = append(samples,
+		noiseSample{at.Add(time.Hour), "us-east-2a", "BenchmarkCalibrateALU", 1200},
+		noiseSample{at, "us-east-2b", "BenchmarkCalibrateALU", 1000},
+		noiseSample{at.Add(time.Hour), "us-east-2b", "BenchmarkCalibrateALU", 1010})
+
+	var b strings.Builder
+	if err := writeNoiseSamples(&b, samples); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readNoiseSamples(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(samples) || !read[1].at.Equal(samples[1].at) || read[3] != samples[3] {
+		t.Fatalf("read %+v, expected %+v", read, samples)
+	}
+
+	b.Reset()
+	printNoiseStudy(&b, read)
+	for _, want := range []string{"4 samples", "quietest zone: us-east-2b (±0.5%)", "09:00", "10:00"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, b.String())
+		}
+	}
+
+	if _, err := readNoiseSamples(strings.NewReader("2026-10-15T09:00:00Z\tus-east-2a\tBenchmarkCalibrateALU\n")); err == nil {
+		t.Error("expected an error on a truncated line")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// benchPackage is the package to benchmark; "." unless given as argument.
+var benchPackage = "."
+
+// testBinaryArgs are the arguments after --, passed to the test binary unchanged after the ones
+// of rbench (e.g. -test.v, or the flags of the benchmarks).
+var testBinaryArgs []string
+
+// parseArgs parses the command line; the package to benchmark may be given before or after the flags:
+//
+//	rbench ./internal/fft -bench=FFT
+//	rbench -bench=FFT ./internal/fft -- -test.v -size=large
+func parseArgs() error {
+	args := os.Args[1:]
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, testBinaryArgs = args[:i], args[i+1:]
+	}
+	flag.CommandLine.Parse(args)
+	if flag.NArg() > 0 {
+		benchPackage = flag.Arg(0)
+		// the flag package stops at the first non-flag argument; parse the remaining ones.
+		if err := flag.CommandLine.Parse(flag.Args()[1:]); err != nil {
+			return err
+		}
+		if flag.NArg() > 0 {
+			return fmt.Errorf("expected a single package, got %s and %s", benchPackage, strings.Join(flag.Args(), " "))
+		}
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	return applyConfigDefaults(flag.CommandLine, c, benchPackage)
+}
+
+// testFiles returns the paths of the test files of pkg.
+func testFiles(pkg string) ([]string, error) {
+	out, err := exec.Command("go", "list", "-f", `{{.Dir}}{{range .TestGoFiles}} {{.}}{{end}}{{range .XTestGoFiles}} {{.}}{{end}}`, pkg).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("unable to list package %s: %s", pkg, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, fmt.Errorf("unable to list package %s: %v", pkg, err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("%s matches %d packages, expected a single package", pkg, len(lines))
+	}
+	fields := strings.Fields(lines[0])
+	files := make([]string, 0, len(fields)-1)
+	for _, name := range fields[1:] {
+		files = append(files, filepath.Join(fields[0], name))
+	}
+	return files, nil
+}
+
+var benchFuncRegexp = regexp.MustCompile(`^func (Benchmark\w*)\(\w+ \*testing\.B\)`)
+
+// listBenchmarks returns the benchmarks of pkg matching the -bench regular expression,
+// by scanning the package test files.
+func listBenchmarks(pkg, bench string) ([]string, error) {
+	files, err := testFiles(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	// go test matches the first element of the -bench pattern against top-level benchmarks.
+	topLevel, _, _ := strings.Cut(bench, "/")
+	re, err := regexp.Compile(topLevel)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -bench regular expression: %v", err)
+	}
+
+	var benchmarks []string
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if m := benchFuncRegexp.FindStringSubmatch(scanner.Text()); m != nil && re.MatchString(m[1]) {
+				benchmarks = append(benchmarks, m[1])
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return benchmarks, nil
+}
+
+// sourceLine is a line of a file, relative to the root of the git repository.
+type sourceLine struct {
+	path string
+	line int
+}
+
+// benchmarkLines returns the declarations of the benchmarks of pkg, by name.
+func benchmarkLines(pkg string) (map[string]sourceLine, error) {
+	files, err := testFiles(pkg)
+	if err != nil {
+		return nil, err
+	}
+	top, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to locate the git repository, %v", err)
+	}
+	root := strings.TrimSpace(string(top))
+
+	lines := make(map[string]sourceLine)
+	for _, file := range files {
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			if m := benchFuncRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+				lines[m[1]] = sourceLine{path: filepath.ToSlash(rel), line: n}
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// perfBudgetFile is the performance budget of a repository, at its root, enforced by the runs in
+// CI (or with -perf-budget). It uses the syntax of the configuration file:
+//
+//	# saved outputs (or bundles) to compare to, relative to the root of the repository
+//	baselines:
+//	  main: bench/main.txt
+//	# constraints on the median of each benchmark (without the -N suffix), comma-separated:
+//	# a maximum ns/op (1200, 1.2µs, 3ms) or a maximum regression from a baseline (+5% main)
+//	budgets:
+//	  BenchmarkHash: 1.2µs
+//	  BenchmarkSign/p256: 40µs, +5% main
+const perfBudgetFile = "perf-budget.yaml"
+
+// perfBudget is a constraint on the median ns/op of a benchmark.
+type perfBudget struct {
+	name     string
+	maxNs    float64 // maximum median, 0 if none
+	maxDelta float64 // maximum regression from baseline, in percent
+	baseline string  // "" if none
+}
+
+// parsePerfBudget returns the budgets of a budget file, and its baselines by name.
+func parsePerfBudget(r io.Reader) ([]perfBudget, map[string]string, error) {
+	c, err := parseConfig(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	for section := range c {
+		if section != "baselines" && section != "budgets" {
+			return nil, nil, fmt.Errorf("unknown section %q, expected baselines or budgets", section)
+		}
+	}
+	var budgets []perfBudget
+	for _, name := range sortedKeys(c["budgets"]) {
+		for _, item := range splitList(c["budgets"][name]) {
+			b := perfBudget{name: name}
+			if limit, baseline, ok := strings.Cut(item, " "); ok || strings.HasPrefix(item, "+") {
+				pct, found := strings.CutSuffix(strings.TrimPrefix(limit, "+"), "%")
+				delta, err := strconv.ParseFloat(pct, 64)
+				baseline = strings.TrimSpace(baseline)
+				if !found || err != nil || baseline == "" {
+					return nil, nil, fmt.Errorf("%s: invalid budget %q, expected +N%% <baseline>", name, item)
+				}
+				if _, ok := c["baselines"][baseline]; !ok {
+					return nil, nil, fmt.Errorf("%s: unknown baseline %q", name, baseline)
+				}
+				b.maxDelta, b.baseline = delta, baseline
+			} else if b.maxNs, err = parseNs(item); err != nil {
+				return nil, nil, fmt.Errorf("%s: invalid budget %q, expected a duration or ns/op", name, item)
+			}
+			budgets = append(budgets, b)
+		}
+	}
+	return budgets, c["baselines"], nil
+}
+
+// parseNs parses ns/op: a number, or a duration (1.2µs).
+func parseNs(s string) (float64, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return float64(d), nil
+}
+
+// checkPerfBudget returns the budgets violated by results, one line each; the benchmarks that
+// didn't run are ignored.
+func checkPerfBudget(budgets []perfBudget, baselines map[string]*benchResults, results *benchResults) []string {
+	medians := make(map[string]float64)
+	for _, name := range results.names {
+		if values := results.values(name, "ns/op"); len(values) > 0 {
+			medians[trimProcs(name)] = median(values)
+		}
+	}
+	baseMedian := func(base *benchResults, name string) float64 {
+		for _, n := range base.names {
+			if trimProcs(n) == name {
+				return median(base.values(n, "ns/op"))
+			}
+		}
+		return 0
+	}
+	var violations []string
+	for _, b := range budgets {
+		m, ok := medians[b.name]
+		if !ok {
+			continue
+		}
+		if b.baseline == "" {
+			if m > b.maxNs {
+				violations = append(violations, fmt.Sprintf("%s: %.4g ns/op, budget %.4g ns/op", b.name, m, b.maxNs))
+			}
+			continue
+		}
+		base := baseMedian(baselines[b.baseline], b.name)
+		if base == 0 {
+			continue
+		}
+		if delta := 100 * (m - base) / base; delta > b.maxDelta {
+			violations = append(violations, fmt.Sprintf("%s: %+.1f%% from %s (%.4g -> %.4g ns/op), budget +%g%%",
+				b.name, delta, b.baseline, base, m, b.maxDelta))
+		}
+	}
+	return violations
+}
+
+// perfBudgetPath returns the budget file enforced by the run: -perf-budget, or the one of the
+// repository in CI; "" if none.
+func perfBudgetPath() string {
+	if *perfBudgetFlag != "" {
+		return *perfBudgetFlag
+	}
+	if detectCI() == nil {
+		return ""
+	}
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(strings.TrimSpace(string(out)), perfBudgetFile)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// enforcePerfBudget checks the output of the run against the budget file at path and returns
+// the violations.
+func enforcePerfBudget(path, output string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the performance budget, %v", err)
+	}
+	defer f.Close()
+	budgets, baselineFiles, err := parsePerfBudget(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	baselines := make(map[string]*benchResults)
+	for name, file := range baselineFiles {
+		if !filepath.IsAbs(file) {
+			// relative to the budget file, at the root of the repository
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		if _, baselines[name], err = readReportResults(file); err != nil {
+			return nil, fmt.Errorf("%s: baseline %s: %v", path, name, err)
+		}
+	}
+	results := newBenchResults()
+	results.Write([]byte(output + "\n"))
+	if compared, _, ok := compareOutput(output); ok {
+		// the head of a -compare run
+		results = compared[1]
+	}
+	return checkPerfBudget(budgets, baselines, results), nil
+}
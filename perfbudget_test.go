@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePerfBudget(t *testing.T) {
+	budgets, baselines, err := parsePerfBudget(strings.NewReader(`baselines:
+  main: bench/main.txt
+budgets:
+  BenchmarkHash: 1.2µs
+  BenchmarkSign: 4000, +5% main
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baselines["main"] != "bench/main.txt" {
+		t.Errorf("baselines = %v", baselines)
+	}
+	want := []perfBudget{
+		{name: "BenchmarkHash", maxNs: 1200},
+		{name: "BenchmarkSign", maxNs: 4000},
+		{name: "BenchmarkSign", maxDelta: 5, baseline: "main"},
+	}
+	if len(budgets) != len(want) {
+		t.Fatalf("budgets = %+v, expected %+v", budgets, want)
+	}
+	for i := range want {
+		if budgets[i] != want[i] {
+			t.Errorf("budget %d = %+v, expected %+v", i, budgets[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{
+		"budgets:\n  BenchmarkA: fast\n",
+		"budgets:\n  BenchmarkA: +5% nightly\n",
+		"budgets:\n  BenchmarkA: +5 main\nbaselines:\n  main: m.txt\n",
+		"limits:\n  BenchmarkA: 1ms\n",
+	} {
+		if _, _, err := parsePerfBudget(strings.NewReader(bad)); err == nil {
+			t.Errorf("parsePerfBudget(%q) succeeded, expected an error", bad)
+		}
+	}
+}
+
+func TestCheckPerfBudget(t *testing.T) {
+	budgets := []perfBudget{
+		{name: "BenchmarkHash", maxNs: 1200},
+		{name: "BenchmarkSign", maxDelta: 5, baseline: "main"},
+		{name: "BenchmarkVerify", maxDelta: 5, baseline: "main"},
+		// not run
+		{name: "BenchmarkOther", maxNs: 1},
+	}
+	base := newBenchResults()
+	base.Write([]byte("BenchmarkSign-4 100 1000 ns/op\nBenchmarkVerify-4 100 2000 ns/op\n"))
+	results := newBenchResults()
+	results.Write([]byte("BenchmarkHash-8 100 1300 ns/op\nBenchmarkSign-8 100 1100 ns/op\nBenchmarkVerify-8 100 2050 ns/op\n"))
+
+	violations := checkPerfBudget(budgets, map[string]*benchResults{"main": base}, results)
+	if len(violations) != 2 || !strings.HasPrefix(violations[0], "BenchmarkHash: 1300 ns/op") || !strings.HasPrefix(violations[1], "BenchmarkSign: +10.0% from main") {
+		t.Errorf("violations = %q", violations)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	pricingtypes "github.com/aws/aws-sdk-go-v2/service/pricing/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// policyParameter is the SSM parameter holding the account policy, in the config file format:
+//
+//	allowed-types: t3.*, c7g.*, c7i.large
+//	max-price: 2.5
+//	exempt-users: arn:aws:iam::123456789012:user/alice, arn:aws:iam::123456789012:role/perf-admin
+//
+// The policy is enforced client-side. Admins, who can write the parameter, grant exceptions
+// with exempt-users: the ARNs of the callers (GetCallerIdentity), not their names, that anyone
+// assuming a role picks as its session name. The ARN of a role exempts all its sessions; an
+// assumed-role session ARN exempts one session, only trustworthy where the trust policy of the
+// role sets the session name (SSO).
+const policyParameter = "/rbench/policy"
+
+// accountPolicy restricts the instances users may launch.
+type accountPolicy struct {
+	allowedTypes []string // path.Match patterns, empty: any type
+	maxPrice     float64  // on-demand USD per hour, 0: no ceiling
+	exemptUsers  []string // caller ARNs
+}
+
+func parsePolicy(s string) (accountPolicy, error) {
+	c, err := parseConfig(strings.NewReader(s))
+	if err != nil {
+		return accountPolicy{}, err
+	}
+	var p accountPolicy
+	for key, value := range c[""] {
+		switch key {
+		case "allowed-types":
+			p.allowedTypes = splitList(value)
+		case "max-price":
+			if p.maxPrice, err = strconv.ParseFloat(value, 64); err != nil {
+				return accountPolicy{}, fmt.Errorf("invalid max-price %q", value)
+			}
+		case "exempt-users":
+			p.exemptUsers = splitList(value)
+		default:
+			return accountPolicy{}, fmt.Errorf("unknown policy key %q", key)
+		}
+	}
+	return p, nil
+}
+
+// splitList splits a comma-separated list.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// callerARNs returns the ARNs exempting a caller: its own, and for an assumed role the ARN of the
+// role:
+//
+//	arn:aws:sts::123456789012:assumed-role/perf-lab/alice -> arn:aws:iam::123456789012:role/perf-lab
+func callerARNs(arn string) []string {
+	arns := []string{arn}
+	parts := strings.Split(arn, ":")
+	if len(parts) == 6 && parts[2] == "sts" {
+		if rest, ok := strings.CutPrefix(parts[5], "assumed-role/"); ok {
+			if i := strings.LastIndex(rest, "/"); i > 0 {
+				arns = append(arns, strings.Join([]string{parts[0], parts[1], "iam", "", parts[4], "role/" + rest[:i]}, ":"))
+			}
+		}
+	}
+	return arns
+}
+
+// check returns an error if the caller (its ARN) may not launch instanceType; price returns its
+// on-demand price.
+func (p accountPolicy) check(instanceType, caller string, price func(string) (float64, error)) error {
+	if slices.ContainsFunc(callerARNs(caller), func(arn string) bool { return slices.Contains(p.exemptUsers, arn) }) {
+		return nil
+	}
+	override := "ask an admin to change the policy or to add " + caller + " to its exempt-users"
+	if len(p.allowedTypes) > 0 && !slices.ContainsFunc(p.allowedTypes, func(pattern string) bool {
+		ok, _ := path.Match(pattern, instanceType)
+		return ok
+	}) {
+		return fmt.Errorf("instance type %s is not allowed by the account policy %s (allowed: %s); %s",
+			instanceType, policyParameter, strings.Join(p.allowedTypes, ", "), override)
+	}
+	if p.maxPrice > 0 {
+		usd, err := price(instanceType)
+		if err != nil {
+			return err
+		}
+		if usd > p.maxPrice {
+			return fmt.Errorf("instance type %s costs $%.3f/hour, above the $%.3f/hour ceiling of the account policy %s; %s",
+				instanceType, usd, p.maxPrice, policyParameter, override)
+		}
+	}
+	return nil
+}
+
+// enforcePolicy checks -type against the account policy, if any.
+func enforcePolicy() error {
+	out, err := ssm.NewFromConfig(awsConfig).GetParameter(context.TODO(), &ssm.GetParameterInput{
+		Name: aws.String(policyParameter),
+	})
+	var notFound *ssmtypes.ParameterNotFound
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the account policy, %v", err)
+	}
+	p, err := parsePolicy(aws.ToString(out.Parameter.Value))
+	if err != nil {
+		return fmt.Errorf("invalid account policy %s: %v", policyParameter, err)
+	}
+	return p.check(*instanceType, awsCallerARN, onDemandPrice)
+}
+
+// onDemandPrice returns the on-demand price of a linux instance type in the current region, in USD per hour.
+func onDemandPrice(instanceType string) (float64, error) {
+	return regionOnDemandPrice(awsConfig.Region, instanceType)
+}
+
+// regionOnDemandPrice is like onDemandPrice, in region.
+func regionOnDemandPrice(region, instanceType string) (float64, error) {
+	cfg := awsConfig.Copy()
+	// the price list API is only served from a few regions
+	cfg.Region = "us-east-1"
+	filter := func(field, value string) pricingtypes.Filter {
+		return pricingtypes.Filter{Type: pricingtypes.FilterTypeTermMatch, Field: aws.String(field), Value: aws.String(value)}
+	}
+	out, err := pricing.NewFromConfig(cfg).GetProducts(context.TODO(), &pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		Filters: []pricingtypes.Filter{
+			filter("instanceType", instanceType),
+			filter("regionCode", region),
+			filter("operatingSystem", "Linux"),
+			filter("tenancy", "Shared"),
+			filter("preInstalledSw", "NA"),
+			filter("capacitystatus", "Used"),
+		},
+		MaxResults: aws.Int32(1),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get the price of %s, %v", instanceType, err)
+	}
+	for _, product := range out.PriceList {
+		var p struct {
+			Terms struct {
+				OnDemand map[string]struct {
+					PriceDimensions map[string]struct {
+						PricePerUnit map[string]string `json:"pricePerUnit"`
+					} `json:"priceDimensions"`
+				} `json:"OnDemand"`
+			} `json:"terms"`
+		}
+		if err := json.Unmarshal([]byte(product), &p); err != nil {
+			return 0, fmt.Errorf("unable to parse the price of %s, %v", instanceType, err)
+		}
+		for _, term := range p.Terms.OnDemand {
+			for _, dim := range term.PriceDimensions {
+				return strconv.ParseFloat(dim.PricePerUnit["USD"], 64)
+			}
+		}
+	}
+	return 0, fmt.Errorf("no on-demand price found for %s in %s", instanceType, region)
+}
+
+// runPlan is the planned run, passed as JSON on the stdin of the policy-hook of the configuration.
+type runPlan struct {
+	User         string            `json:"user"`
+	Account      string            `json:"account"`
+	Region       string            `json:"region"`
+	InstanceType string            `json:"instanceType"`
+	Instances    int               `json:"instances"`
+	PricePerHour float64           `json:"pricePerHour"` // on-demand, all instances, 0 if unknown
+	Tags         map[string]string `json:"tags"`
+	Args         []string          `json:"args"`
+}
+
+// enforcePolicyHook runs the policy-hook command of the configuration, if any, on the plan of
+// a run on n instances.
+func enforcePolicyHook(n int) error {
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	hook := c[""]["policy-hook"]
+	if hook == "" {
+		return nil
+	}
+	plan := runPlan{
+		User:         awsUserName,
+		Account:      awsAccountID,
+		Region:       awsConfig.Region,
+		InstanceType: *instanceType,
+		Instances:    n,
+		Tags:         map[string]string{"rbench": awsUserName},
+		Args:         os.Args[1:],
+	}
+	if usd, err := onDemandPrice(*instanceType); err == nil {
+		plan.PricePerHour = usd * float64(n)
+	}
+	return evaluatePolicyHook(hook, plan)
+}
+
+// evaluatePolicyHook runs the shell command hook with the plan on its stdin; the run is rejected
+// if it fails, with its output as the reason.
+func evaluatePolicyHook(hook string, plan runPlan) error {
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return fmt.Errorf("unable to run the policy hook %q, %v", hook, err)
+	}
+	reason := strings.TrimSpace(string(out))
+	if reason == "" {
+		reason = exitErr.Error()
+	}
+	return fmt.Errorf("run rejected by the policy hook: %s", reason)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAccountPolicy(t *testing.T) {
+	p, err := parsePolicy("allowed-types: t3.*, c7i.large\nmax-price: 0.5\nexempt-users: arn:aws:iam::123456789012:user/alice, arn:aws:iam::123456789012:role/perf-admin\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := map[string]float64{"t3.micro": 0.01, "t3.2xlarge": 0.33, "c7i.large": 0.09, "p4d.24xlarge": 32.77}
+	price := func(instanceType string) (float64, error) { return prices[instanceType], nil }
+	const bob = "arn:aws:iam::123456789012:user/bob"
+
+	for _, tt := range []struct {
+		instanceType, user string
+		allowed            bool
+	}{
+		{"t3.micro", bob, true},
+		{"c7i.large", bob, true},
+		{"c7i.xlarge", bob, false},
+		{"p4d.24xlarge", bob, false},
+		{"p4d.24xlarge", "arn:aws:iam::123456789012:user/alice", true},
+		// the session name is chosen by the caller
+		{"p4d.24xlarge", "arn:aws:sts::123456789012:assumed-role/perf-lab/alice", false},
+		{"p4d.24xlarge", "arn:aws:sts::123456789012:assumed-role/perf-admin/bob", true},
+	} {
+		err := p.check(tt.instanceType, tt.user, price)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s for %s: expected allowed=%t, got %v", tt.instanceType, tt.user, tt.allowed, err)
+		}
+	}
+
+	p.allowedTypes = nil
+	p.maxPrice = 0.1
+	if err := p.check("t3.2xlarge", bob, price); err == nil {
+		t.Error("expected the price ceiling to reject t3.2xlarge")
+	}
+
+	if _, err := parsePolicy("max-instances: 3\n"); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestPolicyHook(t *testing.T) {
+	const hook = `grep -q '"instanceType":"p4d' && { echo "GPU instances need an approval"; exit 1; } || exit 0`
+	if err := evaluatePolicyHook(hook, runPlan{InstanceType: "c7i.large", Instances: 1}); err != nil {
+		t.Errorf("expected c7i.large to be allowed, got %v", err)
+	}
+	err := evaluatePolicyHook(hook, runPlan{InstanceType: "p4d.24xlarge", Instances: 1})
+	if err == nil || !strings.Contains(err.Error(), "GPU instances need an approval") {
+		t.Errorf("expected the rejection reason, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// -cpuprofile writes the CPU profile of the remote runs, merged across invocations and instances,
+// and bundles it with -bundle; rbench pprof-diff compares the profiles of two runs.
+
+// remoteCPUProfile returns a new CPU profile path on the instance; each invocation of the benchmark
+// binary writes its own profile.
+func remoteCPUProfile() string {
+	return fmt.Sprintf("%s/rbench-cpu-%s.pprof", remoteTmp(), randString(7))
+}
+
+// cpuProfileDirs are the local directories the CPU profiles of the targets are downloaded to.
+var cpuProfileDirs struct {
+	sync.Mutex
+	dirs []string
+}
+
+// downloadCPUProfiles retrieves the CPU profiles written on the instance.
+func downloadCPUProfiles(r remote) error {
+	dir, err := os.MkdirTemp("", "rbench-cpu-")
+	if err != nil {
+		return fmt.Errorf("unable to create CPU profile directory, %v", err)
+	}
+	cpuProfileDirs.Lock()
+	cpuProfileDirs.dirs = append(cpuProfileDirs.dirs, dir)
+	cpuProfileDirs.Unlock()
+
+	if err := scpCopy("download CPU profiles", r.path(remoteTmp()+"/rbench-cpu-*.pprof"), dir); err != nil {
+		return fmt.Errorf("failed to download the CPU profiles: %w", err)
+	}
+	return nil
+}
+
+// writeCPUProfile merges the downloaded CPU profiles into path, with go tool pprof.
+func writeCPUProfile(path string) error {
+	cpuProfileDirs.Lock()
+	defer cpuProfileDirs.Unlock()
+
+	var profiles []string
+	for _, dir := range cpuProfileDirs.dirs {
+		defer os.RemoveAll(dir)
+		files, _ := filepath.Glob(filepath.Join(dir, "*.pprof"))
+		profiles = append(profiles, files...)
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("no CPU profile was retrieved")
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create CPU profile, %v", err)
+	}
+	cmd := exec.Command("go", append([]string{"tool", "pprof", "-proto"}, profiles...)...)
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = out, &stderr
+	if err := cmd.Run(); err != nil {
+		out.Close()
+		return fmt.Errorf("unable to merge the CPU profiles: %s, %v", strings.TrimSpace(stderr.String()), err)
+	}
+	return out.Close()
+}
+
+// runCPUProfile returns the CPU profile of a run: the profile of a bundle, or a profile file.
+func runCPUProfile(run string) ([]byte, error) {
+	if !strings.HasSuffix(run, bundleExt) {
+		return os.ReadFile(run)
+	}
+	m, files, err := readBundle(run)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range m.Files {
+		if e.Kind == "cpuprofile" {
+			return files[e.Name], nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no CPU profile in bundle (run with -cpuprofile and -bundle)", run)
+}
+
+// benchmarkFocus returns the pprof -focus expression of the samples of a benchmark: its function
+// and closures, sub-benchmarks included.
+func benchmarkFocus(name string) string {
+	name, _, _ = strings.Cut(trimProcs(name), "/")
+	return `\.` + regexp.QuoteMeta(name) + `(\.|$)`
+}
+
+// pprofDiffCmd implements "rbench pprof-diff <run-a> <run-b> [benchmark]": go tool pprof of the
+// CPU profile of run-b with the one of run-a as -diff_base, interactive or in the browser.
+func pprofDiffCmd(args []string) error {
+	fs := flag.NewFlagSet("pprof-diff", flag.ExitOnError)
+	web := fs.Bool("web", false, "open the web interface of pprof instead of the interactive terminal")
+	top := fs.Bool("top", false, "print the top functions of the difference and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench pprof-diff [flags] <run-a> <run-b> [benchmark]\n\nruns are .rbench bundles or CPU profiles\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 && fs.NArg() != 3 {
+		fs.Usage()
+		return fmt.Errorf("expected two runs")
+	}
+	if *web && *top {
+		return fmt.Errorf("-web and -top are exclusive")
+	}
+
+	tmp, err := os.MkdirTemp("", "rbench-pprof-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	var profiles [2]string
+	for i, run := range fs.Args()[:2] {
+		data, err := runCPUProfile(run)
+		if err != nil {
+			return err
+		}
+		profiles[i] = filepath.Join(tmp, fmt.Sprintf("%c.pprof", 'a'+i))
+		if err := os.WriteFile(profiles[i], data, 0600); err != nil {
+			return err
+		}
+	}
+
+	pprofArgs := []string{"tool", "pprof", "-diff_base=" + profiles[0]}
+	if fs.NArg() == 3 {
+		pprofArgs = append(pprofArgs, "-focus="+benchmarkFocus(fs.Arg(2)))
+	}
+	switch {
+	case *web:
+		pprofArgs = append(pprofArgs, "-http=localhost:0")
+	case *top:
+		pprofArgs = append(pprofArgs, "-top")
+	}
+	cmd := exec.Command("go", append(pprofArgs, profiles[1])...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestBenchmarkFocus(t *testing.T) {
+	re := regexp.MustCompile(benchmarkFocus("BenchmarkSign/p256-8"))
+	for frame, want := range map[string]bool{
+		"example.com/crypto.BenchmarkSign":         true,
+		"example.com/crypto.BenchmarkSign.func1":   true,
+		"example.com/crypto.BenchmarkSignBatch":    false,
+		"example.com/crypto.sign":                  false,
+		"example.com/crypto.BenchmarkVerify.func1": false,
+	} {
+		if got := re.MatchString(frame); got != want {
+			t.Errorf("%s: match %v, expected %v", frame, got, want)
+		}
+	}
+}
+
+func TestRunCPUProfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "run.rbench")
+	files := []artifact{
+		{"output.txt", "output", []byte("BenchmarkA-2 100 5 ns/op\n")},
+		{"cpuprofile/cpu.pprof", "cpuprofile", []byte("profile")},
+	}
+	if err := createBundle(path, manifest{}, files); err != nil {
+		t.Fatal(err)
+	}
+	data, err := runCPUProfile(path)
+	if err != nil || string(data) != "profile" {
+		t.Errorf("got %q, %v", data, err)
+	}
+
+	path = filepath.Join(dir, "noprofile.rbench")
+	if err := createBundle(path, manifest{}, files[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCPUProfile(path); err == nil {
+		t.Error("expected an error for a bundle without CPU profile")
+	}
+}
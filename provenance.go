@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// provenance is the record of a run written to -provenance: what was benchmarked, on which
+// machines, and what it output.
+type provenance struct {
+	Commit     string          `json:"commit"`
+	CommitTime string          `json:"commitTime,omitempty"`
+	RunStamp   string          `json:"runstamp"`
+	Args       []string        `json:"args"`
+	Runs       []provenanceRun `json:"runs"`
+}
+
+// provenanceRun is the run of a target: on an instance (its provider, type and image), or on a
+// registered host (-target).
+type provenanceRun struct {
+	Provider     string `json:"provider,omitempty"` // aws or gcp
+	InstanceID   string `json:"instanceId,omitempty"`
+	InstanceType string `json:"instanceType,omitempty"` // or machine type, on gcp
+	AMI          string `json:"ami,omitempty"`          // or image family, on gcp
+	Host         string `json:"host,omitempty"`
+	BinarySHA256 string `json:"binarySha256"`
+	// Identity is the EC2 instance identity document, IdentitySignature its PKCS7 signature by AWS.
+	Identity          string `json:"identity,omitempty"`
+	IdentitySignature string `json:"identitySignature,omitempty"`
+	Output            string `json:"output"`
+}
+
+// signedProvenance is the artifact: the provenance, as signed, and its signature.
+type signedProvenance struct {
+	Payload   []byte `json:"payload"`
+	Key       string `json:"key"` // "ed25519:<base64 public key>" or "kms:<key id>:<signing algorithm>"
+	Signature []byte `json:"signature"`
+}
+
+// provenanceRuns are the runs recorded by the targets.
+var provenanceRuns struct {
+	sync.Mutex
+	runs []provenanceRun
+}
+
+func addProvenanceRun(run provenanceRun) {
+	provenanceRuns.Lock()
+	defer provenanceRuns.Unlock()
+	provenanceRuns.runs = append(provenanceRuns.runs, run)
+}
+
+// recordProvenance records the run on target t for the provenance; failures are reported but
+// don't fail the run.
+func recordProvenance(t target, r remote, instanceID, binary, output string) {
+	run := provenanceRun{Output: output}
+	if t.host != "" {
+		run.Host = *targetFlag
+	} else {
+		run.Provider, run.InstanceID, run.InstanceType, run.AMI = *providerFlag, instanceID, *instanceType, t.ami
+	}
+	var err error
+	if run.BinarySHA256, err = fileSHA256(binary); err != nil {
+		slog.Warn(t.prefix() + err.Error())
+		return
+	}
+	if run.Provider == "aws" {
+		if run.Identity, run.IdentitySignature, err = readInstanceIdentity(r); err != nil {
+			slog.Warn(t.prefix() + err.Error())
+		}
+	}
+	addProvenanceRun(run)
+}
+
+// fileSHA256 returns the hex encoded sha256 of a file.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readInstanceIdentity returns the instance identity document and its signature, from the
+// instance metadata service (IMDSv2).
+func readInstanceIdentity(r remote) (document, signature string, err error) {
+	const imds = "http://169.254.169.254/latest"
+	out, err := sshRun(r, `TOKEN=$(curl -sf -X PUT `+imds+`/api/token -H 'X-aws-ec2-metadata-token-ttl-seconds: 60') && `+
+		`curl -sf -H "X-aws-ec2-metadata-token: $TOKEN" `+imds+`/dynamic/instance-identity/document && echo && echo --- && `+
+		`curl -sf -H "X-aws-ec2-metadata-token: $TOKEN" `+imds+`/dynamic/instance-identity/pkcs7`)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read the instance identity document, %v", err)
+	}
+	document, signature, _ = strings.Cut(out, "\n---\n")
+	return strings.TrimSpace(document), strings.TrimSpace(signature), nil
+}
+
+// writeProvenance signs the provenance of the run with -sign-key and writes it to path.
+func writeProvenance(path string, info runInfo) error {
+	provenanceRuns.Lock()
+	p := provenance{
+		Commit:     info.commitID,
+		CommitTime: info.commitTime,
+		RunStamp:   info.runStamp,
+		Args:       os.Args[1:],
+		Runs:       provenanceRuns.runs,
+	}
+	provenanceRuns.Unlock()
+	if len(p.Runs) == 0 {
+		return fmt.Errorf("no run to sign")
+	}
+
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	key, signature, err := signPayload(*signKey, payload)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(signedProvenance{Payload: payload, Key: key, Signature: signature}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("unable to write provenance, %v", err)
+	}
+	slog.Info("signed provenance written to " + path)
+	return nil
+}
+
+// signPayload signs payload with keySpec: "kms:<key id>" or the path of an ed25519 private key
+// (PKCS #8 PEM, e.g. from openssl genpkey -algorithm ed25519).
+func signPayload(keySpec string, payload []byte) (key string, signature []byte, err error) {
+	if keyID, ok := strings.CutPrefix(keySpec, "kms:"); ok {
+		client := kms.NewFromConfig(awsConfig)
+		pub, err := client.GetPublicKey(context.TODO(), &kms.GetPublicKeyInput{KeyId: aws.String(keyID)})
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to get KMS public key, %v", err)
+		}
+		if len(pub.SigningAlgorithms) == 0 {
+			return "", nil, fmt.Errorf("KMS key %s is not a signing key", keyID)
+		}
+		// SHA-256 if the key supports it; P-384 and P-521 keys only sign SHA-384 and SHA-512 digests
+		alg := pub.SigningAlgorithms[0]
+		for _, a := range pub.SigningAlgorithms {
+			if strings.HasSuffix(string(a), "_SHA_256") {
+				alg = a
+				break
+			}
+		}
+		digest, err := kmsDigest(alg, payload)
+		if err != nil {
+			return "", nil, err
+		}
+		out, err := client.Sign(context.TODO(), &kms.SignInput{
+			KeyId:            pub.KeyId,
+			Message:          digest,
+			MessageType:      kmstypes.MessageTypeDigest,
+			SigningAlgorithm: alg,
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to sign with KMS, %v", err)
+		}
+		return "kms:" + aws.ToString(pub.KeyId) + ":" + string(alg), out.Signature, nil
+	}
+
+	data, err := os.ReadFile(keySpec)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to read signing key, %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", nil, fmt.Errorf("%s is not a PEM file", keySpec)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to parse signing key, %v", err)
+	}
+	priv, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return "", nil, fmt.Errorf("%s is not an ed25519 key", keySpec)
+	}
+	pub := priv.Public().(ed25519.PublicKey)
+	return "ed25519:" + base64.StdEncoding.EncodeToString(pub), ed25519.Sign(priv, payload), nil
+}
+
+// kmsDigest returns the digest of payload signed with the KMS signing algorithm, named after
+// its hash (e.g. ECDSA_SHA_384).
+func kmsDigest(alg kmstypes.SigningAlgorithmSpec, payload []byte) ([]byte, error) {
+	var h hash.Hash
+	switch {
+	case strings.HasSuffix(string(alg), "_SHA_256"):
+		h = sha256.New()
+	case strings.HasSuffix(string(alg), "_SHA_384"):
+		h = sha512.New384()
+	case strings.HasSuffix(string(alg), "_SHA_512"):
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported KMS signing algorithm %s", alg)
+	}
+	h.Write(payload)
+	return h.Sum(nil), nil
+}
+
+// verifySignature checks the signature of the artifact; ed25519 signatures must be made by trusted if set.
+func verifySignature(a signedProvenance, trusted ed25519.PublicKey) error {
+	if rest, ok := strings.CutPrefix(a.Key, "kms:"); ok {
+		i := strings.LastIndexByte(rest, ':')
+		if i < 0 {
+			return fmt.Errorf("invalid key %q", a.Key)
+		}
+		digest, err := kmsDigest(kmstypes.SigningAlgorithmSpec(rest[i+1:]), a.Payload)
+		if err != nil {
+			return err
+		}
+		out, err := kms.NewFromConfig(awsConfig).Verify(context.TODO(), &kms.VerifyInput{
+			KeyId:            aws.String(rest[:i]),
+			Message:          digest,
+			MessageType:      kmstypes.MessageTypeDigest,
+			Signature:        a.Signature,
+			SigningAlgorithm: kmstypes.SigningAlgorithmSpec(rest[i+1:]),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to verify with KMS, %v", err)
+		}
+		if !out.SignatureValid {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+
+	encoded, ok := strings.CutPrefix(a.Key, "ed25519:")
+	if !ok {
+		return fmt.Errorf("unsupported key %q", a.Key)
+	}
+	pub, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid key %q", a.Key)
+	}
+	if trusted != nil && !bytes.Equal(pub, trusted) {
+		return fmt.Errorf("signed by an untrusted key")
+	}
+	if !ed25519.Verify(pub, a.Payload, a.Signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// verifyCmd implements "rbench verify [-pubkey file] <artifact>".
+func verifyCmd(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	pubKeyFile := fs.String("pubkey", "", "trusted ed25519 public key (PKIX PEM); without it, the key embedded in the artifact is used")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench verify [flags] <artifact>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected an artifact")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var a signedProvenance
+	if err := json.Unmarshal(data, &a); err != nil {
+		return fmt.Errorf("invalid artifact, %v", err)
+	}
+
+	var trusted ed25519.PublicKey
+	if *pubKeyFile != "" {
+		data, err := os.ReadFile(*pubKeyFile)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s is not a PEM file", *pubKeyFile)
+		}
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("unable to parse public key, %v", err)
+		}
+		if trusted, _ = k.(ed25519.PublicKey); trusted == nil {
+			return fmt.Errorf("%s is not an ed25519 key", *pubKeyFile)
+		}
+	} else if strings.HasPrefix(a.Key, "ed25519:") {
+		slog.Warn("no -pubkey: the signature only proves the artifact wasn't modified since it was signed with " + a.Key)
+	}
+	if strings.HasPrefix(a.Key, "kms:") {
+		if err := loadAWSConfig(); err != nil {
+			return err
+		}
+	}
+	if err := verifySignature(a, trusted); err != nil {
+		return err
+	}
+
+	var p provenance
+	if err := json.Unmarshal(a.Payload, &p); err != nil {
+		return fmt.Errorf("invalid provenance, %v", err)
+	}
+	fmt.Printf("signature ok (%s)\n", a.Key)
+	fmt.Printf("commit: %s\nruns: %d\n", p.Commit, len(p.Runs))
+	for _, run := range p.Runs {
+		fmt.Printf("  %s %s %s binary sha256 %s\n", run.InstanceID, run.InstanceType, run.AMI, run.BinarySHA256)
+	}
+	return nil
+}
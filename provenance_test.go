@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"commit":"abc"}`)
+	key, signature, err := signPayload(keyFile, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := signedProvenance{Payload: payload, Key: key, Signature: signature}
+	if err := verifySignature(a, pub); err != nil {
+		t.Fatalf("expected a valid signature, got %v", err)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if err := verifySignature(a, other); err == nil {
+		t.Error("expected an untrusted key error")
+	}
+	a.Payload = []byte(`{"commit":"abd"}`)
+	if err := verifySignature(a, nil); err == nil {
+		t.Error("expected an invalid signature for a modified payload")
+	}
+}
+
+func TestKMSDigest(t *testing.T) {
+	for alg, size := range map[kmstypes.SigningAlgorithmSpec]int{
+		kmstypes.SigningAlgorithmSpecEcdsaSha256:          32,
+		kmstypes.SigningAlgorithmSpecEcdsaSha384:          48,
+		kmstypes.SigningAlgorithmSpecEcdsaSha512:          64,
+		kmstypes.SigningAlgorithmSpecRsassaPssSha256:      32,
+		kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha512: 64,
+	} {
+		digest, err := kmsDigest(alg, []byte("payload"))
+		if err != nil || len(digest) != size {
+			t.Errorf("%s: got a %d bytes digest, %v; expected %d bytes", alg, len(digest), err, size)
+		}
+	}
+	if _, err := kmsDigest(kmstypes.SigningAlgorithmSpecSm2dsa, []byte("payload")); err == nil {
+		t.Error("expected an error for SM2DSA")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// provider launches and terminates the instances: EC2, or Compute Engine with -provider=gcp.
+// Once an instance is reachable, the upload and the run go through the same ssh/scp path.
+type provider interface {
+	// start launches an instance of image and returns its address and id once its ssh port
+	// is reachable. the instance is terminated if ctx is cancelled meanwhile.
+	start(ctx context.Context, image string) (host, id string, err error)
+	// terminate deletes the instance; see terminateInstance for the bookkeeping.
+	terminate(id string) error
+}
+
+// cloud is the provider of -provider.
+var cloud provider = ec2Provider{}
+
+type ec2Provider struct{}
+
+func (ec2Provider) start(ctx context.Context, ami string) (string, string, error) {
+	return startInstance(ctx, ami)
+}
+
+func (ec2Provider) terminate(instanceID string) error {
+	_, err := ec2Client.TerminateInstances(context.TODO(), &ec2.TerminateInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, eventuallyConsistent)
+	return err
+}
+
+// waitForSSHPort waits until the ssh port of a new instance accepts connections.
+func waitForSSHPort(ctx context.Context, host string) error {
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	for i := 0; i < 5; i++ {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(*sshPort)))
+		if err == nil {
+			conn.Close()
+			time.Sleep(5 * time.Second)
+			return nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return fmt.Errorf("unable to connect to instance")
+}
+
+// liveInstances are the instances launched and not terminated yet.
+var liveInstances sync.Map
+
+// terminateAllInstances terminates the instances launched by this run.
+func terminateAllInstances() {
+	liveInstances.Range(func(key, _ any) bool {
+		terminateInstance(key.(string))
+		return true
+	})
+}
+
+func terminateInstance(instanceID string) error {
+	if _, ok := liveInstances.LoadAndDelete(instanceID); !ok {
+		// already terminated
+		return nil
+	}
+	runCost.stop(instanceID, time.Now())
+	slog.Info("terminating instance " + instanceID)
+	if err := cloud.terminate(instanceID); err != nil {
+		err = fmt.Errorf("unable to terminate instance, %v", err)
+		slog.Error(err.Error())
+		return err
+	}
+	releaseSlotOf(instanceID)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// readyScript returns the shell script waiting for the -ready condition, empty for the default
+// (ssh port open, checked at launch):
+//
+//	cloud-init    cloud-init status --wait
+//	file:<path>   a marker file exists
+//	cmd:<command> a shell command exits 0
+func readyScript() (string, error) {
+	switch cond := *readyFlag; {
+	case cond == "port":
+		return "", nil
+	case cond == "cloud-init":
+		// images without cloud-init (e.g. alpine's tiny-cloud) are ready; errors reported by
+		// cloud-init don't prevent the run.
+		return "if command -v cloud-init >/dev/null; then cloud-init status --wait >/dev/null; fi; true", nil
+	case strings.HasPrefix(cond, "file:"):
+		return fmt.Sprintf("until [ -e %s ]; do sleep 2; done", shellQuote(strings.TrimPrefix(cond, "file:"))), nil
+	case strings.HasPrefix(cond, "cmd:"):
+		return fmt.Sprintf("until sh -c %s; do sleep 2; done", shellQuote(strings.TrimPrefix(cond, "cmd:"))), nil
+	default:
+		return "", fmt.Errorf("invalid -ready condition %q (port, cloud-init, file:<path> or cmd:<command>)", cond)
+	}
+}
+
+// waitReady waits for the -ready condition on the instance, concurrently with the upload, before
+// its setup; benchmarks started while cloud-init or apt still churn in the background have noisy
+// first repetitions.
+func waitReady(r remote) error {
+	script, err := readyScript()
+	if err != nil || script == "" {
+		return err
+	}
+	command := fmt.Sprintf("timeout %d sh -c %s", int(readyTimeout.Seconds()), shellQuote(script))
+	if _, err := sshRun(r, command); err != nil {
+		return fmt.Errorf("instance not ready (-ready=%s) after %s, %v", *readyFlag, *readyTimeout, err)
+	}
+	return nil
+}
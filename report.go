@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+// reportData is the data of a report template.
+type reportData struct {
+	Config     map[string]string // configuration lines of the results: instance-type, cpu, commit, ...
+	BaseConfig map[string]string // of the baseline, nil without one
+	Units      []string          // in order of appearance
+	Benchmarks []reportBenchmark // in order of appearance
+	Geomean    map[string]reportMetric
+	Impact     reportImpact // with -ops-per-day and a baseline
+}
+
+type reportBenchmark struct {
+	Name    string
+	Metrics map[string]reportMetric // by unit
+}
+
+// reportMetric summarizes the values of a benchmark in a unit: the median of the runs and
+// their spread (max deviation from the median, in percent), and the same for the baseline.
+type reportMetric struct {
+	Median  float64
+	Spread  float64
+	Runs    int
+	HasBase bool
+	Base    float64
+	Delta   float64 // change from Base, in percent
+}
+
+// reportFuncs are the functions available to the report templates.
+var reportFuncs = template.FuncMap{
+	// delta formats a change in percent: +1.2%
+	"delta": func(d float64) string { return fmt.Sprintf("%+.1f%%", d) },
+	// ns formats nanoseconds as a duration: 1.23µs
+	"ns": func(ns float64) string { return time.Duration(math.Round(ns)).String() },
+	// match reports whether s matches the regular expression
+	"match": func(expr, s string) (bool, error) { return regexp.MatchString(expr, s) },
+	// num formats a value with 4 significant digits, like the rbench summaries
+	"num": func(v float64) string { return fmt.Sprintf("%.4g", v) },
+}
+
+// newReportData summarizes results, compared to base if not nil.
+func newReportData(config map[string]string, results *benchResults, baseConfig map[string]string, base *benchResults) reportData {
+	d := reportData{Config: config, BaseConfig: baseConfig, Units: results.units(), Geomean: make(map[string]reportMetric)}
+	for _, name := range results.names {
+		b := reportBenchmark{Name: name, Metrics: make(map[string]reportMetric)}
+		for _, unit := range d.Units {
+			values := results.values(name, unit)
+			if len(values) == 0 {
+				continue
+			}
+			m := reportMetric{Median: median(values), Spread: spread(values), Runs: len(values)}
+			if base != nil {
+				if bv := base.values(name, unit); len(bv) > 0 {
+					m.HasBase, m.Base = true, median(bv)
+					if m.Base != 0 {
+						m.Delta = 100 * (m.Median - m.Base) / m.Base
+					}
+				}
+			}
+			b.Metrics[unit] = m
+		}
+		d.Benchmarks = append(d.Benchmarks, b)
+	}
+	// over the benchmarks present in both, with a baseline
+	for _, unit := range d.Units {
+		var medians, bases []float64
+		for _, b := range d.Benchmarks {
+			if m, ok := b.Metrics[unit]; ok && (base == nil || m.HasBase) {
+				medians = append(medians, m.Median)
+				bases = append(bases, m.Base)
+			}
+		}
+		if len(medians) == 0 {
+			continue
+		}
+		g := reportMetric{Median: geomean(medians), Runs: len(medians)}
+		if base != nil {
+			g.HasBase, g.Base = true, geomean(bases)
+			if g.Base != 0 {
+				g.Delta = 100 * (g.Median - g.Base) / g.Base
+			}
+		}
+		d.Geomean[unit] = g
+	}
+	return d
+}
+
+// readReportResults reads the configuration lines and the results of a saved output or bundle.
+func readReportResults(path string) (map[string]string, *benchResults, error) {
+	f, err := openResults(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read %s, %v", path, err)
+	}
+	config, err := readConfigLines(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read %s, %v", path, err)
+	}
+	results := newBenchResults()
+	results.Write(append(data, '\n'))
+	return config, results, nil
+}
+
+// reportCmd implements "rbench report": saved results, optionally compared to a baseline,
+// rendered through a user template (e.g. the performance section of release notes).
+func reportCmd(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	tmplFile := fs.String("template", "", "Go text/template file rendering the report (required without -ops-per-day)")
+	opsPerDay := fs.Float64("ops-per-day", 0, "with a baseline, project the ns/op changes at this many operations per day, in CPU-hours and dollars")
+	price := fs.Float64("price", 0, "with -ops-per-day, USD per hour of the instance (default: the on-demand price of the instance-type of the results)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench report [-template <file>] [-ops-per-day n] [baseline] <results>\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nthe template receives .Config, .BaseConfig, .Units, .Benchmarks (.Name, .Metrics by unit)\n"+
+			"and .Geomean (by unit); metrics have .Median, .Spread, .Runs, .HasBase, .Base and .Delta (%%).\n"+
+			"with -ops-per-day, .Impact has .OpsPerDay, .InstanceType, .Price, .VCPUs and .Benchmarks (.Name,\n"+
+			".Delta in ns/op, .CPUHours and .Dollars per day), also printed after the report.\n"+
+			"functions: delta (+1.2%%), ns (1.23µs), num (%%.4g) and match <regexp> <string>.\n")
+	}
+	fs.Parse(args)
+	if (*tmplFile == "" && *opsPerDay == 0) || fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return fmt.Errorf("expected a -template or -ops-per-day, and one or two result files")
+	}
+	if *opsPerDay > 0 && fs.NArg() != 2 {
+		return fmt.Errorf("-ops-per-day requires a baseline")
+	}
+	var tmpl *template.Template
+	if *tmplFile != "" {
+		src, err := os.ReadFile(*tmplFile)
+		if err != nil {
+			return err
+		}
+		tmpl, err = template.New(*tmplFile).Funcs(reportFuncs).Option("missingkey=zero").Parse(string(src))
+		if err != nil {
+			return fmt.Errorf("invalid template, %v", err)
+		}
+	}
+
+	config, results, err := readReportResults(fs.Arg(fs.NArg() - 1))
+	if err != nil {
+		return err
+	}
+	var baseConfig map[string]string
+	var base *benchResults
+	if fs.NArg() == 2 {
+		if baseConfig, base, err = readReportResults(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	if len(results.names) == 0 {
+		return fmt.Errorf("no benchmark results in %s", fs.Arg(fs.NArg()-1))
+	}
+	d := newReportData(config, results, baseConfig, base)
+	if *opsPerDay > 0 {
+		if *price == 0 && config["instance-type"] != "" {
+			if err := loadAWSConfig(); err == nil {
+				*price, err = onDemandPrice(config["instance-type"])
+				if err != nil {
+					slog.Warn(err.Error() + "; set -price for the cost")
+				}
+			}
+		}
+		d.Impact = newReportImpact(d, *opsPerDay, *price)
+	}
+	if tmpl != nil {
+		if err := tmpl.Execute(os.Stdout, d); err != nil {
+			return err
+		}
+	}
+	if *opsPerDay > 0 {
+		printImpact(os.Stdout, d.Impact)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	old := write("old.txt", `instance-type: c7i.large
+BenchmarkFFT-2 100 2000 ns/op
+BenchmarkFFT-2 100 2000 ns/op
+BenchmarkSort-2 100 500 ns/op
+`)
+	cur := write("new.txt", `instance-type: c7i.xlarge
+cpu: Intel(R) Xeon(R) Platinum 8488C
+BenchmarkFFT-2 100 1500 ns/op
+BenchmarkFFT-2 100 1500 ns/op
+BenchmarkSort-2 100 550 ns/op
+BenchmarkNew-2 100 10 ns/op
+`)
+	config, results, err := readReportResults(cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseConfig, base, err := readReportResults(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := template.Must(template.New("t").Funcs(reportFuncs).Parse(
+		`{{index .Config "cpu"}} on {{index .Config "instance-type"}} (was {{index .BaseConfig "instance-type"}})
+{{range .Benchmarks}}{{if match "FFT|Sort" .Name}}{{with index .Metrics "ns/op"}}- {{ns .Median}} {{if .HasBase}}{{delta .Delta}}{{end}}
+{{end}}{{end}}{{end}}geomean {{delta (index .Geomean "ns/op").Delta}}
+`))
+	var b strings.Builder
+	if err := tmpl.Execute(&b, newReportData(config, results, baseConfig, base)); err != nil {
+		t.Fatal(err)
+	}
+	// geomean over FFT and Sort: sqrt(1500*550)/sqrt(2000*500)-1
+	want := `Intel(R) Xeon(R) Platinum 8488C on c7i.xlarge (was c7i.large)
+- 1.5µs -25.0%
+- 550ns +10.0%
+geomean -9.2%
+`
+	if b.String() != want {
+		t.Errorf("unexpected report:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,767 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// target is an instance the benchmark runs on.
+type target struct {
+	label  string // os name in -os matrix mode, empty otherwise
+	ami    string // or image family, with -provider=gcp
+	user   string // ssh user
+	static bool   // needs a statically linked binary
+	host   string // address of a registered host (-target); no instance is launched
+
+	shard      int      // 1-based shard of the benchmarks with -shards, 0 otherwise
+	benchmarks []string // of the shard
+}
+
+// status logs a transient status line, prefixed with the target label in matrix mode.
+func (t target) status(format string, args ...any) {
+	statusf(t.prefix()+format, args...)
+}
+
+func (t target) prefix() string {
+	label := t.label
+	if t.shard > 0 {
+		label = strings.TrimSpace(fmt.Sprintf("%s shard %d/%d", label, t.shard, *shardsFlag))
+	}
+	if label == "" {
+		return ""
+	}
+	return "[" + label + "] "
+}
+
+// remote is an ssh destination.
+type remote struct {
+	user string
+	host string
+	cpus string // default -test.cpu list, see setupCPUs
+	pin  string // cpus the benchmark is pinned to (taskset list), see setupCoreClasses
+
+	bench string // -test.bench pattern of the shard run on r, see benchPattern
+	bin   string // test binary run on r, in the working directory (default ./bench), see runCompare
+	dir   string // directory the benchmark runs from, if not the working directory, see setupAssets
+
+	env []string // of the benchmark on a node of a multi-node run, see nodeGroup.setup
+
+	agent *agentClient // running the benchmark instead of ssh, with -agent
+}
+
+// binary returns the test binary run on r, from r.runDir().
+func (r remote) binary() string {
+	bin := r.bin
+	if bin == "" {
+		bin = "./bench"
+	}
+	if r.dir != "" {
+		bin = remoteWorkDir() + "/" + strings.TrimPrefix(bin, "./")
+	}
+	return bin
+}
+
+// runDir returns the directory the benchmark runs from on r.
+func (r remote) runDir() string {
+	if r.dir != "" {
+		return r.dir
+	}
+	return remoteWorkDir()
+}
+
+func (r remote) String() string {
+	return r.user + "@" + r.host
+}
+
+// path returns the scp location of path on r; IPv6 addresses are bracketed.
+func (r remote) path(path string) string {
+	if strings.Contains(r.host, ":") {
+		return r.user + "@[" + r.host + "]:" + path
+	}
+	return r.String() + ":" + path
+}
+
+// runInfo is the run-wide metadata written in the header of each result.
+type runInfo struct {
+	commitID   string
+	commitTime string
+	runStamp   string
+	tune       []string
+	toggles    []tuneSetting // -thp, -numa-balancing
+	benchmarks []string      // benchmarks matching -bench, in source order
+	local      *localRun     // nil without -with-local
+	worktree   string        // -stash-run worktree the binary is built in, if any
+
+	budgetViolations []string // of the perf-budget.yaml budgets, after the run
+}
+
+// binaries are the benchmark binaries, built in the background while the instances start.
+type binaries struct {
+	done    chan struct{}
+	files   map[bool]string        // static -> file name
+	slices  []sliceBinary          // with -slices, instead of files
+	compare map[bool][]sliceBinary // with -compare, the base and the head by static, instead of files
+	delve   string                 // dlv binary, with -debug
+	agent   string                 // rbench binary, with -agent
+	calib   string                 // calibration suite binary, with -calibrate
+	assets  string                 // archive of the package assets, if any
+	pkgDir  string                 // directory of the package in the assets, relative to its module
+	err     error
+}
+
+// buildBinaries compiles the binaries needed by the targets; onError is called as soon as a build fails.
+func buildBinaries(arch instanceArch, targets []target, onError func()) *binaries {
+	b := &binaries{done: make(chan struct{}), files: make(map[bool]string), compare: make(map[bool][]sliceBinary)}
+	variants := make(map[bool]bool)
+	for _, t := range targets {
+		variants[t.static] = true
+	}
+	go func() {
+		defer close(b.done)
+		if *slicesFlag != "" {
+			// a single target
+			jobs, _ := parseSlices(*slicesFlag)
+			if b.slices, b.err = buildSlices(arch, jobs, targets[0].static); b.err != nil {
+				onError()
+			}
+			return
+		}
+		for static := range variants {
+			if *compareFlag != "" {
+				jobs, _ := parseCompare(*compareFlag)
+				if b.compare[static], b.err = buildSlices(arch, jobs, static); b.err != nil {
+					onError()
+					return
+				}
+				continue
+			}
+			fileName, err := compileBenchmarkBinary(arch, static)
+			if err != nil {
+				b.err = err
+				onError()
+				return
+			}
+			b.files[static] = fileName
+		}
+		if shipAssets() {
+			if b.assets, b.pkgDir, b.err = packAssets(buildDir, benchPackage, splitList(*uploadFlag)); b.err != nil {
+				onError()
+				return
+			}
+		}
+		if *calibrateFlag {
+			if b.calib, b.err = buildCalibration(arch); b.err != nil {
+				onError()
+				return
+			}
+		}
+		if *debugFlag != "" {
+			if b.delve, b.err = buildDelve(arch); b.err != nil {
+				onError()
+				return
+			}
+		}
+		if *agentFlag {
+			if b.agent, b.err = crossInstall(arch, rbenchPackage(), "rbench"); b.err != nil {
+				onError()
+			}
+		}
+	}()
+	return b
+}
+
+// get waits for the builds and returns the binary for the target.
+func (b *binaries) get(t target) (string, error) {
+	<-b.done
+	return b.files[t.static], b.err
+}
+
+// runOnTarget starts the target instance, runs the benchmark on it and writes the results to out.
+// the instance is terminated when done, unless kept (-keep); a kept instance is reused if any.
+func runOnTarget(ctx context.Context, t target, info runInfo, bins *binaries, out io.Writer) error {
+	publicIP, instanceID := t.host, ""
+	var nodes *nodeGroup
+	if t.host == "" && *nodesFlag > 1 {
+		// launched with the first node
+		nodes = startNodes(ctx, t, *nodesFlag-1)
+		defer nodes.release()
+	}
+	if t.host == "" {
+		var reused bool
+		if publicIP, instanceID, reused = reuseKept(t); !reused {
+			var err error
+			publicIP, instanceID, err = cloud.start(ctx, t.ami)
+			if err != nil {
+				return err
+			}
+		}
+		// terminated, or kept with -keep
+		defer releaseInstance(t, publicIP, instanceID)
+	}
+
+	r := remote{user: t.user, host: publicIP}
+	// the commands restoring the kernel settings of a registered host, see restoreHost
+	var restore []string
+	if t.host != "" {
+		if err := setupHostRun(r); err != nil {
+			return err
+		}
+		defer func() { restoreHost(t, r, restore) }()
+	}
+	benchmarks := info.benchmarks
+	if t.shard > 0 {
+		r.bench, benchmarks = shardPattern(t.benchmarks), t.benchmarks
+	}
+	// the -ready condition is waited for while the binaries build and upload
+	ready := make(chan error, 1)
+	if *readyFlag != "port" {
+		t.status("ssh ready (%s). waiting for %s...", publicIP, *readyFlag)
+		go func() { ready <- waitReady(r) }()
+	} else {
+		ready <- nil
+	}
+	if *mitigationsOff {
+		// the reboot waits for the instance to be ready, the uploads for the reboot
+		if err := <-ready; err != nil {
+			return err
+		}
+		ready <- nil
+		t.status("ssh ready (%s). rebooting with mitigations=off...", publicIP)
+		if err := disableMitigations(r); err != nil {
+			return err
+		}
+	}
+
+	benchFileName, err := bins.get(t)
+	if err != nil {
+		return err
+	}
+
+	t.status("ssh ready (%s). uploading benchmark binary...", publicIP)
+	uploads := []*upload{{local: benchFileName, remote: remoteTmp() + "/bench"}}
+	if len(bins.slices) > 0 {
+		uploads = nil
+		var dirs []string
+		for i, b := range bins.slices {
+			dirs = append(dirs, sliceDir(i))
+			uploads = append(uploads, &upload{local: b.file, remote: sliceDir(i) + "/bench"})
+		}
+		if _, err := sshRun(r, "mkdir -p "+strings.Join(dirs, " ")); err != nil {
+			return err
+		}
+	}
+	compareBins := bins.compare[t.static]
+	if len(compareBins) > 0 {
+		uploads = nil
+		for i, b := range compareBins {
+			uploads = append(uploads, &upload{local: b.file, remote: remoteTmp() + "/" + compareFiles[i]})
+		}
+		// the warmup passes run the head
+		r.bin = "./" + compareFiles[1]
+	}
+	if *debugFlag != "" {
+		uploads = append(uploads, &upload{local: bins.delve, remote: remoteDelve()})
+	}
+	if bins.agent != "" {
+		uploads = append(uploads, &upload{local: bins.agent, remote: remoteAgent()})
+	}
+	if bins.calib != "" {
+		uploads = append(uploads, &upload{local: bins.calib, remote: remoteCalibration()})
+	}
+	if bins.assets != "" {
+		uploads = append(uploads, &upload{local: bins.assets, remote: remoteAssets()})
+	}
+	if err := uploadFiles(t, r, uploads); err != nil {
+		return err
+	}
+	var nodeLines []string
+	if nodes != nil {
+		t.status("ssh ready (%s). setting up the other nodes...", publicIP)
+		if nodeLines, err = nodes.setup(t, &r, instanceID, uploads, bins); err != nil {
+			return err
+		}
+	}
+	if err := <-ready; err != nil {
+		return err
+	}
+
+	if *debugFlag != "" {
+		return debugSession(t, r)
+	}
+
+	if bins.agent != "" {
+		t.status("ssh ready (%s). starting the agent...", publicIP)
+		if r.agent, err = startAgent(t, r); err != nil {
+			return err
+		}
+		defer r.agent.close()
+	}
+
+	var tuneLines []string
+	if len(info.tune) > 0 || len(info.toggles) > 0 {
+		t.status("tuning the kernel...")
+		var tuneRestore []string
+		tuneLines, tuneRestore, err = applyTune(r, info.tune, info.toggles)
+		restore = append(restore, tuneRestore...)
+		if err != nil {
+			return err
+		}
+	}
+
+	virtLines, err := readVirtualization(t, r)
+	if err != nil {
+		if *requireMetal {
+			return err
+		}
+		slog.Warn(t.prefix() + err.Error())
+	}
+
+	if *coreDumps {
+		coreRestore, err := enableCoreDumps(r)
+		if err != nil {
+			return err
+		}
+		restore = append(restore, coreRestore)
+	}
+
+	envLines, err := readEnvironment(r)
+	if err != nil {
+		slog.Warn(t.prefix() + err.Error())
+	}
+
+	clockLines, clockRestore, err := setupClock(r)
+	if clockRestore != "" {
+		restore = append(restore, clockRestore)
+	}
+	if err != nil {
+		if clockLines == nil && *clockSource != "" {
+			return err
+		}
+		// not fatal, the state is recorded.
+		slog.Warn(t.prefix() + err.Error())
+	}
+
+	networkLines, err := setupNetworking(r)
+	if err != nil {
+		return err
+	}
+
+	mitigationLines, err := readMitigations(r)
+	if err != nil {
+		// not fatal, the kernel may not expose it.
+		slog.Warn(t.prefix() + err.Error())
+	}
+
+	var wasmLine string
+	if *wasmFlag != "" {
+		t.status("installing %s...", *wasmFlag)
+		if wasmLine, err = setupWasm(r); err != nil {
+			return err
+		}
+	}
+
+	coreLines, err := setupCoreClasses(t, &r)
+	if err != nil {
+		if *coreClass != "" {
+			return err
+		}
+		slog.Warn(t.prefix() + err.Error())
+	}
+	cpuLines, err := setupCPUs(t, &r)
+	if err != nil {
+		slog.Warn(t.prefix() + err.Error())
+	}
+	cpuLines = append(cpuLines, coreLines...)
+
+	var tmpfsLine string
+	if *tmpfsFlag != "" {
+		if tmpfsLine, err = setupTmpfs(t, r); err != nil {
+			return err
+		}
+	}
+
+	if bins.assets != "" {
+		// in the working directory, on the tmpfs with -tmpfs
+		if r.dir, err = setupAssets(r, bins.pkgDir); err != nil {
+			return err
+		}
+	}
+
+	if *benchPolicy != "" {
+		if err := setupBenchCredentials(t, r, instanceID); err != nil {
+			return err
+		}
+		defer removeBenchCredentials(t, r)
+	}
+
+	var confidentialLines []string
+	if *confidential != "" {
+		t.status("setting up %s...", *confidential)
+		// the enclave image embeds the arguments of the run, see sshExec
+		env := []string{fmt.Sprintf("%s=%d", seedEnv, *seedFlag)}
+		if confidentialLines, err = setupConfidential(r, benchTestArgs(r, benchPattern(r), *countFlag), env); err != nil {
+			return err
+		}
+	}
+
+	if *warmupPasses > 0 || *warmupCmd != "" {
+		t.status("warming up...")
+		if err := warmup(r); err != nil {
+			return err
+		}
+	}
+
+	var calibration, calibrationState string
+	if bins.calib != "" {
+		t.status("calibrating...")
+		if calibration, err = runCalibration(r); err != nil {
+			slog.Warn(t.prefix() + err.Error())
+		} else {
+			calibrationState = checkTargetCalibration(t, calibration)
+		}
+	}
+
+	slog.Info(t.prefix() + "running benchmark...")
+	var conv *test2json
+	if *jsonFlag {
+		// everything written to out, including the header, becomes a test2json event.
+		if conv, err = startTest2JSON(out); err != nil {
+			return err
+		}
+		out = conv
+	}
+	var record strings.Builder
+	if *provenanceFile != "" {
+		out = io.MultiWriter(out, &record)
+	}
+	linker := newSourceLinker(out, info.worktree)
+	if linker != nil {
+		out = linker
+	}
+
+	// write header; these are benchfmt configuration lines (key: value, no spaces in keys)
+	// so the output can be fed to benchstat / benchseries as is.
+	if t.host != "" {
+		fmt.Fprintf(out, "host: %s\n", *targetFlag)
+		fmt.Fprintf(out, "instance-ip: %s\n", publicIP)
+	} else if *providerFlag == "gcp" {
+		fmt.Fprintf(out, "gcp-project: %s\n", *gcpProject)
+		fmt.Fprintf(out, "gcp-zone: %s\n", *gcpZone)
+		fmt.Fprintf(out, "image: %s/%s\n", gcpImageProject, t.ami)
+		fmt.Fprintf(out, "instance-ip: %s\n", publicIP)
+		fmt.Fprintf(out, "instance-type: %s\n", *instanceType)
+	} else {
+		fmt.Fprintf(out, "ec2-user: %s\n", awsUserName)
+		fmt.Fprintf(out, "aws-account: %s\n", awsAccountID)
+		fmt.Fprintf(out, "instance-ip: %s\n", publicIP)
+		fmt.Fprintf(out, "instance-type: %s\n", *instanceType)
+		fmt.Fprintf(out, "ami: %s\n", t.ami)
+	}
+	if t.label != "" {
+		fmt.Fprintf(out, "os: %s\n", t.label)
+	}
+	if t.shard > 0 {
+		fmt.Fprintf(out, "shard: %d/%d\n", t.shard, *shardsFlag)
+	}
+	if *labelFlag != "" {
+		fmt.Fprintf(out, "label: %s\n", *labelFlag)
+	}
+	fmt.Fprintf(out, "commit: %s\n", info.commitID)
+	if info.commitTime != "" {
+		fmt.Fprintf(out, "commit-time: %s\n", info.commitTime)
+	}
+	fmt.Fprintf(out, "runstamp: %s\n", info.runStamp)
+	fmt.Fprintf(out, "seed: %d\n", *seedFlag)
+	fmt.Fprintln(out, flagsLine(flag.CommandLine))
+	if *shuffleFlag != "off" {
+		fmt.Fprintf(out, "shuffle: %s\n", *shuffleFlag)
+	}
+	if *benchTime != "" {
+		fmt.Fprintf(out, "benchtime: %s\n", *benchTime)
+	}
+	if *budgetTime > 0 {
+		fmt.Fprintf(out, "budget-time: %s\n", *budgetTime)
+	}
+	if *readyFlag != "port" {
+		fmt.Fprintf(out, "ready: %s\n", *readyFlag)
+	}
+	if *warmupCmd != "" {
+		fmt.Fprintf(out, "warmup-cmd: %s\n", *warmupCmd)
+	} else if *warmupPasses > 0 {
+		fmt.Fprintf(out, "warmup: %d\n", *warmupPasses)
+	}
+	for _, l := range envLines {
+		fmt.Fprintln(out, l)
+	}
+	for _, l := range tuneLines {
+		fmt.Fprintln(out, l)
+	}
+	if !*aslr {
+		fmt.Fprintf(out, "aslr: off\n")
+	}
+	for _, l := range virtLines {
+		fmt.Fprintln(out, l)
+	}
+	for _, l := range cpuLines {
+		fmt.Fprintln(out, l)
+	}
+	for _, l := range clockLines {
+		fmt.Fprintln(out, l)
+	}
+	for _, l := range mitigationLines {
+		fmt.Fprintln(out, l)
+	}
+	for _, l := range networkLines {
+		fmt.Fprintln(out, l)
+	}
+	for _, l := range nodeLines {
+		fmt.Fprintln(out, l)
+	}
+	if tmpfsLine != "" {
+		fmt.Fprintln(out, tmpfsLine)
+	}
+	if wasmLine != "" {
+		fmt.Fprintln(out, wasmLine)
+	}
+	for _, l := range confidentialLines {
+		fmt.Fprintln(out, l)
+	}
+	if calibration != "" {
+		// next to the results, e.g. to normalize them with benchstat
+		fmt.Fprintf(out, "calibration: %s\n%s", calibrationState, calibration)
+	}
+
+	gpuMonitor := false
+	var clockOffset time.Duration
+	if instanceHasGPU() {
+		if clockOffset, err = remoteClockOffset(r); err != nil {
+			slog.Warn(t.prefix() + err.Error())
+		} else if gpuMonitor, err = startGPUMonitor(r); err != nil {
+			slog.Warn(t.prefix() + err.Error())
+		} else if !gpuMonitor {
+			slog.Warn(t.prefix() + "nvidia-smi not found on the instance, GPU metrics won't be collected")
+		}
+	}
+
+	stealMonitor := true
+	if err := startStealMonitor(r); err != nil {
+		slog.Warn(t.prefix() + err.Error())
+		stealMonitor = false
+	}
+
+	// execute the benchmark
+	activeRemotes.Store(r.host, r)
+	results := newBenchResults()
+	results.onResult = func(name string) {
+		// live aggregate, to eyeball stability mid-run
+		values := results.values(name, "ns/op")
+		t.status("%s: %.4g ns/op ±%.1f%% (%d/%d)", name, median(values), spread(values), len(values), *countFlag)
+		stopIfFinished(r, results, name)
+	}
+	start := time.Now()
+	gogc := splitList(*gogcFlag)
+	sweepResults := make(map[string]*benchResults)
+	compareResults := [2]*benchResults{newBenchResults(), newBenchResults()}
+	switch {
+	case len(bins.slices) > 0:
+		err = runSlices(t, r, out, bins.slices)
+	case len(compareBins) > 0:
+		for i, cr := range compareResults {
+			cr.onResult = func(name string) {
+				values := cr.values(name, "ns/op")
+				t.status("%s %s: %.4g ns/op ±%.1f%% (%d/%d)", name, compareRef(compareBins[i]), median(values), spread(values), len(values), *countFlag)
+				stopIfFinished(r, cr, name)
+			}
+		}
+		err = runCompare(r, out, compareBins, compareResults)
+	case len(gogc) > 0:
+		for _, v := range gogc {
+			vr := newBenchResults()
+			vr.onResult = func(name string) {
+				values := vr.values(name, "ns/op")
+				t.status("%s gogc=%s: %.4g ns/op ±%.1f%% (%d/%d)", name, v, median(values), spread(values), len(values), *countFlag)
+				stopIfFinished(r, vr, name)
+			}
+			sweepResults[v] = vr
+		}
+		err = runSweep(r, out, gogc, sweepResults)
+		results = mergeSweep(gogc, sweepResults)
+	case *budgetTime > 0:
+		err = runWithBudget(r, out, results, benchmarks)
+	default:
+		if nodes != nil {
+			nodes.run(benchPattern(r), *countFlag)
+		}
+		if !*timeTests || *benchFlag != "NONE" {
+			err = sshExec(r, out, results, benchPattern(r), *countFlag)
+		}
+		if nodes != nil {
+			if nerr := nodes.wait(); nerr != nil && err == nil {
+				err = nerr
+			}
+		}
+		if err == nil && *timeTests {
+			err = runTimedTests(t, r, out, results)
+		}
+	}
+	activeRemotes.Delete(r.host)
+	if r.agent != nil {
+		if m, merr := r.agent.metrics(); merr != nil {
+			slog.Warn(t.prefix() + fmt.Sprintf("unable to read the metrics of the instance, %v", merr))
+		} else {
+			slog.Info(t.prefix() + "after the benchmark: " + m.String())
+		}
+	}
+	if *coreDumps && err != nil {
+		name := instanceID
+		if t.host != "" {
+			name = *targetFlag
+		}
+		if cerr := collectCoreDumps(r, benchFileName, name); cerr != nil {
+			slog.Warn(t.prefix() + cerr.Error())
+		}
+	}
+	if *tmpfsFlag != "" {
+		checkTmpfsUsage(t, r)
+	}
+	if *coverProfile != "" {
+		// also on failures: the profile covers the tests that ran.
+		if cerr := downloadCoverProfiles(r); cerr != nil {
+			slog.Warn(t.prefix() + cerr.Error())
+		}
+	}
+	if *cpuProfile != "" {
+		if cerr := downloadCPUProfiles(r); cerr != nil {
+			slog.Warn(t.prefix() + cerr.Error())
+		}
+	}
+	if gpuMonitor {
+		if samples, err := stopGPUMonitor(r); err != nil {
+			slog.Warn(t.prefix() + err.Error())
+		} else {
+			printGPUSummary(out, results, samples, start, clockOffset)
+		}
+	}
+	var highSteal error
+	if stealMonitor {
+		if noise, serr := stopStealMonitor(r); serr != nil {
+			slog.Warn(t.prefix() + serr.Error())
+		} else if q := reportQuality(out, t, r, noise, results); *maxSteal > 0 && q.Steal > *maxSteal {
+			slog.Warn(fmt.Sprintf("%ssteal time %.1f%% above -max-steal=%g: other tenants competed for the CPU, the results are unreliable",
+				t.prefix(), q.Steal, *maxSteal))
+			highSteal = fmt.Errorf("%w: %.1f%%", errHighSteal, q.Steal)
+		}
+	}
+	if *provenanceFile != "" && err == nil {
+		recordProvenance(t, r, instanceID, benchFileName, record.String())
+	}
+	if *gcStats {
+		if trace, terr := sshRun(r, "cat "+remoteGCTrace()); terr != nil {
+			slog.Warn(t.prefix() + terr.Error())
+		} else {
+			events, other := parseGCTrace(trace)
+			for _, l := range other {
+				fmt.Fprintln(os.Stderr, t.prefix()+l)
+			}
+			printGCSummary(out, results, events, start)
+		}
+	}
+	noise := machineNoise(historyMachine(t))
+	printNoiseReport(out, results, *noiseThreshold, noise)
+	if *historyFlag && err == nil && highSteal == nil {
+		recordHistory(t, info, results)
+	}
+	if len(gogc) > 0 {
+		printSweepSummary(out, gogc, sweepResults)
+	}
+	if len(compareBins) > 0 {
+		printCompareSummary(out, compareBins, compareResults, noise)
+	}
+	if info.local != nil {
+		t.status("waiting for the local run...")
+		<-info.local.done
+		if info.local.err != nil {
+			slog.Warn(info.local.err.Error())
+		} else {
+			printLocalComparison(out, info.local.results, results)
+		}
+	}
+	if linker != nil {
+		linker.Flush()
+	}
+	if conv != nil {
+		if cerr := conv.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if failed := conv.events.failures(); len(failed) > 0 {
+			slog.Warn(fmt.Sprintf("%s%d failed: %s", t.prefix(), len(failed), strings.Join(failed, ", ")))
+		}
+	}
+	if err == nil && highSteal != nil && *stealRetries > 0 && t.host == "" {
+		// see runTargets
+		err = highSteal
+	}
+	return err
+}
+
+// runOutput records the results written to stdout, for -bundle, -s3 and the CI summary.
+var runOutput lockedBuilder
+
+// runTargets runs the benchmark on all targets concurrently. With a single target, the output
+// is streamed; otherwise, each target output is printed as a block once done.
+func runTargets(ctx context.Context, targets []target, info runInfo, bins *binaries) {
+	var (
+		wg       sync.WaitGroup
+		outputMu sync.Mutex
+		stdout   io.Writer = os.Stdout
+	)
+	if *bundleFile != "" || *s3URL != "" || detectCI() != nil {
+		stdout = io.MultiWriter(os.Stdout, &runOutput)
+	}
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+			var buf strings.Builder
+			var out io.Writer = &buf
+			// the output of a run retried for its steal time is discarded
+			buffered := len(targets) > 1 || *stealRetries > 0
+			if !buffered {
+				out = statusClearingWriter{stdout}
+			}
+			err := runOnTarget(ctx, t, info, bins, out)
+			for attempt := 1; errors.Is(err, errHighSteal) && attempt <= *stealRetries && ctx.Err() == nil; attempt++ {
+				slog.Warn(fmt.Sprintf("%srerunning on a fresh instance (%d/%d)", t.prefix(), attempt, *stealRetries))
+				buf.Reset()
+				err = runOnTarget(ctx, t, info, bins, out)
+			}
+			if errors.Is(err, errHighSteal) {
+				// out of retries: the results are kept, with their quality score
+				err = nil
+			}
+
+			outputMu.Lock()
+			defer outputMu.Unlock()
+			if buffered {
+				stderrTerminal.clearStatus()
+				fmt.Fprintln(stdout, buf.String())
+			}
+			// if the context was cancelled, the failure is reported by the caller.
+			switch {
+			case err != nil && interrupted.Load():
+				slog.Warn(t.prefix() + "stopped on interrupt, the results are partial")
+			case err != nil && ctx.Err() == nil:
+				slog.Error(t.prefix() + err.Error())
+			}
+		}(t)
+	}
+	wg.Wait()
+}
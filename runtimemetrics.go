@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// -runtime-metrics adds a TestMain to the test binary, with go test -overlay, which snapshots
+// runtime/metrics before and after each benchmark function and appends the differences to its
+// result line, as extra benchfmt metrics read by benchstat like ns/op:
+//
+//	BenchmarkParse-8   1000   1234 ns/op   3 gc-cycles   52000 sched-p99-ns   0 mutex-wait-ns
+//
+// the differences cover the whole benchmark function, b.N ramp-up included.
+
+// runtimeMetricsFile is the name of the harness in the package directory; it only exists in the
+// overlay.
+const runtimeMetricsFile = "zz_rbench_runtime_metrics_test.go"
+
+// runtimeMetricsHarness is the source of the harness, after its package clause: it is in the
+// external test package. The benchmark output is piped through rbenchAnnotate: the name of a
+// benchmark is printed when it starts, its result line when it ends.
+const runtimeMetricsHarness = `
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime/metrics"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		os.Exit(m.Run())
+	}
+	os.Stdout = w
+	done := make(chan struct{})
+	go func() {
+		rbenchAnnotate(stdout, r)
+		close(done)
+	}()
+	code := m.Run()
+	w.Close()
+	<-done
+	os.Stdout = stdout
+	os.Exit(code)
+}
+
+var rbenchMetricNames = []string{
+	"/gc/cycles/total:gc-cycles",
+	"/sched/latencies:seconds",
+	"/sync/mutex/wait/total:seconds",
+}
+
+func rbenchRead() []metrics.Sample {
+	samples := make([]metrics.Sample, len(rbenchMetricNames))
+	for i, name := range rbenchMetricNames {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	return samples
+}
+
+func rbenchAnnotate(out io.Writer, in io.Reader) {
+	var (
+		line   []byte
+		before []metrics.Sample
+		chunk  []byte
+		buf    = make([]byte, 32<<10)
+	)
+	prefix := []byte("Benchmark")
+	for {
+		n, err := in.Read(buf)
+		chunk = chunk[:0]
+		for _, c := range buf[:n] {
+			if c == '\n' {
+				if before != nil && bytes.HasPrefix(line, prefix) && bytes.Contains(line, []byte(" ns/op")) {
+					chunk = append(chunk, rbenchDiff(before, rbenchRead())...)
+				}
+				line, before = line[:0], nil
+			} else {
+				line = append(line, c)
+				if len(line) == len(prefix) && bytes.Equal(line, prefix) {
+					before = rbenchRead()
+				}
+			}
+			chunk = append(chunk, c)
+		}
+		out.Write(chunk)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func rbenchDiff(before, after []metrics.Sample) string {
+	var s string
+	for i, a := range after {
+		b := before[i]
+		switch a.Value.Kind() {
+		case metrics.KindUint64:
+			s += fmt.Sprintf("\t%d gc-cycles", a.Value.Uint64()-b.Value.Uint64())
+		case metrics.KindFloat64:
+			s += fmt.Sprintf("\t%.0f mutex-wait-ns", 1e9*(a.Value.Float64()-b.Value.Float64()))
+		case metrics.KindFloat64Histogram:
+			s += fmt.Sprintf("\t%.0f sched-p99-ns", 1e9*rbenchQuantile(b.Value.Float64Histogram(), a.Value.Float64Histogram(), 0.99))
+		}
+	}
+	return s
+}
+
+// rbenchQuantile returns the quantile q of the values recorded in h2 since h1.
+func rbenchQuantile(h1, h2 *metrics.Float64Histogram, q float64) float64 {
+	var total uint64
+	counts := make([]uint64, len(h2.Counts))
+	for i := range counts {
+		counts[i] = h2.Counts[i] - h1.Counts[i]
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	var cumulated uint64
+	for i, c := range counts {
+		cumulated += c
+		if float64(cumulated) >= q*float64(total) {
+			// upper bound of the bucket
+			if upper := h2.Buckets[i+1]; !math.IsInf(upper, 1) {
+				return upper
+			}
+			return h2.Buckets[i]
+		}
+	}
+	return 0
+}
+`
+
+var testMainRegexp = regexp.MustCompile(`(?m)^func TestMain\(`)
+
+// runtimeMetricsOverlay writes the harness of pkg, built from dir, and the go test -overlay file
+// adding it to the package, in tmp. Packages defining their own TestMain aren't supported.
+func runtimeMetricsOverlay(dir, pkg, tmp string) (string, error) {
+	args := []string{"list", "-f", `{{.Dir}} {{.Name}}{{range .TestGoFiles}} {{.}}{{end}}{{range .XTestGoFiles}} {{.}}{{end}}`}
+	if *tagsFlag != "" {
+		args = append(args, "-tags", *tagsFlag)
+	}
+	cmd := exec.Command("go", append(args, pkg)...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to list package %s, %v", pkg, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 {
+		return "", fmt.Errorf("unable to list package %s: %q", pkg, out)
+	}
+	pkgDir, name := fields[0], fields[1]
+	for _, file := range fields[2:] {
+		src, err := os.ReadFile(filepath.Join(pkgDir, file))
+		if err != nil {
+			return "", err
+		}
+		if testMainRegexp.Match(src) {
+			return "", fmt.Errorf("-runtime-metrics: %s defines a TestMain (%s)", pkg, file)
+		}
+	}
+
+	harness := filepath.Join(tmp, runtimeMetricsFile)
+	if err := os.WriteFile(harness, []byte("package "+name+"_test\n"+runtimeMetricsHarness), 0600); err != nil {
+		return "", err
+	}
+	overlay, err := json.Marshal(map[string]map[string]string{
+		"Replace": {filepath.Join(pkgDir, runtimeMetricsFile): harness},
+	})
+	if err != nil {
+		return "", err
+	}
+	overlayFile := filepath.Join(tmp, "overlay.json")
+	return overlayFile, os.WriteFile(overlayFile, overlay, 0600)
+}
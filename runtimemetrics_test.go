@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRuntimeMetricsOverlay(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module p\n")
+	write("p_test.go", `package p
+
+import (
+	"runtime"
+	"testing"
+)
+
+var sink []byte
+
+func BenchmarkAlloc(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink = make([]byte, 1<<20)
+	}
+	runtime.GC()
+}
+`)
+	overlay, err := runtimeMetricsOverlay(dir, ".", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "test", "-overlay="+overlay, "-run=NONE", "-bench=.", "-benchtime=10x", ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	var result string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "BenchmarkAlloc") {
+			result = line
+		}
+	}
+	for _, unit := range []string{" ns/op\t", " gc-cycles\t", " sched-p99-ns\t", " mutex-wait-ns"} {
+		if !strings.Contains(result, unit) {
+			t.Errorf("result without %q: %q", unit, result)
+		}
+	}
+	if !strings.Contains(string(out), "\nPASS\n") {
+		t.Errorf("output truncated:\n%s", out)
+	}
+
+	write("main_test.go", "package p\n\nimport \"testing\"\n\nfunc TestMain(m *testing.M) { m.Run() }\n")
+	if _, err := runtimeMetricsOverlay(dir, ".", t.TempDir()); err == nil || !strings.Contains(err.Error(), "TestMain") {
+		t.Errorf("expected a TestMain error, got %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// runs published with -s3 are stored as bundles under <prefix>/<repo>/<branch>/, next to an
+// index.json listing them, so that teams share a results store without a server.
+const s3IndexName = "index.json"
+
+// s3Index is the index of the runs of a repository and branch.
+type s3Index struct {
+	Repo   string         `json:"repo"`
+	Branch string         `json:"branch"`
+	Runs   []s3IndexEntry `json:"runs"` // by runstamp
+}
+
+type s3IndexEntry struct {
+	Key        string   `json:"key"`
+	RunStamp   string   `json:"runstamp"`
+	Commit     string   `json:"commit"`
+	CommitTime string   `json:"commit_time,omitempty"`
+	User       string   `json:"user,omitempty"`
+	Args       []string `json:"args,omitempty"`
+	Size       int64    `json:"size"`
+	Cost       float64  `json:"cost,omitempty"` // of the instances, in USD
+}
+
+// parseS3URL splits s3://bucket/prefix.
+func parseS3URL(s string) (bucket, prefix string, err error) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("%q is not an s3://bucket/prefix location", s)
+	}
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%q has no bucket", s)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// s3RunPrefix is the prefix of the runs of a repository and branch. Slashes of branch names are
+// escaped so that the runs of a/b don't appear under a.
+func s3RunPrefix(prefix, repo, branch string) string {
+	return path.Join(prefix, repo, strings.ReplaceAll(branch, "/", "%2F")) + "/"
+}
+
+// publishS3 uploads the bundle of the run to location, adds it to the index of the repository
+// and branch, and sets the expiration of the prefix.
+func publishS3(location string, info runInfo) error {
+	bucket, prefix, err := parseS3URL(location)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", "rbench-*"+bundleExt)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	m, files := bundleArtifacts(info)
+	if err := createBundle(f.Name(), m, files); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+
+	client := s3.NewFromConfig(awsConfig)
+	repo, branch := gitRepoName(), gitBranch()
+	runPrefix := s3RunPrefix(prefix, repo, branch)
+	entry := s3IndexEntry{
+		Key:        runPrefix + strings.ReplaceAll(info.runStamp, ":", "-") + "-" + awsUserName + bundleExt,
+		RunStamp:   info.runStamp,
+		Commit:     info.commitID,
+		CommitTime: info.commitTime,
+		User:       awsUserName,
+		Args:       os.Args[1:],
+		Size:       int64(len(data)),
+		Cost:       m.Cost,
+	}
+	// the metadata lets a later run index the bundle if this index update is lost
+	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(entry.Key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/zstd"),
+		Metadata:    map[string]string{"runstamp": entry.RunStamp, "commit": entry.Commit, "commit-time": entry.CommitTime, "user": entry.User},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to upload the bundle to s3://%s/%s, %v", bucket, entry.Key, err)
+	}
+	if err := updateS3Index(client, bucket, runPrefix, repo, branch, entry); err != nil {
+		return err
+	}
+	if err := setS3Retention(client, bucket, prefix, *s3Retention); err != nil {
+		// the bundle is published: only warn
+		slog.Warn(err.Error())
+	}
+	slog.Info(fmt.Sprintf("run published to s3://%s/%s", bucket, entry.Key))
+	return nil
+}
+
+// updateS3Index adds entry to the index of runPrefix. Concurrent runs may overwrite each other's
+// update: the index is reconciled with the bundles present, which also drops the expired ones.
+func updateS3Index(client *s3.Client, bucket, runPrefix, repo, branch string, entry s3IndexEntry) error {
+	index, err := getS3Index(client, bucket, runPrefix)
+	if err != nil {
+		return err
+	}
+	index.Repo, index.Branch = repo, branch
+
+	present := make(map[string]int64)
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(runPrefix)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return fmt.Errorf("unable to list s3://%s/%s, %v", bucket, runPrefix, err)
+		}
+		for _, o := range page.Contents {
+			if strings.HasSuffix(aws.ToString(o.Key), bundleExt) {
+				present[aws.ToString(o.Key)] = aws.ToInt64(o.Size)
+			}
+		}
+	}
+	present[entry.Key] = entry.Size
+
+	runs, missing := reconcileIndex(index.Runs, present)
+	runs = append(runs, entry)
+	for _, key := range missing {
+		if key == entry.Key {
+			continue
+		}
+		head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			slog.Warn(fmt.Sprintf("s3://%s/%s not indexed, %v", bucket, key, err))
+			continue
+		}
+		runs = append(runs, s3IndexEntry{
+			Key:        key,
+			RunStamp:   head.Metadata["runstamp"],
+			Commit:     head.Metadata["commit"],
+			CommitTime: head.Metadata["commit-time"],
+			User:       head.Metadata["user"],
+			Size:       present[key],
+		})
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].RunStamp < runs[j].RunStamp })
+	index.Runs = runs
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(runPrefix + s3IndexName),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update the index s3://%s/%s%s, %v", bucket, runPrefix, s3IndexName, err)
+	}
+	return nil
+}
+
+// reconcileIndex returns the runs whose bundle is present (key -> size), and the present
+// bundles missing from the index.
+func reconcileIndex(runs []s3IndexEntry, present map[string]int64) (kept []s3IndexEntry, missing []string) {
+	indexed := make(map[string]bool)
+	for _, r := range runs {
+		if _, ok := present[r.Key]; ok && !indexed[r.Key] {
+			kept = append(kept, r)
+			indexed[r.Key] = true
+		}
+	}
+	for key := range present {
+		if !indexed[key] {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	return kept, missing
+}
+
+// getS3Index returns the index of runPrefix, empty if there is none yet.
+func getS3Index(client *s3.Client, bucket, runPrefix string) (s3Index, error) {
+	var index s3Index
+	out, err := client.GetObject(context.TODO(), &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(runPrefix + s3IndexName)})
+	if err != nil {
+		var noKey *s3types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return index, nil
+		}
+		return index, fmt.Errorf("unable to read the index s3://%s/%s%s, %v", bucket, runPrefix, s3IndexName, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return index, fmt.Errorf("unable to read the index s3://%s/%s%s, %v", bucket, runPrefix, s3IndexName, err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, fmt.Errorf("invalid index s3://%s/%s%s, %v", bucket, runPrefix, s3IndexName, err)
+	}
+	return index, nil
+}
+
+// setS3Retention makes the objects under prefix expire after days (never with 0), with a
+// lifecycle rule of the bucket owned by rbench. The other rules of the bucket are kept.
+func setS3Retention(client *s3.Client, bucket, prefix string, days int) error {
+	var rules []s3types.LifecycleRule
+	out, err := client.GetBucketLifecycleConfiguration(context.TODO(), &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		var apiErr smithy.APIError
+		if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("unable to read the lifecycle rules of s3://%s, %v", bucket, err)
+		}
+	} else {
+		rules = out.Rules
+	}
+	rules, changed := lifecycleRules(rules, prefix, days)
+	if !changed {
+		return nil
+	}
+	if len(rules) == 0 {
+		_, err = client.DeleteBucketLifecycle(context.TODO(), &s3.DeleteBucketLifecycleInput{Bucket: aws.String(bucket)})
+	} else {
+		_, err = client.PutBucketLifecycleConfiguration(context.TODO(), &s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(bucket),
+			LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{Rules: rules},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to set the expiration of s3://%s/%s (-s3-retention), %v", bucket, prefix, err)
+	}
+	return nil
+}
+
+// lifecycleRules returns rules with the rbench rule of prefix expiring its objects after days
+// (removed with 0), and whether they changed.
+func lifecycleRules(rules []s3types.LifecycleRule, prefix string, days int) ([]s3types.LifecycleRule, bool) {
+	id := "rbench:" + prefix
+	filter := prefix
+	if filter != "" {
+		filter += "/"
+	}
+	var updated []s3types.LifecycleRule
+	var current *s3types.LifecycleRule
+	for i := range rules {
+		if aws.ToString(rules[i].ID) == id {
+			current = &rules[i]
+			continue
+		}
+		updated = append(updated, rules[i])
+	}
+	if days == 0 {
+		return updated, current != nil
+	}
+	if current != nil && current.Status == s3types.ExpirationStatusEnabled && current.Expiration != nil &&
+		aws.ToInt32(current.Expiration.Days) == int32(days) {
+		return rules, false
+	}
+	updated = append(updated, s3types.LifecycleRule{
+		ID:         aws.String(id),
+		Status:     s3types.ExpirationStatusEnabled,
+		Filter:     &s3types.LifecycleRuleFilterMemberPrefix{Value: filter},
+		Expiration: &s3types.LifecycleExpiration{Days: aws.Int32(int32(days))},
+	})
+	return updated, true
+}
+
+// runsCmd implements "rbench runs": the runs published with -s3 for a repository and branch.
+func runsCmd(args []string) error {
+	fs := flag.NewFlagSet("runs", flag.ExitOnError)
+	repo := fs.String("repo", "", "repository (default: the one of the current directory)")
+	branch := fs.String("branch", "", "branch (default: the current one)")
+	jsonOut := fs.Bool("json", false, "print the index as JSON")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench runs [flags] s3://bucket/prefix\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected an s3:// location")
+	}
+	bucket, prefix, err := parseS3URL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *repo == "" {
+		*repo = gitRepoName()
+	}
+	if *branch == "" {
+		*branch = gitBranch()
+	}
+	if err := loadAWSConfig(); err != nil {
+		return err
+	}
+
+	index, err := getS3Index(s3.NewFromConfig(awsConfig), bucket, s3RunPrefix(prefix, *repo, *branch))
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(index)
+	}
+	if len(index.Runs) == 0 {
+		fmt.Printf("no runs of %s@%s in %s\n", *repo, *branch, fs.Arg(0))
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "RUNSTAMP\tCOMMIT\tUSER\tCOST\tSIZE\tBUNDLE\n")
+	for _, r := range index.Runs {
+		cost := "-"
+		if r.Cost > 0 {
+			cost = formatCost(r.Cost)
+		}
+		fmt.Fprintf(tw, "%s\t%.12s\t%s\t%s\t%d\ts3://%s/%s\n", r.RunStamp, r.Commit, r.User, cost, r.Size, bucket, r.Key)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestParseS3URL(t *testing.T) {
+	for _, tc := range []struct{ url, bucket, prefix string }{
+		{"s3://results", "results", ""},
+		{"s3://results/", "results", ""},
+		{"s3://results/team/perf/", "results", "team/perf"},
+	} {
+		bucket, prefix, err := parseS3URL(tc.url)
+		if err != nil || bucket != tc.bucket || prefix != tc.prefix {
+			t.Errorf("parseS3URL(%q) = %q, %q, %v", tc.url, bucket, prefix, err)
+		}
+	}
+	for _, url := range []string{"results/perf", "s3:///perf"} {
+		if _, _, err := parseS3URL(url); err == nil {
+			t.Errorf("parseS3URL(%q): expected an error", url)
+		}
+	}
+	if got := s3RunPrefix("perf", "github.com/o/r", "feat/x"); got != "perf/github.com/o/r/feat%2Fx/" {
+		t.Errorf("unexpected run prefix %q", got)
+	}
+}
+
+func TestRepoNameFromURL(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://github.com/gbotrel/rbench.git":     "github.com/gbotrel/rbench",
+		"git@github.com:gbotrel/rbench.git":         "github.com/gbotrel/rbench",
+		"ssh://git@gitlab.example.com/team/project": "gitlab.example.com/team/project",
+	} {
+		if got := repoNameFromURL(url); got != want {
+			t.Errorf("repoNameFromURL(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestReconcileIndex(t *testing.T) {
+	runs := []s3IndexEntry{{Key: "a.rbench"}, {Key: "expired.rbench"}, {Key: "b.rbench"}}
+	present := map[string]int64{"a.rbench": 1, "b.rbench": 2, "lost.rbench": 3}
+	kept, missing := reconcileIndex(runs, present)
+	if len(kept) != 2 || kept[0].Key != "a.rbench" || kept[1].Key != "b.rbench" {
+		t.Errorf("unexpected kept runs %v", kept)
+	}
+	if !slices.Equal(missing, []string{"lost.rbench"}) {
+		t.Errorf("unexpected missing bundles %v", missing)
+	}
+}
+
+func TestLifecycleRules(t *testing.T) {
+	other := s3types.LifecycleRule{ID: aws.String("logs"), Status: s3types.ExpirationStatusEnabled}
+	rules, changed := lifecycleRules([]s3types.LifecycleRule{other}, "perf", 30)
+	if !changed || len(rules) != 2 || aws.ToString(rules[1].ID) != "rbench:perf" {
+		t.Fatalf("unexpected rules %v, changed %v", rules, changed)
+	}
+	if f, ok := rules[1].Filter.(*s3types.LifecycleRuleFilterMemberPrefix); !ok || f.Value != "perf/" {
+		t.Errorf("unexpected filter %#v", rules[1].Filter)
+	}
+	if _, changed := lifecycleRules(rules, "perf", 30); changed {
+		t.Error("the same retention must not change the rules")
+	}
+	if rules, changed = lifecycleRules(rules, "perf", 7); !changed || aws.ToInt32(rules[1].Expiration.Days) != 7 {
+		t.Errorf("unexpected rules %v, changed %v", rules, changed)
+	}
+	if rules, changed = lifecycleRules(rules, "perf", 0); !changed || len(rules) != 1 || aws.ToString(rules[0].ID) != "logs" {
+		t.Errorf("unexpected rules %v, changed %v", rules, changed)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ensureSecurityGroup sets securityGroupID, unless the configuration does, to the security group
+// of this machine, named after its key pair: created in the VPC of the instances on the first
+// run, and reused after. Its ssh rule follows the public address of this machine.
+func ensureSecurityGroup() error {
+	if securityGroupID != "" {
+		return nil
+	}
+	if *eiceFlag {
+		return ensureEICESecurityGroup()
+	}
+	if *ipv6Only {
+		return fmt.Errorf("-ipv6 requires a security-group allowing ssh over IPv6 in %s", configPath())
+	}
+	vpc, err := instancesVPC()
+	if err != nil {
+		return err
+	}
+	ip, err := publicIP()
+	if err != nil {
+		return err
+	}
+	out, err := ec2Client.DescribeSecurityGroups(context.TODO(), &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("group-name"), Values: []string{sshKeyName}},
+			{Name: aws.String("vpc-id"), Values: []string{vpc}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to describe security groups, %v", err)
+	}
+	if len(out.SecurityGroups) == 0 {
+		securityGroupID, err = createSecurityGroup(sshKeyName, vpc)
+		return err
+	}
+	g := out.SecurityGroups[0]
+	securityGroupID = aws.ToString(g.GroupId)
+
+	present, stale := sshRanges(g.IpPermissions, ip+"/32")
+	if !present {
+		if err := allowSSH(securityGroupID, ip); err != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		// previous addresses of this machine
+		var ranges []types.IpRange
+		for _, cidr := range stale {
+			ranges = append(ranges, types.IpRange{CidrIp: aws.String(cidr)})
+		}
+		_, err := ec2Client.RevokeSecurityGroupIngress(context.TODO(), &ec2.RevokeSecurityGroupIngressInput{
+			GroupId: g.GroupId,
+			IpPermissions: []types.IpPermission{{
+				IpProtocol: aws.String("tcp"), FromPort: aws.Int32(int32(*sshPort)), ToPort: aws.Int32(int32(*sshPort)), IpRanges: ranges,
+			}},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to revoke the previous ssh addresses of security group %s, %v", securityGroupID, err)
+		}
+	}
+	return nil
+}
+
+// sshRanges reports whether the ssh rules of a security group allow cidr, and returns the other
+// IPv4 ranges they allow.
+func sshRanges(perms []types.IpPermission, cidr string) (present bool, others []string) {
+	for _, p := range perms {
+		if aws.ToString(p.IpProtocol) != "tcp" || aws.ToInt32(p.FromPort) != int32(*sshPort) || aws.ToInt32(p.ToPort) != int32(*sshPort) {
+			continue
+		}
+		for _, r := range p.IpRanges {
+			if aws.ToString(r.CidrIp) == cidr {
+				present = true
+			} else {
+				others = append(others, aws.ToString(r.CidrIp))
+			}
+		}
+	}
+	return present, others
+}
+
+// instancesVPC returns the VPC the instances are launched in: the one of -subnet, or the default VPC.
+func instancesVPC() (string, error) {
+	if *subnetFlag == "" {
+		return defaultVPC()
+	}
+	out, err := ec2Client.DescribeSubnets(context.TODO(), &ec2.DescribeSubnetsInput{SubnetIds: []string{*subnetFlag}})
+	if err != nil {
+		return "", fmt.Errorf("unable to describe subnet %s, %v", *subnetFlag, err)
+	}
+	if len(out.Subnets) == 0 {
+		return "", fmt.Errorf("subnet %s not found in %s", *subnetFlag, awsConfig.Region)
+	}
+	return aws.ToString(out.Subnets[0].VpcId), nil
+}
+
+// defaultVPC returns the default VPC of the region.
+func defaultVPC() (string, error) {
+	vpcs, err := ec2Client.DescribeVpcs(context.TODO(), &ec2.DescribeVpcsInput{
+		Filters: []types.Filter{{Name: aws.String("is-default"), Values: []string{"true"}}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to describe VPCs, %v", err)
+	}
+	if len(vpcs.Vpcs) == 0 {
+		return "", fmt.Errorf("no default VPC in %s", awsConfig.Region)
+	}
+	return aws.ToString(vpcs.Vpcs[0].VpcId), nil
+}
+
+// createSecurityGroup creates a security group in the VPC, allowing ssh from the public address
+// of this machine only.
+func createSecurityGroup(name, vpc string) (string, error) {
+	ip, err := publicIP()
+	if err != nil {
+		return "", err
+	}
+
+	out, err := ec2Client.CreateSecurityGroup(context.TODO(), &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(name),
+		Description: aws.String("rbench instances, ssh access"),
+		VpcId:       aws.String(vpc),
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeSecurityGroup,
+				Tags: []types.Tag{
+					{
+						Key:   aws.String("rbench"),
+						Value: aws.String(awsUserName),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to create security group, %v", err)
+	}
+	if err := allowSSH(aws.ToString(out.GroupId), ip); err != nil {
+		return "", err
+	}
+	return aws.ToString(out.GroupId), nil
+}
+
+// allowSSH allows ssh from ip in the security group.
+func allowSSH(id, ip string) error {
+	_, err := ec2Client.AuthorizeSecurityGroupIngress(context.TODO(), &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:    aws.String(id),
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int32(int32(*sshPort)),
+		ToPort:     aws.Int32(int32(*sshPort)),
+		CidrIp:     aws.String(ip + "/32"),
+	})
+	if err != nil && !strings.Contains(err.Error(), "InvalidPermission.Duplicate") {
+		return fmt.Errorf("unable to allow ssh in security group %s, %v", id, err)
+	}
+	return nil
+}
+
+// publicIP returns the public IPv4 address of this machine, as seen by AWS.
+func publicIP() (string, error) {
+	resp, err := http.Get("https://checkip.amazonaws.com")
+	if err != nil {
+		return "", fmt.Errorf("unable to get the public address, %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to get the public address, %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
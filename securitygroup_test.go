@@ -0,0 +1,30 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestSSHRanges(t *testing.T) {
+	perms := []types.IpPermission{
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int32(22), ToPort: aws.Int32(22), IpRanges: []types.IpRange{
+			{CidrIp: aws.String("198.51.100.7/32")},
+			{CidrIp: aws.String("203.0.113.4/32")},
+		}},
+		// not ssh
+		{IpProtocol: aws.String("tcp"), FromPort: aws.Int32(443), ToPort: aws.Int32(443), IpRanges: []types.IpRange{
+			{CidrIp: aws.String("0.0.0.0/0")},
+		}},
+	}
+	present, others := sshRanges(perms, "203.0.113.4/32")
+	if !present || !slices.Equal(others, []string{"198.51.100.7/32"}) {
+		t.Errorf("got %t %v", present, others)
+	}
+	present, others = sshRanges(perms, "192.0.2.1/32")
+	if present || len(others) != 2 {
+		t.Errorf("got %t %v", present, others)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+// rbench series turns the results history into the comparison series of golang.org/x/perf's
+// benchseries: each commit of a machine is compared with the previous one, the ratio of their
+// medians bootstrapped into a 95% confidence interval. The series are printed, or written as the
+// benchseries JSON (-json), or the history is written as benchfmt tagged like benchseries expects
+// (-benchfmt: toolchain baseline/experiment, experiment-commit-time as the ordinal, runstamp as
+// the experiment), for the upstream tools. The machine and the metadata of the records are the
+// residues: the series of different machines or metadata are never mixed.
+
+// seriesBootstraps is the number of resamples of a comparison.
+const seriesBootstraps = 1000
+
+// seriesSummary is a benchseries ComparisonSummary: the 2.5th, 50th and 97.5th percentiles of the
+// bootstrapped ratios of the experiment to the baseline, at the date of the experiment.
+type seriesSummary struct {
+	Low     float64 `json:"low"`
+	Center  float64 `json:"center"`
+	High    float64 `json:"high"`
+	Date    string  `json:"date"`
+	Present bool    `json:"present"`
+}
+
+// defined reports whether s is a comparison, like benchseries' Defined.
+func (s *seriesSummary) defined() bool {
+	return s != nil && s.Present
+}
+
+// seriesHashes is a benchseries ComparisonHashes: the commits compared at a point of a series.
+type seriesHashes struct {
+	NumHash, DenHash string
+}
+
+// seriesResidue is a benchseries StringAndSlice: a key of the records and its values.
+type seriesResidue struct {
+	S     string   `json:"s"`
+	Slice []string `json:"slice"`
+}
+
+// comparisonSeries is a benchseries ComparisonSeries: the comparisons of the benchmarks (rows) at
+// each commit (columns, by date) of a machine, for a unit. A missing comparison is nil.
+type comparisonSeries struct {
+	Unit       string                  `json:"unit"`
+	Benchmarks []string                `json:"benchmarks"`
+	Series     []string                `json:"series"`
+	Summaries  [][]*seriesSummary      `json:"summaries"`
+	HashPairs  map[string]seriesHashes `json:"hashpairs"`
+	Residues   []seriesResidue         `json:"residues"`
+}
+
+// seriesResidues returns the residue of a record: its machine and metadata, the key of its series.
+func seriesResidues(h historyRecord) []seriesResidue {
+	residues := []seriesResidue{{"machine", []string{h.Machine}}}
+	for _, k := range sortedKeys(h.Meta) {
+		residues = append(residues, seriesResidue{k, []string{h.Meta[k]}})
+	}
+	return residues
+}
+
+// seriesTable returns the name of the series of a record, from its residues.
+func seriesTable(h historyRecord) string {
+	var parts []string
+	for _, r := range seriesResidues(h) {
+		parts = append(parts, r.S+"="+r.Slice[0])
+	}
+	return strings.Join(parts, ",")
+}
+
+// seriesDate formats the date of a point of a series, sortable as a string.
+func seriesDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// seriesPoints groups records by series and benchmark, with the points of historyTrend.
+func seriesPoints(records []historyRecord, unit string) (map[string]map[string][]historyPoint, map[string]historyRecord) {
+	byTable := make(map[string][]historyRecord)
+	sample := make(map[string]historyRecord)
+	for _, h := range records {
+		key := seriesTable(h)
+		byTable[key] = append(byTable[key], h)
+		sample[key] = h
+	}
+	points := make(map[string]map[string][]historyPoint)
+	for key, records := range byTable {
+		points[key] = make(map[string][]historyPoint)
+		for name, trend := range historyTrend(records, unit, 0) {
+			// historyTrend keys by "<benchmark> on <machine>", the machine is the table
+			name = strings.TrimSuffix(name, " on "+sample[key].Machine)
+			points[key][name] = trend
+		}
+	}
+	return points, sample
+}
+
+// buildSeries returns the comparison series of unit over records, one per machine and metadata.
+func buildSeries(records []historyRecord, unit string) []*comparisonSeries {
+	points, sample := seriesPoints(records, unit)
+	var all []*comparisonSeries
+	for _, key := range sortedKeys(points) {
+		cs := &comparisonSeries{Unit: unit, HashPairs: make(map[string]seriesHashes), Residues: seriesResidues(sample[key])}
+		// the commits of the machine, ordered by their first run, are the columns
+		first := make(map[string]time.Time)
+		for _, trend := range points[key] {
+			for _, p := range trend {
+				if t, ok := first[p.commit]; !ok || p.first.Before(t) {
+					first[p.commit] = p.first
+				}
+			}
+		}
+		commits := sortedKeys(first)
+		sort.SliceStable(commits, func(i, j int) bool { return first[commits[i]].Before(first[commits[j]]) })
+		if len(commits) < 2 {
+			continue
+		}
+		column := make(map[string]int)
+		for i, c := range commits[1:] {
+			date := seriesDate(first[c])
+			cs.Series = append(cs.Series, date)
+			cs.HashPairs[date] = seriesHashes{NumHash: c, DenHash: commits[i]}
+			column[c] = i
+		}
+		rng := rand.New(rand.NewSource(1))
+		for _, name := range sortedKeys(points[key]) {
+			row := make([]*seriesSummary, len(cs.Series))
+			trend := points[key][name]
+			for i := 1; i < len(trend); i++ {
+				// the comparison with the previous commit of the benchmark, which isn't the
+				// previous column when the benchmark didn't run at every commit
+				low, center, high := bootstrapRatio(rng, trend[i].values, trend[i-1].values)
+				row[column[trend[i].commit]] = &seriesSummary{low, center, high, cs.Series[column[trend[i].commit]], true}
+			}
+			cs.Benchmarks = append(cs.Benchmarks, name)
+			cs.Summaries = append(cs.Summaries, row)
+		}
+		all = append(all, cs)
+	}
+	return all
+}
+
+// bootstrapRatio returns the 2.5th, 50th and 97.5th percentiles of the ratio of the medians of
+// num to den, over resamples of both.
+func bootstrapRatio(rng *rand.Rand, num, den []float64) (low, center, high float64) {
+	ratios := make([]float64, seriesBootstraps)
+	n, d := make([]float64, len(num)), make([]float64, len(den))
+	for i := range ratios {
+		for j := range n {
+			n[j] = num[rng.Intn(len(num))]
+		}
+		for j := range d {
+			d[j] = den[rng.Intn(len(den))]
+		}
+		if md := median(d); md != 0 {
+			ratios[i] = median(n) / md
+		}
+	}
+	sort.Float64s(ratios)
+	at := func(q float64) float64 { return ratios[int(q*float64(len(ratios)-1))] }
+	return at(0.025), at(0.5), at(0.975)
+}
+
+// printSeries prints the comparison series: the change of each benchmark from the previous
+// commit, with its confidence interval; "*" marks the changes whose interval excludes 0.
+func printSeries(w io.Writer, all []*comparisonSeries) {
+	for i, cs := range all {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		var residues []string
+		for _, r := range cs.Residues {
+			residues = append(residues, r.S+"="+strings.Join(r.Slice, "|"))
+		}
+		fmt.Fprintf(w, "%s (%s, change from the previous commit, 95%% CI):\n", strings.Join(residues, " "), cs.Unit)
+		for b, name := range cs.Benchmarks {
+			fmt.Fprintf(w, "  %s\n", name)
+			tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+			for s, sum := range cs.Summaries[b] {
+				if !sum.defined() {
+					continue
+				}
+				date, _ := time.Parse(time.RFC3339Nano, cs.Series[s])
+				mark := ""
+				if sum.Low > 1 || sum.High < 1 {
+					mark = "*"
+				}
+				fmt.Fprintf(tw, "    %s\t%s\t%+.1f%%\t[%+.1f%%, %+.1f%%]\t%s\n", shortCommit(cs.HashPairs[cs.Series[s]].NumHash),
+					date.Local().Format("2006-01-02 15:04"), 100*(sum.Center-1), 100*(sum.Low-1), 100*(sum.High-1), mark)
+			}
+			tw.Flush()
+		}
+	}
+}
+
+// shortCommit abbreviates a commit for display.
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// writeSeriesBenchfmt writes records as benchfmt for benchseries: for each commit of a machine
+// after the first, a baseline block with the results of the previous commit and an experiment
+// block with the results of the commit, tagged with the commits and the time of the experiment
+// commit. The residues are configuration lines.
+func writeSeriesBenchfmt(w io.Writer, records []historyRecord) {
+	byTable := make(map[string][]historyRecord)
+	for _, h := range records {
+		byTable[seriesTable(h)] = append(byTable[seriesTable(h)], h)
+	}
+	for _, key := range sortedKeys(byTable) {
+		records := byTable[key]
+		first := make(map[string]time.Time)
+		for _, h := range records {
+			if t, ok := first[h.Commit]; !ok || h.Time.Before(t) {
+				first[h.Commit] = h.Time
+			}
+		}
+		commits := sortedKeys(first)
+		sort.SliceStable(commits, func(i, j int) bool { return first[commits[i]].Before(first[commits[j]]) })
+		for i := 1; i < len(commits); i++ {
+			base, head := commits[i-1], commits[i]
+			for _, r := range seriesResidues(records[0]) {
+				fmt.Fprintf(w, "%s: %s\n", r.S, r.Slice[0])
+			}
+			fmt.Fprintf(w, "baseline-commit: %s\nexperiment-commit: %s\n", base, head)
+			fmt.Fprintf(w, "experiment-commit-time: %s\nrunstamp: %s\n", seriesDate(first[head]), seriesDate(first[head]))
+			for j, commit := range []string{base, head} {
+				fmt.Fprintf(w, "toolchain: %s\n", compareToolchains[j])
+				for _, h := range records {
+					if h.Commit != commit {
+						continue
+					}
+					for _, unit := range sortedKeys(h.Values) {
+						for _, v := range h.Values[unit] {
+							fmt.Fprintf(w, "%s 1 %g %s\n", h.Benchmark, v, unit)
+						}
+					}
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// seriesCmd implements "rbench series": the benchseries comparisons of the benchmarks matching a
+// regular expression (like -bench) over the history.
+func seriesCmd(args []string) error {
+	fs := flag.NewFlagSet("series", flag.ExitOnError)
+	machine := fs.String("machine", "", "only the results of this machine (instance type, or host-<name>)")
+	unit := fs.String("unit", "ns/op", "unit of the values, e.g. B/op or a b.ReportMetric unit")
+	jsonFlag := fs.Bool("json", false, "write the comparison series as the JSON of benchseries")
+	benchfmtFlag := fs.Bool("benchfmt", false, "write the history as benchfmt tagged for benchseries, instead of the comparisons")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench series [flags] [benchmark regexp]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("expected at most a benchmark regular expression")
+	}
+	if *jsonFlag && *benchfmtFlag {
+		return fmt.Errorf("-json can't be used with -benchfmt")
+	}
+	re, err := regexp.Compile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid benchmark regexp, %v", err)
+	}
+	records, err := readHistory(*machine, func(h historyRecord) bool {
+		return re.MatchString(trimProcs(h.Benchmark))
+	})
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no result of %q in %s", fs.Arg(0), historyFile())
+	}
+	if *benchfmtFlag {
+		writeSeriesBenchfmt(os.Stdout, records)
+		return nil
+	}
+	all := buildSeries(records, *unit)
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(all)
+	}
+	if len(all) == 0 {
+		return fmt.Errorf("no %s result at two commits of a machine in %s", *unit, historyFile())
+	}
+	printSeries(os.Stdout, all)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildSeries(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 9, 0, 0, 0, time.UTC) }
+	values := func(m float64) map[string][]float64 {
+		return map[string][]float64{"ns/op": {m, m * 1.001, m * 0.999, m}}
+	}
+	records := []historyRecord{
+		{Time: day(12), Commit: "aaa", Machine: "c7g.large", Benchmark: "BenchmarkSign-2", Values: values(100)},
+		{Time: day(13), Commit: "bbb", Machine: "c7g.large", Benchmark: "BenchmarkSign-2", Values: values(100)},
+		{Time: day(14), Commit: "ccc", Machine: "c7g.large", Benchmark: "BenchmarkSign-2", Values: values(120)},
+		// another machine is another series
+		{Time: day(13), Commit: "bbb", Machine: "c6i.large", Benchmark: "BenchmarkSign-2", Values: values(80)},
+	}
+	all := buildSeries(records, "ns/op")
+	if len(all) != 1 {
+		t.Fatalf("got %d series, expected 1 (c6i.large has a single commit)", len(all))
+	}
+	cs := all[0]
+	if cs.Residues[0].S != "machine" || cs.Residues[0].Slice[0] != "c7g.large" {
+		t.Errorf("unexpected residues %+v", cs.Residues)
+	}
+	if len(cs.Series) != 2 || cs.HashPairs[cs.Series[1]] != (seriesHashes{NumHash: "ccc", DenHash: "bbb"}) {
+		t.Fatalf("unexpected series %v %v", cs.Series, cs.HashPairs)
+	}
+	same, slower := cs.Summaries[0][0], cs.Summaries[0][1]
+	if !same.defined() || same.Low > 1 || same.High < 1 {
+		t.Errorf("bbb vs aaa = %+v, expected an interval around 1", same)
+	}
+	if !slower.defined() || slower.Low < 1.15 || slower.High > 1.25 {
+		t.Errorf("ccc vs bbb = %+v, expected an interval around 1.2", slower)
+	}
+
+	var b bytes.Buffer
+	writeSeriesBenchfmt(&b, records)
+	out := b.String()
+	for _, want := range []string{"machine: c7g.large\n", "baseline-commit: bbb\nexperiment-commit: ccc\n", "toolchain: experiment\nBenchmarkSign-2 1 120 ns/op\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("benchfmt output doesn't contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "machine: c6i.large") {
+		t.Errorf("c6i.large has no comparison:\n%s", out)
+	}
+}
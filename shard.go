@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// -shards splits the benchmarks across instances by a stable hash of their names: the
+// composition of a shard only depends on its benchmarks, so that any shard can be re-run in
+// isolation with -shard.
+
+// shardOf returns the shard (0-based) of a benchmark among n.
+func shardOf(name string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(n))
+}
+
+// shardBenchmarks partitions benchmarks in n shards, keeping their order.
+func shardBenchmarks(benchmarks []string, n int) [][]string {
+	shards := make([][]string, n)
+	for _, name := range benchmarks {
+		i := shardOf(name, n)
+		shards[i] = append(shards[i], name)
+	}
+	return shards
+}
+
+// shardPattern returns the -test.bench pattern of the benchmarks of a shard, keeping the
+// sub-benchmark part of -bench, if any.
+func shardPattern(benchmarks []string) string {
+	quoted := make([]string, len(benchmarks))
+	for i, name := range benchmarks {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	pattern := "^(" + strings.Join(quoted, "|") + ")$"
+	if _, sub, ok := strings.Cut(*benchFlag, "/"); ok {
+		pattern += "/" + sub
+	}
+	return pattern
+}
+
+// shardTargets replicates each target for the shards of the benchmarks, or for the -shard one.
+// empty shards are skipped.
+func shardTargets(targets []target, benchmarks []string) ([]target, error) {
+	if len(benchmarks) == 0 {
+		return nil, fmt.Errorf("-shards: no benchmark matches -bench=%s", *benchFlag)
+	}
+	shards := shardBenchmarks(benchmarks, *shardsFlag)
+	if *shardFlag > 0 && len(shards[*shardFlag-1]) == 0 {
+		return nil, fmt.Errorf("-shard=%d: no benchmark in this shard", *shardFlag)
+	}
+	var sharded []target
+	for _, t := range targets {
+		for i, names := range shards {
+			if *shardFlag > 0 && i != *shardFlag-1 {
+				continue
+			}
+			if len(names) == 0 {
+				slog.Info(fmt.Sprintf("shard %d/%d is empty", i+1, len(shards)))
+				continue
+			}
+			st := t
+			st.shard, st.benchmarks = i+1, names
+			sharded = append(sharded, st)
+		}
+	}
+	return sharded, nil
+}
+
+// benchPattern returns the -test.bench pattern of the benchmarks run on r: those of its shard, or
+// -bench.
+func benchPattern(r remote) string {
+	if r.bench != "" {
+		return r.bench
+	}
+	return *benchFlag
+}
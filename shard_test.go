@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestShardBenchmarks(t *testing.T) {
+	var benchmarks []string
+	for _, c := range "ABCDEFGHIJKLMNOPQRSTUVWXYZ" {
+		benchmarks = append(benchmarks, "Benchmark"+string(c))
+	}
+	shards := shardBenchmarks(benchmarks, 4)
+	var all []string
+	for i, names := range shards {
+		for _, name := range names {
+			if shardOf(name, 4) != i {
+				t.Errorf("%s in shard %d, expected %d", name, i, shardOf(name, 4))
+			}
+		}
+		all = append(all, names...)
+	}
+	slices.Sort(all)
+	if !slices.Equal(all, benchmarks) {
+		t.Errorf("the shards don't partition the benchmarks: %v", shards)
+	}
+	// the shard of a benchmark doesn't depend on the others
+	for _, name := range benchmarks {
+		alone := shardBenchmarks([]string{name}, 4)
+		if len(alone[shardOf(name, 4)]) != 1 {
+			t.Errorf("%s changed shard", name)
+		}
+	}
+}
+
+func TestShardTargets(t *testing.T) {
+	defer func(shards, shard int, bench string) { *shardsFlag, *shardFlag, *benchFlag = shards, shard, bench }(*shardsFlag, *shardFlag, *benchFlag)
+	*shardsFlag, *shardFlag, *benchFlag = 2, 0, "."
+	benchmarks := []string{"BenchmarkA", "BenchmarkB", "BenchmarkC", "BenchmarkD"}
+	targets, err := shardTargets([]target{{label: "ubuntu"}}, benchmarks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0].shard != 1 || targets[1].shard != 2 || targets[0].prefix() != "[ubuntu shard 1/2] " {
+		t.Fatalf("unexpected targets %+v", targets)
+	}
+
+	*shardFlag = 2
+	only, err := shardTargets([]target{{}}, benchmarks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(only) != 1 || !slices.Equal(only[0].benchmarks, targets[1].benchmarks) {
+		t.Errorf("unexpected -shard=2 targets %+v", only)
+	}
+
+	*benchFlag = "A|B/size=1k"
+	if got := shardPattern([]string{"BenchmarkA", "BenchmarkB"}); got != "^(BenchmarkA|BenchmarkB)$/size=1k" {
+		t.Errorf("unexpected pattern %q", got)
+	}
+}
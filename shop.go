@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// launchOverhead is the estimated billed time of an instance outside of the benchmark: boot,
+// upload, setup and termination.
+const launchOverhead = 5 * time.Minute
+
+// shopCandidate is an instance type of rbench shop, with its on-demand price in USD per hour.
+type shopCandidate struct {
+	instanceType string
+	price        float64
+}
+
+// shopCmd implements "rbench shop": the benchmark run on several instance types within a dollar
+// budget, ranked by performance per dollar. Each type is run by a child rbench with -type and
+// -budget-time, so that it can't exceed its share of the budget.
+func shopCmd(args []string) error {
+	fs := flag.NewFlagSet("shop", flag.ExitOnError)
+	budget := fs.Float64("budget", 5, "maximum spend of the sweep, in USD (on-demand prices)")
+	typesFlag := fs.String("types", "", "comma-separated candidate instance types (default: current generation, non-burstable types with -vcpus)")
+	vcpus := fs.Int("vcpus", 2, "number of vCPUs of the automatically selected candidates")
+	duration := fs.Duration("duration", 10*time.Minute, "maximum benchmark time per instance type (-budget-time of the runs)")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench shop [flags] [-- run flags] [package]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := loadAWSConfig(); err != nil {
+		return err
+	}
+	ec2Client = ec2.NewFromConfig(awsConfig)
+	names := splitList(*typesFlag)
+	if len(names) == 0 {
+		var err error
+		if names, err = candidateTypes(*vcpus); err != nil {
+			return err
+		}
+	}
+	var candidates []shopCandidate
+	for _, name := range names {
+		statusf("pricing %s...", name)
+		price, err := onDemandPrice(name)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, shopCandidate{name, price})
+	}
+	selected, estimate := selectCandidates(candidates, *budget, *duration+launchOverhead)
+	if len(selected) == 0 {
+		return fmt.Errorf("no candidate fits a $%.2f budget with -duration=%s", *budget, *duration)
+	}
+	var list []string
+	for _, c := range selected {
+		list = append(list, c.instanceType)
+	}
+	statusf("running on %s (at most $%.2f)...", strings.Join(list, ", "), estimate)
+
+	// the run flags follow the shop flags (after --)
+	runArgs := []string{"-budget-time=" + duration.String(), "-ci=off"}
+	if *awsProfile != "" {
+		runArgs = append(runArgs, "-profile="+*awsProfile)
+	}
+	runArgs = append(runArgs, fs.Args()...)
+	outputs := make([]string, len(selected))
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(selected))
+	)
+	for i, c := range selected {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outputs[i], errs[i] = runChild(append([]string{"-type=" + c.instanceType}, runArgs...))
+		}()
+	}
+	wg.Wait()
+	stderrTerminal.clearStatus()
+
+	results := make(map[string]*benchResults)
+	for i, c := range selected {
+		fmt.Println(outputs[i])
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", c.instanceType, errs[i])
+			continue
+		}
+		results[c.instanceType] = newBenchResults()
+		results[c.instanceType].Write([]byte(outputs[i] + "\n"))
+	}
+	printShopSummary(os.Stdout, selected, results)
+	return nil
+}
+
+// candidateTypes returns the current generation, non-burstable, virtualized instance types
+// with vcpus vCPUs offered in the region.
+func candidateTypes(vcpus int) ([]string, error) {
+	filters := []types.Filter{
+		{Name: aws.String("current-generation"), Values: []string{"true"}},
+		{Name: aws.String("burstable-performance-supported"), Values: []string{"false"}},
+		{Name: aws.String("bare-metal"), Values: []string{"false"}},
+		{Name: aws.String("vcpu-info.default-vcpus"), Values: []string{fmt.Sprint(vcpus)}},
+		{Name: aws.String("supported-usage-class"), Values: []string{"on-demand"}},
+	}
+	paginator := ec2.NewDescribeInstanceTypesPaginator(ec2Client, &ec2.DescribeInstanceTypesInput{Filters: filters})
+	var names []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe instance types, %v", err)
+		}
+		for _, t := range page.InstanceTypes {
+			names = append(names, string(t.InstanceType))
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no instance type with %d vCPUs in %s", vcpus, awsConfig.Region)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// selectCandidates returns the cheapest candidates whose runs of billed duration fit in the
+// budget, and their estimated cost.
+func selectCandidates(candidates []shopCandidate, budget float64, billed time.Duration) ([]shopCandidate, float64) {
+	sorted := append([]shopCandidate(nil), candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].price < sorted[j].price })
+	var (
+		selected []shopCandidate
+		total    float64
+	)
+	for _, c := range sorted {
+		cost := c.price * billed.Hours()
+		if total+cost > budget {
+			break
+		}
+		selected = append(selected, c)
+		total += cost
+	}
+	return selected, total
+}
+
+// runChild runs rbench with args and returns its output; its status lines are discarded.
+func runChild(args []string) (string, error) {
+	return runChildIn("", args)
+}
+
+// childArgs returns the run arguments args with extra flags, inserted before the test binary
+// arguments (after --) if any: the last occurrence of a flag wins.
+func childArgs(args []string, extra ...string) []string {
+	i := slices.Index(args, "--")
+	if i < 0 {
+		i = len(args)
+	}
+	return slices.Concat(args[:i], extra, args[i:])
+}
+
+// runChildIn is like runChild, in dir.
+func runChildIn(dir string, args []string) (string, error) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("%v: %s", err, lastLine(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// lastLine returns the last non-empty line of s, without status line rewrites.
+func lastLine(s string) string {
+	lines := strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == '\r' })
+	for i := len(lines) - 1; i >= 0; i-- {
+		if l := strings.TrimSpace(lines[i]); l != "" {
+			return l
+		}
+	}
+	return ""
+}
+
+// printShopSummary prints, for each benchmark, the median ns/op on each instance type and the
+// number of benchmark operations per dollar, best first.
+func printShopSummary(w io.Writer, candidates []shopCandidate, results map[string]*benchResults) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if r := results[c.instanceType]; r != nil {
+			for _, name := range r.names {
+				// the -N GOMAXPROCS suffix differs across vCPU counts
+				base := trimProcs(name)
+				if !seen[base] {
+					seen[base] = true
+					names = append(names, base)
+				}
+			}
+		}
+	}
+	for _, name := range names {
+		type row struct {
+			instanceType    string
+			price, ns, opsD float64
+		}
+		var rows []row
+		for _, c := range candidates {
+			r := results[c.instanceType]
+			if r == nil {
+				continue
+			}
+			for _, n := range r.names {
+				if trimProcs(n) != name {
+					continue
+				}
+				ns := median(r.values(n, "ns/op"))
+				if ns > 0 && c.price > 0 {
+					rows = append(rows, row{c.instanceType, c.price, ns, 3600e9 / (ns * c.price)})
+				}
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].opsD > rows[j].opsD })
+		fmt.Fprintf(w, "\n%s, by performance per dollar (on-demand):\n", name)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "type\t$/hour\tns/op\top/$\n")
+		for i, r := range rows {
+			best := ""
+			if i == 0 {
+				best = "  <- best"
+			}
+			fmt.Fprintf(tw, "%s\t%.4f\t%.4g\t%.3g%s\n", r.instanceType, r.price, r.ns, r.opsD, best)
+		}
+		tw.Flush()
+	}
+}
+
+// trimProcs removes the -N GOMAXPROCS suffix of a benchmark name.
+func trimProcs(name string) string {
+	if i := strings.LastIndexByte(name, '-'); i > 0 && i+1 < len(name) && strings.Trim(name[i+1:], "0123456789") == "" {
+		return name[:i]
+	}
+	return name
+}
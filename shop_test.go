@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectCandidates(t *testing.T) {
+	candidates := []shopCandidate{{"c7i.large", 0.0893}, {"c7g.large", 0.0725}, {"m7i.large", 0.1008}, {"r7i.large", 0.1323}}
+	// 3 hours: 0.2175 + 0.2679 + 0.3024 = 0.7878
+	selected, cost := selectCandidates(candidates, 0.8, 3*time.Hour)
+	if len(selected) != 3 || selected[0].instanceType != "c7g.large" || selected[2].instanceType != "m7i.large" {
+		t.Errorf("unexpected selection %v", selected)
+	}
+	if cost < 0.78 || cost > 0.79 {
+		t.Errorf("unexpected cost %f", cost)
+	}
+	if selected, _ := selectCandidates(candidates, 0.1, 3*time.Hour); len(selected) != 0 {
+		t.Errorf("expected no candidate, got %v", selected)
+	}
+}
+
+func TestPrintShopSummary(t *testing.T) {
+	candidates := []shopCandidate{{"c7g.large", 0.0725}, {"c7i.xlarge", 0.1785}}
+	results := map[string]*benchResults{}
+	for name, out := range map[string]string{
+		"c7g.large":  "BenchmarkA-2 100 100 ns/op\n",
+		"c7i.xlarge": "BenchmarkA-4 100 50 ns/op\n",
+	} {
+		results[name] = newBenchResults()
+		results[name].Write([]byte(out))
+	}
+	var b strings.Builder
+	printShopSummary(&b, candidates, results)
+	lines := strings.Split(b.String(), "\n")
+	if len(lines) < 5 || !strings.HasPrefix(lines[3], "c7g.large") || !strings.HasSuffix(lines[3], "<- best") {
+		t.Errorf("unexpected summary:\n%s", b.String())
+	}
+}
+
+func TestTrimProcs(t *testing.T) {
+	for name, want := range map[string]string{
+		"BenchmarkA-8":         "BenchmarkA",
+		"BenchmarkA/size-10-2": "BenchmarkA/size-10",
+		"BenchmarkA":           "BenchmarkA",
+		"BenchmarkA/x-":        "BenchmarkA/x-",
+	} {
+		if got := trimProcs(name); got != want {
+			t.Errorf("trimProcs(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestChildArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args, want []string
+	}{
+		{[]string{"-count=3", "./fft"}, []string{"-count=3", "./fft", "-type=c7g.large"}},
+		{[]string{"-count=3", "--", "-test.v"}, []string{"-count=3", "-type=c7g.large", "--", "-test.v"}},
+	} {
+		if got := childArgs(tc.args, "-type=c7g.large"); !slices.Equal(got, tc.want) {
+			t.Errorf("childArgs(%q) = %q, expected %q", tc.args, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// -slices runs several benchmark jobs (packages or git refs) concurrently on one instance, each
+// in its own cgroup with an exclusive share of the physical cores: for comparisons, a large
+// instance is cheaper than one instance per job.
+
+// sliceJob is a job of -slices: a package at a git ref, the benchmarked package and the working
+// tree by default.
+type sliceJob struct {
+	pkg, ref string
+}
+
+func (j sliceJob) String() string {
+	if j.ref == "" {
+		return j.pkg
+	}
+	return j.pkg + "@" + j.ref
+}
+
+// parseSlices parses the -slices jobs: comma-separated [package][@ref].
+func parseSlices(s string) ([]sliceJob, error) {
+	var jobs []sliceJob
+	for _, item := range splitList(s) {
+		pkg, ref, _ := strings.Cut(item, "@")
+		if pkg == "" {
+			pkg = benchPackage
+		}
+		jobs = append(jobs, sliceJob{pkg: pkg, ref: ref})
+	}
+	if len(jobs) < 2 {
+		return nil, fmt.Errorf("-slices: expected at least 2 jobs, got %q", s)
+	}
+	return jobs, nil
+}
+
+// sliceBinary is the test binary of a job.
+type sliceBinary struct {
+	job    sliceJob
+	file   string
+	commit string
+}
+
+// buildSlices compiles the test binary of each job; the jobs at a ref are built in a worktree.
+func buildSlices(arch instanceArch, jobs []sliceJob, static bool) ([]sliceBinary, error) {
+	bins := make([]sliceBinary, len(jobs))
+	for i, job := range jobs {
+		bins[i].job = job
+		if job.ref == "" {
+			commit, err := gitCommitID()
+			if err != nil {
+				return nil, err
+			}
+			if bins[i].file, err = compileTestBinary(arch, static, buildDir, job.pkg); err != nil {
+				return nil, fmt.Errorf("%s: %v", job, err)
+			}
+			bins[i].commit = commit
+			continue
+		}
+		out, err := exec.Command("git", "rev-parse", "--verify", job.ref+"^{commit}").Output()
+		if err != nil {
+			return nil, fmt.Errorf("-slices: unknown ref %q", job.ref)
+		}
+		bins[i].commit = strings.TrimSpace(string(out))
+		worktree, dir, err := addWorktree(bins[i].commit)
+		if err != nil {
+			return nil, err
+		}
+		bins[i].file, err = compileTestBinary(arch, static, dir, job.pkg)
+		removeWorktree(worktree)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", job, err)
+		}
+	}
+	return bins, nil
+}
+
+// sliceDir is the remote directory of the binary and the results of slice i.
+func sliceDir(i int) string {
+	return remoteTmp() + "/rbench-slice-" + strconv.Itoa(i)
+}
+
+// sliceCgroup is the cgroup of slice i, named after its directory.
+func sliceCgroup(i int) string {
+	return strings.ReplaceAll(strings.TrimPrefix(sliceDir(i), "/tmp/"), "/", "-")
+}
+
+// cpuSlice is a share of the machine: whole physical cores, and their NUMA nodes.
+type cpuSlice struct {
+	cpus, nodes []int
+	cores       int
+}
+
+// partitionCPUs splits the cores listed by lscpu -p=CPU,Core,Node into n slices of as many
+// physical cores, keeping the SMT siblings together and, as far as possible, the slices within a
+// NUMA node. The remaining cores are left idle.
+func partitionCPUs(lscpu string, n int) ([]cpuSlice, error) {
+	type core struct{ node, id int }
+	siblings := make(map[core][]int)
+	var cores []core
+	for _, line := range strings.Split(lscpu, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("unexpected lscpu output %q", line)
+		}
+		cpu, err1 := strconv.Atoi(fields[0])
+		id, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("unexpected lscpu output %q", line)
+		}
+		c := core{id: id}
+		if len(fields) > 2 {
+			// empty without NUMA
+			c.node, _ = strconv.Atoi(fields[2])
+		}
+		if _, ok := siblings[c]; !ok {
+			cores = append(cores, c)
+		}
+		siblings[c] = append(siblings[c], cpu)
+	}
+	per := len(cores) / n
+	if per == 0 {
+		return nil, fmt.Errorf("%d slices need at least %d physical cores, the instance has %d", n, n, len(cores))
+	}
+	slices.SortFunc(cores, func(a, b core) int {
+		if a.node != b.node {
+			return a.node - b.node
+		}
+		return a.id - b.id
+	})
+	parts := make([]cpuSlice, n)
+	for i := range parts {
+		parts[i].cores = per
+		for _, c := range cores[i*per : (i+1)*per] {
+			parts[i].cpus = append(parts[i].cpus, siblings[c]...)
+			if !slices.Contains(parts[i].nodes, c.node) {
+				parts[i].nodes = append(parts[i].nodes, c.node)
+			}
+		}
+	}
+	return parts, nil
+}
+
+// setupSlices creates the cgroup of each slice and returns the isolation achieved: partition
+// (the slices are exclusive cpuset partitions, with their own scheduling domain), cpuset, or
+// taskset when the cgroup v2 cpuset controller isn't available.
+func setupSlices(r remote, parts []cpuSlice) (string, error) {
+	var script strings.Builder
+	script.WriteString("cd /sys/fs/cgroup && echo +cpuset > cgroup.subtree_control 2>/dev/null || { echo taskset; exit 0; }; ")
+	for i, p := range parts {
+		cg := sliceCgroup(i)
+		fmt.Fprintf(&script, "mkdir -p %s && echo %s > %s/cpuset.cpus && echo %s > %s/cpuset.mems || { echo taskset; exit 0; }; ",
+			cg, joinCPUs(p.cpus), cg, joinCPUs(p.nodes), cg)
+	}
+	script.WriteString("iso=partition; ")
+	for i := range parts {
+		cg := sliceCgroup(i)
+		fmt.Fprintf(&script, "echo root > %s/cpuset.cpus.partition 2>/dev/null || iso=cpuset; ", cg)
+	}
+	script.WriteString("echo $iso")
+	out, err := sshRun(r, "sudo sh -c '"+script.String()+"'")
+	if err != nil {
+		return "", fmt.Errorf("unable to create the slices, %v", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// sliceCommand returns the command running the benchmark in slice i of r, pinned to r.pin.
+func sliceCommand(r remote, i int, isolation string) string {
+	dir := sliceDir(i)
+	benchCmd := "./bench"
+	if !*aslr {
+		benchCmd = "setarch $(uname -m) -R " + benchCmd
+	}
+	benchCmd = fmt.Sprintf("%s=%d taskset -c %s %s", seedEnv, *seedFlag, r.pin, benchCmd)
+	for _, a := range benchTestArgs(r, *benchFlag, *countFlag) {
+		benchCmd += " " + shellQuote(a)
+	}
+	enter := ""
+	if isolation != "taskset" {
+		// the shell joins the cgroup, and so does the benchmark it starts
+		enter = fmt.Sprintf("echo $$ | sudo tee /sys/fs/cgroup/%s/cgroup.procs >/dev/null && ", sliceCgroup(i))
+	}
+	return fmt.Sprintf("trap '' HUP PIPE; cd %s && %s{ %s 2>&1; echo $? > exit; } | tee results.txt; exit $(cat exit)",
+		dir, enter, benchCmd)
+}
+
+// runSlices runs the jobs concurrently on r, one per slice, and writes the results of each as a
+// block with its own slice configuration lines.
+func runSlices(t target, r remote, out io.Writer, bins []sliceBinary) error {
+	lscpu, err := sshRun(r, "lscpu -p=CPU,Core,Node")
+	if err != nil {
+		return fmt.Errorf("unable to read the CPU topology, %v", err)
+	}
+	parts, err := partitionCPUs(lscpu, len(bins))
+	if err != nil {
+		return err
+	}
+	isolation, err := setupSlices(r, parts)
+	if err != nil {
+		return err
+	}
+	if isolation == "taskset" {
+		slog.Warn(t.prefix() + "no cgroup v2 cpuset controller on the instance, the slices are only pinned with taskset")
+	} else {
+		defer sshRun(r, "sudo rmdir /sys/fs/cgroup/rbench-slice-* 2>/dev/null; true")
+	}
+	fmt.Fprintf(out, "slices: %d\nslice-isolation: %s\n", len(bins), isolation)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		done    int
+		outputs = make([]string, len(bins))
+		errs    = make([]error, len(bins))
+	)
+	t.status("running %d slices...", len(bins))
+	for i := range bins {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sr := r
+			sr.pin = joinCPUs(parts[i].cpus)
+			// GOMAXPROCS defaults to the cpus of the slice
+			sr.cpus = cpuList(len(parts[i].cpus), parts[i].cores)
+			outputs[i], errs[i] = sshRunOnce(sr, sliceCommand(sr, i, isolation))
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("slice %d (%s): %v", i, bins[i].job, errs[i])
+			}
+			mu.Lock()
+			done++
+			t.status("%d/%d slices done", done, len(bins))
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, b := range bins {
+		fmt.Fprintf(out, "slice: %d\nslice-job: %s\nslice-commit: %s\nslice-cpus: %s\n", i, b.job, b.commit, joinCPUs(parts[i].cpus))
+		fmt.Fprint(out, outputs[i])
+		results := newBenchResults()
+		results.Write([]byte(outputs[i]))
+		printNoiseReport(out, results, *noiseThreshold, nil)
+	}
+	return errors.Join(errs...)
+}
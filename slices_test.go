@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSlices(t *testing.T) {
+	jobs, err := parseSlices("@main,./internal/fft,./internal/fft@v1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sliceJob{{benchPackage, "main"}, {"./internal/fft", ""}, {"./internal/fft", "v1.2.0"}}
+	if !slices.Equal(jobs, want) {
+		t.Errorf("parseSlices = %v, want %v", jobs, want)
+	}
+	if _, err := parseSlices("@main"); err == nil {
+		t.Error("expected an error for a single job")
+	}
+}
+
+func TestPartitionCPUs(t *testing.T) {
+	// 2 nodes of 3 cores with SMT siblings (cpu n and n+6)
+	var lscpu strings.Builder
+	lscpu.WriteString("# CPU,Core,Node\n")
+	for cpu := 0; cpu < 12; cpu++ {
+		core := cpu % 6
+		fmt.Fprintf(&lscpu, "%d,%d,%d\n", cpu, core, core/3)
+	}
+	parts, err := partitionCPUs(lscpu.String(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := joinCPUs(parts[0].cpus); got != "0,1,2,6,7,8" {
+		t.Errorf("slice 0 cpus %s", got)
+	}
+	if got := joinCPUs(parts[1].cpus); got != "3,4,5,9,10,11" {
+		t.Errorf("slice 1 cpus %s", got)
+	}
+	if !slices.Equal(parts[1].nodes, []int{1}) || parts[1].cores != 3 {
+		t.Errorf("unexpected slice 1 %+v", parts[1])
+	}
+
+	// the remaining core is idle
+	parts, err = partitionCPUs(lscpu.String(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 4 || parts[3].cores != 1 || !slices.Equal(parts[3].nodes, []int{1}) {
+		t.Errorf("unexpected slices %+v", parts)
+	}
+	if _, err := partitionCPUs(lscpu.String(), 7); err == nil {
+		t.Error("expected an error with more slices than cores")
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// instanceSlotTable is the DynamoDB table of the -max-instances slots, shared by all the users
+// of the account (one per region). An item per held slot, created with a conditional write: two
+// launches can't take the same slot.
+const instanceSlotTable = "rbench-instance-slots"
+
+// slotLaunchTimeout is the age after which a slot without instance is considered abandoned (the
+// process holding it died before recording its instance).
+const slotLaunchTimeout = 15 * time.Minute
+
+// instanceSlot is a slot held by this process.
+type instanceSlot struct {
+	key    string
+	holder string
+}
+
+// heldSlots are the slots of the instances launched by this process, by instance id.
+var heldSlots sync.Map
+
+// slotItem is an item of the slot table.
+type slotItem struct {
+	key      string
+	holder   string
+	instance string
+	acquired time.Time
+}
+
+// acquireInstanceSlot blocks until one of the max slots of the table is free and takes it.
+func acquireInstanceSlot(ctx context.Context, max int) (instanceSlot, error) {
+	client := dynamodb.NewFromConfig(awsConfig)
+	if err := ensureSlotTable(ctx, client); err != nil {
+		return instanceSlot{}, err
+	}
+	holder := awsUserName + "/" + randomToken()
+	for {
+		for i := 0; i < max; i++ {
+			s := instanceSlot{key: strconv.Itoa(i), holder: holder}
+			ok, err := putSlot(ctx, client, s, "attribute_not_exists(#slot)", nil)
+			if err != nil {
+				return instanceSlot{}, err
+			}
+			if ok {
+				return s, nil
+			}
+		}
+
+		// all taken: reclaim the slots of the terminated instances and of the dead processes
+		items, err := scanSlots(ctx, client)
+		if err != nil {
+			return instanceSlot{}, err
+		}
+		running, err := runningInstances(ctx, items)
+		if err != nil {
+			return instanceSlot{}, err
+		}
+		now := time.Now()
+		for _, item := range items {
+			if n, err := strconv.Atoi(item.key); err != nil || n >= max || !item.stale(running, now) {
+				continue
+			}
+			s := instanceSlot{key: item.key, holder: holder}
+			ok, err := putSlot(ctx, client, s, "#holder = :old", map[string]dbtypes.AttributeValue{
+				":old": &dbtypes.AttributeValueMemberS{Value: item.holder},
+			})
+			if err != nil {
+				return instanceSlot{}, err
+			}
+			if ok {
+				slog.Debug(fmt.Sprintf("reclaimed the instance slot %s of %s", item.key, item.holder))
+				return s, nil
+			}
+		}
+		statusf("waiting for a free slot (%d/%d rbench instances running)...", len(items), max)
+		select {
+		case <-ctx.Done():
+			return instanceSlot{}, ctx.Err()
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+// stale reports whether the slot can be reclaimed: its instance is no longer pending or
+// running, or it never got one.
+func (item slotItem) stale(running map[string]bool, now time.Time) bool {
+	if item.instance != "" {
+		return !running[item.instance]
+	}
+	return now.Sub(item.acquired) > slotLaunchTimeout
+}
+
+// putSlot writes the slot if condition holds; it returns false if it doesn't.
+func putSlot(ctx context.Context, client *dynamodb.Client, s instanceSlot, condition string, values map[string]dbtypes.AttributeValue) (bool, error) {
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(instanceSlotTable),
+		Item: map[string]dbtypes.AttributeValue{
+			"slot":     &dbtypes.AttributeValueMemberS{Value: s.key},
+			"holder":   &dbtypes.AttributeValueMemberS{Value: s.holder},
+			"acquired": &dbtypes.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  slotAttributeNames(condition),
+		ExpressionAttributeValues: values,
+	})
+	var failed *dbtypes.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to take an instance slot, %v", err)
+	}
+	return true, nil
+}
+
+// slotAttributeNames are the attribute names of condition, escaped against the reserved words
+// of DynamoDB (DynamoDB rejects the unused ones).
+func slotAttributeNames(condition string) map[string]string {
+	names := make(map[string]string)
+	for _, name := range []string{"slot", "holder"} {
+		if strings.Contains(condition, "#"+name) {
+			names["#"+name] = name
+		}
+	}
+	return names
+}
+
+// scanSlots returns the held slots.
+func scanSlots(ctx context.Context, client *dynamodb.Client) ([]slotItem, error) {
+	var items []slotItem
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName:      aws.String(instanceSlotTable),
+		ConsistentRead: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the instance slots, %v", err)
+		}
+		for _, attrs := range page.Items {
+			var item slotItem
+			if v, ok := attrs["slot"].(*dbtypes.AttributeValueMemberS); ok {
+				item.key = v.Value
+			}
+			if v, ok := attrs["holder"].(*dbtypes.AttributeValueMemberS); ok {
+				item.holder = v.Value
+			}
+			if v, ok := attrs["instance"].(*dbtypes.AttributeValueMemberS); ok {
+				item.instance = v.Value
+			}
+			if v, ok := attrs["acquired"].(*dbtypes.AttributeValueMemberN); ok {
+				if sec, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+					item.acquired = time.Unix(sec, 0)
+				}
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// runningInstances returns the pending or running instances of the slots.
+func runningInstances(ctx context.Context, items []slotItem) (map[string]bool, error) {
+	var ids []string
+	for _, item := range items {
+		if item.instance != "" {
+			ids = append(ids, item.instance)
+		}
+	}
+	running := make(map[string]bool)
+	if len(ids) == 0 {
+		return running, nil
+	}
+	// a filter rather than InstanceIds: an unknown id is not an error
+	paginator := ec2.NewDescribeInstancesPaginator(ec2Client, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("instance-id"), Values: ids},
+			{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe instances, %v", err)
+		}
+		for _, r := range page.Reservations {
+			for _, instance := range r.Instances {
+				running[*instance.InstanceId] = true
+			}
+		}
+	}
+	return running, nil
+}
+
+// recordSlotInstance records the instance launched in the slot, which is then released when it
+// terminates, by this process or, once it's gone, by the next launch waiting for a slot.
+func recordSlotInstance(s instanceSlot, instanceID string) {
+	heldSlots.Store(instanceID, s)
+	_, err := dynamodb.NewFromConfig(awsConfig).UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:                aws.String(instanceSlotTable),
+		Key:                      map[string]dbtypes.AttributeValue{"slot": &dbtypes.AttributeValueMemberS{Value: s.key}},
+		UpdateExpression:         aws.String("SET #instance = :instance"),
+		ConditionExpression:      aws.String("#holder = :holder"),
+		ExpressionAttributeNames: map[string]string{"#instance": "instance", "#holder": "holder"},
+		ExpressionAttributeValues: map[string]dbtypes.AttributeValue{
+			":instance": &dbtypes.AttributeValueMemberS{Value: instanceID},
+			":holder":   &dbtypes.AttributeValueMemberS{Value: s.holder},
+		},
+	})
+	if err != nil {
+		slog.Warn(fmt.Sprintf("unable to record instance %s in its slot, %v", instanceID, err))
+	}
+}
+
+// releaseSlotOf releases the slot of the instance, if it holds one.
+func releaseSlotOf(instanceID string) {
+	if s, ok := heldSlots.LoadAndDelete(instanceID); ok {
+		releaseInstanceSlot(s.(instanceSlot))
+	}
+}
+
+// releaseInstanceSlot frees the slot, unless it was reclaimed meanwhile.
+func releaseInstanceSlot(s instanceSlot) {
+	_, err := dynamodb.NewFromConfig(awsConfig).DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName:                aws.String(instanceSlotTable),
+		Key:                      map[string]dbtypes.AttributeValue{"slot": &dbtypes.AttributeValueMemberS{Value: s.key}},
+		ConditionExpression:      aws.String("#holder = :holder"),
+		ExpressionAttributeNames: map[string]string{"#holder": "holder"},
+		ExpressionAttributeValues: map[string]dbtypes.AttributeValue{
+			":holder": &dbtypes.AttributeValueMemberS{Value: s.holder},
+		},
+	})
+	var failed *dbtypes.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &failed) {
+		slog.Warn(fmt.Sprintf("unable to release the instance slot %s, %v", s.key, err))
+	}
+}
+
+// ensureSlotTable creates the slot table on first use.
+func ensureSlotTable(ctx context.Context, client *dynamodb.Client) error {
+	_, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(instanceSlotTable)})
+	var notFound *dbtypes.ResourceNotFoundException
+	if err == nil {
+		return nil
+	} else if !errors.As(err, &notFound) {
+		return fmt.Errorf("unable to describe the instance slot table, %v", err)
+	}
+	slog.Info("creating the DynamoDB table " + instanceSlotTable + " of the -max-instances slots")
+	_, err = client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:            aws.String(instanceSlotTable),
+		AttributeDefinitions: []dbtypes.AttributeDefinition{{AttributeName: aws.String("slot"), AttributeType: dbtypes.ScalarAttributeTypeS}},
+		KeySchema:            []dbtypes.KeySchemaElement{{AttributeName: aws.String("slot"), KeyType: dbtypes.KeyTypeHash}},
+		BillingMode:          dbtypes.BillingModePayPerRequest,
+		Tags:                 []dbtypes.Tag{{Key: aws.String("rbench"), Value: aws.String(awsUserName)}},
+	})
+	// ResourceInUse: created concurrently
+	var inUse *dbtypes.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		return fmt.Errorf("unable to create the instance slot table, %v", err)
+	}
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(instanceSlotTable)}, 2*time.Minute); err != nil {
+		return fmt.Errorf("error waiting for the instance slot table, %v", err)
+	}
+	return nil
+}
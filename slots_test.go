@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlotStale(t *testing.T) {
+	now := time.Now()
+	running := map[string]bool{"i-running": true}
+	for _, test := range []struct {
+		item  slotItem
+		stale bool
+	}{
+		{slotItem{instance: "i-running", acquired: now.Add(-time.Hour)}, false},
+		{slotItem{instance: "i-terminated", acquired: now}, true},
+		// launching
+		{slotItem{acquired: now.Add(-time.Minute)}, false},
+		// the launching process died
+		{slotItem{acquired: now.Add(-time.Hour)}, true},
+	} {
+		if got := test.item.stale(running, now); got != test.stale {
+			t.Errorf("%+v: got stale %v, want %v", test.item, got, test.stale)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// sourceRefRegexp matches the file:line references of test failures (-test.fullpath) and stack traces.
+var sourceRefRegexp = regexp.MustCompile(`(/[^\s:]+\.go):(\d+)`)
+
+// sourceLinker rewrites the file:line references of the output written to w: paths of a
+// -stash-run worktree are mapped back to the local checkout, and with -source-links they become
+// terminal hyperlinks (OSC 8), e.g. to open them in an editor.
+type sourceLinker struct {
+	w        io.Writer
+	from, to string // path prefix mapping, from is empty if none
+	link     string // URL template, with {path} and {line}
+	buf      []byte // incomplete line
+}
+
+// newSourceLinker returns the linker of the output written to w, or nil if there is nothing
+// to rewrite; worktree is the -stash-run worktree, if any.
+func newSourceLinker(w io.Writer, worktree string) *sourceLinker {
+	s := &sourceLinker{w: w}
+	if worktree != "" {
+		if root, err := exec.Command("git", "rev-parse", "--show-toplevel").Output(); err == nil {
+			s.from, s.to = worktree, strings.TrimSpace(string(root))
+		}
+	}
+	if *sourceLinks != "" && !*jsonFlag && isTerminal(os.Stdout) {
+		s.link = *sourceLinks
+	}
+	if s.from == "" && s.link == "" {
+		return nil
+	}
+	return s
+}
+
+func (s *sourceLinker) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := s.rewrite(string(s.buf[:i+1]))
+		s.buf = s.buf[i+1:]
+		if _, err := io.WriteString(s.w, line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes the last incomplete line, if any.
+func (s *sourceLinker) Flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(s.w, s.rewrite(string(s.buf)))
+	s.buf = nil
+	return err
+}
+
+func (s *sourceLinker) rewrite(line string) string {
+	return sourceRefRegexp.ReplaceAllStringFunc(line, func(ref string) string {
+		m := sourceRefRegexp.FindStringSubmatch(ref)
+		path, lineNo := m[1], m[2]
+		if s.from != "" && strings.HasPrefix(path, s.from+"/") {
+			path = s.to + strings.TrimPrefix(path, s.from)
+		}
+		ref = path + ":" + lineNo
+		if s.link == "" {
+			return ref
+		}
+		url := strings.NewReplacer("{path}", path, "{line}", lineNo).Replace(s.link)
+		return "\x1b]8;;" + url + "\x1b\\" + ref + "\x1b]8;;\x1b\\"
+	})
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSourceLinker(t *testing.T) {
+	var b strings.Builder
+	s := &sourceLinker{w: &b, from: "/tmp/rbench-worktree-1", to: "/home/me/repo"}
+	s.Write([]byte("--- FAIL: TestX (0.00s)\n    /tmp/rbench-worktree-1/fft/fft_test.go:12: bad\n\t/usr/lo"))
+	s.Write([]byte("cal/go/src/testing/testing.go:1690 +0x1d"))
+	s.Flush()
+	want := "--- FAIL: TestX (0.00s)\n    /home/me/repo/fft/fft_test.go:12: bad\n\t/usr/local/go/src/testing/testing.go:1690 +0x1d"
+	if b.String() != want {
+		t.Errorf("unexpected output:\n%q\nwant:\n%q", b.String(), want)
+	}
+
+	b.Reset()
+	s = &sourceLinker{w: &b, link: "vscode://file{path}:{line}"}
+	s.Write([]byte("    /repo/a_test.go:3: bad\n"))
+	want = "    \x1b]8;;vscode://file/repo/a_test.go:3\x1b\\/repo/a_test.go:3\x1b]8;;\x1b\\: bad\n"
+	if b.String() != want {
+		t.Errorf("unexpected link:\n%q\nwant:\n%q", b.String(), want)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+var (
+	// errSSHAuth is returned when the instance rejects our key; it is not retried.
+	errSSHAuth = errors.New("ssh authentication failed")
+	// errSSHNetwork is returned when the instance can't be reached; it is retried.
+	errSSHNetwork = errors.New("ssh connection failed")
+)
+
+// sshExitCode is the exit code of ssh (and scp) on connection errors, as opposed to
+// the exit code of the remote command.
+const sshExitCode = 255
+
+// classifySSHError wraps the error of an ssh/scp command with errSSHAuth or errSSHNetwork
+// when it failed before the remote command ran.
+func classifySSHError(err error, stderr string) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	stderr = strings.TrimSpace(stderr)
+	if strings.Contains(stderr, "Permission denied") || strings.Contains(stderr, "Too many authentication failures") {
+		return fmt.Errorf("%w: %s", errSSHAuth, stderr)
+	}
+	if exitErr.ExitCode() == sshExitCode || strings.Contains(stderr, "lost connection") {
+		return fmt.Errorf("%w: %s", errSSHNetwork, stderr)
+	}
+	return fmt.Errorf("%v: %s", err, stderr)
+}
+
+// withRetry calls f until it succeeds, returns a non network error, or the
+// attempts (-ssh-retries) or deadline (-ssh-deadline) are exhausted.
+func withRetry(op string, f func() error) error {
+	deadline := time.Now().Add(*sshDeadline)
+	backoff := *sshBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !errors.Is(err, errSSHNetwork) {
+			return err
+		}
+		// jitter in [backoff/2, 3*backoff/2)
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)+1))
+		if attempt >= *sshRetries || time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("%s: giving up after %d attempts: %w", op, attempt, err)
+		}
+		slog.Debug(op+": retrying", "attempt", attempt, "delay", delay.Round(time.Millisecond), "err", err)
+		time.Sleep(delay)
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// sshOptions returns the options shared by ssh and scp; portFlag is "-p" for ssh and "-P" for scp.
+func sshOptions(portFlag string) []string {
+	opts := []string{
+		"-o", "StrictHostKeyChecking=no",
+		"-o", fmt.Sprintf("ConnectTimeout=%d", int(sshConnectTimeout.Seconds())),
+		portFlag, strconv.Itoa(*sshPort),
+	}
+	switch {
+	case eiceKey != "":
+		opts = append(opts, "-i", eiceKey, "-o", "IdentitiesOnly=yes", "-o", "ProxyCommand="+eiceProxyCommand())
+	case sshKeyName != "":
+		// registered hosts (-target) use the user's own keys
+		opts = append(opts, "-i", privateKeyPath())
+	}
+	if *compress {
+		opts = append(opts, "-C")
+	}
+	return opts
+}
+
+// remoteRunID is set on the registered hosts, shared with other users and runs, to keep the
+// remote files of the run in a directory of its own; an instance runs a single benchmark, in /tmp.
+var remoteRunID string
+
+// remoteTmp returns the directory of the remote files of the run.
+func remoteTmp() string {
+	if remoteRunID == "" {
+		return "/tmp"
+	}
+	return "/tmp/rbench-run-" + remoteRunID
+}
+
+// remoteResultsFile is the output of the benchmark runs, kept on the remote; on an instance,
+// everything under /tmp/rbench-* is retrieved by rbench fetch.
+func remoteResultsFile() string {
+	return remoteTmp() + "/rbench-results.txt"
+}
+
+// remoteBenchPID is the pid of the running benchmark, see benchCommand.
+func remoteBenchPID() string {
+	return remoteTmp() + "/rbench-pid"
+}
+
+// remoteExitFile is the exit status of the last benchmark run.
+func remoteExitFile() string {
+	return remoteTmp() + "/rbench-exit"
+}
+
+// seedEnv is the environment variable the benchmarks can seed their random inputs from (-seed).
+const seedEnv = "RBENCH_SEED"
+
+// benchTestArgs returns the arguments of the test binary running the benchmarks matching bench on r.
+func benchTestArgs(r remote, bench string, count int) []string {
+	testArgs := []string{
+		fmt.Sprintf("-test.bench=%s", bench),
+		fmt.Sprintf("-test.count=%d", count),
+		fmt.Sprintf("-test.benchmem=%t", *benchMem),
+		fmt.Sprintf("-test.run=%s", benchRun()),
+		// absolute file:line references in failures (go 1.21+), see sourceLinker
+		"-test.fullpath=true",
+	}
+	if arg := cpuArg(r); arg != "" {
+		testArgs = append(testArgs, arg)
+	}
+	if *benchTime != "" {
+		testArgs = append(testArgs, fmt.Sprintf("-test.benchtime=%s", *benchTime))
+	}
+	if *shuffleFlag != "off" {
+		testArgs = append(testArgs, "-test.shuffle="+*shuffleFlag)
+	}
+	if *coverProfile != "" {
+		testArgs = append(testArgs, "-test.coverprofile="+remoteCoverProfile())
+	}
+	if *cpuProfile != "" {
+		testArgs = append(testArgs, "-test.cpuprofile="+remoteCPUProfile())
+	}
+	if *jsonFlag {
+		// framing markers for test2json
+		testArgs = append(testArgs, "-test.v=test2json")
+	}
+	return append(testArgs, extraTestArgs()...)
+}
+
+// benchRun returns the -test.run pattern of the benchmark runs: with -time-tests, the tests and
+// examples run separately.
+func benchRun() string {
+	if *timeTests {
+		return "NONE"
+	}
+	return *run
+}
+
+// extraTestArgs returns the arguments of the test binary common to all its runs: -timeout,
+// -short and the arguments after --.
+func extraTestArgs() []string {
+	var args []string
+	if *timeoutFlag > 0 {
+		args = append(args, "-test.timeout="+timeoutFlag.String())
+	}
+	if *shortFlag {
+		args = append(args, "-test.short")
+	}
+	return append(args, testBinaryArgs...)
+}
+
+// sshExec runs the benchmark on the instance, with the env variables (KEY=value), streams its
+// output and collects the results. connection failures are only retried if the benchmark didn't
+// produce any output yet.
+func sshExec(r remote, out io.Writer, results *benchResults, bench string, count int, env ...string) error {
+	benchCmd := benchCommand(r, benchTestArgs(r, bench, count), env...)
+	if *confidential == "enclave" {
+		// the arguments and the environment are in the enclave image
+		benchCmd = enclaveCommand()
+	}
+	// the output is also kept on the instance (see rbench fetch): hangups and broken pipes
+	// are ignored so that the benchmark runs to completion if the local side goes away.
+	// like go test, stderr is merged into stdout, unless it carries the gc trace.
+	stderr, stderrFile := "2>&1", remoteResultsFile()
+	if *gcStats {
+		stderr, stderrFile = "2>>"+remoteGCTrace(), remoteGCTrace()
+	}
+	command := fmt.Sprintf("trap '' HUP PIPE; cd %s && %s{ %s %s; echo $? > %s; } | tee -a %s; exit $(cat %s)",
+		r.runDir(), benchCredsPrefix(), benchCmd, stderr, remoteExitFile(), remoteResultsFile(), remoteExitFile())
+	if *watchdog > 0 && *watchdogAbort {
+		// the watchdog runs next to the benchmark, so that it also stops hangs if the
+		// connection is lost.
+		command = fmt.Sprintf("trap '' HUP PIPE; cd %s && %srm -f %s; { %s; } & wd=$!; { %s %s; echo $? > %s; } | tee -a %s; kill $wd 2>/dev/null; exit $(cat %s)",
+			r.runDir(), benchCredsPrefix(), remoteHangFile(), watchdogScript(stderrFile), benchCmd, stderr, remoteExitFile(), remoteResultsFile(), remoteExitFile())
+	} else if *watchdog > 0 {
+		command = "rm -f " + remoteHangFile() + "; " + command
+	}
+	args := append(sshOptions("-p"), r.String(), command)
+
+	return withRetry("run benchmark", func() error {
+		cmd := exec.Command("ssh", args...)
+
+		// Stream stdout and stderr
+		stdout := &countingWriter{w: io.MultiWriter(out, results)}
+		if *watchdog > 0 && !*watchdogAbort {
+			monitor := &hangMonitor{}
+			stdout.w = io.MultiWriter(out, results, monitor)
+			done := make(chan struct{})
+			defer close(done)
+			go monitor.watch(r, stderrFile, done)
+		}
+		var stderr bytes.Buffer
+		cmd.Stdout = stdout
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+
+		start := time.Now()
+		var err error
+		if r.agent != nil {
+			// reconnects by itself
+			err = r.agent.run(command, stdout)
+		} else {
+			err = cmd.Run()
+		}
+		auditCommand(r, command, start, err)
+		if err != nil {
+			if r.agent == nil {
+				err = classifySSHError(err, stderr.String())
+			}
+			if stdout.n > 0 && errors.Is(err, errSSHNetwork) {
+				// the benchmark started; don't run it twice.
+				return fmt.Errorf("connection lost during the benchmark: %v", err)
+			}
+			if *watchdog > 0 {
+				if herr := checkHang(r, stderrFile); herr != nil {
+					return herr
+				}
+			}
+			return fmt.Errorf("failed to run the benchmark: %w", err)
+		}
+		return nil
+	})
+}
+
+// benchCommand returns the shell command running the test binary of r with testArgs, in the
+// environment of the benchmark (its seed, -gcstats, -aslr, the pinning) plus env; the warmup
+// passes run the same command.
+func benchCommand(r remote, testArgs []string, env ...string) string {
+	// the command is interpreted by the remote shell; regular expressions must be quoted.
+	env = append(append(env, r.env...), fmt.Sprintf("%s=%d", seedEnv, *seedFlag))
+	if *gcStats {
+		env = append(env, "GODEBUG=gctrace=1")
+	}
+	benchCmd := r.binary()
+	if *wasmFlag != "" {
+		// the runtime passes the variables to the module
+		benchCmd, env = wasmCommand(env), nil
+	}
+	if !*aslr {
+		benchCmd = "setarch $(uname -m) -R " + benchCmd
+	}
+	if r.pin != "" {
+		benchCmd = "taskset -c " + r.pin + " " + benchCmd
+	}
+	for _, e := range env {
+		// an assignment, the value only is quoted
+		k, v, _ := strings.Cut(e, "=")
+		benchCmd = k + "=" + shellQuote(v) + " " + benchCmd
+	}
+	// the benchmark records its pid before replacing the shell (env, setarch and taskset exec
+	// it too), to be signaled without touching the other runs of a shared host.
+	benchCmd = "sh -c " + shellQuote("echo $$ > "+remoteBenchPID()+`; exec "$@"`) + " bench env " + benchCmd
+	if *coreDumps {
+		benchCmd = "ulimit -c unlimited; GOTRACEBACK=crash " + benchCmd
+	}
+	for _, a := range testArgs {
+		benchCmd += " " + shellQuote(a)
+	}
+	return benchCmd
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// sshRun runs a command on the instance and returns its combined output.
+// it is retried on network errors, so command must be idempotent.
+func sshRun(r remote, command string) (out string, err error) {
+	err = withRetry("ssh", func() error {
+		out, err = sshRunOnce(r, command)
+		return err
+	})
+	return out, err
+}
+
+// sshRunOnce is like sshRun, without retries.
+func sshRunOnce(r remote, command string) (string, error) {
+	args := append(sshOptions("-p"), r.String(), command)
+	cmd := exec.Command("ssh", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	start := time.Now()
+	err := cmd.Run()
+	auditCommand(r, command, start, err)
+	if err != nil {
+		return stdout.String(), fmt.Errorf("ssh command failed: %w", classifySSHError(err, stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// scpCopy copies src to dst (either may be remote, user@host:path), retrying on network errors.
+func scpCopy(op, src, dst string) error {
+	args := sshOptions("-P")
+	if *bwLimit > 0 {
+		args = append(args, "-l", strconv.Itoa(*bwLimit))
+	}
+	args = append(args, "-r", src, dst)
+
+	return withRetry(op, func() error {
+		cmd := exec.Command("scp", args...)
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return classifySSHError(err, stderr.String())
+		}
+		return nil
+	})
+}
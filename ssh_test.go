@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifySSHError(t *testing.T) {
+	exit := func(code string) error {
+		return exec.Command("sh", "-c", "exit "+code).Run()
+	}
+
+	if err := classifySSHError(exit("255"), "ubuntu@1.2.3.4: Permission denied (publickey)."); !errors.Is(err, errSSHAuth) {
+		t.Errorf("expected auth error, got %v", err)
+	}
+	if err := classifySSHError(exit("255"), "ssh: connect to host 1.2.3.4 port 22: Connection refused"); !errors.Is(err, errSSHNetwork) {
+		t.Errorf("expected network error, got %v", err)
+	}
+	if err := classifySSHError(exit("1"), ""); errors.Is(err, errSSHNetwork) || errors.Is(err, errSSHAuth) {
+		t.Errorf("remote command failure classified as ssh error: %v", err)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	defer func(r int, b time.Duration) { *sshRetries, *sshBackoff = r, b }(*sshRetries, *sshBackoff)
+	*sshRetries, *sshBackoff = 3, time.Millisecond
+
+	calls := 0
+	err := withRetry("test", func() error {
+		calls++
+		return errSSHNetwork
+	})
+	if !errors.Is(err, errSSHNetwork) || calls != 3 {
+		t.Errorf("expected 3 attempts and a network error, got %d attempts, %v", calls, err)
+	}
+
+	calls = 0
+	err = withRetry("test", func() error {
+		calls++
+		return errSSHAuth
+	})
+	if !errors.Is(err, errSSHAuth) || calls != 1 {
+		t.Errorf("auth errors must not be retried, got %d attempts, %v", calls, err)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	out, err := exec.Command("sh", "-c", "printf %s "+shellQuote("^A|B$ 'x'")).Output()
+	if err != nil || string(out) != "^A|B$ 'x'" {
+		t.Fatalf("unexpected output %q, %v", out, err)
+	}
+}
+
+func TestRemotePath(t *testing.T) {
+	if got := (remote{user: "ubuntu", host: "203.0.113.7"}).path("/tmp/bench"); got != "ubuntu@203.0.113.7:/tmp/bench" {
+		t.Errorf("unexpected IPv4 path %q", got)
+	}
+	if got := (remote{user: "ubuntu", host: "2001:db8::7"}).path("/tmp/bench"); got != "ubuntu@[2001:db8::7]:/tmp/bench" {
+		t.Errorf("unexpected IPv6 path %q", got)
+	}
+}
+
+func TestBenchTestArgsExtra(t *testing.T) {
+	defer func(timeout time.Duration, short bool, extra []string) {
+		*timeoutFlag, *shortFlag, testBinaryArgs = timeout, short, extra
+	}(*timeoutFlag, *shortFlag, testBinaryArgs)
+	*timeoutFlag, *shortFlag, testBinaryArgs = 20*time.Minute, true, []string{"-test.v", "-size=large"}
+
+	args := benchTestArgs(remote{}, ".", 1)
+	want := []string{"-test.timeout=20m0s", "-test.short", "-test.v", "-size=large"}
+	if got := args[len(args)-len(want):]; !slices.Equal(got, want) {
+		t.Errorf("benchTestArgs ends with %q, expected %q", got, want)
+	}
+}
+
+func TestBenchCommand(t *testing.T) {
+	defer func(aslrOn bool) { *aslr = aslrOn }(*aslr)
+	*aslr = false
+
+	got := benchCommand(remote{bin: "./bench", pin: "2-3"}, []string{"-test.bench=A|B"}, "GOGC=off")
+	for _, want := range []string{seedEnv + "=", "GOGC='off' ", "taskset -c 2-3 setarch $(uname -m) -R ", "'-test.bench=A|B'", "echo $$ > /tmp/rbench-pid; exec"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("benchCommand = %q, expected it to contain %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// checkStatic returns an error if the binary has dynamic dependencies: an interpreter or
+// shared libraries, which minimal images (e.g. alpine, musl based) may not provide.
+func checkStatic(fileName string) error {
+	f, err := elf.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to read binary, %v", err)
+	}
+	defer f.Close()
+	libs, _ := f.ImportedLibraries()
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			libs = append(libs, "interpreter")
+			break
+		}
+	}
+	if len(libs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("binary is not static (needs %s)%s", strings.Join(libs, ", "), cgoReport())
+}
+
+// cgoReport lists the packages of the benchmark which use cgo, to explain why a static
+// build fails or isn't static.
+func cgoReport() string {
+	args := []string{"list", "-deps", "-test", "-f", "{{if .CgoFiles}}{{.ImportPath}}{{end}}"}
+	if *tagsFlag != "" {
+		args = append(args, "-tags", *tagsFlag)
+	}
+	cmd := exec.Command("go", append(args, benchPackage)...)
+	cmd.Dir = buildDir
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=1")
+	out, err := cmd.Output()
+	pkgs := strings.Fields(string(out))
+	if err != nil || len(pkgs) == 0 {
+		return ""
+	}
+	return "; packages using cgo: " + strings.Join(pkgs, ", ")
+}
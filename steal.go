@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the first line of /proc/stat is sampled every second during the run, to measure the CPU time
+// stolen by the hypervisor for other tenants and the time waiting for I/O: remoteCPUStat is the
+// samples, remoteCPUStatPID the pid of the sampler.
+func remoteCPUStat() string {
+	return remoteTmp() + "/rbench-cpustat.txt"
+}
+
+func remoteCPUStatPID() string {
+	return remoteTmp() + "/rbench-cpustat.pid"
+}
+
+// errHighSteal reports a run above -max-steal, retried on a fresh instance with -steal-retries.
+var errHighSteal = errors.New("steal time above -max-steal")
+
+// startStealMonitor starts sampling /proc/stat in the background on the instance.
+func startStealMonitor(r remote) error {
+	_, err := sshRun(r, fmt.Sprintf("nohup sh -c 'while :; do head -1 /proc/stat; sleep 1; done' > %s 2>&1 < /dev/null & echo $! > %s",
+		remoteCPUStat(), remoteCPUStatPID()))
+	if err != nil {
+		return fmt.Errorf("unable to start the steal time monitor, %v", err)
+	}
+	return nil
+}
+
+// stopStealMonitor stops the sampling and returns the noise measured over the run.
+func stopStealMonitor(r remote) (cpuNoise, error) {
+	out, err := sshRun(r, fmt.Sprintf("kill $(cat %s); cat %s", remoteCPUStatPID(), remoteCPUStat()))
+	if err != nil {
+		return cpuNoise{}, fmt.Errorf("unable to collect the steal time samples, %v", err)
+	}
+	return parseCPUStat(out)
+}
+
+// cpuNoise is the share of the CPU time stolen by the hypervisor and spent waiting for I/O, in
+// percent, over the run and for the worst sample.
+type cpuNoise struct {
+	steal, maxSteal, iowait float64
+}
+
+// parseCPUStat computes the noise from successive "cpu ..." lines of /proc/stat: user nice
+// system idle iowait irq softirq steal, in clock ticks.
+func parseCPUStat(samples string) (cpuNoise, error) {
+	var ticks [][]uint64
+	for _, line := range strings.Split(samples, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || fields[0] != "cpu" {
+			continue
+		}
+		t := make([]uint64, 8)
+		for i := range t {
+			t[i], _ = strconv.ParseUint(fields[i+1], 10, 64)
+		}
+		ticks = append(ticks, t)
+	}
+	if len(ticks) < 2 {
+		return cpuNoise{}, fmt.Errorf("not enough /proc/stat samples to measure the steal time")
+	}
+	total := func(t []uint64) (sum uint64) {
+		for _, v := range t {
+			sum += v
+		}
+		return sum
+	}
+	var n cpuNoise
+	for i := 1; i < len(ticks); i++ {
+		if d := total(ticks[i]) - total(ticks[i-1]); d > 0 {
+			n.maxSteal = math.Max(n.maxSteal, 100*float64(ticks[i][7]-ticks[i-1][7])/float64(d))
+		}
+	}
+	first, last := ticks[0], ticks[len(ticks)-1]
+	if d := total(last) - total(first); d > 0 {
+		n.steal = 100 * float64(last[7]-first[7]) / float64(d)
+		n.iowait = 100 * float64(last[4]-first[4]) / float64(d)
+	}
+	return n, nil
+}
+
+// qualityScore rates a run from 0 to 100: 100 minus 10 points per percent of steal time, 2 per
+// percent of iowait and 1 per percent of mean run-to-run spread of the benchmarks.
+func qualityScore(n cpuNoise, results *benchResults) float64 {
+	var sum float64
+	var count int
+	for _, name := range results.names {
+		if values := results.values(name, "ns/op"); len(values) > 1 {
+			sum += spread(values)
+			count++
+		}
+	}
+	score := 100 - 10*n.steal - 2*n.iowait
+	if count > 0 {
+		score -= sum / float64(count)
+	}
+	return math.Max(0, math.Min(100, score))
+}
+
+// runQuality records the quality of the runs of the targets, for the bundle manifest.
+var runQuality struct {
+	sync.Mutex
+	targets []targetQuality
+}
+
+type targetQuality struct {
+	Target   string  `json:"target"`
+	Score    float64 `json:"score"`
+	Steal    float64 `json:"steal"`     // percent of the CPU time
+	MaxSteal float64 `json:"max_steal"` // worst one-second sample
+	IOWait   float64 `json:"iowait"`
+}
+
+// reportQuality prints the noise and the quality score of the run of the target, and records them.
+func reportQuality(w io.Writer, t target, r remote, n cpuNoise, results *benchResults) targetQuality {
+	q := targetQuality{Target: r.host, Score: qualityScore(n, results), Steal: n.steal, MaxSteal: n.maxSteal, IOWait: n.iowait}
+	if t.label != "" {
+		q.Target = t.label
+	}
+	fmt.Fprintf(w, "measurement quality: %.0f/100 (steal %.1f%%, max %.1f%% over 1s, iowait %.1f%%)\n", q.Score, q.Steal, q.MaxSteal, q.IOWait)
+	runQuality.Lock()
+	runQuality.targets = append(runQuality.targets, q)
+	runQuality.Unlock()
+	return q
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseCPUStat(t *testing.T) {
+	const samples = `cpu  1000 0 100 800 0 0 0 100 0 0
+cpu  1050 0 110 820 10 0 0 110 0 0
+cpu  1100 0 120 900 10 0 0 170 0 0
+`
+	n, err := parseCPUStat(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 70 of 300 ticks stolen, 60 of 200 in the worst second
+	if math.Abs(n.steal-100*70.0/300) > 1e-9 || math.Abs(n.maxSteal-30) > 1e-9 || math.Abs(n.iowait-100*10.0/300) > 1e-9 {
+		t.Errorf("unexpected noise %+v", n)
+	}
+	if _, err := parseCPUStat("cpu  1 2 3 4 5 6 7 8 9 10\n"); err == nil {
+		t.Error("expected an error with a single sample")
+	}
+}
+
+func TestQualityScore(t *testing.T) {
+	results := newBenchResults()
+	results.Write([]byte("BenchmarkA-8 100 100 ns/op\nBenchmarkA-8 100 104 ns/op\nBenchmarkA-8 100 102 ns/op\n"))
+	// spread ±2/102
+	want := 100 - 10 - 1 - 200.0/102
+	if got := qualityScore(cpuNoise{steal: 1, iowait: 0.5}, results); math.Abs(got-want) > 1e-9 {
+		t.Errorf("qualityScore = %v, want %v", got, want)
+	}
+	if got := qualityScore(cpuNoise{steal: 20}, newBenchResults()); got != 0 {
+		t.Errorf("qualityScore = %v, want 0", got)
+	}
+}
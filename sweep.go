@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// runSweep runs the benchmarks with each -gogc value on the same instance, in time slices:
+// every round runs one repetition of each variant, in a rotating order, so that slow drifts
+// (thermal throttling, noisy neighbors) affect all the variants equally. Each slice is preceded
+// by a gogc config line (benchstat -col gogc); results are collected per variant.
+func runSweep(r remote, out io.Writer, variants []string, results map[string]*benchResults) error {
+	for round := 0; round < *countFlag; round++ {
+		if finishing.Load() || interrupted.Load() {
+			// stopped on interrupt, after the current round
+			return nil
+		}
+		for i := range variants {
+			v := variants[(round+i)%len(variants)]
+			fmt.Fprintf(out, "gogc: %s\n", v)
+			if err := sshExec(r, out, results[v], benchPattern(r), 1, "GOGC="+v); err != nil {
+				if continueAfterHang(err) {
+					continue
+				}
+				return fmt.Errorf("gogc=%s: %w", v, err)
+			}
+		}
+	}
+	return nil
+}
+
+// mergeSweep returns the results of all the variants, named by variantName: the results of the
+// run for the consumers of a single set (noise report, history, summaries), like the CI and the
+// performance budget read them from the output.
+func mergeSweep(variants []string, results map[string]*benchResults) *benchResults {
+	merged := newBenchResults()
+	for _, v := range variants {
+		vr := results[v]
+		vr.mu.Lock()
+		for _, name := range vr.names {
+			merged.add(variantName(name, "gogc", v), vr.samples[name])
+		}
+		vr.mu.Unlock()
+	}
+	return merged
+}
+
+// printSweepSummary prints the medians of each variant, one table per unit.
+func printSweepSummary(w io.Writer, variants []string, results map[string]*benchResults) {
+	first := results[variants[0]]
+	for _, unit := range first.units() {
+		fmt.Fprintf(w, "\ngogc sweep (median %s ±spread, %d time-sliced rounds):\n", unit, *countFlag)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "\t%s\n", strings.Join(variants, "\t"))
+		for _, name := range first.names {
+			fmt.Fprintf(tw, "%s", name)
+			for _, v := range variants {
+				values := results[v].values(name, unit)
+				if len(values) == 0 {
+					fmt.Fprintf(tw, "\t-")
+					continue
+				}
+				fmt.Fprintf(tw, "\t%.4g ±%.1f%%", median(values), spread(values))
+			}
+			fmt.Fprintf(tw, "\n")
+		}
+		tw.Flush()
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSweepSummary(t *testing.T) {
+	variants := []string{"100", "off"}
+	results := map[string]*benchResults{"100": newBenchResults(), "off": newBenchResults()}
+	results["100"].Write([]byte("BenchmarkA-2 100 10 ns/op\nBenchmarkA-2 100 12 ns/op\nBenchmarkB-2 100 5 ns/op\n"))
+	results["off"].Write([]byte("BenchmarkA-2 100 8 ns/op\n"))
+
+	var b strings.Builder
+	printSweepSummary(&b, variants, results)
+	for _, want := range []string{"gogc sweep (median ns/op", "BenchmarkA-2  11 ±", "8 ±0.0%", "BenchmarkB-2  5 ±0.0%   -"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("summary doesn't contain %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestMergeSweep(t *testing.T) {
+	variants := []string{"100", "off"}
+	results := map[string]*benchResults{"100": newBenchResults(), "off": newBenchResults()}
+	results["100"].Write([]byte("BenchmarkA-2 100 10 ns/op\nBenchmarkA-2 100 12 ns/op\n"))
+	results["off"].Write([]byte("BenchmarkA-2 100 8 ns/op\n"))
+
+	// the live results and the output read back agree
+	output := newBenchResults()
+	output.Write([]byte("goos: linux\ngogc: 100\nBenchmarkA-2 100 10 ns/op\ngogc: off\nBenchmarkA-2 100 8 ns/op\ngogc: 100\nBenchmarkA-2 100 12 ns/op\n"))
+	for _, merged := range []*benchResults{mergeSweep(variants, results), output} {
+		if !slices.Equal(merged.names, []string{"BenchmarkA/gogc=100-2", "BenchmarkA/gogc=off-2"}) {
+			t.Fatalf("unexpected names %v", merged.names)
+		}
+		if v := merged.values("BenchmarkA/gogc=100-2", "ns/op"); len(v) != 2 || median(v) != 11 {
+			t.Errorf("unexpected gogc=100 values %v", v)
+		}
+	}
+}
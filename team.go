@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// teamConfigParameter is the SSM parameter holding the team configuration (AMIs, security group,
+// subnet, default flags), published by an admin with rbench config push.
+const teamConfigParameter = "/rbench/config"
+
+// configCmd implements "rbench config push <file>" and "rbench config pull".
+func configCmd(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	acceptHook := fs.Bool("accept-hook", false, "pull: accept a new or changed policy-hook of the team configuration without asking")
+	awsFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench config push [flags] <file>   publish the team configuration\n")
+		fmt.Fprintf(fs.Output(), "       rbench config pull [flags]          sync the team configuration to %s\n", teamConfigPath())
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("expected push or pull")
+	}
+	action := args[0]
+	fs.Parse(args[1:])
+	if err := loadAWSConfig(); err != nil {
+		return err
+	}
+
+	switch action {
+	case "push":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return fmt.Errorf("expected a configuration file")
+		}
+		return pushTeamConfig(fs.Arg(0))
+	case "pull":
+		return pullTeamConfig(*acceptHook)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
+
+// pushTeamConfig publishes the configuration file path as the team configuration.
+func pushTeamConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := parseConfig(strings.NewReader(string(data))); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	out, err := ssm.NewFromConfig(awsConfig).PutParameter(context.TODO(), &ssm.PutParameterInput{
+		Name:        aws.String(teamConfigParameter),
+		Value:       aws.String(string(data)),
+		Type:        ssmtypes.ParameterTypeString,
+		Tier:        ssmtypes.ParameterTierIntelligentTiering,
+		Description: aws.String("rbench team configuration (rbench config pull)"),
+		Overwrite:   aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to publish the team configuration, %v", err)
+	}
+	fmt.Printf("team configuration version %d published to %s\n", out.Version, teamConfigParameter)
+	return nil
+}
+
+// pullTeamConfig writes the published team configuration next to the configuration file; keys
+// of the local configuration take precedence. A new or changed policy-hook, a command run on this
+// machine before each launch, is only kept if accepted (on the terminal, or with acceptHook).
+func pullTeamConfig(acceptHook bool) error {
+	out, err := ssm.NewFromConfig(awsConfig).GetParameter(context.TODO(), &ssm.GetParameterInput{
+		Name: aws.String(teamConfigParameter),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read the team configuration %s, %v", teamConfigParameter, err)
+	}
+	data := aws.ToString(out.Parameter.Value)
+	team, err := parseConfig(strings.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid team configuration %s: %v", teamConfigParameter, err)
+	}
+
+	path := teamConfigPath()
+	previous, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if hook := team[""]["policy-hook"]; hook != "" && hook != previous[""]["policy-hook"] && !acceptHook {
+		accepted := false
+		if isTerminal(os.Stdin) {
+			fmt.Printf("the team configuration runs this policy-hook before each launch:\n  %s\n", hook)
+			answer, err := prompt(bufio.NewReader(os.Stdin), "accept it (yes/no)", "no", nil)
+			if err != nil {
+				return err
+			}
+			accepted = answer == "yes" || answer == "y"
+		}
+		if !accepted {
+			slog.Warn("policy-hook of the team configuration not accepted, removed; pull again with -accept-hook to keep it")
+			data = withoutConfigKey(data, "policy-hook")
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create config directory, %v", err)
+	}
+	// written then renamed, so that a concurrent run never reads a partial file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return fmt.Errorf("unable to write the team configuration, %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("unable to write the team configuration, %v", err)
+	}
+	fmt.Printf("team configuration version %d written to %s\n", out.Parameter.Version, path)
+	return nil
+}
+
+// withoutConfigKey returns the configuration data without its top-level key.
+func withoutConfigKey(data, key string) string {
+	var lines []string
+	for _, line := range strings.SplitAfter(data, "\n") {
+		if k, _, ok := strings.Cut(line, ":"); ok && line[0] != ' ' && line[0] != '\t' && strings.TrimSpace(k) == key {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestWithoutConfigKey(t *testing.T) {
+	data := "region: us-east-2\npolicy-hook: conftest test -\nprofiles:\n  policy-hook: kept\n  arm: type=c7g.large"
+	want := "region: us-east-2\nprofiles:\n  policy-hook: kept\n  arm: type=c7g.large"
+	if got := withoutConfigKey(data, "policy-hook"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
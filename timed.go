@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// -time-tests times the tests and examples selected by -run on the instance, for the scenarios
+// written as tests or examples rather than benchmarks: each one runs alone in a test binary
+// process, -count rounds, and its wall-clock duration (process start included) is reported as a
+// benchmark result, so that it is summarized and compared like the benchmarks:
+//
+//	BenchmarkRun/ExampleCheckout 1 48211734 ns/op
+//
+// only the examples with an output comment are run, like with go test.
+
+// timedPrefix is the benchmark name prefix of the durations of the tests and examples.
+const timedPrefix = "BenchmarkRun/"
+
+// timedTestsScript runs the tests and examples of the binary matching the -run pattern once each
+// and prints their durations; the output of the failed ones is printed instead.
+func timedTestsScript(r remote) string {
+	bench := r.binary()
+	if r.pin != "" {
+		bench = "taskset -c " + r.pin + " " + bench
+	}
+	bench = fmt.Sprintf("%s=%d %s", seedEnv, *seedFlag, bench)
+	args := ""
+	for _, a := range extraTestArgs() {
+		args += " " + shellQuote(a)
+	}
+	return fmt.Sprintf(`cd %[1]s && %[6]s%[2]s -test.list=%[3]s | grep -E '^(Test|Example)' | while read -r name; do
+  start=$(date +%%s%%N)
+  if %[2]s -test.run="^${name}\$" -test.bench=NONE -test.count=1%[4]s > rbench-timed.out 2>&1; then
+    echo "%[5]s$name 1 $(( $(date +%%s%%N) - start )) ns/op"
+  else
+    cat rbench-timed.out; echo "--- FAIL: $name"
+  fi
+done`, r.runDir(), bench, shellQuote(*run), args, timedPrefix, benchCredsPrefix())
+}
+
+// runTimedTests runs -count rounds of the timed tests and examples, and writes their durations to
+// out and results.
+func runTimedTests(t target, r remote, out io.Writer, results *benchResults) error {
+	script := timedTestsScript(r)
+	for round := 0; round < *countFlag; round++ {
+		if finishing.Load() || interrupted.Load() {
+			return nil
+		}
+		t.status("timing the tests and examples (%d/%d)...", round+1, *countFlag)
+		output, err := sshRun(r, script)
+		if err != nil {
+			return fmt.Errorf("unable to time the tests, %v", err)
+		}
+		if round == 0 && !strings.Contains(output, timedPrefix) && !strings.Contains(output, "--- FAIL") {
+			return fmt.Errorf("-time-tests: no test nor example with an output comment matches -run=%s", *run)
+		}
+		fmt.Fprint(out, output)
+		results.Write([]byte(output))
+	}
+	return nil
+}
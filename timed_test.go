@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTimedTestsScript(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module p\n")
+	write("p_test.go", `package p
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCheckout(t *testing.T) {}
+
+func TestBroken(t *testing.T) { t.Fatal("broken") }
+
+func ExampleCheckout() {
+	fmt.Println("ok")
+	// Output: ok
+}
+
+// not run, without an output comment
+func ExampleCart() {}
+`)
+	bin := filepath.Join(dir, "bench")
+	cmd := exec.Command("go", "test", "-c", "-vet=off", "-o", bin, ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	defer func(pattern string) { *run = pattern }(*run)
+	*run = "Checkout|Cart|Broken"
+	out, err := exec.Command("sh", "-c", timedTestsScript(remote{bin: bin})).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	results := newBenchResults()
+	results.Write(out)
+	for _, name := range []string{"BenchmarkRun/TestCheckout", "BenchmarkRun/ExampleCheckout"} {
+		if v := results.values(name, "ns/op"); len(v) != 1 || v[0] <= 0 {
+			t.Errorf("no duration of %s:\n%s", name, out)
+		}
+	}
+	if !strings.Contains(string(out), "--- FAIL: TestBroken") || strings.Contains(string(out), "ExampleCart") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// remoteTmpfsDir is the tmpfs working directory of the benchmark (-tmpfs); one per run on a
+// registered host.
+func remoteTmpfsDir() string {
+	if remoteRunID == "" {
+		return "/mnt/rbench-tmpfs"
+	}
+	return "/mnt/rbench-tmpfs-" + remoteRunID
+}
+
+var tmpfsSizeRegexp = regexp.MustCompile(`^[0-9]+[kmgKMG]?$`)
+
+// remoteWorkDir returns the working directory of the benchmark on the instance: the tmpfs
+// with -tmpfs, remoteTmp otherwise. The binary is ./bench in it.
+func remoteWorkDir() string {
+	if *tmpfsFlag != "" {
+		return remoteTmpfsDir()
+	}
+	return remoteTmp()
+}
+
+// setupTmpfs mounts a tmpfs of -tmpfs size and copies the benchmark binary to it, so that the
+// files the benchmark writes in its working directory stay in memory. It returns the benchfmt
+// configuration line of the mount, and warns if the tmpfs can't fit in the free memory.
+func setupTmpfs(t target, r remote) (string, error) {
+	out, err := sshRun(r, fmt.Sprintf(`sudo mkdir -p %[1]s && (mountpoint -q %[1]s || sudo mount -t tmpfs -o size=%[2]s tmpfs %[1]s) && `+
+		`sudo chown $(id -u):$(id -g) %[1]s && cp %[3]s/bench %[1]s/bench && `+
+		`df -B1 --output=size %[1]s | tail -1 && grep MemAvailable /proc/meminfo`, remoteTmpfsDir(), *tmpfsFlag, remoteTmp()))
+	if err != nil {
+		return "", fmt.Errorf("unable to mount the tmpfs, %v", err)
+	}
+	fields := strings.Fields(out)
+	if len(fields) >= 3 {
+		size, _ := strconv.ParseInt(fields[0], 10, 64)
+		availKB, _ := strconv.ParseInt(fields[2], 10, 64)
+		if size > availKB*1024 {
+			slog.Warn(fmt.Sprintf("%s-tmpfs=%s is larger than the available memory (%d MB): filling it will swap or trigger the OOM killer",
+				t.prefix(), *tmpfsFlag, availKB/1024))
+		}
+	}
+	return "tmpfs: " + *tmpfsFlag, nil
+}
+
+// checkTmpfsUsage warns if the benchmark filled the tmpfs: its writes beyond the size failed.
+func checkTmpfsUsage(t target, r remote) {
+	out, err := sshRun(r, "df -B1 --output=used,size "+remoteTmpfsDir()+" | tail -1")
+	if err != nil {
+		slog.Warn(t.prefix() + err.Error())
+		return
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return
+	}
+	used, _ := strconv.ParseInt(fields[0], 10, 64)
+	size, _ := strconv.ParseInt(fields[1], 10, 64)
+	if size > 0 && used*100 >= size*95 {
+		slog.Warn(fmt.Sprintf("%sthe benchmark filled the tmpfs (%d/%d MB); its data exceeds -tmpfs=%s, writes may have failed (ENOSPC)",
+			t.prefix(), used>>20, size>>20, *tmpfsFlag))
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestTmpfsSize(t *testing.T) {
+	for size, ok := range map[string]bool{"2g": true, "512M": true, "1048576": true, "2gb": false, "-1g": false, "": false} {
+		if tmpfsSizeRegexp.MatchString(size) != ok {
+			t.Errorf("size %q: expected valid=%t", size, ok)
+		}
+	}
+}
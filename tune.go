@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tuneSetting is a kernel parameter set on the instance before the run;
+// key is either a sysctl name or an absolute path (e.g. in /sys), which may be a glob
+// pattern setting all the matching files.
+type tuneSetting struct {
+	key   string
+	value string
+}
+
+// tunePresets are the named presets selectable with -tune.
+var tunePresets = map[string][]tuneSetting{
+	"network": {
+		{"net.core.somaxconn", "65535"},
+		{"net.core.netdev_max_backlog", "65535"},
+		{"net.ipv4.tcp_max_syn_backlog", "65535"},
+		{"net.ipv4.tcp_tw_reuse", "1"},
+		{"net.ipv4.tcp_fin_timeout", "15"},
+		{"net.core.rmem_max", "16777216"},
+		{"net.core.wmem_max", "16777216"},
+	},
+	"lowlatency": {
+		{"net.core.busy_poll", "50"},
+		{"net.core.busy_read", "50"},
+		{"kernel.numa_balancing", "0"},
+		{"vm.swappiness", "0"},
+		{"/sys/kernel/mm/transparent_hugepage/enabled", "never"},
+		{"/sys/kernel/mm/transparent_hugepage/defrag", "never"},
+	},
+	// -stable: the clock of the cores doesn't depend on the load nor on the temperature. The
+	// cpufreq controls are only exposed on metal and on the types with processor state control.
+	"stable": {
+		{"/sys/devices/system/cpu/cpu*/cpufreq/scaling_governor", "performance"},
+		{"/sys/devices/system/cpu/intel_pstate/no_turbo", "1"},
+		{"/sys/devices/system/cpu/cpufreq/boost", "0"},
+	},
+}
+
+// parseTunePresets validates a comma separated list of preset names.
+func parseTunePresets(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var presets []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := tunePresets[name]; !ok {
+			known := make([]string, 0, len(tunePresets))
+			for k := range tunePresets {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown tune preset %q (available: %s)", name, strings.Join(known, ", "))
+		}
+		presets = append(presets, name)
+	}
+	return presets, nil
+}
+
+// parseToggles returns the settings of the -thp and -numa-balancing switches.
+func parseToggles() ([]tuneSetting, error) {
+	var toggles []tuneSetting
+	switch *thpFlag {
+	case "":
+	case "never", "madvise", "always":
+		toggles = append(toggles, tuneSetting{"/sys/kernel/mm/transparent_hugepage/enabled", *thpFlag})
+	default:
+		return nil, fmt.Errorf("-thp: invalid mode %q, expected never, madvise or always", *thpFlag)
+	}
+	switch *numaBalancing {
+	case "":
+	case "on":
+		toggles = append(toggles, tuneSetting{"kernel.numa_balancing", "1"})
+	case "off":
+		toggles = append(toggles, tuneSetting{"kernel.numa_balancing", "0"})
+	default:
+		return nil, fmt.Errorf("-numa-balancing: invalid value %q, expected on or off", *numaBalancing)
+	}
+	if *smtOff {
+		// the benchmark runs on one hardware thread per core
+		toggles = append(toggles, tuneSetting{"/sys/devices/system/cpu/smt/control", "off"})
+	}
+	return toggles, nil
+}
+
+// name returns a benchfmt compatible name for the setting.
+func (t tuneSetting) name() string {
+	return strings.ReplaceAll(strings.TrimPrefix(t.key, "/sys/"), "/", ".")
+}
+
+func (t tuneSetting) apply() string {
+	if strings.Contains(t.key, "*") {
+		return fmt.Sprintf("for f in %s; do echo %s > $f; done", t.key, t.value)
+	}
+	if strings.HasPrefix(t.key, "/") {
+		return fmt.Sprintf("echo %s > %s", t.value, t.key)
+	}
+	return fmt.Sprintf("sysctl -qw %s=%s", t.key, t.value)
+}
+
+// read returns the command printing the value; the distinct values of the files of a glob
+// pattern, comma-separated.
+func (t tuneSetting) read() string {
+	if strings.Contains(t.key, "*") {
+		return "cat " + t.key + " | sort -u | paste -sd, -"
+	}
+	if strings.HasPrefix(t.key, "/") {
+		return "cat " + t.key
+	}
+	return "sysctl -n " + t.key
+}
+
+// tuneCurrent is a shell function printing the value of a file setting; the selected one for
+// the files listing the choices, e.g. "always [madvise] never".
+const tuneCurrent = `cur() { v=$(cat $1 2>/dev/null) || return 1; case $v in *"["*) v=${v#*"["}; v=${v%%"]"*};; esac; echo $v; }; `
+
+// save returns the command printing the commands restoring the current value, prefixed with
+// "restore "; it requires tuneCurrent.
+func (t tuneSetting) save() string {
+	if strings.HasPrefix(t.key, "/") {
+		return fmt.Sprintf(`for f in %s; do v=$(cur $f) && echo "restore echo $v > $f"; done`, t.key)
+	}
+	return fmt.Sprintf(`v=$(sysctl -n %[1]s 2>/dev/null) && echo "restore sysctl -qw %[1]s=$v"`, t.key)
+}
+
+// applyTune applies the presets, then the toggles, on the instance, and returns the resulting
+// values as benchfmt configuration lines to document them in the output. An instance is
+// terminated after the run, but a registered host isn't: the commands restoring the previous
+// values are also returned, for restoreHost.
+func applyTune(r remote, presets []string, toggles []tuneSetting) (lines, restore []string, err error) {
+	var settings []tuneSetting
+	for _, p := range presets {
+		settings = append(settings, tunePresets[p]...)
+	}
+	settings = append(settings, toggles...)
+
+	var script strings.Builder
+	script.WriteString(tuneCurrent)
+	for _, s := range settings {
+		fmt.Fprintf(&script, "%s; ", s.save())
+	}
+	for _, s := range settings {
+		// some settings don't exist on all kernels; record what we get instead of failing.
+		fmt.Fprintf(&script, "%s 2>/dev/null; ", s.apply())
+	}
+	for _, s := range settings {
+		fmt.Fprintf(&script, "echo \"%s=$(%s 2>/dev/null || echo unavailable)\"; ", s.name(), s.read())
+	}
+
+	out, err := sshRun(r, "sudo sh -c '"+script.String()+"'")
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to apply tune presets, %v", err)
+	}
+
+	if len(presets) > 0 {
+		lines = append(lines, "tune: "+strings.Join(presets, ","))
+	}
+	seen := make(map[string]bool)
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		if cmd, ok := strings.CutPrefix(l, "restore "); ok {
+			restore = append(restore, cmd)
+			continue
+		}
+		// a toggle may repeat a setting of the presets
+		if k, v, ok := strings.Cut(l, "="); ok && !seen[k] {
+			seen[k] = true
+			lines = append(lines, fmt.Sprintf("tune-%s: %s", k, v))
+		}
+	}
+	return lines, restore, nil
+}
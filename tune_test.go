@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTuneSettingGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, cpu := range []string{"cpu0", "cpu1"} {
+		if err := os.MkdirAll(filepath.Join(dir, cpu), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, cpu, "scaling_governor"), []byte("powersave\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := tuneSetting{filepath.Join(dir, "cpu*", "scaling_governor"), "performance"}
+
+	read := func() string {
+		out, err := exec.Command("sh", "-c", s.read()).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if got := read(); got != "powersave" {
+		t.Errorf("read %q before, expected powersave", got)
+	}
+	if out, err := exec.Command("sh", "-c", s.apply()).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v", out, err)
+	}
+	if got := read(); got != "performance" {
+		t.Errorf("read %q after, expected performance", got)
+	}
+}
+
+func TestTuneSettingSave(t *testing.T) {
+	dir := t.TempDir()
+	thp := filepath.Join(dir, "enabled")
+	if err := os.WriteFile(thp, []byte("always [madvise] never\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := tuneSetting{thp, "never"}
+	out, err := exec.Command("sh", "-c", tuneCurrent+s.save()).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore, ok := strings.CutPrefix(strings.TrimSpace(string(out)), "restore ")
+	if !ok || restore != "echo madvise > "+thp {
+		t.Fatalf("save printed %q, expected to restore madvise", out)
+	}
+	if out, err := exec.Command("sh", "-c", s.apply()).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v", out, err)
+	}
+	if out, err := exec.Command("sh", "-c", restore).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v", out, err)
+	}
+	if data, _ := os.ReadFile(thp); strings.TrimSpace(string(data)) != "madvise" {
+		t.Errorf("restored %q, expected madvise", data)
+	}
+}
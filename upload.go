@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// upload is a local file to copy to the instance.
+type upload struct {
+	local, remote string
+	size          int64
+	sent          atomic.Int64
+}
+
+// uploadFiles copies the files to the instance concurrently, showing their progress and the
+// overall ETA. Each file is streamed over ssh, resumed from the bytes already received after a
+// network failure, and checked with sha256sum.
+func uploadFiles(t target, r remote, files []*upload) error {
+	var total int64
+	for _, u := range files {
+		fi, err := os.Stat(u.local)
+		if err != nil {
+			return err
+		}
+		u.size = fi.Size()
+		total += u.size
+	}
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				t.status("%s", uploadProgress(files, total, time.Since(start)))
+			}
+		}
+	}()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(files))
+	)
+	limiter := newRateLimiter(*bwLimit * 1000 / 8)
+	for i, u := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = uploadFile(r, u, limiter)
+		}()
+	}
+	wg.Wait()
+	close(done)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	elapsed := time.Since(start)
+	slog.Debug(fmt.Sprintf("uploaded %.1f MB in %s (%.2f MB/s)",
+		float64(total)/1e6, elapsed.Round(time.Millisecond), float64(total)/1e6/elapsed.Seconds()))
+	return nil
+}
+
+// uploadProgress returns the progress of the uploads, e.g.
+//
+//	uploading bench 12.3/45.6 MB, rbench-dlv 3.0/20.0 MB (2.1 MB/s, ETA 24s)
+func uploadProgress(files []*upload, total int64, elapsed time.Duration) string {
+	var (
+		parts []string
+		sent  int64
+	)
+	for _, u := range files {
+		n := u.sent.Load()
+		sent += n
+		parts = append(parts, fmt.Sprintf("%s %.1f/%.1f MB", filepath.Base(u.remote), float64(n)/1e6, float64(u.size)/1e6))
+	}
+	s := "uploading " + strings.Join(parts, ", ")
+	if sent == 0 || elapsed <= 0 {
+		return s
+	}
+	rate := float64(sent) / elapsed.Seconds()
+	eta := time.Duration(float64(total-sent) / rate * float64(time.Second))
+	return fmt.Sprintf("%s (%.1f MB/s, ETA %s)", s, rate/1e6, eta.Round(time.Second))
+}
+
+// uploadFile streams a file to the instance, resuming after network failures.
+func uploadFile(r remote, u *upload, limiter *rateLimiter) error {
+	f, err := os.Open(u.local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sum, err := fileSHA256(u.local)
+	if err != nil {
+		return err
+	}
+	dst := shellQuote(u.remote)
+
+	first := true
+	err = withRetry("upload "+filepath.Base(u.remote), func() error {
+		var offset int64
+		if !first {
+			// resume from what was received
+			out, err := sshRunOnce(r, "stat -c %s "+dst+" 2>/dev/null || echo 0")
+			if err != nil {
+				return err
+			}
+			if offset, err = strconv.ParseInt(strings.TrimSpace(out), 10, 64); err != nil || offset > u.size {
+				offset = 0
+			}
+		}
+		first = false
+		redirect := ">"
+		if offset > 0 {
+			redirect = ">>"
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		u.sent.Store(offset)
+
+		command := "cat " + redirect + " " + dst
+		cmd := exec.Command("ssh", append(sshOptions("-p"), r.String(), command)...)
+		cmd.Stdin = &progressReader{r: f, n: &u.sent, limiter: limiter}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		start := time.Now()
+		err := cmd.Run()
+		auditCommand(r, command, start, err)
+		if err != nil {
+			return classifySSHError(err, stderr.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", filepath.Base(u.local), err)
+	}
+
+	out, err := sshRun(r, "chmod +x "+dst+" && sha256sum "+dst)
+	if err != nil {
+		return fmt.Errorf("unable to check the upload of %s, %v", filepath.Base(u.local), err)
+	}
+	if got, _, _ := strings.Cut(out, " "); got != sum {
+		return fmt.Errorf("upload of %s corrupted: sha256 %s, expected %s", filepath.Base(u.local), got, sum)
+	}
+	return nil
+}
+
+// progressReader counts the bytes read from r, throttled by limiter.
+type progressReader struct {
+	r       io.Reader
+	n       *atomic.Int64
+	limiter *rateLimiter
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n.Add(int64(n))
+	p.limiter.wait(n)
+	return n, err
+}
+
+// rateLimiter limits the overall rate of the uploads (-bwlimit); a nil limiter doesn't limit.
+type rateLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec int
+	start       time.Time
+	n           int64
+}
+
+func newRateLimiter(bytesPerSec int) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{bytesPerSec: bytesPerSec, start: time.Now()}
+}
+
+// wait accounts for n bytes and sleeps until they fit in the rate.
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.n += int64(n)
+	due := l.start.Add(time.Duration(float64(l.n) / float64(l.bytesPerSec) * float64(time.Second)))
+	l.mu.Unlock()
+	time.Sleep(time.Until(due))
+}
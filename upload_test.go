@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUploadProgress(t *testing.T) {
+	files := []*upload{
+		{remote: "/tmp/bench", size: 40e6},
+		{remote: "/tmp/rbench-dlv", size: 20e6},
+	}
+	if got, want := uploadProgress(files, 60e6, 0), "uploading bench 0.0/40.0 MB, rbench-dlv 0.0/20.0 MB"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	files[0].sent.Store(10e6)
+	files[1].sent.Store(10e6)
+	if got, want := uploadProgress(files, 60e6, 10*time.Second), "uploading bench 10.0/40.0 MB, rbench-dlv 10.0/20.0 MB (2.0 MB/s, ETA 20s)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
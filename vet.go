@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log/slog"
+	"os/exec"
+	"strings"
+)
+
+// preflight runs go vet (with -vettool if set) and checkBenchmarks on the package,
+// to fail fast on broken benchmark code before paying for an instance.
+func preflight(benchmarks []string) error {
+	args := []string{"vet"}
+	if *tagsFlag != "" {
+		args = append(args, "-tags", *tagsFlag)
+	}
+	if *vetTool != "" {
+		args = append(args, "-vettool="+*vetTool)
+	}
+	args = append(args, benchPackage)
+	if out, err := exec.Command("go", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("go vet failed:\n%s", strings.TrimSpace(string(out)))
+	}
+
+	files, err := testFiles(benchPackage)
+	if err != nil {
+		return err
+	}
+	selected := make(map[string]bool, len(benchmarks))
+	for _, b := range benchmarks {
+		selected[b] = true
+	}
+	problems, warnings, err := checkBenchmarks(files, selected)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		slog.Warn(w)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("benchmark checks failed:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// pretest runs the tests of the package locally in short mode (the -run ones if set), and each
+// selected benchmark once: a panicking benchmark fails here instead of on a paid instance.
+func pretest() error {
+	tests := "."
+	if *run != "NONE" {
+		tests = *run
+	}
+	args := []string{"test", "-short", "-count=1", "-timeout=" + pretestTimeout.String(),
+		"-run=" + tests, "-bench=" + *benchFlag, "-benchtime=1x"}
+	if *tagsFlag != "" {
+		args = append(args, "-tags", *tagsFlag)
+	}
+	args = append(args, benchPackage)
+	cmd := exec.Command("go", args...)
+	cmd.Dir = buildDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("local pre-test failed (-pretest), not launching:\n%s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// checkBenchmarks inspects the selected benchmark functions. Benchmarks that don't use
+// b.N (nor b.Loop, b.Run or b.RunParallel) are problems: their results are meaningless.
+// Benchmarks timing their setup (calls before the b.N loop, without b.ResetTimer or b.StopTimer) are warnings.
+func checkBenchmarks(files []string, selected map[string]bool) (problems, warnings []string, err error) {
+	fset := token.NewFileSet()
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || fn.Body == nil || !selected[fn.Name.Name] || len(fn.Type.Params.List) != 1 || len(fn.Type.Params.List[0].Names) != 1 {
+				continue
+			}
+			b := fn.Type.Params.List[0].Names[0].Name
+			pos := fset.Position(fn.Pos())
+			where := fmt.Sprintf("%s:%d: %s", pos.Filename, pos.Line, fn.Name.Name)
+
+			uses := benchmarkUses(fn.Body, b)
+			if !uses["N"] && !uses["Loop"] && !uses["Run"] && !uses["RunParallel"] {
+				problems = append(problems, where+" doesn't use "+b+".N")
+				continue
+			}
+			if uses["N"] && !uses["ResetTimer"] && !uses["StopTimer"] && timedSetup(fn.Body, b) {
+				warnings = append(warnings, where+" has setup before the "+b+".N loop; call "+b+".ResetTimer() after it")
+			}
+		}
+	}
+	return problems, warnings, nil
+}
+
+// benchmarkUses returns the fields and methods of b used in body.
+func benchmarkUses(body ast.Node, b string) map[string]bool {
+	uses := make(map[string]bool)
+	ast.Inspect(body, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Name == b {
+				uses[sel.Sel.Name] = true
+			}
+		}
+		return true
+	})
+	return uses
+}
+
+// timedSetup reports whether function calls (other than methods of b) occur before the first
+// top level statement using b.N.
+func timedSetup(body *ast.BlockStmt, b string) bool {
+	for _, stmt := range body.List {
+		if benchmarkUses(stmt, b)["N"] {
+			return false
+		}
+		setup := false
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok && id.Name == b {
+					return true
+				}
+			}
+			if id, ok := call.Fun.(*ast.Ident); ok && (id.Name == "make" || id.Name == "len" || id.Name == "new") {
+				return true
+			}
+			setup = true
+			return false
+		})
+		if setup {
+			return true
+		}
+	}
+	return false
+}
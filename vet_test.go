@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckBenchmarks(t *testing.T) {
+	const src = `package p
+
+import "testing"
+
+func BenchmarkNoN(b *testing.B) {
+	work()
+}
+
+func BenchmarkSetup(b *testing.B) {
+	data := load()
+	for i := 0; i < b.N; i++ {
+		work(data)
+	}
+}
+
+func BenchmarkReset(b *testing.B) {
+	data := load()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		work(data)
+	}
+}
+
+func BenchmarkClean(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 10)
+	for i := 0; i < b.N; i++ {
+		work(buf)
+	}
+}
+
+func BenchmarkSub(b *testing.B) {
+	b.Run("x", BenchmarkClean)
+}
+`
+	file := filepath.Join(t.TempDir(), "p_test.go")
+	if err := os.WriteFile(file, []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	selected := map[string]bool{"BenchmarkNoN": true, "BenchmarkSetup": true, "BenchmarkReset": true, "BenchmarkClean": true, "BenchmarkSub": true}
+	problems, warnings, err := checkBenchmarks([]string{file}, selected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || filepath.Base(problems[0]) != "p_test.go:5: BenchmarkNoN doesn't use b.N" {
+		t.Errorf("unexpected problems %q", problems)
+	}
+	if len(warnings) != 1 || filepath.Base(warnings[0]) != "p_test.go:9: BenchmarkSetup has setup before the b.N loop; call b.ResetTimer() after it" {
+		t.Errorf("unexpected warnings %q", warnings)
+	}
+}
+
+func TestPretest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module p\n")
+	write("p_test.go", `package p
+
+import "testing"
+
+func TestOK(t *testing.T) {}
+
+func BenchmarkPanic(b *testing.B) { panic("boom") }
+`)
+	oldDir, oldPkg, oldBench := buildDir, benchPackage, *benchFlag
+	defer func() { buildDir, benchPackage, *benchFlag = oldDir, oldPkg, oldBench }()
+	buildDir, benchPackage = dir, "."
+
+	*benchFlag = "NONE"
+	if err := pretest(); err != nil {
+		t.Fatalf("pretest without benchmarks: %v", err)
+	}
+	*benchFlag = "."
+	if err := pretest(); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected the panicking benchmark to fail, got %v", err)
+	}
+}
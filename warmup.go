@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// warmup runs unrecorded passes of the selected benchmarks, or the -warmup-cmd shell command,
+// before the measured runs: on a cold instance, the first repetition also pays for the page
+// cache, lazy initialization and sync.Once paths. The passes run like the benchmark (see
+// benchCommand), once per variant of the run: each -gogc value, each -compare binary.
+func warmup(r remote) error {
+	if *warmupCmd != "" {
+		return warmupRun(r, *warmupCmd)
+	}
+	testArgs := []string{
+		"-test.run=NONE",
+		fmt.Sprintf("-test.bench=%s", benchPattern(r)),
+		fmt.Sprintf("-test.count=%d", *warmupPasses),
+	}
+	if arg := cpuArg(r); arg != "" {
+		testArgs = append(testArgs, arg)
+	}
+	if *benchTime != "" {
+		testArgs = append(testArgs, fmt.Sprintf("-test.benchtime=%s", *benchTime))
+	}
+	testArgs = append(testArgs, extraTestArgs()...)
+
+	bins := []remote{r}
+	if *compareFlag != "" {
+		bins = nil
+		for _, f := range compareFiles {
+			br := r
+			br.bin = "./" + f
+			bins = append(bins, br)
+		}
+	}
+	envs := [][]string{nil}
+	if gogc := splitList(*gogcFlag); len(gogc) > 0 {
+		envs = nil
+		for _, v := range gogc {
+			envs = append(envs, []string{"GOGC=" + v})
+		}
+	}
+	for _, br := range bins {
+		for _, env := range envs {
+			if err := warmupRun(br, benchCommand(br, testArgs, env...)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// warmupRun runs a warmup command in the working directory of the run, with the credentials of
+// the benchmark.
+func warmupRun(r remote, command string) error {
+	if _, err := sshRun(r, "cd "+r.runDir()+" && "+benchCredsPrefix()+command); err != nil {
+		return fmt.Errorf("warmup failed, %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// wasmRuntimes are the WebAssembly runtimes of -wasm: the installation command (in the home
+// directory of the ssh user) and the path of the runtime.
+var wasmRuntimes = map[string]struct{ install, path string }{
+	"wasmtime": {"curl -sSf https://wasmtime.dev/install.sh | bash -s -- >/dev/null", "$HOME/.wasmtime/bin/wasmtime"},
+	"wazero":   {"cd $HOME && curl -sSfL https://wazero.io/install.sh | sh >/dev/null", "$HOME/bin/wazero"},
+}
+
+// wasmEnv returns the environment of the compiler for the -wasm builds (GOOS=wasip1 GOARCH=wasm).
+func wasmEnv() []string {
+	return []string{"GOOS=wasip1", "GOARCH=wasm"}
+}
+
+// setupWasm installs the -wasm runtime on the instance, if needed, and returns its version as
+// a benchfmt configuration line.
+func setupWasm(r remote) (string, error) {
+	rt := wasmRuntimes[*wasmFlag]
+	version := "version"
+	if *wasmFlag == "wasmtime" {
+		version = "--version"
+	}
+	out, err := sshRun(r, fmt.Sprintf("(test -x %[1]s || (%[2]s)) && %[1]s %[3]s", rt.path, rt.install, version))
+	if err != nil {
+		return "", fmt.Errorf("unable to install %s, %v", *wasmFlag, err)
+	}
+	return fmt.Sprintf("wasm-runtime: %s %s", *wasmFlag, strings.TrimPrefix(strings.TrimSpace(out), *wasmFlag+" ")), nil
+}
+
+// wasmCommand returns the command running ./bench under the -wasm runtime; the environment
+// variables (KEY=value) are passed to the module, which sees the working directory and /tmp.
+func wasmCommand(env []string) string {
+	rt := wasmRuntimes[*wasmFlag]
+	dirs := []string{"/tmp"}
+	if wd := remoteWorkDir(); wd != "/tmp" {
+		dirs = append(dirs, wd)
+	}
+	cmd := rt.path + " run"
+	switch *wasmFlag {
+	case "wasmtime":
+		for _, d := range dirs {
+			cmd += " --dir=" + d
+		}
+		for _, e := range env {
+			cmd += " --env " + shellQuote(e)
+		}
+		return cmd + " ./bench"
+	default:
+		for _, d := range dirs {
+			cmd += " -mount=" + d + ":" + d
+		}
+		for _, e := range env {
+			cmd += " -env=" + shellQuote(e)
+		}
+		return cmd + " ./bench --"
+	}
+}
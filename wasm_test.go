@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestWasmCommand(t *testing.T) {
+	defer func(w, tmpfs string) { *wasmFlag, *tmpfsFlag = w, tmpfs }(*wasmFlag, *tmpfsFlag)
+
+	*wasmFlag, *tmpfsFlag = "wasmtime", ""
+	if got, want := wasmCommand([]string{"GOGC=off"}), "$HOME/.wasmtime/bin/wasmtime run --dir=/tmp --env 'GOGC=off' ./bench"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	*wasmFlag, *tmpfsFlag = "wazero", "1g"
+	if got, want := wasmCommand(nil), "$HOME/bin/wazero run -mount=/tmp:/tmp -mount="+remoteTmpfsDir()+":"+remoteTmpfsDir()+" ./bench --"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
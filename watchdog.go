@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// remoteHangFile is written when a hung benchmark is dumped: the offset of the dump in the
+// benchmark stderr.
+func remoteHangFile() string {
+	return remoteTmp() + "/rbench-hang"
+}
+
+// errBenchmarkHung is returned when a benchmark producing no output (-watchdog) was dumped.
+var errBenchmarkHung = errors.New("benchmark hung")
+
+// watchdogScript returns the shell loop watching the benchmark output on the instance under
+// -watchdog-abort: after -watchdog without output, it dumps the benchmark (see dumpCommand).
+// SIGQUIT ends a Go program: the run fails.
+func watchdogScript(stderrFile string) string {
+	limit := int(watchdog.Seconds())
+	poll := min(limit, 10)
+	return fmt.Sprintf(`last=-1; idle=0; while sleep %d; do `+
+		`n=$(stat -c %%s %s 2>/dev/null || echo 0); `+
+		`if [ "$n" != "$last" ]; then last=$n; idle=0; else idle=$((idle+%d)); fi; `+
+		`if [ $idle -ge %d ]; then %s; break; fi; done`,
+		poll, remoteResultsFile(), poll, limit, dumpCommand(stderrFile))
+}
+
+// dumpCommand returns the shell command sending SIGQUIT to the benchmark, whose goroutine dump
+// goes to stderrFile, after recording where the dump starts.
+func dumpCommand(stderrFile string) string {
+	// under -wasm, the runtime ends without a goroutine dump
+	return fmt.Sprintf("{ stat -c %%s %s > %s 2>/dev/null || echo 0 > %s; }; kill -QUIT $(cat %s)",
+		stderrFile, remoteHangFile(), remoteHangFile(), remoteBenchPID())
+}
+
+// hangMonitor watches the output of a benchmark from the local side, under -watchdog without
+// -watchdog-abort: the hung benchmark is only dumped if asked to once the hang is detected.
+type hangMonitor struct {
+	last atomic.Int64 // of the last output, in unix nanoseconds
+}
+
+func (m *hangMonitor) Write(p []byte) (int, error) {
+	m.last.Store(time.Now().UnixNano())
+	return len(p), nil
+}
+
+// watch checks the output of the benchmark on r until done is closed. After -watchdog without
+// output, the benchmark is dumped if hangMenu says so; otherwise the watch starts over.
+func (m *hangMonitor) watch(r remote, stderrFile string, done <-chan struct{}) {
+	m.last.Store(time.Now().UnixNano())
+	ticker := time.NewTicker(min(*watchdog, 10*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		idle := time.Since(time.Unix(0, m.last.Load()))
+		if idle < *watchdog {
+			continue
+		}
+		if hangMenu(r, idle) {
+			if _, err := sshRunOnce(r, dumpCommand(stderrFile)+"; true"); err != nil {
+				slog.Warn(fmt.Sprintf("unable to dump the benchmark on %s, %v", r.host, err))
+			}
+			return
+		}
+		m.last.Store(time.Now().UnixNano())
+	}
+}
+
+// hangMenuMu serializes the menus of the targets hanging together.
+var hangMenuMu sync.Mutex
+
+// hangMenu reports whether to dump and stop the benchmark on r, silent for idle. Interactive
+// runs ask, keeping waiting by default; the others warn and keep waiting.
+func hangMenu(r remote, idle time.Duration) bool {
+	idle = idle.Round(time.Second)
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		slog.Warn(fmt.Sprintf("no output from the benchmark on %s for %s, still waiting (-watchdog-abort dumps and stops hung benchmarks)", r.host, idle))
+		return false
+	}
+	hangMenuMu.Lock()
+	defer hangMenuMu.Unlock()
+	stderrTerminal.clearStatus()
+	fmt.Fprintf(os.Stderr, "\nno output from the benchmark on %s for %s, what now?\n"+
+		"  1) keep waiting (default in %s)\n"+
+		"  2) dump its goroutines to a file and stop it\n"+
+		"> ", r.host, idle, abortTimeout)
+	select {
+	case a := <-menuInput():
+		return a == "2"
+	case <-time.After(abortTimeout):
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+}
+
+// checkHang returns errBenchmarkHung, after saving the goroutine dump to a local file, if the
+// failed run was dumped for a hang.
+func checkHang(r remote, stderrFile string) error {
+	out, err := sshRunOnce(r, "cat "+remoteHangFile()+" 2>/dev/null")
+	if err != nil || strings.TrimSpace(out) == "" {
+		return nil
+	}
+	offset, _ := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	var dump []byte
+	if r.agent != nil {
+		dump, err = r.agent.fetch(stderrFile, offset)
+	} else {
+		var tail string
+		tail, err = sshRun(r, fmt.Sprintf("tail -c +%d %s", offset+1, stderrFile))
+		dump = []byte(tail)
+	}
+	if err != nil {
+		return fmt.Errorf("%w after %s without output; unable to download the goroutine dump, %v", errBenchmarkHung, *watchdog, err)
+	}
+	name := fmt.Sprintf("rbench-hang-%s-%s.txt", strings.ReplaceAll(r.host, ":", "-"), time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(name, dump, 0644); err != nil {
+		return fmt.Errorf("%w after %s without output; unable to write the goroutine dump, %v", errBenchmarkHung, *watchdog, err)
+	}
+	slog.Info("goroutine dump of the hung benchmark written to " + name)
+	return fmt.Errorf("%w after %s without output", errBenchmarkHung, *watchdog)
+}
+
+// continueAfterHang reports whether a run that failed with err should go on with its remaining
+// benchmarks (-watchdog-abort unset).
+func continueAfterHang(err error) bool {
+	if !errors.Is(err, errBenchmarkHung) || *watchdogAbort {
+		return false
+	}
+	slog.Warn(err.Error() + ", continuing with the remaining runs")
+	return true
+}
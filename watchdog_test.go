@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestContinueAfterHang(t *testing.T) {
+	defer func(v bool) { *watchdogAbort = v }(*watchdogAbort)
+
+	*watchdogAbort = false
+	if !continueAfterHang(fmt.Errorf("gogc=100: %w", errBenchmarkHung)) {
+		t.Error("expected to continue after a hang")
+	}
+	if continueAfterHang(errors.New("exit status 1")) {
+		t.Error("expected to stop after a failure")
+	}
+	*watchdogAbort = true
+	if continueAfterHang(errBenchmarkHung) {
+		t.Error("expected to stop after a hang with -watchdog-abort")
+	}
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rbench web is a local browser of the results history and of the bundles of an artifacts
+// directory: the runs, the trend of a benchmark over the commits, the comparison of two runs and
+// the CPU profiles of the bundles, shown by go tool pprof (flame graph included). The history is
+// read at every request: the runs recorded while the server runs show up.
+
+// historyRun is a run of the history: its records share the time, commit, machine and metadata.
+type historyRun struct {
+	ID      string
+	Time    time.Time
+	Commit  string
+	Machine string
+	Meta    map[string]string
+	Records []historyRecord
+}
+
+// runID returns the identifier of the run of a record, stable across reads of the history.
+func runID(h historyRecord) string {
+	key := []string{h.Time.UTC().Format(time.RFC3339Nano), h.Commit, h.Machine}
+	for _, k := range sortedKeys(h.Meta) {
+		key = append(key, k+"="+h.Meta[k])
+	}
+	f := fnv.New64a()
+	f.Write([]byte(strings.Join(key, " ")))
+	return fmt.Sprintf("%016x", f.Sum64())
+}
+
+// historyRuns groups records by run, the most recent first.
+func historyRuns(records []historyRecord) []*historyRun {
+	byID := make(map[string]*historyRun)
+	var runs []*historyRun
+	for _, h := range records {
+		id := runID(h)
+		run := byID[id]
+		if run == nil {
+			run = &historyRun{ID: id, Time: h.Time, Commit: h.Commit, Machine: h.Machine, Meta: h.Meta}
+			byID[id] = run
+			runs = append(runs, run)
+		}
+		run.Records = append(run.Records, h)
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time.After(runs[j].Time) })
+	return runs
+}
+
+// results returns the values of the run as benchResults.
+func (run *historyRun) results() *benchResults {
+	results := newBenchResults()
+	var b bytes.Buffer
+	for _, h := range run.Records {
+		for _, unit := range sortedKeys(h.Values) {
+			for _, v := range h.Values[unit] {
+				fmt.Fprintf(&b, "%s 1 %g %s\n", h.Benchmark, v, unit)
+			}
+		}
+	}
+	results.Write(b.Bytes())
+	return results
+}
+
+// webBundle is a bundle of the artifacts directory.
+type webBundle struct {
+	Path     string // relative to the artifacts directory
+	Manifest manifest
+}
+
+// scanBundles returns the bundles under dir, the most recent first.
+func scanBundles(dir string) []webBundle {
+	var bundles []webBundle
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, bundleExt) {
+			return nil
+		}
+		m, _, err := readBundle(path)
+		if err != nil {
+			slog.Debug(err.Error())
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		bundles = append(bundles, webBundle{filepath.ToSlash(rel), m})
+		return nil
+	})
+	sort.SliceStable(bundles, func(i, j int) bool { return bundles[i].Manifest.RunStamp > bundles[j].Manifest.RunStamp })
+	return bundles
+}
+
+// webServer serves rbench web.
+type webServer struct {
+	artifacts string // directory of the bundles
+
+	mu    sync.Mutex
+	pprof map[string]string // address of the go tool pprof server of a profile, by bundle
+	procs []*exec.Cmd
+}
+
+// webFuncs are the functions available to the templates.
+var webFuncs = template.FuncMap{
+	"short": shortCommit,
+	"time":  func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") },
+	"value": func(v float64) string { return fmt.Sprintf("%.4g", v) },
+	"delta": func(d float64) string { return fmt.Sprintf("%+.2f%%", d) },
+	"meta": func(m map[string]string) string {
+		var kv []string
+		for _, k := range sortedKeys(m) {
+			kv = append(kv, k+"="+m[k])
+		}
+		return strings.Join(kv, " ")
+	},
+}
+
+var webTemplates = template.Must(template.New("layout").Funcs(webFuncs).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>rbench{{with .Title}} — {{.}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 10px; text-align: left; border-bottom: 1px solid #ddd; }
+td.num { text-align: right; font-family: monospace; }
+.better { color: #080; } .worse { color: #b00; }
+nav a { margin-right: 1em; }
+</style></head>
+<body><nav><a href="/">runs</a><a href="/trend">trends</a><a href="/bundles">bundles</a></nav>
+<h1>{{.Title}}</h1>
+{{template "content" .}}
+</body></html>`))
+
+var webPages = map[string]string{
+	"runs": `{{define "content"}}
+<form action="/compare">
+<table><tr><th>base</th><th>head</th><th>time</th><th>commit</th><th>machine</th><th>metadata</th><th>benchmarks</th></tr>
+{{range .Runs}}<tr><td><input type="radio" name="a" value="{{.ID}}"></td><td><input type="radio" name="b" value="{{.ID}}"></td>
+<td><a href="/run?id={{.ID}}">{{time .Time}}</a></td><td>{{short .Commit}}</td><td>{{.Machine}}</td><td>{{meta .Meta}}</td><td class="num">{{len .Records}}</td></tr>
+{{end}}</table>
+<p><input type="submit" value="compare"></p></form>
+{{end}}`,
+	"run": `{{define "content"}}
+<p>commit {{.Run.Commit}} on {{.Run.Machine}} {{meta .Run.Meta}}</p>
+{{range $unit := .Report.Units}}<h2>{{$unit}}</h2>
+<table><tr><th>benchmark</th><th>median</th><th>spread</th><th>runs</th></tr>
+{{range $b := $.Report.Benchmarks}}{{with index $b.Metrics $unit}}{{if .Runs}}<tr><td><a href="/trend?bench={{$.Quote $b.Name}}&amp;unit={{$unit}}">{{$b.Name}}</a></td>
+<td class="num">{{value .Median}}</td><td class="num">±{{printf "%.1f" .Spread}}%</td><td class="num">{{.Runs}}</td></tr>{{end}}{{end}}
+{{end}}</table>{{end}}
+{{with .Bundles}}<h2>bundles</h2><ul>{{range .}}<li><a href="/bundle?path={{.Path}}">{{.Path}}</a></li>{{end}}</ul>{{end}}
+{{end}}`,
+	"compare": `{{define "content"}}
+<p>base: <a href="/run?id={{.Base.ID}}">{{short .Base.Commit}}</a> on {{.Base.Machine}} ({{time .Base.Time}}),
+head: <a href="/run?id={{.Run.ID}}">{{short .Run.Commit}}</a> on {{.Run.Machine}} ({{time .Run.Time}})</p>
+{{range $unit := .Report.Units}}<h2>{{$unit}}</h2>
+<table><tr><th>benchmark</th><th>base</th><th>head</th><th>delta</th><th>p</th></tr>
+{{range $b := $.Report.Benchmarks}}{{with index $b.Metrics $unit}}{{if .HasBase}}<tr><td>{{$b.Name}}</td>
+<td class="num">{{value .Base}}</td><td class="num">{{value .Median}}</td>
+{{$p := $.P $b.Name $unit}}<td class="num {{if lt $p 0.05}}{{if lt .Delta 0.0}}better{{else}}worse{{end}}{{end}}">{{if lt $p 0.05}}{{delta .Delta}}{{else}}~{{end}}</td>
+<td class="num">{{printf "%.3f" $p}}</td></tr>{{end}}{{end}}
+{{end}}</table>{{end}}
+{{end}}`,
+	"trend": `{{define "content"}}
+<form action="/trend">benchmark <input name="bench" value="{{.Bench}}"> machine <input name="machine" value="{{.Machine}}">
+unit <input name="unit" value="{{.Unit}}" size="8"> <input type="submit" value="show"></form>
+{{range .Trends}}<h2>{{.Key}}</h2>
+{{.Chart}}
+<table><tr><th>commit</th><th>date</th><th>runs</th><th>median</th><th>spread</th><th>delta</th></tr>
+{{range .Points}}<tr><td>{{short .Commit}}</td><td>{{time .First}}</td><td class="num">{{.Runs}}</td><td class="num">{{value .Median}}</td>
+<td class="num">±{{printf "%.1f" .Spread}}%</td><td class="num">{{.Delta}}</td></tr>{{end}}
+</table>{{end}}
+{{end}}`,
+	"bundles": `{{define "content"}}
+<table><tr><th>bundle</th><th>runstamp</th><th>commit</th><th>files</th></tr>
+{{range .Bundles}}<tr><td><a href="/bundle?path={{.Path}}">{{.Path}}</a></td><td>{{.Manifest.RunStamp}}</td><td>{{short .Manifest.Commit}}</td><td class="num">{{len .Manifest.Files}}</td></tr>
+{{end}}</table>
+{{end}}`,
+	"bundle": `{{define "content"}}
+<p>commit {{.Bundle.Manifest.Commit}}, runstamp {{.Bundle.Manifest.RunStamp}}: rbench {{range .Bundle.Manifest.Args}}{{.}} {{end}}</p>
+<table><tr><th>file</th><th>kind</th><th>size</th></tr>
+{{range .Bundle.Manifest.Files}}<tr><td><a href="/file?path={{$.Bundle.Path}}&amp;name={{.Name}}">{{.Name}}</a></td><td>{{.Kind}}</td><td class="num">{{.Size}}</td>
+<td>{{if eq .Kind "cpuprofile"}}<a href="/profile?path={{$.Bundle.Path}}">flame graph</a>{{end}}</td></tr>
+{{end}}</table>
+{{end}}`,
+}
+
+// webPage is the data of a page.
+type webPage struct {
+	Title   string
+	Runs    []*historyRun
+	Run     *historyRun
+	Base    *historyRun
+	Report  reportData
+	Bundles []webBundle
+	Bundle  webBundle
+	Bench   string
+	Machine string
+	Unit    string
+	Trends  []webTrend
+
+	base, head *benchResults
+}
+
+// Quote returns the regular expression matching exactly a benchmark, without its GOMAXPROCS
+// suffix.
+func (p webPage) Quote(name string) string {
+	return "^" + regexp.QuoteMeta(trimProcs(name)) + "$"
+}
+
+// P returns the p-value of the change of a benchmark between the compared runs.
+func (p webPage) P(name, unit string) float64 {
+	return mannWhitneyP(p.base.values(name, unit), p.head.values(name, unit))
+}
+
+// webTrend is the trend of a benchmark on a machine.
+type webTrend struct {
+	Key    string
+	Chart  template.HTML
+	Points []webPoint
+}
+
+type webPoint struct {
+	Commit         string
+	First          time.Time
+	Runs           int
+	Median, Spread float64
+	Delta          string
+}
+
+// render writes a page.
+func (s *webServer) render(w http.ResponseWriter, name string, p webPage) {
+	t, err := template.Must(webTemplates.Clone()).Parse(webPages[name])
+	if err == nil {
+		var b bytes.Buffer
+		if err = t.Execute(&b, p); err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(b.Bytes())
+			return
+		}
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// runs returns the runs of the history.
+func (s *webServer) runs() ([]*historyRun, error) {
+	records, err := readHistory("", nil)
+	if err != nil {
+		return nil, err
+	}
+	return historyRuns(records), nil
+}
+
+// findRun returns the run of the history with id.
+func findRun(runs []*historyRun, id string) *historyRun {
+	for _, run := range runs {
+		if run.ID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+func (s *webServer) handleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	runs, err := s.runs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.render(w, "runs", webPage{Title: fmt.Sprintf("%d runs", len(runs)), Runs: runs})
+}
+
+func (s *webServer) handleRun(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.runs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	run := findRun(runs, r.FormValue("id"))
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// the bundles of the run, by commit and runstamp
+	var bundles []webBundle
+	for _, b := range scanBundles(s.artifacts) {
+		if at, err := time.Parse(time.RFC3339, b.Manifest.RunStamp); err == nil && at.Equal(run.Time) && b.Manifest.Commit == run.Commit {
+			bundles = append(bundles, b)
+		}
+	}
+	s.render(w, "run", webPage{Title: "run of " + run.Time.Local().Format("2006-01-02 15:04"), Run: run,
+		Report: newReportData(nil, run.results(), nil, nil), Bundles: bundles})
+}
+
+func (s *webServer) handleCompare(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.runs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	base, head := findRun(runs, r.FormValue("a")), findRun(runs, r.FormValue("b"))
+	if base == nil || head == nil {
+		http.Error(w, "select a base and a head run", http.StatusBadRequest)
+		return
+	}
+	p := webPage{Title: "comparison", Base: base, Run: head, base: base.results(), head: head.results()}
+	p.Report = newReportData(nil, p.head, nil, p.base)
+	s.render(w, "compare", p)
+}
+
+func (s *webServer) handleTrend(w http.ResponseWriter, r *http.Request) {
+	p := webPage{Title: "trends", Bench: r.FormValue("bench"), Machine: r.FormValue("machine"), Unit: r.FormValue("unit")}
+	if p.Unit == "" {
+		p.Unit = "ns/op"
+	}
+	if p.Bench != "" {
+		re, err := regexp.Compile(p.Bench)
+		if err != nil {
+			http.Error(w, "invalid benchmark regexp, "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		records, err := readHistory(p.Machine, func(h historyRecord) bool {
+			return re.MatchString(trimProcs(h.Benchmark))
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		trends := historyTrend(records, p.Unit, 0)
+		for _, key := range sortedKeys(trends) {
+			t := webTrend{Key: key, Chart: trendChart(trends[key])}
+			for i, point := range trends[key] {
+				delta := "-"
+				if i > 0 {
+					delta = formatDelta(median(trends[key][i-1].values), median(point.values))
+				}
+				t.Points = append(t.Points, webPoint{point.commit, point.first, point.runs, median(point.values), spread(point.values), delta})
+			}
+			p.Trends = append(p.Trends, t)
+		}
+	}
+	s.render(w, "trend", p)
+}
+
+// trendChart draws the medians of a trend, with the range of the values of each commit, as SVG.
+func trendChart(points []historyPoint) template.HTML {
+	const width, height, pad = 600, 160, 10
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		for _, v := range p.values {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	if len(points) == 0 || hi <= lo {
+		hi, lo = lo+1, lo-1
+	}
+	x := func(i int) float64 {
+		if len(points) == 1 {
+			return width / 2
+		}
+		return pad + float64(i)*(width-2*pad)/float64(len(points)-1)
+	}
+	y := func(v float64) float64 { return height - pad - (v-lo)*(height-2*pad)/(hi-lo) }
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg width="%d" height="%d" style="border:1px solid #ddd">`, width, height)
+	var line []string
+	for i, p := range points {
+		vmin, vmax := math.Inf(1), math.Inf(-1)
+		for _, v := range p.values {
+			vmin, vmax = math.Min(vmin, v), math.Max(vmax, v)
+		}
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#bbb" stroke-width="3"/>`, x(i), y(vmin), x(i), y(vmax))
+		line = append(line, fmt.Sprintf("%.1f,%.1f", x(i), y(median(p.values))))
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3"><title>%s: %.4g</title></circle>`, x(i), y(median(p.values)),
+			template.HTMLEscapeString(shortCommit(p.commit)), median(p.values))
+	}
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="#36c"/></svg>`, strings.Join(line, " "))
+	return template.HTML(b.String())
+}
+
+// bundlePath returns the path of a bundle of the artifacts directory, refusing the paths out of it.
+func (s *webServer) bundlePath(rel string) (string, error) {
+	path := filepath.Join(s.artifacts, filepath.FromSlash(rel))
+	if r, err := filepath.Rel(s.artifacts, path); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) || !strings.HasSuffix(path, bundleExt) {
+		return "", fmt.Errorf("invalid bundle %q", rel)
+	}
+	return path, nil
+}
+
+func (s *webServer) handleBundles(w http.ResponseWriter, r *http.Request) {
+	s.render(w, "bundles", webPage{Title: "bundles in " + s.artifacts, Bundles: scanBundles(s.artifacts)})
+}
+
+func (s *webServer) handleBundle(w http.ResponseWriter, r *http.Request) {
+	path, err := s.bundlePath(r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, _, err := readBundle(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.render(w, "bundle", webPage{Title: r.FormValue("path"), Bundle: webBundle{r.FormValue("path"), m}})
+}
+
+func (s *webServer) handleFile(w http.ResponseWriter, r *http.Request) {
+	path, err := s.bundlePath(r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, files, err := readBundle(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := r.FormValue("name")
+	data, ok := files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// never rendered by the browser: the bundles may come from anyone
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	i := slices.IndexFunc(m.Files, func(e bundleEntry) bool { return e.Name == name })
+	if i < 0 || !webTextKinds[m.Files[i].Kind] {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(name)}))
+	}
+	w.Write(data)
+}
+
+// webTextKinds are the kinds of bundled files shown in the browser; the others are downloaded.
+var webTextKinds = map[string]bool{
+	"output":     true,
+	"json":       true,
+	"coverage":   true,
+	"provenance": true,
+	"log":        true,
+	"audit":      true,
+}
+
+// handleProfile redirects to the flame graph of the CPU profile of a bundle, served by go tool
+// pprof, started on the first request.
+func (s *webServer) handleProfile(w http.ResponseWriter, r *http.Request) {
+	path, err := s.bundlePath(r.FormValue("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	addr, err := s.pprofServer(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "http://"+addr+"/ui/flamegraph", http.StatusFound)
+}
+
+// pprofServer returns the address of the go tool pprof web interface of the CPU profile of a
+// bundle, starting it if needed.
+func (s *webServer) pprofServer(bundle string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if addr, ok := s.pprof[bundle]; ok {
+		return addr, nil
+	}
+	data, err := runCPUProfile(bundle)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "rbench-web-*.pprof")
+	if err != nil {
+		return "", err
+	}
+	// pprof has read it once it serves
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return "", err
+	}
+	addr, err := freeAddr()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("go", "tool", "pprof", "-no_browser", "-http="+addr, f.Name())
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("unable to start go tool pprof, %v", err)
+	}
+	s.procs = append(s.procs, cmd)
+	// pprof prints its URL once it serves
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), "Serving web UI on") {
+			s.pprof[bundle] = addr
+			// drained, pprof would block on a full pipe
+			go io.Copy(io.Discard, stderr)
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("go tool pprof exited without serving %s", bundle)
+}
+
+// freeAddr returns a free local address.
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// handler returns the routes of the server.
+func (s *webServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleRuns)
+	mux.HandleFunc("/run", s.handleRun)
+	mux.HandleFunc("/compare", s.handleCompare)
+	mux.HandleFunc("/trend", s.handleTrend)
+	mux.HandleFunc("/bundles", s.handleBundles)
+	mux.HandleFunc("/bundle", s.handleBundle)
+	mux.HandleFunc("/file", s.handleFile)
+	mux.HandleFunc("/profile", s.handleProfile)
+	return mux
+}
+
+// webCmd implements "rbench web": serves the results browser until interrupted.
+func webCmd(args []string) error {
+	fs := flag.NewFlagSet("web", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8036", "address to listen on")
+	artifacts := fs.String("artifacts", ".", "directory of the .rbench bundles (-bundle), searched recursively")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: rbench web [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	dir, err := filepath.Abs(*artifacts)
+	if err != nil {
+		return err
+	}
+	s := &webServer{artifacts: dir, pprof: make(map[string]string)}
+	defer func() {
+		for _, cmd := range s.procs {
+			cmd.Process.Kill()
+		}
+	}()
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("serving the results history (%s) and the bundles of %s on http://%s\n", historyFile(), dir, l.Addr())
+	return http.Serve(l, s.handler())
+}
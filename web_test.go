@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeb(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("RBENCH_CONFIG", filepath.Join(dir, "config"))
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 9, 0, 0, 0, time.UTC) }
+	records := []historyRecord{
+		{Time: day(13), Commit: "aaa", Machine: "c7g.large", Benchmark: "BenchmarkSign-2", Values: map[string][]float64{"ns/op": {100, 101, 99, 100, 100}}},
+		{Time: day(13), Commit: "aaa", Machine: "c7g.large", Benchmark: "BenchmarkVerify-2", Values: map[string][]float64{"ns/op": {500, 500, 500, 500, 500}}},
+		{Time: day(14), Commit: "bbb", Machine: "c7g.large", Benchmark: "BenchmarkSign-2", Values: map[string][]float64{"ns/op": {120, 121, 119, 120, 120}}},
+	}
+	if _, err := appendHistory(records); err != nil {
+		t.Fatal(err)
+	}
+	runs := historyRuns(records)
+	if len(runs) != 2 || runs[0].Commit != "bbb" || len(runs[1].Records) != 2 {
+		t.Fatalf("unexpected runs %+v", runs)
+	}
+
+	s := &webServer{artifacts: dir, pprof: make(map[string]string)}
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+	get := func(path string, status int) string {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != status {
+			t.Fatalf("GET %s: %s, expected %d\n%s", path, resp.Status, status, body)
+		}
+		return string(body)
+	}
+
+	if body := get("/", http.StatusOK); !strings.Contains(body, "/run?id="+runs[0].ID) || !strings.Contains(body, "/run?id="+runs[1].ID) {
+		t.Errorf("the runs page doesn't link the runs:\n%s", body)
+	}
+	if body := get("/run?id="+runs[1].ID, http.StatusOK); !strings.Contains(body, "BenchmarkVerify-2") {
+		t.Errorf("the run page misses a benchmark:\n%s", body)
+	}
+	if body := get("/compare?a="+runs[1].ID+"&b="+runs[0].ID, http.StatusOK); !strings.Contains(body, "20.00%") || strings.Contains(body, "BenchmarkVerify") {
+		t.Errorf("expected the +20%% of BenchmarkSign only:\n%s", body)
+	}
+	if body := get("/trend?bench="+url.QueryEscape("^BenchmarkSign$"), http.StatusOK); !strings.Contains(body, "<svg") || !strings.Contains(body, "&#43;20.0%") {
+		t.Errorf("unexpected trend page:\n%s", body)
+	}
+	get("/run?id=unknown", http.StatusNotFound)
+	get("/compare?a="+runs[0].ID, http.StatusBadRequest)
+	get("/bundle?path=../outside.rbench", http.StatusBadRequest)
+
+	// bundled files are never rendered, and only the text ones are shown
+	err := createBundle(filepath.Join(dir, "run.rbench"), manifest{}, []artifact{
+		{"output.txt", "output", []byte("<script>alert(1)</script>")},
+		{"cpuprofile/cpu.out", "cpuprofile", []byte("\x1f\x8b")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, disposition := range map[string]string{"output.txt": "", "cpuprofile/cpu.out": "attachment; filename=cpu.out"} {
+		resp, err := http.Get(srv.URL + "/file?path=run.rbench&name=" + url.QueryEscape(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("%s: Content-Type %q", name, got)
+		}
+		if got := resp.Header.Get("Content-Disposition"); got != disposition {
+			t.Errorf("%s: Content-Disposition %q, expected %q", name, got, disposition)
+		}
+	}
+}